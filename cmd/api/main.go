@@ -4,21 +4,36 @@ import (
 	"context"
 	internalapi "image-processing-service/internal/api"
 	"image-processing-service/internal/api/middleware"
+	"image-processing-service/internal/modules/activity"
+	"image-processing-service/internal/modules/apikey"
 	"image-processing-service/internal/modules/auth"
+	"image-processing-service/internal/modules/export"
 	"image-processing-service/internal/modules/file"
+	"image-processing-service/internal/modules/job"
+	"image-processing-service/internal/modules/quota"
 	"image-processing-service/internal/modules/session"
 	"image-processing-service/internal/modules/user"
+	"image-processing-service/internal/modules/webhook"
 	tokenManager "image-processing-service/internal/shared/auth"
 	"image-processing-service/internal/shared/config"
 	"image-processing-service/internal/shared/database"
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/mail"
+	"image-processing-service/internal/shared/oidc"
+	"image-processing-service/internal/shared/outbox"
+	"image-processing-service/internal/shared/password"
+	"image-processing-service/internal/shared/ratelimit"
+	"image-processing-service/internal/shared/utils"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsConfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -27,6 +42,14 @@ func main() {
 	// ==========================================
 	cfg := config.NewEnv()
 
+	if err := utils.SetPasswordParams(utils.PasswordParams{
+		Memory:      cfg.PasswordArgon2Memory,
+		Iterations:  cfg.PasswordArgon2Iterations,
+		Parallelism: cfg.PasswordArgon2Parallelism,
+	}); err != nil {
+		log.Fatalf("Configuración de contraseñas inválida: %v", err)
+	}
+
 	// ==========================================
 	// Configuración de S3
 	// ==========================================
@@ -50,33 +73,336 @@ func main() {
 	// ==========================================
 	// Configuración de JWT y base de datos
 	// ==========================================
-	m := tokenManager.NewTokenManager(cfg.SecretKey, time.Hour*24*7)
 	db := database.NewConection(cfg.DatabaseURL, cfg.EnableAutoMigrate)
 
+	// La clave anterior tiene que seguir validando al menos lo que dura un
+	// access token.
+	keyring, err := tokenManager.NewKeyring(tokenManager.NewKeyStore(db), tokenManager.KeyringConfig{
+		Algorithm:        cfg.JWTAlgorithm,
+		RotationInterval: cfg.JWTKeyRotationInterval,
+		GracePeriod:      max(cfg.JWTKeyGracePeriod, cfg.AccessTokenTTL),
+	})
+	if err != nil {
+		log.Fatal("Error cargando las claves de firma:", err)
+	}
+	m := tokenManager.NewTokenManager(keyring, cfg.AccessTokenTTL)
+
 	// ==========================================
 	// Wiring de módulos
 	// ==========================================
 	userRepo := user.NewRepository(db)
-	userSvc := user.NewService(userRepo)
+	sessionRepo := session.NewRepository(db)
+	redisClient := newRedisClient(cfg.RedisURL)
+	sessionCfg := session.ServiceConfig{
+		Cache:      session.CacheConfig{Size: cfg.SessionCacheSize, TTL: cfg.SessionCacheTTL},
+		MaxPerUser: cfg.SessionMaxPerUser,
+	}
+	if redisClient != nil {
+		sessionCfg.Invalidator = session.NewRedisInvalidator(redisClient)
+	}
+	sessionSvc := session.NewService(sessionRepo, sessionCfg)
+
+	passwordPolicy := user.NewPasswordPolicy(userRepo, newPasswordRules(cfg))
+	apiKeyCfg := apikey.ServiceConfig{
+		Cache: apikey.CacheConfig{Size: cfg.APIKeyCacheSize, TTL: cfg.APIKeyCacheTTL},
+	}
+	if redisClient != nil {
+		apiKeyCfg.Invalidator = apikey.NewRedisInvalidator(redisClient)
+	}
+	apiKeySvc := apikey.NewService(apikey.NewRepository(db), apiKeyCfg)
+	apiKeyHdl := apikey.NewHandler(apiKeySvc)
+
+	authSvc := auth.NewService(auth.NewRepository(db), userRepo, sessionSvc, m, newMailer(cfg), auth.ServiceConfig{
+		RefreshTokenTTL:   cfg.RefreshTokenTTL,
+		BaseURL:           cfg.AppBaseURL,
+		VerifyEmailTTL:    cfg.VerifyEmailTTL,
+		ResetPasswordTTL:  cfg.ResetPasswordTTL,
+		TOTPIssuer:        cfg.TOTPIssuer,
+		IdentityProviders: newIdentityProviders(cfg),
+		Passwords:         passwordPolicy,
+		Lockout: auth.LockoutConfig{
+			Account: auth.LockoutPolicy{
+				MaxFailures:     cfg.LoginMaxFailures,
+				LockoutDuration: cfg.LoginLockoutDuration,
+			},
+		},
+	})
+	authHdl := auth.NewHandler(authSvc, newCookieConfig(cfg))
+
+	quotaRepo := quota.NewRepository(db)
+	quotaSvc := quota.NewService(quotaRepo, quota.Limits{
+		MaxBytes: cfg.QuotaMaxBytes,
+		MaxFiles: cfg.QuotaMaxFiles,
+	})
+	quotaHdl := quota.NewHandler(quotaSvc)
+
+	jobRepo := job.NewRepository(db)
+	jobSvc := job.NewService(jobRepo, job.ServiceConfig{MaxAttempts: cfg.JobMaxAttempts})
+	jobHdl := job.NewHandler(jobSvc)
+
+	webhookRepo := webhook.NewRepository(db)
+	webhookSvc := webhook.NewService(webhookRepo, jobSvc, webhook.ServiceConfig{})
+	webhookHdl := webhook.NewHandler(webhookSvc)
+
+	userSvc := user.NewService(userRepo, sessionSvc, passwordPolicy, apiKeySvc, webhookSvc, authSvc)
 	userHdl := user.NewHandler(userSvc)
 
-	sessionRepo := session.NewRepository(db)
-	sessionSvc := session.NewService(sessionRepo)
+	if promoted, err := userSvc.PromoteAdmins(cfg.AdminEmails); err != nil {
+		log.Printf("Error asignando el rol de administrador: %v", err)
+	} else if promoted > 0 {
+		log.Printf("%d usuarios de ADMIN_EMAILS pasan a ser administradores", promoted)
+	}
 
-	authSvc := auth.NewService(userRepo, sessionSvc, m)
-	authHdl := auth.NewHandler(authSvc)
+	activityRepo := activity.NewRepository(db)
+	activitySvc := activity.NewService(activityRepo)
+	activityHdl := activity.NewHandler(activitySvc, activity.StreamConfig{})
 
 	fileRepo := file.NewRepository(db)
 	storage := file.NewS3Storage(s3Client, cfg.S3Bucket)
-	fileSvc := file.NewService(fileRepo, storage)
-	fileHdl := file.NewHandler(fileSvc)
+	fileSvc := file.NewService(fileRepo, storage, quotaSvc, jobSvc, userSvc, file.ServiceConfig{
+		DedupAcrossUsers:     cfg.DedupAcrossUsers,
+		RequireVerifiedEmail: cfg.RequireVerifiedEmail,
+	})
+	fileHdl := file.NewHandler(fileSvc, file.CachePolicy{
+		Originals:   cfg.CacheControlOriginals,
+		Derivatives: cfg.CacheControlDerivatives,
+	})
+
+	exportHdl := export.NewHandler(export.NewService(userSvc, fileSvc))
+
+	authMW := middleware.NewAuthMiddleware(m, sessionSvc, apiKeySvc)
+
+	// ==========================================
+	// Rate limiting
+	// ==========================================
+	trustedProxies, err := middleware.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("TRUSTED_PROXIES inválido: %v", err)
+	}
+	rateLimiter := middleware.NewRateLimiter(newRateLimitStore(redisClient), middleware.RateLimitConfig{
+		SignIn:   cfg.RateLimitSignIn,
+		Uploads:  cfg.RateLimitUploads,
+		Delivery: cfg.RateLimitDelivery,
+	})
+
+	// ==========================================
+	// Workers de trabajos asíncronos
+	// ==========================================
+	pool := job.NewPool(jobRepo, job.PoolConfig{
+		Workers:      cfg.JobWorkers,
+		PollInterval: cfg.JobPollInterval,
+	})
+	pool.Register(file.JobProcessBlob, fileSvc.ProcessBlob)
+	pool.RegisterInternal(file.JobPurgeUser, fileSvc.PurgeUser)
+	pool.RegisterInternal(webhook.JobDeliver, webhookSvc.Deliver)
+	pool.Start(context.Background())
+
+	// ==========================================
+	// Relay del outbox de eventos
+	// ==========================================
+	// Los eventos se guardan en el outbox junto con cada cambio; el relay
+	// los reparte por el bus del proceso (stream SSE), a los webhooks y al
+	// log.
+	bus := events.NewBus()
+	bus.Subscribe(activitySvc.Publish)
+	bus.Subscribe(fileSvc.HandleUserDeleted)
+
+	outboxRepo := outbox.NewRepository(db)
+	relay := outbox.NewRelay(outboxRepo, outbox.RelayConfig{
+		PollInterval: cfg.OutboxPollInterval,
+	}, bus, webhookSvc, events.Log)
+	relay.Start(context.Background())
 
-	authMW := middleware.NewAuthMiddleware(m, sessionSvc)
+	go prune(activitySvc, outboxRepo, cfg.EventLogRetention)
+	go pruneLoginAttempts(authSvc, cfg.LoginAttemptRetention)
+	go pruneSessions(sessionSvc)
+	go rotateSigningKeys(keyring)
 
 	// ==========================================
 	// Servidor
 	// ==========================================
 	addr := ":" + cfg.Port
 	log.Printf("Iniciando servidor en el puerto %s", cfg.Port)
-	http.ListenAndServe(addr, internalapi.NewRouter(authMW, authHdl, userHdl, fileHdl))
+	http.ListenAndServe(addr, internalapi.NewRouter(trustedProxies, authMW, rateLimiter, authHdl, userHdl, fileHdl, quotaHdl, jobHdl, webhookHdl, activityHdl, apiKeyHdl, exportHdl, keyring))
+}
+
+// newCookieConfig configura las cookies del modo cookie. Duran lo mismo que
+// sus tokens.
+func newCookieConfig(cfg *config.Config) tokenManager.CookieConfig {
+	sameSite, err := tokenManager.ParseSameSite(cfg.CookieSameSite)
+	if err != nil {
+		log.Fatalf("COOKIE_SAME_SITE inválido: %v", err)
+	}
+	if sameSite == http.SameSiteNoneMode && !cfg.CookieSecure {
+		log.Fatal("COOKIE_SAME_SITE=none requiere COOKIE_SECURE")
+	}
+
+	return tokenManager.CookieConfig{
+		Secure:     cfg.CookieSecure,
+		SameSite:   sameSite,
+		Domain:     cfg.CookieDomain,
+		AccessTTL:  cfg.AccessTokenTTL,
+		RefreshTTL: cfg.RefreshTokenTTL,
+	}
+}
+
+// newRedisClient conecta con REDIS_URL. Devuelve nil si no está definida.
+func newRedisClient(redisURL string) *redis.Client {
+	if redisURL == "" {
+		return nil
+	}
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		log.Fatalf("REDIS_URL inválida: %v", err)
+	}
+	return redis.NewClient(opts)
+}
+
+// newRateLimitStore guarda el estado del rate limiting en Redis si está
+// configurado, de modo que todas las instancias compartan los límites; si
+// no, en memoria.
+func newRateLimitStore(client *redis.Client) ratelimit.Store {
+	if client == nil {
+		return ratelimit.NewMemoryStore()
+	}
+	return ratelimit.NewRedisStore(client)
+}
+
+// newMailer elige cómo se envían los correos según MAIL_DRIVER.
+func newMailer(cfg *config.Config) mail.Mailer {
+	switch cfg.MailDriver {
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			log.Fatal("MAIL_DRIVER=smtp requiere SMTP_HOST")
+		}
+		return mail.NewSMTPMailer(mail.SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUser,
+			Password: cfg.SMTPPass,
+			From:     cfg.MailFrom,
+		})
+	case "file":
+		return mail.NewFileMailer(cfg.MailDir, cfg.MailFrom)
+	case "log":
+		return mail.NewLogMailer()
+	default:
+		log.Fatalf("MAIL_DRIVER inválido: %q", cfg.MailDriver)
+		return nil
+	}
+}
+
+// newIdentityProviders crea los proveedores OpenID Connect configurados. El
+// proveedor redirige al frontend, que envía el code y el state al callback
+// de la API.
+func newIdentityProviders(cfg *config.Config) map[string]auth.IdentityProvider {
+	providers := make(map[string]auth.IdentityProvider, len(cfg.OIDCProviders))
+	for _, p := range cfg.OIDCProviders {
+		providers[p.Name] = oidc.NewProvider(oidc.Config{
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  strings.TrimSuffix(cfg.AppBaseURL, "/") + "/oidc/" + p.Name + "/callback",
+			Scopes:       p.Scopes,
+		}, nil)
+	}
+	return providers
+}
+
+// newPasswordRules arma la política de contraseñas. Sale si un tipo de
+// carácter no existe o no se puede leer PASSWORD_BREACHED_LIST.
+func newPasswordRules(cfg *config.Config) password.Policy {
+	classes, err := password.ParseClasses(cfg.PasswordRequiredClasses)
+	if err != nil {
+		log.Fatalf("PASSWORD_REQUIRED_CLASSES inválido: %v", err)
+	}
+
+	breached, err := password.LoadBreached(cfg.PasswordBreachedList)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return password.Policy{
+		MinLength:       cfg.PasswordMinLength,
+		RequiredClasses: classes,
+		History:         cfg.PasswordHistory,
+		Breached:        breached,
+	}
+}
+
+// prune borra cada hora los eventos del stream y los mensajes ya entregados
+// del outbox más antiguos que la retención configurada.
+func prune(activitySvc activity.Service, outboxRepo outbox.Repository, retention time.Duration) {
+	for range time.Tick(time.Hour) {
+		before := time.Now().UTC().Add(-retention)
+
+		deleted, err := activitySvc.Prune(before)
+		if err != nil {
+			log.Printf("Error purgando eventos antiguos: %v", err)
+		} else if deleted > 0 {
+			log.Printf("Purgados %d eventos antiguos", deleted)
+		}
+
+		deleted, err = outboxRepo.DeletePublishedBefore(before)
+		if err != nil {
+			log.Printf("Error purgando el outbox: %v", err)
+		} else if deleted > 0 {
+			log.Printf("Purgados %d mensajes entregados del outbox", deleted)
+		}
+	}
+}
+
+// pruneLoginAttempts borra cada hora los intentos de inicio de sesión más
+// antiguos que la retención configurada y los contadores de fallos que ya
+// no penalizan.
+func pruneLoginAttempts(authSvc auth.Service, retention time.Duration) {
+	for range time.Tick(time.Hour) {
+		now := time.Now().UTC()
+
+		deleted, err := authSvc.PruneAttempts(now.Add(-retention))
+		if err != nil {
+			log.Printf("Error purgando intentos de inicio de sesión: %v", err)
+		} else if deleted > 0 {
+			log.Printf("Purgados %d intentos de inicio de sesión antiguos", deleted)
+		}
+
+		deleted, err = authSvc.PruneThrottles(now)
+		if err != nil {
+			log.Printf("Error purgando contadores de fallos: %v", err)
+		} else if deleted > 0 {
+			log.Printf("Purgados %d contadores de fallos caducados", deleted)
+		}
+	}
+}
+
+// pruneSessions borra cada hora las sesiones caducadas y los refresh tokens
+// rotados que ya caducaron: presentarlos no revocaría nada.
+func pruneSessions(sessionSvc session.Service) {
+	for range time.Tick(time.Hour) {
+		now := time.Now()
+
+		deleted, err := sessionSvc.PruneExpired(now)
+		if err != nil {
+			log.Printf("Error purgando sesiones caducadas: %v", err)
+		} else if deleted > 0 {
+			log.Printf("Purgadas %d sesiones caducadas", deleted)
+		}
+
+		deleted, err = sessionSvc.PruneRotated(now)
+		if err != nil {
+			log.Printf("Error purgando refresh tokens rotados: %v", err)
+		} else if deleted > 0 {
+			log.Printf("Purgados %d refresh tokens rotados caducados", deleted)
+		}
+	}
+}
+
+// rotateSigningKeys comprueba cada hora si toca rotar la clave de firma y
+// carga las que hayan creado otras instancias.
+func rotateSigningKeys(keyring *tokenManager.Keyring) {
+	for range time.Tick(time.Hour) {
+		if err := keyring.Rotate(time.Now()); err != nil {
+			log.Printf("Error rotando las claves de firma: %v", err)
+		}
+	}
 }
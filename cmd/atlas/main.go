@@ -4,9 +4,17 @@ import (
 	"fmt"
 	"os"
 
+	"image-processing-service/internal/modules/activity"
+	"image-processing-service/internal/modules/apikey"
+	"image-processing-service/internal/modules/auth"
 	"image-processing-service/internal/modules/file"
+	"image-processing-service/internal/modules/job"
+	"image-processing-service/internal/modules/quota"
 	"image-processing-service/internal/modules/session"
 	"image-processing-service/internal/modules/user"
+	"image-processing-service/internal/modules/webhook"
+	sharedAuth "image-processing-service/internal/shared/auth"
+	"image-processing-service/internal/shared/outbox"
 
 	"ariga.io/atlas-provider-gorm/gormschema"
 )
@@ -14,8 +22,28 @@ import (
 func main() {
 	stmts, err := gormschema.New("postgres").Load(
 		&user.User{},
+		&user.PasswordHistory{},
 		&session.Session{},
+		&session.RotatedToken{},
 		&file.File{},
+		&file.Blob{},
+		&quota.Usage{},
+		&job.Job{},
+		&webhook.Endpoint{},
+		&webhook.Delivery{},
+		&activity.Event{},
+		&activity.Cursor{},
+		&outbox.Message{},
+		&auth.LoginAttempt{},
+		&auth.Throttle{},
+		&apikey.APIKey{},
+		&auth.EmailToken{},
+		&auth.TwoFactor{},
+		&auth.RecoveryCode{},
+		&auth.LoginChallenge{},
+		&auth.OIDCLogin{},
+		&auth.ExternalIdentity{},
+		&sharedAuth.SigningKey{},
 	)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to load gorm schema: %v\n", err)
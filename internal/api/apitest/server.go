@@ -0,0 +1,507 @@
+// Package apitest levanta el router completo de la API sobre SQLite y un
+// almacenamiento en memoria, para escribir pruebas end-to-end sin Postgres
+// ni MinIO.
+package apitest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"path/filepath"
+	"testing"
+	"time"
+
+	internalapi "image-processing-service/internal/api"
+	"image-processing-service/internal/api/middleware"
+	"image-processing-service/internal/modules/activity"
+	"image-processing-service/internal/modules/apikey"
+	"image-processing-service/internal/modules/auth"
+	"image-processing-service/internal/modules/export"
+	"image-processing-service/internal/modules/file"
+	"image-processing-service/internal/modules/job"
+	"image-processing-service/internal/modules/quota"
+	"image-processing-service/internal/modules/session"
+	"image-processing-service/internal/modules/user"
+	"image-processing-service/internal/modules/webhook"
+	tokenManager "image-processing-service/internal/shared/auth"
+	"image-processing-service/internal/shared/database"
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/mail"
+	"image-processing-service/internal/shared/oidc"
+	"image-processing-service/internal/shared/outbox"
+	"image-processing-service/internal/shared/password"
+	"image-processing-service/internal/shared/ratelimit"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// CachePolicy es la política de Cache-Control con la que se sirven las
+// imágenes en las pruebas.
+var CachePolicy = file.CachePolicy{
+	Originals:   "private, max-age=31536000, immutable",
+	Derivatives: "private, max-age=86400",
+}
+
+// Server es un servidor HTTP de prueba con el mismo wiring que cmd/api.
+type Server struct {
+	*httptest.Server
+	DB      *gorm.DB
+	Storage file.StorageProvider
+	// Mailer guarda los correos enviados para leer los enlaces.
+	Mailer *mail.MemoryMailer
+	// Keyring firma los access tokens; sirve para forzar rotaciones.
+	Keyring *tokenManager.Keyring
+}
+
+// Option modifica la configuración con la que New arma el servidor.
+type Option func(*options)
+
+type options struct {
+	fileConfig  file.ServiceConfig
+	quotaLimits quota.Limits
+	rateLimits  middleware.RateLimitConfig
+	proxies     []*net.IPNet
+	authConfig  auth.ServiceConfig
+	maxSessions int
+	passwords   *password.Policy
+	webhooks    webhook.ServiceConfig
+}
+
+// WithFileConfig configura el servicio de archivos.
+func WithFileConfig(cfg file.ServiceConfig) Option {
+	return func(o *options) { o.fileConfig = cfg }
+}
+
+// WithWebhookConfig configura las entregas de webhooks. Sin Client se
+// entregan con uno sin filtro de direcciones, para poder usar receptores de
+// httptest en 127.0.0.1; el de producción es webhook.NewClient.
+func WithWebhookConfig(cfg webhook.ServiceConfig) Option {
+	return func(o *options) { o.webhooks = cfg }
+}
+
+// WithQuotaLimits fija los límites globales de almacenamiento por usuario.
+func WithQuotaLimits(limits quota.Limits) Option {
+	return func(o *options) { o.quotaLimits = limits }
+}
+
+// WithRateLimits activa el rate limiting; por defecto está desactivado
+// para que las pruebas no dependan del número de peticiones.
+func WithRateLimits(cfg middleware.RateLimitConfig) Option {
+	return func(o *options) { o.rateLimits = cfg }
+}
+
+// WithTrustedProxies fija los proxies cuyo X-Forwarded-For se acepta.
+func WithTrustedProxies(proxies []*net.IPNet) Option {
+	return func(o *options) { o.proxies = proxies }
+}
+
+// WithLockout configura la penalización de los inicios de sesión fallidos.
+func WithLockout(cfg auth.LockoutConfig) Option {
+	return func(o *options) { o.authConfig.Lockout = cfg }
+}
+
+// WithMaxSessions limita las sesiones activas de cada usuario.
+func WithMaxSessions(n int) Option {
+	return func(o *options) { o.maxSessions = n }
+}
+
+// WithPasswordPolicy sustituye la política de contraseñas por defecto:
+// longitud mínima y historial por defecto y la lista de filtradas incluida.
+func WithPasswordPolicy(rules password.Policy) Option {
+	return func(o *options) { o.passwords = &rules }
+}
+
+// WithOIDCProvider añade un proveedor OpenID Connect con el que iniciar
+// sesión, normalmente uno de oidctest.
+func WithOIDCProvider(name string, cfg oidc.Config) Option {
+	return func(o *options) {
+		if o.authConfig.IdentityProviders == nil {
+			o.authConfig.IdentityProviders = map[string]auth.IdentityProvider{}
+		}
+		o.authConfig.IdentityProviders[name] = oidc.NewProvider(cfg, nil)
+	}
+}
+
+// New crea la base de datos SQLite en un directorio temporal, migra el
+// esquema y arranca el servidor. Todo se libera al terminar el test.
+func New(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	db := newSQLiteDB(t)
+	storage := file.NewMemoryStorage()
+	mailer := mail.NewMemoryMailer()
+
+	keyring, err := tokenManager.NewKeyring(tokenManager.NewKeyStore(db), tokenManager.KeyringConfig{})
+	if err != nil {
+		t.Fatalf("no se pudieron crear las claves de firma: %v", err)
+	}
+	accessTTL := time.Hour * 24 * 7
+	m := tokenManager.NewTokenManager(keyring, accessTTL)
+
+	userRepo := user.NewRepository(db)
+	sessionRepo := session.NewRepository(db)
+	sessionSvc := session.NewService(sessionRepo, session.ServiceConfig{
+		Cache:      session.CacheConfig{TTL: time.Minute},
+		MaxPerUser: o.maxSessions,
+	})
+
+	if o.passwords == nil {
+		breached, err := password.LoadBreached("")
+		if err != nil {
+			t.Fatalf("no se pudo cargar la lista de contraseñas filtradas: %v", err)
+		}
+		o.passwords = &password.Policy{
+			MinLength: password.DefaultMinLength,
+			History:   password.DefaultHistory,
+			Breached:  breached,
+		}
+	}
+	passwordPolicy := user.NewPasswordPolicy(userRepo, *o.passwords)
+	o.authConfig.Passwords = passwordPolicy
+
+	apiKeySvc := apikey.NewService(apikey.NewRepository(db), apikey.ServiceConfig{
+		Cache: apikey.CacheConfig{TTL: time.Minute},
+	})
+	apiKeyHdl := apikey.NewHandler(apiKeySvc)
+
+	authSvc := auth.NewService(auth.NewRepository(db), userRepo, sessionSvc, m, mailer, o.authConfig)
+	authHdl := auth.NewHandler(authSvc, tokenManager.CookieConfig{
+		Secure:     true,
+		SameSite:   http.SameSiteStrictMode,
+		AccessTTL:  accessTTL,
+		RefreshTTL: auth.DefaultRefreshTokenTTL,
+	})
+
+	quotaRepo := quota.NewRepository(db)
+	quotaSvc := quota.NewService(quotaRepo, o.quotaLimits)
+	quotaHdl := quota.NewHandler(quotaSvc)
+
+	jobRepo := job.NewRepository(db)
+	jobSvc := job.NewService(jobRepo, job.ServiceConfig{})
+	jobHdl := job.NewHandler(jobSvc)
+
+	webhookRepo := webhook.NewRepository(db)
+	if o.webhooks.Client == nil {
+		o.webhooks.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	webhookSvc := webhook.NewService(webhookRepo, jobSvc, o.webhooks)
+	webhookHdl := webhook.NewHandler(webhookSvc)
+
+	userSvc := user.NewService(userRepo, sessionSvc, passwordPolicy, apiKeySvc, webhookSvc, authSvc)
+	userHdl := user.NewHandler(userSvc)
+
+	activityRepo := activity.NewRepository(db)
+	activitySvc := activity.NewService(activityRepo)
+	activityHdl := activity.NewHandler(activitySvc, activity.StreamConfig{
+		PollInterval:      50 * time.Millisecond,
+		HeartbeatInterval: time.Second,
+	})
+
+	fileRepo := file.NewRepository(db)
+	fileSvc := file.NewService(fileRepo, storage, quotaSvc, jobSvc, userSvc, o.fileConfig)
+	fileHdl := file.NewHandler(fileSvc, CachePolicy)
+
+	exportHdl := export.NewHandler(export.NewService(userSvc, fileSvc))
+
+	authMW := middleware.NewAuthMiddleware(m, sessionSvc, apiKeySvc)
+	rateLimiter := middleware.NewRateLimiter(ratelimit.NewMemoryStore(), o.rateLimits)
+
+	srv := httptest.NewServer(internalapi.NewRouter(o.proxies, authMW, rateLimiter, authHdl, userHdl, fileHdl, quotaHdl, jobHdl, webhookHdl, activityHdl, apiKeyHdl, exportHdl, keyring))
+	t.Cleanup(srv.Close)
+
+	// El pool y el relay se detienen antes de cerrar la base de datos:
+	// t.Cleanup ejecuta en orden inverso al de registro.
+	pool := job.NewPool(jobRepo, job.PoolConfig{
+		Workers:      2,
+		PollInterval: 10 * time.Millisecond,
+		BaseBackoff:  10 * time.Millisecond,
+	})
+	pool.Register(file.JobProcessBlob, fileSvc.ProcessBlob)
+	pool.RegisterInternal(file.JobPurgeUser, fileSvc.PurgeUser)
+	pool.RegisterInternal(webhook.JobDeliver, webhookSvc.Deliver)
+	ctx, cancel := context.WithCancel(context.Background())
+	pool.Start(ctx)
+
+	bus := events.NewBus()
+	bus.Subscribe(activitySvc.Publish)
+	bus.Subscribe(fileSvc.HandleUserDeleted)
+	relay := outbox.NewRelay(outbox.NewRepository(db), outbox.RelayConfig{
+		PollInterval: 10 * time.Millisecond,
+		BaseBackoff:  10 * time.Millisecond,
+	}, bus, webhookSvc)
+	relay.Start(ctx)
+
+	t.Cleanup(func() {
+		cancel()
+		pool.Wait()
+		relay.Wait()
+	})
+
+	return &Server{Server: srv, DB: db, Storage: storage, Mailer: mailer, Keyring: keyring}
+}
+
+// WaitForJobs espera a que la cola no tenga trabajos pendientes ni en curso,
+// ni el outbox eventos por entregar, por ejemplo para que las subidas tengan
+// ya su miniatura o para que sus webhooks estén enviados.
+func (s *Server) WaitForJobs(t testing.TB) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var active, undelivered int64
+		err := s.DB.Model(&job.Job{}).
+			Where("status IN ?", []job.Status{job.StatusPending, job.StatusRunning}).
+			Count(&active).Error
+		if err != nil {
+			t.Fatalf("no se pudo consultar la cola de trabajos: %v", err)
+		}
+		err = s.DB.Model(&outbox.Message{}).Where("published_at IS NULL").Count(&undelivered).Error
+		if err != nil {
+			t.Fatalf("no se pudo consultar el outbox: %v", err)
+		}
+		if active == 0 && undelivered == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("quedan %d trabajos sin terminar", active)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newSQLiteDB usa un archivo en lugar de ":memory:" porque cada conexión del
+// pool vería una base de datos en memoria distinta, y el servidor atiende
+// peticiones concurrentes.
+func newSQLiteDB(t testing.TB) *gorm.DB {
+	t.Helper()
+
+	dsn := filepath.Join(t.TempDir(), "apitest.db") + "?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=off"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("no se pudo abrir la base de datos SQLite: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("no se pudo obtener la conexión SQL: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := database.AutoMigrate(db); err != nil {
+		t.Fatalf("no se pudo migrar el esquema: %v", err)
+	}
+
+	return db
+}
+
+// Envelope es la forma común de todas las respuestas JSON de la API.
+type Envelope struct {
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data"`
+	Error   struct {
+		Code    string      `json:"code"`
+		Message string      `json:"message"`
+		Details interface{} `json:"details"`
+	} `json:"error"`
+}
+
+// Do envía una petición al servidor. Si token no está vacío se envía como
+// Bearer en la cabecera Authorization.
+func (s *Server) Do(t testing.TB, method, path string, body io.Reader, contentType, token string, headers ...http.Header) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, s.URL+path, body)
+	if err != nil {
+		t.Fatalf("no se pudo crear la petición: %v", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for _, h := range headers {
+		for k, values := range h {
+			for _, v := range values {
+				req.Header.Add(k, v)
+			}
+		}
+	}
+
+	res, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatalf("la petición %s %s falló: %v", method, path, err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+
+	return res
+}
+
+// JSON envía payload serializado como JSON y decodifica la respuesta.
+func (s *Server) JSON(t testing.TB, method, path string, payload interface{}, token string) (*http.Response, Envelope) {
+	t.Helper()
+
+	var body io.Reader
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatalf("no se pudo serializar el payload: %v", err)
+		}
+		body = bytes.NewReader(raw)
+	}
+
+	res := s.Do(t, method, path, body, "application/json", token)
+	return res, DecodeEnvelope(t, res)
+}
+
+// DecodeEnvelope decodifica el cuerpo de una respuesta JSON de la API.
+func DecodeEnvelope(t testing.TB, res *http.Response) Envelope {
+	t.Helper()
+
+	var env Envelope
+	if err := json.NewDecoder(res.Body).Decode(&env); err != nil {
+		t.Fatalf("la respuesta no es JSON válido: %v", err)
+	}
+	return env
+}
+
+// Decode deserializa el campo data de la respuesta en dst.
+func (e Envelope) Decode(t testing.TB, dst interface{}) {
+	t.Helper()
+
+	if err := json.Unmarshal(e.Data, dst); err != nil {
+		t.Fatalf("no se pudo decodificar data: %v", err)
+	}
+}
+
+// SignUp registra un usuario y falla el test si la API no responde 201.
+func (s *Server) SignUp(t testing.TB, name, email, password string) {
+	t.Helper()
+
+	res, env := s.JSON(t, http.MethodPost, "/api/v1/auth/signup", map[string]string{
+		"name":     name,
+		"email":    email,
+		"password": password,
+	}, "")
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("signup respondió %d: %s", res.StatusCode, env.Error.Code)
+	}
+}
+
+// SignIn inicia sesión y devuelve el par de tokens emitido.
+func (s *Server) SignIn(t testing.TB, email, password string) auth.Auth {
+	t.Helper()
+
+	res, env := s.JSON(t, http.MethodPost, "/api/v1/auth/signin", map[string]string{
+		"email":    email,
+		"password": password,
+	}, "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("signin respondió %d: %s", res.StatusCode, env.Error.Code)
+	}
+
+	var tokens auth.Auth
+	env.Decode(t, &tokens)
+	return tokens
+}
+
+// NewUser registra un usuario con datos únicos y devuelve su access token.
+func (s *Server) NewUser(t testing.TB) string {
+	t.Helper()
+
+	email := fmt.Sprintf("user-%d@test.com", time.Now().UnixNano())
+	s.SignUp(t, "Usuario de prueba", email, "secreto123")
+	return s.SignIn(t, email, "secreto123").AccessToken
+}
+
+// NewAdmin registra un usuario con rol de administrador y devuelve su ID y
+// su access token.
+func (s *Server) NewAdmin(t testing.TB) (string, string) {
+	t.Helper()
+
+	email := fmt.Sprintf("admin-%d@test.com", time.Now().UnixNano())
+	s.SignUp(t, "Administrador", email, "secreto123")
+
+	var admin user.User
+	if err := s.DB.Where("email = ?", email).First(&admin).Error; err != nil {
+		t.Fatalf("no se encontró el administrador: %v", err)
+	}
+	if err := s.DB.Model(&admin).Update("role", tokenManager.RoleAdmin).Error; err != nil {
+		t.Fatalf("no se pudo asignar el rol de administrador: %v", err)
+	}
+
+	return admin.ID, s.SignIn(t, email, "secreto123").AccessToken
+}
+
+// Upload sube content como campo multipart "file" con el content-type dado.
+func (s *Server) Upload(t testing.TB, token, filename, contentType string, content []byte) *http.Response {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, filename))
+	header.Set("Content-Type", contentType)
+
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		t.Fatalf("no se pudo crear la parte multipart: %v", err)
+	}
+	if _, err := part.Write(content); err != nil {
+		t.Fatalf("no se pudo escribir el archivo: %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("no se pudo cerrar el multipart: %v", err)
+	}
+
+	return s.Do(t, http.MethodPost, "/api/v1/files/", &body, mw.FormDataContentType(), token)
+}
+
+// NewPNG genera una imagen PNG de ancho x alto con un degradado, un círculo
+// claro y un rectángulo oscuro. La escena es la misma a cualquier tamaño, de
+// modo que dos tamaños distintos son bytes distintos pero visualmente
+// equivalentes.
+func NewPNG(t testing.TB, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x)/float64(width), float64(y)/float64(height)
+
+			v := uint8(60 + 120*fx)
+			if (fx-0.3)*(fx-0.3)+(fy-0.4)*(fy-0.4) < 0.04 {
+				v = 240
+			}
+			if fx > 0.6 && fx < 0.9 && fy > 0.55 && fy < 0.85 {
+				v = 20
+			}
+
+			img.Set(x, y, color.RGBA{R: v, G: v / 2, B: 255 - v, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("no se pudo codificar el PNG: %v", err)
+	}
+	return buf.Bytes()
+}
@@ -2,48 +2,87 @@ package middleware
 
 import (
 	"context"
+	"image-processing-service/internal/modules/apikey"
 	"image-processing-service/internal/modules/session"
 	"image-processing-service/internal/shared/auth"
 	"image-processing-service/internal/shared/utils"
+	"log"
 	"net/http"
 	"strings"
+
+	"github.com/go-chi/chi/v5"
 )
 
 var (
 	ErrInvalidToken = utils.NewError(401, "INVALID_TOKEN", "Token invalido", nil)
+	ErrForbidden    = utils.NewError(403, "FORBIDDEN", "No tienes permiso para realizar esta acción", nil)
+	// ErrInsufficientScope lo recibe una API key sin el scope de la ruta.
+	ErrInsufficientScope = utils.NewError(403, "INSUFFICIENT_SCOPE", "La API key no tiene permiso para realizar esta acción", nil)
+	// ErrAPIKeyNotAllowed lo recibe una API key en una ruta que requiere
+	// una sesión interactiva.
+	ErrAPIKeyNotAllowed = utils.NewError(403, "API_KEY_NOT_ALLOWED", "Esta ruta no admite API keys", nil)
 )
 
 type AuthMiddleware struct {
 	tokenManager *auth.TokenManager
 	sessionSrv   session.Service
+	apiKeySrv    apikey.Service
 }
 
-func NewAuthMiddleware(tm *auth.TokenManager, sess session.Service) *AuthMiddleware {
+func NewAuthMiddleware(tm *auth.TokenManager, sess session.Service, keys apikey.Service) *AuthMiddleware {
 	return &AuthMiddleware{
 		tokenManager: tm,
 		sessionSrv:   sess,
+		apiKeySrv:    keys,
 	}
 }
 
+// Authenticate acepta un access token o una API key como bearer token, o un
+// access token en la cookie del modo cookie. Las rutas que admiten API keys
+// deben limitarlas con RequireScope y las que no, rechazarlas con
+// RequireSession.
 func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token, err := m.extractTokenFromHeader(r)
+		token, err := m.extractToken(r)
 		if err != nil {
 			utils.HandleError(w, err)
 			return
 		}
 
+		if strings.HasPrefix(token, apikey.KeyPrefix) {
+			key, err := m.apiKeySrv.Authenticate(token)
+			if err != nil {
+				utils.HandleError(w, err)
+				return
+			}
+
+			// Una API key nunca da permisos de administrador.
+			authUser := auth.AuthenticatedUser{
+				UserID:   key.UserID,
+				Role:     auth.RoleMember,
+				APIKeyID: key.ID,
+				Scopes:   key.ScopeList(),
+			}
+
+			ctx := context.WithValue(r.Context(), auth.AuthKey, authUser)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
 		claims, err := m.tokenManager.Validate(token)
 		if err != nil {
 			utils.HandleError(w, err)
 			return
 		}
 
-		_, err = m.sessionSrv.IsValid(claims.ID, session.Access)
+		sess, err := m.sessionSrv.IsValid(claims.ID, session.Access)
 		if err != nil {
 			utils.HandleError(w, ErrInvalidToken)
 			return
 		}
+		if err := m.sessionSrv.Touch(sess); err != nil {
+			log.Printf("Error actualizando el último uso de la sesión %s: %v", sess.ID, err)
+		}
 
 		userID := claims.UserID
 		if !utils.IsValidID(userID) {
@@ -56,9 +95,15 @@ func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		role := claims.Role
+		if role == "" {
+			role = auth.RoleMember
+		}
+
 		authUser := auth.AuthenticatedUser{
 			UserID: userID,
 			JTI:    jti,
+			Role:   role,
 		}
 
 		ctx := context.WithValue(r.Context(), auth.AuthKey, authUser)
@@ -66,6 +111,87 @@ func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 	})
 }
 
+// RequireRole deja pasar solo a los usuarios con alguno de los roles. Debe ir
+// después de Authenticate.
+func (m *AuthMiddleware) RequireRole(roles ...auth.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authUser, ok := auth.GetAuthUser(r.Context())
+			if !ok || !authUser.HasRole(roles...) {
+				utils.HandleError(w, ErrForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSelfOrRole deja pasar al usuario cuyo ID coincide con el parámetro
+// de ruta param, o a los usuarios con alguno de los roles. Debe ir después de
+// Authenticate.
+func (m *AuthMiddleware) RequireSelfOrRole(param string, roles ...auth.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authUser, ok := auth.GetAuthUser(r.Context())
+			if !ok || (authUser.UserID != chi.URLParam(r, param) && !authUser.HasRole(roles...)) {
+				utils.HandleError(w, ErrForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScope deja pasar a las API keys con el scope y a las sesiones
+// interactivas. Debe ir después de Authenticate.
+func (m *AuthMiddleware) RequireScope(scope auth.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authUser, ok := auth.GetAuthUser(r.Context())
+			if !ok || !authUser.HasScope(scope) {
+				utils.HandleError(w, ErrInsufficientScope)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession rechaza las API keys. Debe ir después de Authenticate.
+func (m *AuthMiddleware) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authUser, ok := auth.GetAuthUser(r.Context())
+		if !ok || authUser.IsAPIKey() {
+			utils.HandleError(w, ErrAPIKeyNotAllowed)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// extractToken prefiere la cabecera Authorization: un cliente que la envía
+// no depende de las cookies y no necesita token CSRF. Sin ella, usa la
+// cookie y exige el double-submit en los métodos que modifican algo.
+func (m *AuthMiddleware) extractToken(r *http.Request) (string, error) {
+	if r.Header.Get("Authorization") != "" {
+		return m.extractTokenFromHeader(r)
+	}
+
+	cookie, err := r.Cookie(auth.AccessCookie)
+	if err != nil || cookie.Value == "" || strings.HasPrefix(cookie.Value, apikey.KeyPrefix) {
+		return "", ErrInvalidToken
+	}
+	if !auth.ValidCSRF(r) {
+		return "", auth.ErrInvalidCSRFToken
+	}
+
+	return cookie.Value, nil
+}
+
 func (m *AuthMiddleware) extractTokenFromHeader(r *http.Request) (string, error) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
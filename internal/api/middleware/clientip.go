@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"image-processing-service/internal/shared/auth"
+)
+
+// RealIP resuelve la IP del cliente con ClientIP y la guarda en el contexto,
+// donde la leen el rate limiting y la auditoría de inicios de sesión con
+// auth.GetClientIP.
+func RealIP(trusted []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), auth.ClientIPKey, ClientIP(r, trusted))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ClientIP devuelve la IP del cliente. X-Forwarded-For solo se tiene en
+// cuenta si la petición llega de un proxy de confianza, y se recorre de
+// derecha a izquierda saltando los proxies de confianza: la primera IP que no
+// lo es es el cliente. Las anteriores las puede inventar el propio cliente.
+func ClientIP(r *http.Request, trusted []*net.IPNet) string {
+	remote := remoteIP(r.RemoteAddr)
+	if !isTrusted(remote, trusted) {
+		return remote
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		if !isTrusted(hop, trusted) {
+			return hop
+		}
+		remote = hop
+	}
+
+	return remote
+}
+
+// ParseTrustedProxies convierte una lista de IPs o redes CIDR.
+func ParseTrustedProxies(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("proxy de confianza inválido: %q", value)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("proxy de confianza inválido: %q", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+func isTrusted(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestIP devuelve la IP que resolvió RealIP o, si no pasó por él,
+// RemoteAddr.
+func requestIP(r *http.Request) string {
+	if ip := auth.GetClientIP(r.Context()); ip != "" {
+		return ip
+	}
+	return remoteIP(r.RemoteAddr)
+}
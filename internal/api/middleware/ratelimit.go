@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"image-processing-service/internal/shared/auth"
+	"image-processing-service/internal/shared/ratelimit"
+	"image-processing-service/internal/shared/utils"
+)
+
+var (
+	ErrRateLimited = utils.NewError(429, "RATE_LIMITED", "Demasiadas peticiones, inténtalo de nuevo más tarde", nil)
+)
+
+// RateLimitConfig agrupa los límites de cada grupo de rutas. Un límite
+// desactivado deja pasar todas las peticiones.
+type RateLimitConfig struct {
+	SignIn   ratelimit.Limit
+	Uploads  ratelimit.Limit
+	Delivery ratelimit.Limit
+}
+
+// RateLimiter limita las peticiones por usuario autenticado o, si no lo hay,
+// por IP del cliente. Cada grupo de rutas tiene su propio presupuesto.
+type RateLimiter struct {
+	store  ratelimit.Store
+	config RateLimitConfig
+	now    func() time.Time
+}
+
+func NewRateLimiter(store ratelimit.Store, cfg RateLimitConfig) *RateLimiter {
+	return &RateLimiter{store: store, config: cfg, now: time.Now}
+}
+
+// SignIn limita los intentos de inicio de sesión.
+func (rl *RateLimiter) SignIn(next http.Handler) http.Handler {
+	return rl.Limit("signin", rl.config.SignIn)(next)
+}
+
+// Uploads limita las subidas de archivos.
+func (rl *RateLimiter) Uploads(next http.Handler) http.Handler {
+	return rl.Limit("uploads", rl.config.Uploads)(next)
+}
+
+// Delivery limita la descarga de imágenes.
+func (rl *RateLimiter) Delivery(next http.Handler) http.Handler {
+	return rl.Limit("delivery", rl.config.Delivery)(next)
+}
+
+// Limit devuelve un middleware que aplica limit al grupo name. Para limitar
+// por usuario debe ir después de Authenticate.
+//
+// Las respuestas llevan RateLimit-Limit, RateLimit-Remaining,
+// RateLimit-Reset y RateLimit-Policy; las rechazadas, además, Retry-After.
+// Si el store falla la petición pasa: es preferible a tumbar la API.
+func (rl *RateLimiter) Limit(name string, limit ratelimit.Limit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !limit.Enabled() {
+			return next
+		}
+
+		policy := fmt.Sprintf("%d;w=%d", limit.Requests, ceilSeconds(limit.Window))
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := name + ":" + rl.clientKey(r)
+
+			decision, err := rl.store.Take(r.Context(), key, limit, rl.now())
+			if err != nil {
+				log.Printf("Error consultando el rate limit de %s: %v", key, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+			h.Set("RateLimit-Policy", policy)
+
+			if !decision.Allowed {
+				h.Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(decision.RetryAfter))))
+				utils.HandleError(w, ErrRateLimited)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientKey identifica a quien hace la petición: el usuario autenticado o,
+// si no lo hay, la IP del cliente que resolvió RealIP.
+func (rl *RateLimiter) clientKey(r *http.Request) string {
+	if authUser, ok := auth.GetAuthUser(r.Context()); ok && authUser.UserID != "" {
+		return "user:" + authUser.UserID
+	}
+	return "ip:" + requestIP(r)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -2,12 +2,21 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
 
 	"image-processing-service/internal/api/middleware"
+	"image-processing-service/internal/modules/activity"
+	"image-processing-service/internal/modules/apikey"
 	"image-processing-service/internal/modules/auth"
+	"image-processing-service/internal/modules/export"
 	"image-processing-service/internal/modules/file"
+	"image-processing-service/internal/modules/job"
+	"image-processing-service/internal/modules/quota"
 	"image-processing-service/internal/modules/user"
+	"image-processing-service/internal/modules/webhook"
+	sharedAuth "image-processing-service/internal/shared/auth"
 
 	"github.com/go-chi/chi/v5"
 	chi_middleware "github.com/go-chi/chi/v5/middleware"
@@ -18,43 +27,127 @@ type pingResponse struct {
 }
 
 func NewRouter(
+	trustedProxies []*net.IPNet,
 	authMW *middleware.AuthMiddleware,
+	rateLimiter *middleware.RateLimiter,
 	authHdl auth.Handler,
 	userHdl user.Handler,
 	fileHdl file.Handler,
+	quotaHdl quota.Handler,
+	jobHdl job.Handler,
+	webhookHdl webhook.Handler,
+	activityHdl activity.Handler,
+	apiKeyHdl apikey.Handler,
+	exportHdl export.Handler,
+	keyring *sharedAuth.Keyring,
 ) http.Handler {
 	r := chi.NewRouter()
 
 	r.Use(chi_middleware.Logger)
 	r.Use(chi_middleware.Recoverer)
+	r.Use(middleware.RealIP(trustedProxies))
 
 	r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(pingResponse{Message: "pong"})
 	})
 
+	// Claves públicas con las que otros servicios validan nuestros access
+	// tokens. Se sirven en el formato estándar, fuera del sobre de la API.
+	r.Get("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(sharedAuth.JWKSMaxAge.Seconds())))
+		json.NewEncoder(w).Encode(keyring.JWKS())
+	})
+
 	r.Route("/api", func(r chi.Router) {
 		r.Route("/v1/auth", func(r chi.Router) {
 			r.Post("/signup", authHdl.SignUp)
-			r.Post("/signin", authHdl.SignIn)
-			r.With(authMW.Authenticate).Post("/signout", authHdl.SignOut)
+			r.With(rateLimiter.SignIn).Post("/signin", authHdl.SignIn)
+			r.With(rateLimiter.SignIn).Post("/2fa/verify", authHdl.VerifyTwoFactor)
+			r.Get("/oidc/providers", authHdl.ListOIDCProviders)
+			r.With(rateLimiter.SignIn).Post("/oidc/{provider}/start", authHdl.StartOIDCLogin)
+			r.With(rateLimiter.SignIn).Post("/oidc/{provider}/callback", authHdl.CompleteOIDCLogin)
 			r.Post("/renew-session", authHdl.RenewSession)
+			r.Post("/verify-email", authHdl.VerifyEmail)
+			// Cada petición puede enviar un correo: comparten el límite del
+			// inicio de sesión.
+			r.With(rateLimiter.SignIn).Post("/forgot-password", authHdl.ForgotPassword)
+			r.With(rateLimiter.SignIn).Post("/reset-password", authHdl.ResetPassword)
+
+			r.Group(func(r chi.Router) {
+				r.Use(authMW.Authenticate, authMW.RequireSession)
+				r.Post("/signout", authHdl.SignOut)
+				r.Get("/sessions", authHdl.ListSessions)
+				r.Delete("/sessions", authHdl.SignOutEverywhere)
+				r.Delete("/sessions/{id}", authHdl.RevokeSession)
+				r.Get("/attempts", authHdl.ListAttempts)
+				r.Delete("/lockout", authHdl.Unlock)
+				r.Post("/verify-email/resend", authHdl.ResendVerification)
+				r.Get("/2fa", authHdl.TwoFactorStatus)
+				r.Post("/2fa/enroll", authHdl.EnrollTwoFactor)
+				r.Post("/2fa/confirm", authHdl.ConfirmTwoFactor)
+				r.Post("/2fa/disable", authHdl.DisableTwoFactor)
+				r.Post("/2fa/recovery-codes", authHdl.RegenerateRecoveryCodes)
+			})
 		})
 
 		r.Route("/v1/users", func(r chi.Router) {
-			r.Use(authMW.Authenticate)
-			r.Get("/", userHdl.GetAll)
-			r.Get("/{id}", userHdl.GetByID)
-			r.Patch("/{id}", userHdl.Update)
+			r.Use(authMW.Authenticate, authMW.RequireSession)
+			r.Get("/me/usage", quotaHdl.GetMine)
+			r.Get("/me/export", exportHdl.Export)
 			r.Patch("/change-password/me", userHdl.UpdatePassword)
-			r.Delete("/{id}", userHdl.Delete)
+
+			// Cada usuario gestiona su propia cuenta; un admin, cualquiera.
+			r.Group(func(r chi.Router) {
+				r.Use(authMW.RequireSelfOrRole("id", sharedAuth.RoleAdmin))
+				r.Get("/{id}", userHdl.GetByID)
+				r.Patch("/{id}", userHdl.Update)
+				r.Delete("/{id}", userHdl.Delete)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(authMW.RequireRole(sharedAuth.RoleAdmin))
+				r.Get("/", userHdl.GetAll)
+				r.Patch("/{id}/role", userHdl.UpdateRole)
+				r.Delete("/{id}/lockout", authHdl.UnlockUser)
+			})
 		})
 
+		// Las rutas de archivos y trabajos admiten API keys con el scope
+		// correspondiente.
 		r.Route("/v1/files", func(r chi.Router) {
 			r.Use(authMW.Authenticate)
-			r.Get("/", fileHdl.ListMine)
-			r.Get("/*", fileHdl.GetOne)
-			r.Post("/", fileHdl.Upload)
+			read := authMW.RequireScope(sharedAuth.ScopeFilesRead)
+			r.With(read).Get("/", fileHdl.ListMine)
+			r.With(read, rateLimiter.Delivery).Get("/*", fileHdl.GetOne)
+			r.With(read, rateLimiter.Delivery).Head("/*", fileHdl.GetOne)
+			r.With(authMW.RequireScope(sharedAuth.ScopeFilesWrite), rateLimiter.Uploads).Post("/", fileHdl.Upload)
+			r.With(read).Get("/{id}/similar", fileHdl.Similar)
+			r.With(authMW.RequireScope(sharedAuth.ScopeFilesDelete)).Delete("/{id}", fileHdl.Delete)
+		})
+
+		r.Route("/v1/jobs", func(r chi.Router) {
+			r.Use(authMW.Authenticate, authMW.RequireScope(sharedAuth.ScopeFilesRead))
+			r.Get("/{id}", jobHdl.GetByID)
+		})
+
+		r.With(authMW.Authenticate, authMW.RequireSession).Get("/v1/events", activityHdl.Stream)
+
+		r.Route("/v1/webhooks", func(r chi.Router) {
+			r.Use(authMW.Authenticate, authMW.RequireSession)
+			r.Get("/", webhookHdl.List)
+			r.Post("/", webhookHdl.Create)
+			r.Delete("/{id}", webhookHdl.Delete)
+			r.Get("/{id}/deliveries", webhookHdl.ListDeliveries)
+			r.Post("/{id}/deliveries/{deliveryId}/redeliver", webhookHdl.Redeliver)
+		})
+
+		r.Route("/v1/api-keys", func(r chi.Router) {
+			r.Use(authMW.Authenticate, authMW.RequireSession)
+			r.Get("/", apiKeyHdl.List)
+			r.Post("/", apiKeyHdl.Create)
+			r.Delete("/{id}", apiKeyHdl.Delete)
 		})
 	})
 
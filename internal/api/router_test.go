@@ -0,0 +1,3548 @@
+package api_test
+
+// Los tests end-to-end levantan el router completo con apitest: SQLite como
+// base de datos y un StorageProvider en memoria. Verifican los flujos
+// principales de la API tal como los vería un cliente HTTP.
+//
+// Estrategia:
+//   - Cada test crea su propio servidor: no hay estado compartido.
+//   - Se usan los helpers de apitest para registrar usuarios y subir imágenes.
+//   - Se verifican códigos de estado, cabeceras y el contenido servido.
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"image-processing-service/internal/api/apitest"
+	"image-processing-service/internal/api/middleware"
+	"image-processing-service/internal/modules/auth"
+	"image-processing-service/internal/modules/export"
+	"image-processing-service/internal/modules/file"
+	"image-processing-service/internal/modules/quota"
+	"image-processing-service/internal/modules/user"
+	"image-processing-service/internal/modules/webhook"
+	sharedAuth "image-processing-service/internal/shared/auth"
+	"image-processing-service/internal/shared/oidc/oidctest"
+	"image-processing-service/internal/shared/outbox"
+	"image-processing-service/internal/shared/password"
+	"image-processing-service/internal/shared/ratelimit"
+	"image-processing-service/internal/shared/totp"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type fileResponse struct {
+	ID           string `json:"id"`
+	OriginalName string `json:"originalName"`
+	MimeType     string `json:"mimeType"`
+	Size         int64  `json:"size"`
+	Width        int64  `json:"width"`
+	Height       int64  `json:"height"`
+	Format       string `json:"format"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnailUrl"`
+	Status       string `json:"status"`
+	JobID        string `json:"jobId"`
+}
+
+// uploadPNG sube un PNG de las dimensiones dadas y devuelve la respuesta.
+func uploadPNG(t *testing.T, srv *apitest.Server, token string, width, height int) fileResponse {
+	t.Helper()
+
+	return uploadPNGContent(t, srv, token, apitest.NewPNG(t, width, height))
+}
+
+// pathOf convierte una URL absoluta de la API en una ruta relativa al servidor.
+func pathOf(srv *apitest.Server, url string) string {
+	return strings.TrimPrefix(url, srv.URL)
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Auth
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRouter_Auth(t *testing.T) {
+	t.Run("Debe registrar e iniciar sesión devolviendo el par de tokens", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+
+		// WHEN
+		tokens := srv.SignIn(t, "ana@test.com", "secreto123")
+
+		// THEN
+		assert.NotEmpty(t, tokens.AccessToken)
+		assert.NotEmpty(t, tokens.RefreshToken)
+	})
+
+	t.Run("Debe retornar 409 cuando el email ya está registrado", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodPost, "/api/v1/auth/signup", map[string]string{
+			"name": "Otra Ana", "email": "ana@test.com", "password": "secreto123",
+		}, "")
+
+		// THEN
+		assert.Equal(t, http.StatusConflict, res.StatusCode)
+		assert.Equal(t, "USER_ALREADY_EXISTS", env.Error.Code)
+	})
+
+	t.Run("Debe retornar 401 cuando la contraseña es incorrecta", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodPost, "/api/v1/auth/signin", map[string]string{
+			"email": "ana@test.com", "password": "incorrecta",
+		}, "")
+
+		// THEN
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		assert.Equal(t, "INVALID_CREDENTIALS", env.Error.Code)
+	})
+
+	t.Run("Debe rechazar el access token después de cerrar sesión", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		res, _ := srv.JSON(t, http.MethodPost, "/api/v1/auth/signout", nil, token)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodGet, "/api/v1/files/", nil, token)
+
+		// THEN
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		assert.Equal(t, "INVALID_TOKEN", env.Error.Code)
+	})
+
+	t.Run("Debe aceptar contraseñas de más de 32 caracteres", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		password := strings.Repeat("frase-de-paso-", 8)
+		srv.SignUp(t, "Ana García", "ana@test.com", password)
+
+		// WHEN
+		tokens := srv.SignIn(t, "ana@test.com", password)
+
+		// THEN
+		assert.NotEmpty(t, tokens.AccessToken)
+		var stored user.User
+		require.NoError(t, srv.DB.First(&stored, "email = ?", "ana@test.com").Error)
+		assert.True(t, strings.HasPrefix(stored.Password, "$argon2id$"))
+	})
+
+	t.Run("Debe recalcular con argon2id el hash bcrypt al iniciar sesión", func(t *testing.T) {
+		// GIVEN: un usuario registrado antes del cambio a argon2id
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		legacy, err := bcrypt.GenerateFromPassword([]byte("secreto123"), bcrypt.MinCost)
+		require.NoError(t, err)
+		require.NoError(t, srv.DB.Model(&user.User{}).Where("email = ?", "ana@test.com").
+			Update("password", string(legacy)).Error)
+
+		// WHEN
+		srv.SignIn(t, "ana@test.com", "secreto123")
+
+		// THEN: el hash nuevo sigue sirviendo para iniciar sesión
+		var stored user.User
+		require.NoError(t, srv.DB.First(&stored, "email = ?", "ana@test.com").Error)
+		assert.True(t, strings.HasPrefix(stored.Password, "$argon2id$"))
+		assert.NotEmpty(t, srv.SignIn(t, "ana@test.com", "secreto123").AccessToken)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Upload
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRouter_Upload(t *testing.T) {
+	t.Run("Debe retornar 401 cuando no se envía token", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+
+		// WHEN
+		res := srv.Upload(t, "", "foto.png", "image/png", apitest.NewPNG(t, 10, 10))
+
+		// THEN
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("Debe retornar 415 cuando el archivo no es una imagen", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+
+		// WHEN
+		res := srv.Upload(t, token, "notas.txt", "text/plain", []byte("no soy una imagen"))
+
+		// THEN
+		assert.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+		assert.Equal(t, "UNSUPPORTED_FILE_TYPE", apitest.DecodeEnvelope(t, res).Error.Code)
+	})
+
+	t.Run("Debe guardar la imagen y devolver sus metadatos", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+
+		// WHEN
+		uploaded := uploadPNG(t, srv, token, 640, 480)
+
+		// THEN
+		assert.NotEmpty(t, uploaded.ID)
+		assert.Equal(t, "foto.png", uploaded.OriginalName)
+		assert.Equal(t, "image/png", uploaded.MimeType)
+		assert.Equal(t, "PNG", uploaded.Format)
+		assert.Equal(t, int64(640), uploaded.Width)
+		assert.Equal(t, int64(480), uploaded.Height)
+		assert.True(t, strings.HasPrefix(uploaded.URL, srv.URL+"/api/v1/files/"))
+		assert.NotEmpty(t, uploaded.ThumbnailURL)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// ListMine
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRouter_ListMine(t *testing.T) {
+	t.Run("Debe listar solo las imágenes del usuario autenticado", func(t *testing.T) {
+		// GIVEN: dos usuarios con imágenes propias
+		srv := apitest.New(t)
+		tokenAna := srv.NewUser(t)
+		tokenLuis := srv.NewUser(t)
+		first := uploadPNG(t, srv, tokenAna, 20, 20)
+		second := uploadPNG(t, srv, tokenAna, 30, 30)
+		uploadPNG(t, srv, tokenLuis, 40, 40)
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodGet, "/api/v1/files/", nil, tokenAna)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var files []fileResponse
+		env.Decode(t, &files)
+		require.Len(t, files, 2)
+		ids := []string{files[0].ID, files[1].ID}
+		assert.ElementsMatch(t, []string{first.ID, second.ID}, ids)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// GetOne
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRouter_GetOne(t *testing.T) {
+	t.Run("Debe servir la imagen original tal como se subió", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		content := apitest.NewPNG(t, 64, 48)
+		res := srv.Upload(t, token, "foto.png", "image/png", content)
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		var uploaded fileResponse
+		apitest.DecodeEnvelope(t, res).Decode(t, &uploaded)
+
+		// WHEN
+		res = srv.Do(t, http.MethodGet, pathOf(srv, uploaded.URL), nil, "", token)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "image/png", res.Header.Get("Content-Type"))
+		served, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		assert.Equal(t, content, served)
+	})
+
+	t.Run("Debe servir una miniatura JPEG dentro de 200x200", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		uploaded := uploadPNG(t, srv, token, 800, 400)
+
+		// WHEN
+		res := srv.Do(t, http.MethodGet, pathOf(srv, uploaded.ThumbnailURL), nil, "", token)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "image/jpeg", res.Header.Get("Content-Type"))
+		served, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		config, format, err := image.DecodeConfig(bytes.NewReader(served))
+		require.NoError(t, err)
+		assert.Equal(t, "jpeg", format)
+		assert.Equal(t, 200, config.Width)
+		assert.Equal(t, 100, config.Height)
+	})
+
+	t.Run("Debe retornar 404 cuando la imagen pertenece a otro usuario", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		owner := srv.NewUser(t)
+		intruder := srv.NewUser(t)
+		uploaded := uploadPNG(t, srv, owner, 20, 20)
+
+		// WHEN
+		res := srv.Do(t, http.MethodGet, pathOf(srv, uploaded.URL), nil, "", intruder)
+
+		// THEN
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+		assert.Equal(t, "FILE_NOT_FOUND", apitest.DecodeEnvelope(t, res).Error.Code)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Caché HTTP
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRouter_GetOneCaching(t *testing.T) {
+	t.Run("Debe enviar ETag, Last-Modified, Content-Length y Cache-Control", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		content := apitest.NewPNG(t, 32, 32)
+		res := srv.Upload(t, token, "foto.png", "image/png", content)
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		var uploaded fileResponse
+		apitest.DecodeEnvelope(t, res).Decode(t, &uploaded)
+
+		// WHEN
+		res = srv.Do(t, http.MethodGet, pathOf(srv, uploaded.URL), nil, "", token)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, `"`+sha256Hex(content)+`"`, res.Header.Get("ETag"))
+		assert.NotEmpty(t, res.Header.Get("Last-Modified"))
+		assert.Equal(t, strconv.Itoa(len(content)), res.Header.Get("Content-Length"))
+		assert.Equal(t, apitest.CachePolicy.Originals, res.Header.Get("Cache-Control"))
+		assert.Equal(t, "bytes", res.Header.Get("Accept-Ranges"))
+	})
+
+	t.Run("Debe usar la política de derivadas para la miniatura", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		uploaded := uploadPNG(t, srv, token, 300, 300)
+
+		// WHEN
+		res := srv.Do(t, http.MethodGet, pathOf(srv, uploaded.ThumbnailURL), nil, "", token)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, apitest.CachePolicy.Derivatives, res.Header.Get("Cache-Control"))
+		assert.NotEmpty(t, res.Header.Get("ETag"))
+	})
+
+	t.Run("Debe retornar 304 cuando If-None-Match coincide con el ETag", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		uploaded := uploadPNG(t, srv, token, 32, 32)
+		first := srv.Do(t, http.MethodGet, pathOf(srv, uploaded.URL), nil, "", token)
+		etag := first.Header.Get("ETag")
+
+		// WHEN
+		res := srv.Do(t, http.MethodGet, pathOf(srv, uploaded.URL), nil, "", token, http.Header{"If-None-Match": {etag}})
+
+		// THEN
+		assert.Equal(t, http.StatusNotModified, res.StatusCode)
+		assert.Equal(t, etag, res.Header.Get("ETag"))
+		body, _ := io.ReadAll(res.Body)
+		assert.Empty(t, body)
+	})
+
+	t.Run("Debe retornar 200 cuando If-None-Match no coincide", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		uploaded := uploadPNG(t, srv, token, 32, 32)
+
+		// WHEN
+		res := srv.Do(t, http.MethodGet, pathOf(srv, uploaded.URL), nil, "", token, http.Header{"If-None-Match": {`"otro"`}})
+
+		// THEN
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	})
+
+	t.Run("Debe retornar 304 cuando If-Modified-Since es posterior a la subida", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		uploaded := uploadPNG(t, srv, token, 32, 32)
+		since := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+
+		// WHEN
+		res := srv.Do(t, http.MethodGet, pathOf(srv, uploaded.URL), nil, "", token, http.Header{"If-Modified-Since": {since}})
+
+		// THEN
+		assert.Equal(t, http.StatusNotModified, res.StatusCode)
+	})
+
+	t.Run("Debe servir solo el rango de bytes pedido con 206", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		content := apitest.NewPNG(t, 32, 32)
+		res := srv.Upload(t, token, "foto.png", "image/png", content)
+		var uploaded fileResponse
+		apitest.DecodeEnvelope(t, res).Decode(t, &uploaded)
+
+		// WHEN
+		res = srv.Do(t, http.MethodGet, pathOf(srv, uploaded.URL), nil, "", token, http.Header{"Range": {"bytes=10-19"}})
+
+		// THEN
+		require.Equal(t, http.StatusPartialContent, res.StatusCode)
+		assert.Equal(t, "bytes 10-19/"+strconv.Itoa(len(content)), res.Header.Get("Content-Range"))
+		assert.Equal(t, "10", res.Header.Get("Content-Length"))
+		served, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		assert.Equal(t, content[10:20], served)
+	})
+
+	t.Run("Debe servir los últimos N bytes con un rango de sufijo", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		content := apitest.NewPNG(t, 32, 32)
+		res := srv.Upload(t, token, "foto.png", "image/png", content)
+		var uploaded fileResponse
+		apitest.DecodeEnvelope(t, res).Decode(t, &uploaded)
+
+		// WHEN
+		res = srv.Do(t, http.MethodGet, pathOf(srv, uploaded.URL), nil, "", token, http.Header{"Range": {"bytes=-5"}})
+
+		// THEN
+		require.Equal(t, http.StatusPartialContent, res.StatusCode)
+		served, _ := io.ReadAll(res.Body)
+		assert.Equal(t, content[len(content)-5:], served)
+	})
+
+	t.Run("Debe retornar 416 cuando el rango empieza fuera del archivo", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		uploaded := uploadPNG(t, srv, token, 32, 32)
+
+		// WHEN
+		res := srv.Do(t, http.MethodGet, pathOf(srv, uploaded.URL), nil, "", token, http.Header{"Range": {"bytes=999999-"}})
+
+		// THEN
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, res.StatusCode)
+		assert.True(t, strings.HasPrefix(res.Header.Get("Content-Range"), "bytes */"))
+	})
+
+	t.Run("Debe ignorar el rango cuando If-Range no coincide", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		uploaded := uploadPNG(t, srv, token, 32, 32)
+
+		// WHEN
+		res := srv.Do(t, http.MethodGet, pathOf(srv, uploaded.URL), nil, "", token, http.Header{
+			"Range":    {"bytes=0-9"},
+			"If-Range": {`"version-anterior"`},
+		})
+
+		// THEN
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	})
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Deduplicación y Delete
+// ─────────────────────────────────────────────────────────────────────────────
+
+// countBlobs devuelve cuántos objetos físicos distintos hay registrados.
+func countBlobs(t *testing.T, srv *apitest.Server) int64 {
+	t.Helper()
+
+	var count int64
+	require.NoError(t, srv.DB.Model(&file.Blob{}).Count(&count).Error)
+	return count
+}
+
+func TestRouter_Deduplication(t *testing.T) {
+	t.Run("Debe reutilizar el objeto cuando el mismo usuario sube el mismo contenido", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		content := apitest.NewPNG(t, 50, 50)
+		first := srv.Upload(t, token, "a.png", "image/png", content)
+		require.Equal(t, http.StatusCreated, first.StatusCode)
+
+		// WHEN
+		res := srv.Upload(t, token, "b.png", "image/png", content)
+
+		// THEN: dos archivos con URL propia pero un solo blob
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		var uploaded fileResponse
+		apitest.DecodeEnvelope(t, res).Decode(t, &uploaded)
+		assert.Equal(t, int64(1), countBlobs(t, srv))
+		served := srv.Do(t, http.MethodGet, pathOf(srv, uploaded.URL), nil, "", token)
+		require.Equal(t, http.StatusOK, served.StatusCode)
+		body, _ := io.ReadAll(served.Body)
+		assert.Equal(t, content, body)
+		srv.WaitForJobs(t)
+		thumb := srv.Do(t, http.MethodGet, pathOf(srv, uploaded.ThumbnailURL), nil, "", token)
+		assert.Equal(t, http.StatusOK, thumb.StatusCode)
+	})
+
+	t.Run("No debe compartir objetos entre usuarios por defecto", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		content := apitest.NewPNG(t, 50, 50)
+		srv.Upload(t, srv.NewUser(t), "a.png", "image/png", content)
+
+		// WHEN
+		res := srv.Upload(t, srv.NewUser(t), "a.png", "image/png", content)
+
+		// THEN
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		assert.Equal(t, int64(2), countBlobs(t, srv))
+	})
+
+	t.Run("Debe compartir objetos entre usuarios cuando está habilitado", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t, apitest.WithFileConfig(file.ServiceConfig{DedupAcrossUsers: true}))
+		content := apitest.NewPNG(t, 50, 50)
+		srv.Upload(t, srv.NewUser(t), "a.png", "image/png", content)
+
+		// WHEN
+		res := srv.Upload(t, srv.NewUser(t), "a.png", "image/png", content)
+
+		// THEN
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		assert.Equal(t, int64(1), countBlobs(t, srv))
+	})
+}
+
+func TestRouter_Delete(t *testing.T) {
+	t.Run("Debe conservar el objeto mientras otro archivo lo referencie", func(t *testing.T) {
+		// GIVEN: dos archivos con el mismo contenido
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		content := apitest.NewPNG(t, 50, 50)
+		first := uploadPNGContent(t, srv, token, content)
+		second := uploadPNGContent(t, srv, token, content)
+
+		// WHEN
+		res, _ := srv.JSON(t, http.MethodDelete, "/api/v1/files/"+first.ID, nil, token)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		gone := srv.Do(t, http.MethodGet, pathOf(srv, first.URL), nil, "", token)
+		assert.Equal(t, http.StatusNotFound, gone.StatusCode)
+		kept := srv.Do(t, http.MethodGet, pathOf(srv, second.URL), nil, "", token)
+		assert.Equal(t, http.StatusOK, kept.StatusCode)
+	})
+
+	t.Run("Debe borrar el objeto físico al eliminar la última referencia", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		uploaded := uploadPNG(t, srv, token, 50, 50)
+		var blob file.Blob
+		require.NoError(t, srv.DB.First(&blob).Error)
+
+		// WHEN
+		res, _ := srv.JSON(t, http.MethodDelete, "/api/v1/files/"+uploaded.ID, nil, token)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Zero(t, countBlobs(t, srv))
+		_, err := srv.Storage.Get(blob.ObjectKey)
+		assert.Error(t, err)
+		_, err = srv.Storage.Get(blob.ThumbnailObjectKey)
+		assert.Error(t, err)
+	})
+
+	t.Run("Debe retornar 404 cuando el archivo es de otro usuario", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		uploaded := uploadPNG(t, srv, srv.NewUser(t), 50, 50)
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodDelete, "/api/v1/files/"+uploaded.ID, nil, srv.NewUser(t))
+
+		// THEN
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+		assert.Equal(t, "FILE_NOT_FOUND", env.Error.Code)
+	})
+}
+
+// uploadPNGContent sube content y espera a que termine su procesamiento, de
+// modo que la miniatura y los hashes ya están disponibles.
+func uploadPNGContent(t *testing.T, srv *apitest.Server, token string, content []byte) fileResponse {
+	t.Helper()
+
+	res := srv.Upload(t, token, "foto.png", "image/png", content)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	var uploaded fileResponse
+	apitest.DecodeEnvelope(t, res).Decode(t, &uploaded)
+	srv.WaitForJobs(t)
+	return uploaded
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Similar
+// ─────────────────────────────────────────────────────────────────────────────
+
+type similarResponse struct {
+	fileResponse
+	Distance int `json:"distance"`
+}
+
+// checkerboardPNG genera un tablero de ajedrez, visualmente muy distinto del
+// degradado de apitest.NewPNG.
+func checkerboardPNG(t *testing.T, size int) []byte {
+	t.Helper()
+
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if (x/(size/8)+y/(size/8))%2 == 0 {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestRouter_Similar(t *testing.T) {
+	t.Run("Debe encontrar la versión redimensionada y excluir imágenes distintas", func(t *testing.T) {
+		// GIVEN: un original, su versión reducida y una imagen distinta
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		original := uploadPNG(t, srv, token, 400, 300)
+		resized := uploadPNG(t, srv, token, 200, 150)
+		uploadPNGContent(t, srv, token, checkerboardPNG(t, 256))
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodGet, "/api/v1/files/"+original.ID+"/similar?threshold=6", nil, token)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var similar []similarResponse
+		env.Decode(t, &similar)
+		require.Len(t, similar, 1)
+		assert.Equal(t, resized.ID, similar[0].ID)
+		assert.LessOrEqual(t, similar[0].Distance, 6)
+	})
+
+	t.Run("No debe devolver imágenes de otros usuarios", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		original := uploadPNG(t, srv, token, 400, 300)
+		uploadPNG(t, srv, srv.NewUser(t), 400, 300)
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodGet, "/api/v1/files/"+original.ID+"/similar", nil, token)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var similar []similarResponse
+		env.Decode(t, &similar)
+		assert.Empty(t, similar)
+	})
+
+	t.Run("Debe retornar 422 cuando threshold está fuera de rango", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		original := uploadPNG(t, srv, token, 40, 30)
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodGet, "/api/v1/files/"+original.ID+"/similar?threshold=99", nil, token)
+
+		// THEN
+		assert.Equal(t, http.StatusUnprocessableEntity, res.StatusCode)
+		assert.Equal(t, "VALIDATION_FAILED", env.Error.Code)
+	})
+
+	t.Run("Debe retornar 409 cuando la imagen no tiene hash perceptual", func(t *testing.T) {
+		// GIVEN: un archivo anterior al cálculo de hashes
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		original := uploadPNG(t, srv, token, 40, 30)
+		require.NoError(t, srv.DB.Model(&file.File{}).Where("id = ?", original.ID).
+			Updates(map[string]interface{}{"a_hash": nil, "d_hash": nil, "p_hash": nil}).Error)
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodGet, "/api/v1/files/"+original.ID+"/similar", nil, token)
+
+		// THEN
+		assert.Equal(t, http.StatusConflict, res.StatusCode)
+		assert.Equal(t, "PERCEPTUAL_HASH_UNAVAILABLE", env.Error.Code)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Quota
+// ─────────────────────────────────────────────────────────────────────────────
+
+type usageResponse struct {
+	UsedBytes int64  `json:"used_bytes"`
+	UsedFiles int64  `json:"used_files"`
+	MaxBytes  *int64 `json:"max_bytes"`
+	MaxFiles  *int64 `json:"max_files"`
+}
+
+func getUsage(t *testing.T, srv *apitest.Server, token string) usageResponse {
+	t.Helper()
+
+	res, env := srv.JSON(t, http.MethodGet, "/api/v1/users/me/usage", nil, token)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var usage usageResponse
+	env.Decode(t, &usage)
+	return usage
+}
+
+// storedBytes suma lo que ocupan en la base de datos los archivos del usuario,
+// originales más miniaturas.
+func storedBytes(t *testing.T, srv *apitest.Server) int64 {
+	t.Helper()
+
+	var total int64
+	require.NoError(t, srv.DB.Model(&file.File{}).
+		Select("COALESCE(SUM(file_size + thumbnail_size), 0)").Scan(&total).Error)
+	return total
+}
+
+func TestRouter_Quota(t *testing.T) {
+	t.Run("Debe contar originales y miniaturas de cada archivo, también los deduplicados", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		content := apitest.NewPNG(t, 50, 50)
+
+		// WHEN
+		uploadPNGContent(t, srv, token, content)
+		uploadPNGContent(t, srv, token, content)
+
+		// THEN
+		usage := getUsage(t, srv, token)
+		assert.Equal(t, int64(2), usage.UsedFiles)
+		assert.Equal(t, storedBytes(t, srv), usage.UsedBytes)
+		assert.Greater(t, usage.UsedBytes, 2*int64(len(content)))
+		assert.Nil(t, usage.MaxBytes)
+		assert.Nil(t, usage.MaxFiles)
+	})
+
+	t.Run("Debe rechazar con QUOTA_EXCEEDED la subida que supera la cuota", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t, apitest.WithQuotaLimits(quota.Limits{MaxFiles: 1}))
+		token := srv.NewUser(t)
+		uploadPNG(t, srv, token, 50, 50)
+
+		// WHEN
+		res := srv.Upload(t, token, "foto.png", "image/png", apitest.NewPNG(t, 60, 60))
+
+		// THEN
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+		assert.Equal(t, "QUOTA_EXCEEDED", apitest.DecodeEnvelope(t, res).Error.Code)
+		usage := getUsage(t, srv, token)
+		assert.Equal(t, int64(1), usage.UsedFiles)
+		require.NotNil(t, usage.MaxFiles)
+		assert.Equal(t, int64(1), *usage.MaxFiles)
+	})
+
+	t.Run("Debe rechazar la subida cuando los bytes no caben", func(t *testing.T) {
+		// GIVEN: una cuota menor que la imagen
+		srv := apitest.New(t, apitest.WithQuotaLimits(quota.Limits{MaxBytes: 100}))
+		token := srv.NewUser(t)
+
+		// WHEN
+		res := srv.Upload(t, token, "foto.png", "image/png", apitest.NewPNG(t, 50, 50))
+
+		// THEN
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+		assert.Zero(t, getUsage(t, srv, token).UsedBytes)
+		assert.Zero(t, countBlobs(t, srv))
+	})
+
+	t.Run("Debe liberar el espacio al eliminar un archivo", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t, apitest.WithQuotaLimits(quota.Limits{MaxFiles: 1}))
+		token := srv.NewUser(t)
+		uploaded := uploadPNG(t, srv, token, 50, 50)
+
+		// WHEN
+		res, _ := srv.JSON(t, http.MethodDelete, "/api/v1/files/"+uploaded.ID, nil, token)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		usage := getUsage(t, srv, token)
+		assert.Zero(t, usage.UsedFiles)
+		assert.Zero(t, usage.UsedBytes)
+		uploadPNG(t, srv, token, 60, 60)
+	})
+
+	t.Run("Debe respetar la cuota con subidas concurrentes", func(t *testing.T) {
+		// GIVEN
+		const limit, attempts = 3, 8
+		srv := apitest.New(t, apitest.WithQuotaLimits(quota.Limits{MaxFiles: limit}))
+		token := srv.NewUser(t)
+		images := make([][]byte, attempts)
+		for i := range images {
+			images[i] = apitest.NewPNG(t, 40+i, 40+i)
+		}
+
+		// WHEN
+		statuses := make([]int, attempts)
+		var wg sync.WaitGroup
+		for i := range images {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				statuses[i] = srv.Upload(t, token, "foto.png", "image/png", images[i]).StatusCode
+			}(i)
+		}
+		wg.Wait()
+		srv.WaitForJobs(t)
+
+		// THEN
+		created := 0
+		for _, status := range statuses {
+			if status == http.StatusCreated {
+				created++
+			} else {
+				assert.Equal(t, http.StatusForbidden, status)
+			}
+		}
+		assert.Equal(t, limit, created)
+		usage := getUsage(t, srv, token)
+		assert.Equal(t, int64(limit), usage.UsedFiles)
+		assert.Equal(t, storedBytes(t, srv), usage.UsedBytes)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Jobs
+// ─────────────────────────────────────────────────────────────────────────────
+
+type jobResponse struct {
+	ID            string   `json:"id"`
+	Type          string   `json:"type"`
+	Status        string   `json:"status"`
+	Progress      int      `json:"progress"`
+	Attempts      int      `json:"attempts"`
+	Error         *string  `json:"error"`
+	ResultFileIDs []string `json:"result_file_ids"`
+}
+
+func TestRouter_Jobs(t *testing.T) {
+	t.Run("Debe aceptar la subida y generar la miniatura en un trabajo", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+
+		// WHEN
+		res := srv.Upload(t, token, "foto.png", "image/png", apitest.NewPNG(t, 300, 300))
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		var uploaded fileResponse
+		apitest.DecodeEnvelope(t, res).Decode(t, &uploaded)
+		srv.WaitForJobs(t)
+
+		// THEN: el trabajo terminó y devuelve el archivo procesado
+		assert.Equal(t, "processing", uploaded.Status)
+		require.NotEmpty(t, uploaded.JobID)
+		jobRes, env := srv.JSON(t, http.MethodGet, "/api/v1/jobs/"+uploaded.JobID, nil, token)
+		require.Equal(t, http.StatusOK, jobRes.StatusCode)
+		var processed jobResponse
+		env.Decode(t, &processed)
+		assert.Equal(t, "succeeded", processed.Status)
+		assert.Equal(t, 100, processed.Progress)
+		assert.Equal(t, 1, processed.Attempts)
+		assert.Nil(t, processed.Error)
+		assert.Equal(t, []string{uploaded.ID}, processed.ResultFileIDs)
+
+		thumb := srv.Do(t, http.MethodGet, pathOf(srv, uploaded.ThumbnailURL), nil, "", token)
+		assert.Equal(t, http.StatusOK, thumb.StatusCode)
+	})
+
+	t.Run("Debe responder FILE_PROCESSING mientras la miniatura no existe", func(t *testing.T) {
+		// GIVEN: un archivo cuyo blob todavía no se procesó
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		uploaded := uploadPNG(t, srv, token, 50, 50)
+		require.NoError(t, srv.DB.Model(&file.Blob{}).Where("1 = 1").
+			Update("thumbnail_object_key", "").Error)
+
+		// WHEN
+		res := srv.Do(t, http.MethodGet, pathOf(srv, uploaded.ThumbnailURL), nil, "", token)
+
+		// THEN
+		assert.Equal(t, http.StatusConflict, res.StatusCode)
+		assert.Equal(t, "FILE_PROCESSING", apitest.DecodeEnvelope(t, res).Error.Code)
+	})
+
+	t.Run("Debe mandar a la cola de muertos un trabajo que no puede procesarse", func(t *testing.T) {
+		// GIVEN: el original desaparece del storage antes del procesamiento
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		content := apitest.NewPNG(t, 50, 50)
+		res := srv.Upload(t, token, "foto.png", "image/png", content)
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		var uploaded fileResponse
+		apitest.DecodeEnvelope(t, res).Decode(t, &uploaded)
+		srv.WaitForJobs(t)
+		var blob file.Blob
+		require.NoError(t, srv.DB.First(&blob).Error)
+		_, err := srv.Storage.Save(strings.NewReader("no es una imagen"), blob.ObjectKey, "image/png")
+		require.NoError(t, err)
+		require.NoError(t, srv.DB.Model(&file.Blob{}).Where("id = ?", blob.ID).
+			Update("thumbnail_object_key", "").Error)
+		require.NoError(t, srv.DB.Table("jobs").Where("id = ?", uploaded.JobID).
+			Updates(map[string]interface{}{"status": "pending", "attempts": 0}).Error)
+
+		// WHEN
+		srv.WaitForJobs(t)
+
+		// THEN: el error de decodificación es permanente
+		_, env := srv.JSON(t, http.MethodGet, "/api/v1/jobs/"+uploaded.JobID, nil, token)
+		var dead jobResponse
+		env.Decode(t, &dead)
+		assert.Equal(t, "dead", dead.Status)
+		assert.Equal(t, 1, dead.Attempts)
+		require.NotNil(t, dead.Error)
+
+		// THEN: el archivo queda fallido en lugar de en proceso
+		var failed file.File
+		require.NoError(t, srv.DB.Where("id = ?", uploaded.ID).First(&failed).Error)
+		assert.True(t, failed.ProcessingFailed)
+		thumbnail := srv.Do(t, http.MethodGet, pathOf(srv, uploaded.ThumbnailURL), nil, "", token)
+		assert.Equal(t, http.StatusUnprocessableEntity, thumbnail.StatusCode)
+		assert.Equal(t, "FILE_PROCESSING_FAILED", apitest.DecodeEnvelope(t, thumbnail).Error.Code)
+
+		// THEN: el mismo contenido subido otra vez no reutiliza el blob fallido
+		again := uploadPNGContent(t, srv, token, content)
+		assert.NotEmpty(t, again.JobID)
+		assert.Equal(t, int64(2), countBlobs(t, srv))
+		processed := srv.Do(t, http.MethodGet, pathOf(srv, again.ThumbnailURL), nil, "", token)
+		assert.Equal(t, http.StatusOK, processed.StatusCode)
+	})
+
+	t.Run("Debe retornar 404 cuando el trabajo es de otro usuario", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		res := srv.Upload(t, srv.NewUser(t), "foto.png", "image/png", apitest.NewPNG(t, 50, 50))
+		var uploaded fileResponse
+		apitest.DecodeEnvelope(t, res).Decode(t, &uploaded)
+
+		// WHEN
+		jobRes, env := srv.JSON(t, http.MethodGet, "/api/v1/jobs/"+uploaded.JobID, nil, srv.NewUser(t))
+
+		// THEN
+		assert.Equal(t, http.StatusNotFound, jobRes.StatusCode)
+		assert.Equal(t, "JOB_NOT_FOUND", env.Error.Code)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Webhooks
+// ─────────────────────────────────────────────────────────────────────────────
+
+type receivedWebhook struct {
+	Header http.Header
+	Body   []byte
+}
+
+// webhookReceiver es un servidor que registra cada entrega. status decide el
+// código de respuesta según el número de petición (empezando en 1).
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	received []receivedWebhook
+}
+
+func newWebhookReceiver(t *testing.T, status func(n int) int) *webhookReceiver {
+	t.Helper()
+
+	rcv := &webhookReceiver{}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		rcv.mu.Lock()
+		rcv.received = append(rcv.received, receivedWebhook{Header: r.Header.Clone(), Body: body})
+		n := len(rcv.received)
+		rcv.mu.Unlock()
+
+		w.WriteHeader(status(n))
+	}))
+	t.Cleanup(rcv.Close)
+	return rcv
+}
+
+func alwaysOK(int) int { return http.StatusOK }
+
+func (rcv *webhookReceiver) count() int {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	return len(rcv.received)
+}
+
+// byEvent devuelve las entregas recibidas de un tipo de evento.
+func (rcv *webhookReceiver) byEvent(eventType string) []receivedWebhook {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	var matches []receivedWebhook
+	for _, r := range rcv.received {
+		if r.Header.Get(webhook.EventHeader) == eventType {
+			matches = append(matches, r)
+		}
+	}
+	return matches
+}
+
+type webhookResponse struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+type deliveryResponse struct {
+	ID           string  `json:"id"`
+	EventType    string  `json:"event_type"`
+	Status       string  `json:"status"`
+	Attempts     int     `json:"attempts"`
+	Error        *string `json:"error"`
+	RedeliveryOf string  `json:"redelivery_of"`
+}
+
+type webhookEvent struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+func createWebhook(t *testing.T, srv *apitest.Server, token, url string, events ...string) webhookResponse {
+	t.Helper()
+
+	res, env := srv.JSON(t, http.MethodPost, "/api/v1/webhooks/", map[string]interface{}{
+		"url":    url,
+		"events": events,
+	}, token)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	var created webhookResponse
+	env.Decode(t, &created)
+	return created
+}
+
+func listDeliveries(t *testing.T, srv *apitest.Server, token, webhookID string) []deliveryResponse {
+	t.Helper()
+
+	res, env := srv.JSON(t, http.MethodGet, "/api/v1/webhooks/"+webhookID+"/deliveries", nil, token)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var deliveries []deliveryResponse
+	env.Decode(t, &deliveries)
+	return deliveries
+}
+
+func TestRouter_Webhooks(t *testing.T) {
+	t.Run("Debe entregar file.uploaded y job.completed firmados con el secreto", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		rcv := newWebhookReceiver(t, alwaysOK)
+		hook := createWebhook(t, srv, token, rcv.URL, "file.uploaded", "job.completed")
+		require.NotEmpty(t, hook.Secret)
+
+		// WHEN
+		uploaded := uploadPNG(t, srv, token, 50, 50)
+		srv.WaitForJobs(t)
+
+		// THEN
+		deliveries := rcv.byEvent("file.uploaded")
+		require.Len(t, deliveries, 1)
+		delivery := deliveries[0]
+		signature := delivery.Header.Get(webhook.SignatureHeader)
+		timestamp, err := strconv.ParseInt(strings.TrimPrefix(strings.Split(signature, ",")[0], "t="), 10, 64)
+		require.NoError(t, err)
+		assert.Equal(t, webhook.Sign(hook.Secret, time.Unix(timestamp, 0), delivery.Body), signature)
+
+		var event webhookEvent
+		require.NoError(t, json.Unmarshal(delivery.Body, &event))
+		assert.Equal(t, "file.uploaded", event.Type)
+		assert.Contains(t, string(event.Data), uploaded.ID)
+
+		completed := rcv.byEvent("job.completed")
+		require.Len(t, completed, 1)
+		require.NoError(t, json.Unmarshal(completed[0].Body, &event))
+		var data struct {
+			JobID         string   `json:"job_id"`
+			ResultFileIDs []string `json:"result_file_ids"`
+		}
+		require.NoError(t, json.Unmarshal(event.Data, &data))
+		assert.Equal(t, uploaded.JobID, data.JobID)
+		assert.Equal(t, []string{uploaded.ID}, data.ResultFileIDs)
+	})
+
+	t.Run("Debe entregar solo los eventos suscritos y solo al dueño", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		rcv := newWebhookReceiver(t, alwaysOK)
+		createWebhook(t, srv, token, rcv.URL, "file.deleted")
+		uploaded := uploadPNG(t, srv, token, 50, 50)
+		uploadPNG(t, srv, srv.NewUser(t), 60, 60)
+
+		// WHEN
+		res, _ := srv.JSON(t, http.MethodDelete, "/api/v1/files/"+uploaded.ID, nil, token)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		srv.WaitForJobs(t)
+
+		// THEN
+		assert.Equal(t, 1, rcv.count())
+		assert.Len(t, rcv.byEvent("file.deleted"), 1)
+	})
+
+	t.Run("Debe reintentar las entregas fallidas y registrarlas", func(t *testing.T) {
+		// GIVEN: el receptor falla la primera vez
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		rcv := newWebhookReceiver(t, func(n int) int {
+			if n == 1 {
+				return http.StatusServiceUnavailable
+			}
+			return http.StatusOK
+		})
+		hook := createWebhook(t, srv, token, rcv.URL, "file.uploaded")
+
+		// WHEN
+		uploadPNG(t, srv, token, 50, 50)
+		srv.WaitForJobs(t)
+
+		// THEN
+		assert.Len(t, rcv.byEvent("file.uploaded"), 2)
+		deliveries := listDeliveries(t, srv, token, hook.ID)
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, "succeeded", deliveries[0].Status)
+		assert.Equal(t, 2, deliveries[0].Attempts)
+		assert.Nil(t, deliveries[0].Error)
+	})
+
+	t.Run("Debe dejar la entrega como fallida al agotar los intentos", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		rcv := newWebhookReceiver(t, func(int) int { return http.StatusInternalServerError })
+		hook := createWebhook(t, srv, token, rcv.URL, "file.uploaded")
+
+		// WHEN
+		uploadPNG(t, srv, token, 50, 50)
+		srv.WaitForJobs(t)
+
+		// THEN
+		deliveries := listDeliveries(t, srv, token, hook.ID)
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, "failed", deliveries[0].Status)
+		assert.Equal(t, 5, deliveries[0].Attempts)
+		require.NotNil(t, deliveries[0].Error)
+		assert.Contains(t, *deliveries[0].Error, "500")
+	})
+
+	t.Run("Debe reenviar el mismo cuerpo como una entrega nueva", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		rcv := newWebhookReceiver(t, alwaysOK)
+		hook := createWebhook(t, srv, token, rcv.URL, "file.uploaded")
+		uploadPNG(t, srv, token, 50, 50)
+		srv.WaitForJobs(t)
+		original := listDeliveries(t, srv, token, hook.ID)[0]
+
+		// WHEN
+		path := "/api/v1/webhooks/" + hook.ID + "/deliveries/" + original.ID + "/redeliver"
+		res, env := srv.JSON(t, http.MethodPost, path, nil, token)
+		srv.WaitForJobs(t)
+
+		// THEN
+		require.Equal(t, http.StatusAccepted, res.StatusCode)
+		var redelivery deliveryResponse
+		env.Decode(t, &redelivery)
+		assert.Equal(t, original.ID, redelivery.RedeliveryOf)
+		received := rcv.byEvent("file.uploaded")
+		require.Len(t, received, 2)
+		assert.Equal(t, received[0].Body, received[1].Body)
+		assert.NotEqual(t, received[0].Header.Get(webhook.DeliveryHeader), received[1].Header.Get(webhook.DeliveryHeader))
+		assert.Len(t, listDeliveries(t, srv, token, hook.ID), 2)
+	})
+
+	t.Run("Debe validar la URL y los tipos de evento", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodPost, "/api/v1/webhooks/", map[string]interface{}{
+			"url":    "ftp://example.com",
+			"events": []string{"file.renamed"},
+		}, token)
+
+		// THEN
+		assert.Equal(t, http.StatusUnprocessableEntity, res.StatusCode)
+		assert.Equal(t, "VALIDATION_FAILED", env.Error.Code)
+		details, _ := env.Error.Details.(map[string]interface{})
+		assert.Contains(t, details, "url")
+		assert.Contains(t, details, "events[0]")
+	})
+
+	t.Run("Debe retornar 404 al reenviar una entrega de otro usuario", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		rcv := newWebhookReceiver(t, alwaysOK)
+		hook := createWebhook(t, srv, token, rcv.URL, "file.uploaded")
+		uploadPNG(t, srv, token, 50, 50)
+		srv.WaitForJobs(t)
+		original := listDeliveries(t, srv, token, hook.ID)[0]
+
+		// WHEN
+		path := "/api/v1/webhooks/" + hook.ID + "/deliveries/" + original.ID + "/redeliver"
+		res, env := srv.JSON(t, http.MethodPost, path, nil, srv.NewUser(t))
+
+		// THEN
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+		assert.Equal(t, "WEBHOOK_NOT_FOUND", env.Error.Code)
+	})
+
+	t.Run("Debe rechazar las entregas a direcciones internas", func(t *testing.T) {
+		// GIVEN: el cliente de producción, que filtra las direcciones
+		srv := apitest.New(t, apitest.WithWebhookConfig(webhook.ServiceConfig{
+			Client: webhook.NewClient(10 * time.Second),
+		}))
+		token := srv.NewUser(t)
+		rcv := newWebhookReceiver(t, alwaysOK)
+		require.True(t, strings.HasPrefix(rcv.URL, "http://127.0.0.1:"))
+		hook := createWebhook(t, srv, token, rcv.URL, "file.uploaded")
+
+		// WHEN
+		uploadPNG(t, srv, token, 50, 50)
+		srv.WaitForJobs(t)
+
+		// THEN: no se reintenta y no se expone ninguna respuesta
+		assert.Zero(t, rcv.count())
+		deliveries := listDeliveries(t, srv, token, hook.ID)
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, "failed", deliveries[0].Status)
+		assert.Equal(t, 1, deliveries[0].Attempts)
+		require.NotNil(t, deliveries[0].Error)
+		assert.Contains(t, *deliveries[0].Error, "dirección interna")
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Outbox
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRouter_Outbox(t *testing.T) {
+	t.Run("Debe guardar y entregar un evento por cada cambio del usuario", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		token := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+
+		// WHEN
+		uploaded := uploadPNG(t, srv, token, 50, 50)
+		res, _ := srv.JSON(t, http.MethodPatch, "/api/v1/users/change-password/me", map[string]string{
+			"current_password": "secreto123",
+			"new_password":     "secreto456",
+		}, token)
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		srv.WaitForJobs(t)
+
+		// THEN: todos quedan entregados, en el orden en que ocurrieron
+		var messages []outbox.Message
+		require.NoError(t, srv.DB.Order("id").Find(&messages).Error)
+		types := make([]string, 0, len(messages))
+		for _, m := range messages {
+			assert.NotNil(t, m.PublishedAt, m.Type)
+			types = append(types, m.Type)
+		}
+		assert.Equal(t, []string{"user.signed_up", "file.uploaded", "job.completed", "user.password_changed"}, types)
+		assert.Contains(t, messages[1].Data, uploaded.ID)
+	})
+
+	t.Run("Debe entregar user.password_changed a los webhooks del usuario", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		token := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+		rcv := newWebhookReceiver(t, alwaysOK)
+		createWebhook(t, srv, token, rcv.URL, "user.password_changed")
+
+		// WHEN
+		res, _ := srv.JSON(t, http.MethodPatch, "/api/v1/users/change-password/me", map[string]string{
+			"current_password": "secreto123",
+			"new_password":     "secreto456",
+		}, token)
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		srv.WaitForJobs(t)
+
+		// THEN
+		deliveries := rcv.byEvent("user.password_changed")
+		require.Len(t, deliveries, 1)
+		var event webhookEvent
+		require.NoError(t, json.Unmarshal(deliveries[0].Body, &event))
+		assert.Contains(t, string(event.Data), "ana@test.com")
+		assert.Equal(t, 1, rcv.count())
+	})
+
+	t.Run("No debe guardar eventos de un cambio que falla", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+
+		// WHEN: el email ya existe
+		res, _ := srv.JSON(t, http.MethodPost, "/api/v1/auth/signup", map[string]string{
+			"name": "Otra Ana", "email": "ana@test.com", "password": "secreto123",
+		}, "")
+
+		// THEN
+		require.Equal(t, http.StatusConflict, res.StatusCode)
+		var count int64
+		require.NoError(t, srv.DB.Model(&outbox.Message{}).Count(&count).Error)
+		assert.Equal(t, int64(1), count)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Events (SSE)
+// ─────────────────────────────────────────────────────────────────────────────
+
+type streamEvent struct {
+	ID    string
+	Event string
+	Data  struct {
+		ID   string          `json:"id"`
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+}
+
+// eventStream lee en segundo plano los mensajes de GET /api/v1/events.
+type eventStream struct {
+	events chan streamEvent
+}
+
+// openStream abre el stream de eventos del usuario. La conexión se cierra al
+// terminar el test, antes que el servidor, que si no esperaría a que el
+// stream acabase.
+func openStream(t *testing.T, srv *apitest.Server, token, lastEventID string) *eventStream {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/v1/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	res, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	stream := &eventStream{events: make(chan streamEvent, 16)}
+	ready := make(chan struct{})
+	go func() {
+		defer res.Body.Close()
+		defer close(stream.events)
+
+		scanner := bufio.NewScanner(res.Body)
+		var current streamEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "retry:"):
+				close(ready)
+			case strings.HasPrefix(line, "id: "):
+				current.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				current.Event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &current.Data); err != nil {
+					return
+				}
+			case line == "" && current.ID != "":
+				stream.events <- current
+				current = streamEvent{}
+			}
+		}
+	}()
+
+	// El stream fija su posición inicial antes de enviar retry: a partir de
+	// aquí no se pierde ningún evento.
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("el stream no envió la cabecera inicial")
+	}
+
+	return stream
+}
+
+// next espera el siguiente evento del stream.
+func (s *eventStream) next(t *testing.T) streamEvent {
+	t.Helper()
+
+	select {
+	case event, ok := <-s.events:
+		require.True(t, ok, "el stream se cerró")
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no llegó ningún evento")
+		return streamEvent{}
+	}
+}
+
+// nextOf espera el siguiente evento del tipo indicado e ignora el resto.
+func (s *eventStream) nextOf(t *testing.T, eventType string) streamEvent {
+	t.Helper()
+
+	for {
+		if event := s.next(t); event.Event == eventType {
+			return event
+		}
+	}
+}
+
+func TestRouter_Events(t *testing.T) {
+	t.Run("Debe enviar los eventos de subida, procesamiento y borrado", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		stream := openStream(t, srv, token, "")
+
+		// WHEN
+		uploaded := uploadPNG(t, srv, token, 50, 50)
+		res, _ := srv.JSON(t, http.MethodDelete, "/api/v1/files/"+uploaded.ID, nil, token)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		// THEN
+		first := stream.nextOf(t, "file.uploaded")
+		assert.Equal(t, "file.uploaded", first.Data.Type)
+		var fileData file.FileEventData
+		require.NoError(t, json.Unmarshal(first.Data.Data, &fileData))
+		assert.Equal(t, uploaded.ID, fileData.FileID)
+
+		completed := stream.nextOf(t, "job.completed")
+		assert.Contains(t, string(completed.Data.Data), uploaded.JobID)
+
+		deleted := stream.nextOf(t, "file.deleted")
+		require.NoError(t, json.Unmarshal(deleted.Data.Data, &fileData))
+		assert.Equal(t, uploaded.ID, fileData.FileID)
+	})
+
+	t.Run("Debe reanudar después del evento indicado en Last-Event-ID", func(t *testing.T) {
+		// GIVEN: eventos guardados sin ningún stream abierto
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		uploaded := uploadPNG(t, srv, token, 50, 50)
+		res, _ := srv.JSON(t, http.MethodDelete, "/api/v1/files/"+uploaded.ID, nil, token)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		replay := openStream(t, srv, token, "0")
+		assert.Equal(t, "user.signed_up", replay.next(t).Event)
+		first := replay.next(t)
+		assert.Equal(t, "file.uploaded", first.Event)
+
+		// WHEN
+		resumed := openStream(t, srv, token, first.ID)
+
+		// THEN
+		assert.Equal(t, "job.completed", resumed.next(t).Event)
+		assert.Equal(t, "file.deleted", resumed.next(t).Event)
+	})
+
+	t.Run("No debe enviar eventos de otros usuarios", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		stream := openStream(t, srv, token, "")
+
+		// WHEN: otro usuario sube primero
+		uploadPNG(t, srv, srv.NewUser(t), 50, 50)
+		mine := uploadPNG(t, srv, token, 50, 50)
+
+		// THEN
+		event := stream.nextOf(t, "file.uploaded")
+		var fileData file.FileEventData
+		require.NoError(t, json.Unmarshal(event.Data.Data, &fileData))
+		assert.Equal(t, mine.ID, fileData.FileID)
+	})
+
+	t.Run("Debe retornar 400 cuando Last-Event-ID no es un número", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+
+		// WHEN
+		res := srv.Do(t, http.MethodGet, "/api/v1/events", nil, "", token, http.Header{"Last-Event-ID": {"abc"}})
+
+		// THEN
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		assert.Equal(t, "INVALID_LAST_EVENT_ID", apitest.DecodeEnvelope(t, res).Error.Code)
+	})
+
+	t.Run("Debe retornar 401 sin token", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+
+		// WHEN
+		res := srv.Do(t, http.MethodGet, "/api/v1/events", nil, "", "")
+
+		// THEN
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Rate limiting
+// ─────────────────────────────────────────────────────────────────────────────
+
+// signInFrom intenta iniciar sesión con credenciales incorrectas enviando ip
+// en X-Forwarded-For, si no está vacía.
+func signInFrom(t *testing.T, srv *apitest.Server, ip string) *http.Response {
+	t.Helper()
+
+	return signInAs(t, srv, "ana@test.com", "incorrecta", ip)
+}
+
+// signInAs intenta iniciar sesión enviando ip en X-Forwarded-For, si no está
+// vacía, y devuelve la respuesta tal cual.
+func signInAs(t *testing.T, srv *apitest.Server, email, password, ip string) *http.Response {
+	t.Helper()
+
+	headers := http.Header{}
+	if ip != "" {
+		headers.Set("X-Forwarded-For", ip)
+	}
+	body, err := json.Marshal(map[string]string{"email": email, "password": password})
+	require.NoError(t, err)
+	return srv.Do(t, http.MethodPost, "/api/v1/auth/signin", bytes.NewReader(body), "application/json", "", headers)
+}
+
+func TestRouter_RateLimit(t *testing.T) {
+	t.Run("Debe retornar 429 con Retry-After al agotar el presupuesto de signin", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t, apitest.WithRateLimits(middleware.RateLimitConfig{
+			SignIn: ratelimit.Limit{Requests: 2, Window: time.Minute},
+		}))
+		first := signInFrom(t, srv, "")
+		signInFrom(t, srv, "")
+
+		// WHEN
+		res := signInFrom(t, srv, "")
+
+		// THEN
+		assert.Equal(t, http.StatusUnauthorized, first.StatusCode)
+		assert.Equal(t, "2", first.Header.Get("RateLimit-Limit"))
+		assert.Equal(t, "1", first.Header.Get("RateLimit-Remaining"))
+		assert.Equal(t, "2;w=60", first.Header.Get("RateLimit-Policy"))
+
+		assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+		assert.Equal(t, "RATE_LIMITED", apitest.DecodeEnvelope(t, res).Error.Code)
+		assert.Equal(t, "0", res.Header.Get("RateLimit-Remaining"))
+		assert.Equal(t, "30", res.Header.Get("Retry-After"))
+	})
+
+	t.Run("Debe llevar un presupuesto por IP detrás de un proxy de confianza", func(t *testing.T) {
+		// GIVEN
+		proxies, err := middleware.ParseTrustedProxies([]string{"127.0.0.1"})
+		require.NoError(t, err)
+		srv := apitest.New(t,
+			apitest.WithTrustedProxies(proxies),
+			apitest.WithRateLimits(middleware.RateLimitConfig{
+				SignIn: ratelimit.Limit{Requests: 1, Window: time.Minute},
+			}),
+		)
+		signInFrom(t, srv, "203.0.113.1")
+
+		// WHEN
+		same := signInFrom(t, srv, "203.0.113.1")
+		other := signInFrom(t, srv, "203.0.113.2")
+
+		// THEN
+		assert.Equal(t, http.StatusTooManyRequests, same.StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, other.StatusCode)
+	})
+
+	t.Run("Debe ignorar X-Forwarded-For cuando la petición no llega de un proxy de confianza", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t, apitest.WithRateLimits(middleware.RateLimitConfig{
+			SignIn: ratelimit.Limit{Requests: 1, Window: time.Minute},
+		}))
+		signInFrom(t, srv, "203.0.113.1")
+
+		// WHEN
+		res := signInFrom(t, srv, "203.0.113.2")
+
+		// THEN
+		assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	})
+
+	t.Run("Debe limitar las subidas por usuario autenticado", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t, apitest.WithRateLimits(middleware.RateLimitConfig{
+			Uploads: ratelimit.Limit{Requests: 1, Window: time.Hour},
+		}))
+		ana := srv.NewUser(t)
+		luis := srv.NewUser(t)
+		uploadPNG(t, srv, ana, 8, 8)
+
+		// WHEN
+		again := srv.Upload(t, ana, "otra.png", "image/png", apitest.NewPNG(t, 9, 9))
+		fromOther := srv.Upload(t, luis, "luis.png", "image/png", apitest.NewPNG(t, 10, 10))
+
+		// THEN
+		assert.Equal(t, http.StatusTooManyRequests, again.StatusCode)
+		assert.Equal(t, http.StatusCreated, fromOther.StatusCode)
+	})
+
+	t.Run("Debe limitar la entrega de imágenes sin afectar al resto de rutas", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t, apitest.WithRateLimits(middleware.RateLimitConfig{
+			Delivery: ratelimit.Limit{Requests: 1, Window: time.Hour},
+		}))
+		token := srv.NewUser(t)
+		uploaded := uploadPNG(t, srv, token, 8, 8)
+		srv.Do(t, http.MethodGet, pathOf(srv, uploaded.URL), nil, "", token)
+
+		// WHEN
+		res := srv.Do(t, http.MethodGet, pathOf(srv, uploaded.URL), nil, "", token)
+		list := srv.Do(t, http.MethodGet, "/api/v1/files", nil, "", token)
+
+		// THEN
+		assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+		assert.Equal(t, http.StatusOK, list.StatusCode)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Bloqueo de cuentas
+// ─────────────────────────────────────────────────────────────────────────────
+
+type attemptResponse struct {
+	Email  string `json:"email"`
+	IP     string `json:"ip"`
+	Result string `json:"result"`
+}
+
+func TestRouter_Lockout(t *testing.T) {
+	// Con una espera de una hora el test no depende del reloj.
+	slow := auth.LockoutPolicy{FreeAttempts: 1, BaseDelay: time.Hour, MaxDelay: time.Hour, MaxFailures: 5}
+
+	t.Run("Debe exigir una espera tras los fallos sin penalización aunque la contraseña sea correcta", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t, apitest.WithLockout(auth.LockoutConfig{Account: slow}))
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		first := signInAs(t, srv, "ana@test.com", "incorrecta", "")
+		second := signInAs(t, srv, "ana@test.com", "incorrecta", "")
+
+		// WHEN
+		res := signInAs(t, srv, "ana@test.com", "secreto123", "")
+
+		// THEN
+		assert.Equal(t, http.StatusUnauthorized, first.StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, second.StatusCode)
+		assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+		assert.Equal(t, "TOO_MANY_ATTEMPTS", apitest.DecodeEnvelope(t, res).Error.Code)
+		assert.Equal(t, "3600", res.Header.Get("Retry-After"))
+	})
+
+	t.Run("Debe bloquear la cuenta con ACCOUNT_LOCKED al llegar al máximo de fallos", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t, apitest.WithLockout(auth.LockoutConfig{
+			Account: auth.LockoutPolicy{FreeAttempts: 5, MaxFailures: 2, LockoutDuration: time.Hour},
+		}))
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		signInAs(t, srv, "ana@test.com", "incorrecta", "")
+		signInAs(t, srv, "ana@test.com", "incorrecta", "")
+
+		// WHEN
+		res := signInAs(t, srv, "ana@test.com", "secreto123", "")
+
+		// THEN
+		assert.Equal(t, http.StatusLocked, res.StatusCode)
+		assert.Equal(t, "ACCOUNT_LOCKED", apitest.DecodeEnvelope(t, res).Error.Code)
+		assert.Equal(t, "3600", res.Header.Get("Retry-After"))
+	})
+
+	t.Run("Debe penalizar igual un email que no existe para no delatar qué cuentas hay", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t, apitest.WithLockout(auth.LockoutConfig{Account: slow}))
+		signInAs(t, srv, "nadie@test.com", "incorrecta", "")
+		signInAs(t, srv, "nadie@test.com", "incorrecta", "")
+
+		// WHEN
+		res := signInAs(t, srv, "nadie@test.com", "incorrecta", "")
+
+		// THEN
+		assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+		assert.Equal(t, "TOO_MANY_ATTEMPTS", apitest.DecodeEnvelope(t, res).Error.Code)
+	})
+
+	t.Run("Debe reiniciar los fallos de la cuenta tras un inicio de sesión correcto", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t, apitest.WithLockout(auth.LockoutConfig{Account: slow}))
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		signInAs(t, srv, "ana@test.com", "incorrecta", "")
+		srv.SignIn(t, "ana@test.com", "secreto123")
+		signInAs(t, srv, "ana@test.com", "incorrecta", "")
+
+		// WHEN
+		res := signInAs(t, srv, "ana@test.com", "secreto123", "")
+
+		// THEN
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	})
+
+	t.Run("Debe penalizar la IP que falla en muchas cuentas distintas", func(t *testing.T) {
+		// GIVEN
+		proxies, err := middleware.ParseTrustedProxies([]string{"127.0.0.1"})
+		require.NoError(t, err)
+		srv := apitest.New(t,
+			apitest.WithTrustedProxies(proxies),
+			apitest.WithLockout(auth.LockoutConfig{IP: slow}),
+		)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		signInAs(t, srv, "luis@test.com", "incorrecta", "203.0.113.1")
+		signInAs(t, srv, "eva@test.com", "incorrecta", "203.0.113.1")
+
+		// WHEN
+		blocked := signInAs(t, srv, "ana@test.com", "secreto123", "203.0.113.1")
+		other := signInAs(t, srv, "ana@test.com", "secreto123", "203.0.113.2")
+
+		// THEN
+		assert.Equal(t, http.StatusTooManyRequests, blocked.StatusCode)
+		assert.Equal(t, http.StatusOK, other.StatusCode)
+	})
+
+	t.Run("Debe desbloquear la cuenta desde una sesión abierta", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t, apitest.WithLockout(auth.LockoutConfig{
+			Account: auth.LockoutPolicy{FreeAttempts: 5, MaxFailures: 1, LockoutDuration: time.Hour},
+		}))
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		token := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+		signInAs(t, srv, "ana@test.com", "incorrecta", "")
+		locked := signInAs(t, srv, "ana@test.com", "secreto123", "")
+
+		// WHEN
+		res := srv.Do(t, http.MethodDelete, "/api/v1/auth/lockout", nil, "", token)
+
+		// THEN
+		assert.Equal(t, http.StatusLocked, locked.StatusCode)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, http.StatusOK, signInAs(t, srv, "ana@test.com", "secreto123", "").StatusCode)
+	})
+
+	t.Run("Debe registrar todos los intentos en la cuenta", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t, apitest.WithLockout(auth.LockoutConfig{
+			Account: auth.LockoutPolicy{FreeAttempts: 5, MaxFailures: 2, LockoutDuration: time.Hour},
+		}))
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		token := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+		signInAs(t, srv, "ana@test.com", "incorrecta", "")
+		signInAs(t, srv, "ana@test.com", "incorrecta", "")
+		signInAs(t, srv, "ana@test.com", "secreto123", "")
+		signInAs(t, srv, "nadie@test.com", "incorrecta", "")
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodGet, "/api/v1/auth/attempts", nil, token)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var attempts []attemptResponse
+		env.Decode(t, &attempts)
+		require.Len(t, attempts, 4)
+		results := make([]string, 0, len(attempts))
+		for _, attempt := range attempts {
+			assert.Equal(t, "ana@test.com", attempt.Email)
+			assert.Equal(t, "127.0.0.1", attempt.IP)
+			results = append(results, attempt.Result)
+		}
+		assert.Equal(t, []string{"locked", "invalid_credentials", "invalid_credentials", "succeeded"}, results)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Roles
+// ─────────────────────────────────────────────────────────────────────────────
+
+// newMember registra un usuario con el rol por defecto y devuelve su ID y su
+// access token.
+func newMember(t *testing.T, srv *apitest.Server, email string) (string, string) {
+	t.Helper()
+
+	res, env := srv.JSON(t, http.MethodPost, "/api/v1/auth/signup", map[string]string{
+		"name": "Miembro", "email": email, "password": "secreto123",
+	}, "")
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	var created struct {
+		ID   string `json:"id"`
+		Role string `json:"role"`
+	}
+	env.Decode(t, &created)
+	require.Equal(t, "member", created.Role)
+
+	return created.ID, srv.SignIn(t, email, "secreto123").AccessToken
+}
+
+func TestRouter_Roles(t *testing.T) {
+	t.Run("Debe reservar el listado de usuarios a los administradores", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		_, member := newMember(t, srv, "ana@test.com")
+		_, admin := srv.NewAdmin(t)
+
+		// WHEN
+		forbidden, env := srv.JSON(t, http.MethodGet, "/api/v1/users/", nil, member)
+		allowed, _ := srv.JSON(t, http.MethodGet, "/api/v1/users/", nil, admin)
+
+		// THEN
+		assert.Equal(t, http.StatusForbidden, forbidden.StatusCode)
+		assert.Equal(t, "FORBIDDEN", env.Error.Code)
+		assert.Equal(t, http.StatusOK, allowed.StatusCode)
+	})
+
+	t.Run("Debe impedir que un miembro consulte, modifique o borre otra cuenta", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		_, ana := newMember(t, srv, "ana@test.com")
+		luisID, _ := newMember(t, srv, "luis@test.com")
+
+		// WHEN
+		get, _ := srv.JSON(t, http.MethodGet, "/api/v1/users/"+luisID, nil, ana)
+		patch, _ := srv.JSON(t, http.MethodPatch, "/api/v1/users/"+luisID, map[string]string{"name": "Hackeado"}, ana)
+		del, _ := srv.JSON(t, http.MethodDelete, "/api/v1/users/"+luisID, nil, ana)
+
+		// THEN
+		assert.Equal(t, http.StatusForbidden, get.StatusCode)
+		assert.Equal(t, http.StatusForbidden, patch.StatusCode)
+		assert.Equal(t, http.StatusForbidden, del.StatusCode)
+		var luis user.User
+		require.NoError(t, srv.DB.First(&luis, "id = ?", luisID).Error)
+		assert.Equal(t, "Miembro", luis.Name)
+	})
+
+	t.Run("Debe permitir a cada usuario gestionar su propia cuenta", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		anaID, ana := newMember(t, srv, "ana@test.com")
+
+		// WHEN
+		get, _ := srv.JSON(t, http.MethodGet, "/api/v1/users/"+anaID, nil, ana)
+		patch, _ := srv.JSON(t, http.MethodPatch, "/api/v1/users/"+anaID, map[string]string{"name": "Ana García"}, ana)
+
+		// THEN
+		assert.Equal(t, http.StatusOK, get.StatusCode)
+		assert.Equal(t, http.StatusCreated, patch.StatusCode)
+	})
+
+	t.Run("Debe permitir a un administrador gestionar cualquier cuenta", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		luisID, _ := newMember(t, srv, "luis@test.com")
+		_, admin := srv.NewAdmin(t)
+
+		// WHEN
+		patch, _ := srv.JSON(t, http.MethodPatch, "/api/v1/users/"+luisID, map[string]string{"name": "Luis Pérez"}, admin)
+		del, _ := srv.JSON(t, http.MethodDelete, "/api/v1/users/"+luisID, nil, admin)
+
+		// THEN
+		assert.Equal(t, http.StatusCreated, patch.StatusCode)
+		assert.Equal(t, http.StatusOK, del.StatusCode)
+	})
+
+	t.Run("Debe cerrar las sesiones del usuario al cambiarle el rol", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		anaID, oldToken := newMember(t, srv, "ana@test.com")
+		_, admin := srv.NewAdmin(t)
+
+		// WHEN
+		res, _ := srv.JSON(t, http.MethodPatch, "/api/v1/users/"+anaID+"/role", map[string]string{"role": "admin"}, admin)
+
+		// THEN: el token anterior ya no vale y el nuevo lleva el rol de admin
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		stale, _ := srv.JSON(t, http.MethodGet, "/api/v1/users/"+anaID, nil, oldToken)
+		assert.Equal(t, http.StatusUnauthorized, stale.StatusCode)
+
+		newToken := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+		list, _ := srv.JSON(t, http.MethodGet, "/api/v1/users/", nil, newToken)
+		assert.Equal(t, http.StatusOK, list.StatusCode)
+	})
+
+	t.Run("Debe impedir que un miembro cambie roles", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		anaID, ana := newMember(t, srv, "ana@test.com")
+
+		// WHEN
+		res, _ := srv.JSON(t, http.MethodPatch, "/api/v1/users/"+anaID+"/role", map[string]string{"role": "admin"}, ana)
+
+		// THEN
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	})
+
+	t.Run("Debe permitir a un administrador desbloquear una cuenta", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t, apitest.WithLockout(auth.LockoutConfig{
+			Account: auth.LockoutPolicy{FreeAttempts: 5, MaxFailures: 1, LockoutDuration: time.Hour},
+		}))
+		anaID, _ := newMember(t, srv, "ana@test.com")
+		_, admin := srv.NewAdmin(t)
+		signInAs(t, srv, "ana@test.com", "incorrecta", "")
+		require.Equal(t, http.StatusLocked, signInAs(t, srv, "ana@test.com", "secreto123", "").StatusCode)
+
+		// WHEN
+		res, _ := srv.JSON(t, http.MethodDelete, "/api/v1/users/"+anaID+"/lockout", nil, admin)
+
+		// THEN
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, http.StatusOK, signInAs(t, srv, "ana@test.com", "secreto123", "").StatusCode)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Sesiones
+// ─────────────────────────────────────────────────────────────────────────────
+
+type sessionResponse struct {
+	ID          string     `json:"id"`
+	DeviceLabel string     `json:"device_label"`
+	UserAgent   string     `json:"user_agent"`
+	IP          string     `json:"ip"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	Current     bool       `json:"current"`
+}
+
+const (
+	chromeMac    = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0 Safari/537.36"
+	firefoxLinux = "Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0"
+)
+
+// signInDevice inicia sesión con el user agent y el nombre de dispositivo
+// dados y devuelve el access token.
+func signInDevice(t *testing.T, srv *apitest.Server, email, deviceName, userAgent string) string {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]string{"email": email, "password": "secreto123", "device_name": deviceName})
+	require.NoError(t, err)
+	res := srv.Do(t, http.MethodPost, "/api/v1/auth/signin", bytes.NewReader(body), "application/json", "",
+		http.Header{"User-Agent": {userAgent}})
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var tokens auth.Auth
+	apitest.DecodeEnvelope(t, res).Decode(t, &tokens)
+	return tokens.AccessToken
+}
+
+// listSessions devuelve las sesiones activas del usuario de token.
+func listSessions(t *testing.T, srv *apitest.Server, token string) []sessionResponse {
+	t.Helper()
+
+	res, env := srv.JSON(t, http.MethodGet, "/api/v1/auth/sessions", nil, token)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var sessions []sessionResponse
+	env.Decode(t, &sessions)
+	return sessions
+}
+
+func TestRouter_Sessions(t *testing.T) {
+	t.Run("Debe listar los dispositivos con sesión marcando la actual", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		signInDevice(t, srv, "ana@test.com", "", firefoxLinux)
+		laptop := signInDevice(t, srv, "ana@test.com", "Portátil", chromeMac)
+
+		// WHEN
+		sessions := listSessions(t, srv, laptop)
+
+		// THEN
+		require.Len(t, sessions, 2)
+		byLabel := map[string]sessionResponse{}
+		for _, sess := range sessions {
+			byLabel[sess.DeviceLabel] = sess
+			assert.Equal(t, "127.0.0.1", sess.IP)
+			assert.NotNil(t, sess.LastUsedAt)
+		}
+		assert.True(t, byLabel["Portátil"].Current)
+		assert.Equal(t, chromeMac, byLabel["Portátil"].UserAgent)
+		assert.False(t, byLabel["Firefox en Linux"].Current)
+	})
+
+	t.Run("Debe cerrar una sesión concreta sin afectar a la actual", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		phone := signInDevice(t, srv, "ana@test.com", "Móvil", firefoxLinux)
+		laptop := signInDevice(t, srv, "ana@test.com", "Portátil", chromeMac)
+		var phoneID string
+		for _, sess := range listSessions(t, srv, laptop) {
+			if !sess.Current {
+				phoneID = sess.ID
+			}
+		}
+
+		// WHEN
+		res, _ := srv.JSON(t, http.MethodDelete, "/api/v1/auth/sessions/"+phoneID, nil, laptop)
+
+		// THEN
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		revoked, _ := srv.JSON(t, http.MethodGet, "/api/v1/auth/sessions", nil, phone)
+		assert.Equal(t, http.StatusUnauthorized, revoked.StatusCode)
+		assert.Len(t, listSessions(t, srv, laptop), 1)
+	})
+
+	t.Run("Debe retornar 404 al cerrar la sesión de otro usuario", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		srv.SignUp(t, "Luis Pérez", "luis@test.com", "secreto123")
+		ana := signInDevice(t, srv, "ana@test.com", "", chromeMac)
+		luis := signInDevice(t, srv, "luis@test.com", "", chromeMac)
+		luisSession := listSessions(t, srv, luis)[0].ID
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodDelete, "/api/v1/auth/sessions/"+luisSession, nil, ana)
+
+		// THEN
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+		assert.Equal(t, "SESSION_NOT_FOUND", env.Error.Code)
+		assert.Len(t, listSessions(t, srv, luis), 1)
+	})
+
+	t.Run("Debe cerrar todas las sesiones al salir de todos los dispositivos", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		phone := signInDevice(t, srv, "ana@test.com", "Móvil", firefoxLinux)
+		laptop := signInDevice(t, srv, "ana@test.com", "Portátil", chromeMac)
+
+		// WHEN
+		res, _ := srv.JSON(t, http.MethodDelete, "/api/v1/auth/sessions", nil, laptop)
+
+		// THEN
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		for _, token := range []string{phone, laptop} {
+			after, _ := srv.JSON(t, http.MethodGet, "/api/v1/auth/sessions", nil, token)
+			assert.Equal(t, http.StatusUnauthorized, after.StatusCode)
+		}
+	})
+
+	t.Run("Debe cerrar las demás sesiones al cambiar la contraseña", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		phone := signInDevice(t, srv, "ana@test.com", "Móvil", firefoxLinux)
+		laptop := signInDevice(t, srv, "ana@test.com", "Portátil", chromeMac)
+
+		// WHEN
+		res, _ := srv.JSON(t, http.MethodPatch, "/api/v1/users/change-password/me", map[string]string{
+			"current_password": "secreto123",
+			"new_password":     "secreto456",
+		}, laptop)
+
+		// THEN
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		revoked, _ := srv.JSON(t, http.MethodGet, "/api/v1/auth/sessions", nil, phone)
+		assert.Equal(t, http.StatusUnauthorized, revoked.StatusCode)
+		sessions := listSessions(t, srv, laptop)
+		require.Len(t, sessions, 1)
+		assert.True(t, sessions[0].Current)
+	})
+	t.Run("Debe cerrar la sesión más antigua al superar el máximo por usuario", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t, apitest.WithMaxSessions(2))
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		oldest := signInDevice(t, srv, "ana@test.com", "Móvil", firefoxLinux)
+		signInDevice(t, srv, "ana@test.com", "Tableta", firefoxLinux)
+
+		// WHEN
+		laptop := signInDevice(t, srv, "ana@test.com", "Portátil", chromeMac)
+
+		// THEN
+		res, _ := srv.JSON(t, http.MethodGet, "/api/v1/auth/sessions", nil, oldest)
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		labels := []string{}
+		for _, sess := range listSessions(t, srv, laptop) {
+			labels = append(labels, sess.DeviceLabel)
+		}
+		assert.ElementsMatch(t, []string{"Tableta", "Portátil"}, labels)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Refresh tokens
+// ─────────────────────────────────────────────────────────────────────────────
+
+// renew cambia refreshToken por un par nuevo.
+func renew(t *testing.T, srv *apitest.Server, refreshToken string) (*http.Response, apitest.Envelope) {
+	t.Helper()
+
+	return srv.JSON(t, http.MethodPost, "/api/v1/auth/renew-session", map[string]string{
+		"refresh_token": refreshToken,
+	}, "")
+}
+
+// renewOK renueva y devuelve el par nuevo.
+func renewOK(t *testing.T, srv *apitest.Server, refreshToken string) auth.Auth {
+	t.Helper()
+
+	res, env := renew(t, srv, refreshToken)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var tokens auth.Auth
+	env.Decode(t, &tokens)
+	return tokens
+}
+
+func TestRouter_RefreshTokens(t *testing.T) {
+	t.Run("Debe rotar el refresh token en cada renovación", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		first := srv.SignIn(t, "ana@test.com", "secreto123")
+
+		// WHEN
+		second := renewOK(t, srv, first.RefreshToken)
+		third := renewOK(t, srv, second.RefreshToken)
+
+		// THEN
+		assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+		assert.NotEqual(t, second.RefreshToken, third.RefreshToken)
+		res, _ := srv.JSON(t, http.MethodGet, "/api/v1/auth/sessions", nil, third.AccessToken)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	})
+
+	t.Run("Debe revocar la familia cuando se reutiliza un refresh token rotado", func(t *testing.T) {
+		// GIVEN: el atacante se queda con el primer refresh token y el
+		// cliente legítimo ya lo ha rotado
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		stolen := srv.SignIn(t, "ana@test.com", "secreto123")
+		current := renewOK(t, srv, stolen.RefreshToken)
+
+		// WHEN
+		res, env := renew(t, srv, stolen.RefreshToken)
+
+		// THEN
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		assert.Equal(t, "REFRESH_TOKEN_REUSED", env.Error.Code)
+		revoked, _ := renew(t, srv, current.RefreshToken)
+		assert.NotEqual(t, http.StatusOK, revoked.StatusCode)
+		access, _ := srv.JSON(t, http.MethodGet, "/api/v1/auth/sessions", nil, current.AccessToken)
+		assert.Equal(t, http.StatusUnauthorized, access.StatusCode)
+	})
+
+	t.Run("Debe registrar la reutilización como evento de seguridad", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		stolen := srv.SignIn(t, "ana@test.com", "secreto123")
+		renewOK(t, srv, stolen.RefreshToken)
+
+		// WHEN: se reutiliza dos veces
+		renew(t, srv, stolen.RefreshToken)
+		res, env := renew(t, srv, stolen.RefreshToken)
+
+		// THEN: sigue rechazándose, pero el evento se registra una vez
+		assert.Equal(t, "REFRESH_TOKEN_REUSED", env.Error.Code)
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		var messages []outbox.Message
+		require.NoError(t, srv.DB.Where("type = ?", "session.refresh_token_reused").Find(&messages).Error)
+		require.Len(t, messages, 1)
+		assert.Contains(t, messages[0].Data, `"rotated_at"`)
+	})
+
+	t.Run("Debe mantener las sesiones de los demás dispositivos", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		stolen := srv.SignIn(t, "ana@test.com", "secreto123")
+		other := srv.SignIn(t, "ana@test.com", "secreto123")
+		renewOK(t, srv, stolen.RefreshToken)
+
+		// WHEN
+		res, _ := renew(t, srv, stolen.RefreshToken)
+
+		// THEN
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		renewOK(t, srv, other.RefreshToken)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// API keys
+// ─────────────────────────────────────────────────────────────────────────────
+
+type apiKeyResponse struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Prefix string   `json:"prefix"`
+	Scopes []string `json:"scopes"`
+	Key    string   `json:"key"`
+}
+
+// createAPIKey crea una API key con los scopes dados y la devuelve con la
+// clave en claro.
+func createAPIKey(t *testing.T, srv *apitest.Server, token string, scopes ...string) apiKeyResponse {
+	t.Helper()
+
+	res, env := srv.JSON(t, http.MethodPost, "/api/v1/api-keys", map[string]interface{}{
+		"name": "CI", "scopes": scopes,
+	}, token)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	var key apiKeyResponse
+	env.Decode(t, &key)
+	return key
+}
+
+func TestRouter_APIKeys(t *testing.T) {
+	t.Run("Debe mostrar la clave solo al crearla", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+
+		// WHEN
+		created := createAPIKey(t, srv, token, "files:read", "files:write")
+
+		// THEN
+		assert.True(t, strings.HasPrefix(created.Key, "ipk_"))
+		assert.True(t, strings.HasPrefix(created.Key, created.Prefix))
+		assert.Equal(t, []string{"files:read", "files:write"}, created.Scopes)
+		res, env := srv.JSON(t, http.MethodGet, "/api/v1/api-keys", nil, token)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var keys []apiKeyResponse
+		env.Decode(t, &keys)
+		require.Len(t, keys, 1)
+		assert.Equal(t, created.ID, keys[0].ID)
+		assert.Empty(t, keys[0].Key)
+	})
+
+	t.Run("Debe subir y listar archivos con una API key con los scopes", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		key := createAPIKey(t, srv, token, "files:read", "files:write").Key
+
+		// WHEN
+		uploaded := uploadPNG(t, srv, key, 20, 20)
+
+		// THEN
+		res, env := srv.JSON(t, http.MethodGet, "/api/v1/files/", nil, key)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var files []fileResponse
+		env.Decode(t, &files)
+		require.Len(t, files, 1)
+		assert.Equal(t, uploaded.ID, files[0].ID)
+	})
+
+	t.Run("Debe retornar 403 cuando la API key no tiene el scope de la ruta", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		uploaded := uploadPNG(t, srv, token, 20, 20)
+		key := createAPIKey(t, srv, token, "files:read").Key
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodDelete, "/api/v1/files/"+uploaded.ID, nil, key)
+
+		// THEN
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+		assert.Equal(t, "INSUFFICIENT_SCOPE", env.Error.Code)
+	})
+
+	t.Run("Debe rechazar las API keys en las rutas que requieren sesión", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		key := createAPIKey(t, srv, token, "files:read", "files:write", "files:delete").Key
+
+		for _, path := range []string{"/api/v1/api-keys", "/api/v1/users/me/usage", "/api/v1/webhooks", "/api/v1/auth/sessions"} {
+			// WHEN
+			res, env := srv.JSON(t, http.MethodGet, path, nil, key)
+
+			// THEN
+			assert.Equal(t, http.StatusForbidden, res.StatusCode, path)
+			assert.Equal(t, "API_KEY_NOT_ALLOWED", env.Error.Code, path)
+		}
+	})
+
+	t.Run("Debe retornar 401 con una API key revocada", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		created := createAPIKey(t, srv, token, "files:read")
+		res, _ := srv.JSON(t, http.MethodGet, "/api/v1/files/", nil, created.Key)
+		require.Equal(t, http.StatusOK, res.StatusCode, "la clave queda en la caché")
+		res, _ = srv.JSON(t, http.MethodDelete, "/api/v1/api-keys/"+created.ID, nil, token)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodGet, "/api/v1/files/", nil, created.Key)
+
+		// THEN
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		assert.Equal(t, "INVALID_API_KEY", env.Error.Code)
+	})
+
+	t.Run("Debe retornar 401 con una API key caducada", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+		res, env := srv.JSON(t, http.MethodPost, "/api/v1/api-keys", map[string]interface{}{
+			"name": "CI", "scopes": []string{"files:read"}, "expires_at": time.Now().Add(time.Hour),
+		}, token)
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		var created apiKeyResponse
+		env.Decode(t, &created)
+		require.NoError(t, srv.DB.Table("api_keys").Where("id = ?", created.ID).
+			Update("expires_at", time.Now().Add(-time.Minute)).Error)
+
+		// WHEN
+		res, env = srv.JSON(t, http.MethodGet, "/api/v1/files/", nil, created.Key)
+
+		// THEN
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		assert.Equal(t, "INVALID_API_KEY", env.Error.Code)
+	})
+
+	t.Run("Debe retornar 422 con un scope desconocido", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		token := srv.NewUser(t)
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodPost, "/api/v1/api-keys", map[string]interface{}{
+			"name": "CI", "scopes": []string{"users:admin"},
+		}, token)
+
+		// THEN
+		assert.Equal(t, http.StatusUnprocessableEntity, res.StatusCode)
+		assert.Equal(t, "VALIDATION_FAILED", env.Error.Code)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Verificación de email y restablecimiento de contraseña
+// ─────────────────────────────────────────────────────────────────────────────
+
+var mailTokenPattern = regexp.MustCompile(`token=([0-9a-f]+)`)
+
+// mailToken devuelve el token del último correo enviado a email con ese
+// asunto.
+func mailToken(t *testing.T, srv *apitest.Server, email, subject string) string {
+	t.Helper()
+
+	sent := srv.Mailer.Sent(email)
+	for i := len(sent) - 1; i >= 0; i-- {
+		if sent[i].Subject != subject {
+			continue
+		}
+		match := mailTokenPattern.FindStringSubmatch(sent[i].Body)
+		require.NotNil(t, match, "el correo no lleva enlace")
+		return match[1]
+	}
+	t.Fatalf("no se envió ningún correo %q a %s", subject, email)
+	return ""
+}
+
+func TestRouter_VerifyEmail(t *testing.T) {
+	t.Run("Debe verificar el email con el enlace enviado al registrarse", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		token := mailToken(t, srv, "ana@test.com", "Verifica tu email")
+
+		// WHEN
+		res, _ := srv.JSON(t, http.MethodPost, "/api/v1/auth/verify-email", map[string]string{"token": token}, "")
+
+		// THEN
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var verified user.User
+		require.NoError(t, srv.DB.Where("email = ?", "ana@test.com").First(&verified).Error)
+		assert.NotNil(t, verified.EmailVerifiedAt)
+	})
+
+	t.Run("Debe retornar 400 al reutilizar el enlace", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		token := mailToken(t, srv, "ana@test.com", "Verifica tu email")
+		res, _ := srv.JSON(t, http.MethodPost, "/api/v1/auth/verify-email", map[string]string{"token": token}, "")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodPost, "/api/v1/auth/verify-email", map[string]string{"token": token}, "")
+
+		// THEN
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		assert.Equal(t, "INVALID_OR_EXPIRED_TOKEN", env.Error.Code)
+	})
+
+	t.Run("Debe invalidar el enlace anterior al reenviar la verificación", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		first := mailToken(t, srv, "ana@test.com", "Verifica tu email")
+		access := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+
+		// WHEN
+		res, _ := srv.JSON(t, http.MethodPost, "/api/v1/auth/verify-email/resend", nil, access)
+
+		// THEN
+		require.Equal(t, http.StatusAccepted, res.StatusCode)
+		second := mailToken(t, srv, "ana@test.com", "Verifica tu email")
+		assert.NotEqual(t, first, second)
+		old, _ := srv.JSON(t, http.MethodPost, "/api/v1/auth/verify-email", map[string]string{"token": first}, "")
+		assert.Equal(t, http.StatusBadRequest, old.StatusCode)
+		current, _ := srv.JSON(t, http.MethodPost, "/api/v1/auth/verify-email", map[string]string{"token": second}, "")
+		assert.Equal(t, http.StatusOK, current.StatusCode)
+	})
+
+	t.Run("Debe rechazar las subidas sin email verificado cuando se exige", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t, apitest.WithFileConfig(file.ServiceConfig{RequireVerifiedEmail: true}))
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		access := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+		png := checkerboardPNG(t, 16)
+
+		// WHEN
+		res := srv.Upload(t, access, "foto.png", "image/png", png)
+
+		// THEN
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+		assert.Equal(t, "EMAIL_NOT_VERIFIED", apitest.DecodeEnvelope(t, res).Error.Code)
+
+		token := mailToken(t, srv, "ana@test.com", "Verifica tu email")
+		verified, _ := srv.JSON(t, http.MethodPost, "/api/v1/auth/verify-email", map[string]string{"token": token}, "")
+		require.Equal(t, http.StatusOK, verified.StatusCode)
+		res = srv.Upload(t, access, "foto.png", "image/png", png)
+		assert.Equal(t, http.StatusCreated, res.StatusCode)
+	})
+}
+
+func TestRouter_ResetPassword(t *testing.T) {
+	t.Run("Debe restablecer la contraseña y cerrar las sesiones", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		access := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+		res, _ := srv.JSON(t, http.MethodPost, "/api/v1/auth/forgot-password", map[string]string{"email": "ana@test.com"}, "")
+		require.Equal(t, http.StatusAccepted, res.StatusCode)
+		token := mailToken(t, srv, "ana@test.com", "Restablece tu contraseña")
+
+		// WHEN
+		res, _ = srv.JSON(t, http.MethodPost, "/api/v1/auth/reset-password", map[string]string{
+			"token": token, "new_password": "nueva456",
+		}, "")
+
+		// THEN
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		old, _ := srv.JSON(t, http.MethodPost, "/api/v1/auth/signin", map[string]string{
+			"email": "ana@test.com", "password": "secreto123",
+		}, "")
+		assert.Equal(t, http.StatusUnauthorized, old.StatusCode)
+		srv.SignIn(t, "ana@test.com", "nueva456")
+		revoked, _ := srv.JSON(t, http.MethodGet, "/api/v1/auth/sessions", nil, access)
+		assert.Equal(t, http.StatusUnauthorized, revoked.StatusCode)
+	})
+
+	t.Run("Debe responder igual cuando el email no está registrado", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+
+		// WHEN
+		res, _ := srv.JSON(t, http.MethodPost, "/api/v1/auth/forgot-password", map[string]string{"email": "nadie@test.com"}, "")
+
+		// THEN
+		assert.Equal(t, http.StatusAccepted, res.StatusCode)
+		assert.Empty(t, srv.Mailer.Sent("nadie@test.com"))
+	})
+
+	t.Run("Debe retornar 400 con un token de verificación", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		token := mailToken(t, srv, "ana@test.com", "Verifica tu email")
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodPost, "/api/v1/auth/reset-password", map[string]string{
+			"token": token, "new_password": "nueva456",
+		}, "")
+
+		// THEN
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		assert.Equal(t, "INVALID_OR_EXPIRED_TOKEN", env.Error.Code)
+	})
+}
+
+// violatedRules devuelve las reglas de la política de contraseñas que
+// incumple field según los details de un 422.
+func violatedRules(t *testing.T, env apitest.Envelope, field string) []string {
+	t.Helper()
+
+	details, ok := env.Error.Details.(map[string]interface{})
+	require.True(t, ok, "details no es un objeto: %v", env.Error.Details)
+	violations, ok := details[field].([]interface{})
+	require.True(t, ok, "details no tiene violaciones de %s: %v", field, details)
+
+	rules := make([]string, 0, len(violations))
+	for _, v := range violations {
+		rules = append(rules, v.(map[string]interface{})["rule"].(string))
+	}
+	return rules
+}
+
+func TestRouter_PasswordPolicy(t *testing.T) {
+	t.Run("Debe rechazar al registrarse una contraseña filtrada o con el nombre", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+
+		// WHEN
+		breached, breachedEnv := srv.JSON(t, http.MethodPost, "/api/v1/auth/signup", map[string]string{
+			"name": "Ana García", "email": "ana@test.com", "password": "Password123",
+		}, "")
+		personal, personalEnv := srv.JSON(t, http.MethodPost, "/api/v1/auth/signup", map[string]string{
+			"name": "Ana García", "email": "ana@test.com", "password": "ana@test.com!",
+		}, "")
+
+		// THEN
+		assert.Equal(t, http.StatusUnprocessableEntity, breached.StatusCode)
+		assert.Equal(t, "VALIDATION_FAILED", breachedEnv.Error.Code)
+		assert.Equal(t, []string{"breached"}, violatedRules(t, breachedEnv, "password"))
+		assert.Equal(t, http.StatusUnprocessableEntity, personal.StatusCode)
+		assert.Equal(t, []string{"personal_info"}, violatedRules(t, personalEnv, "password"))
+	})
+
+	t.Run("Debe aplicar las reglas configuradas", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t, apitest.WithPasswordPolicy(password.Policy{
+			MinLength:       12,
+			RequiredClasses: []password.Class{password.Uppercase, password.Symbol},
+		}))
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodPost, "/api/v1/auth/signup", map[string]string{
+			"name": "Ana García", "email": "ana@test.com", "password": "secreto123",
+		}, "")
+
+		// THEN
+		assert.Equal(t, http.StatusUnprocessableEntity, res.StatusCode)
+		assert.Equal(t, []string{"min_length", "uppercase", "symbol"}, violatedRules(t, env, "password"))
+	})
+
+	t.Run("Debe rechazar al cambiarla una de las últimas contraseñas", func(t *testing.T) {
+		// GIVEN: la cuenta ha pasado de secreto123 a secreto456
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		token := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+		res, _ := srv.JSON(t, http.MethodPatch, "/api/v1/users/change-password/me", map[string]string{
+			"current_password": "secreto123",
+			"new_password":     "secreto456",
+		}, token)
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodPatch, "/api/v1/users/change-password/me", map[string]string{
+			"current_password": "secreto456",
+			"new_password":     "secreto123",
+		}, token)
+
+		// THEN
+		assert.Equal(t, http.StatusUnprocessableEntity, res.StatusCode)
+		assert.Equal(t, []string{"reused"}, violatedRules(t, env, "new_password"))
+		srv.SignIn(t, "ana@test.com", "secreto456")
+	})
+
+	t.Run("Debe permitir reintentar el restablecimiento con el mismo enlace", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		res, _ := srv.JSON(t, http.MethodPost, "/api/v1/auth/forgot-password", map[string]string{"email": "ana@test.com"}, "")
+		require.Equal(t, http.StatusAccepted, res.StatusCode)
+		token := mailToken(t, srv, "ana@test.com", "Restablece tu contraseña")
+
+		// WHEN: la primera contraseña es la actual
+		rejected, env := srv.JSON(t, http.MethodPost, "/api/v1/auth/reset-password", map[string]string{
+			"token": token, "new_password": "secreto123",
+		}, "")
+		accepted, _ := srv.JSON(t, http.MethodPost, "/api/v1/auth/reset-password", map[string]string{
+			"token": token, "new_password": "nueva456",
+		}, "")
+
+		// THEN
+		assert.Equal(t, http.StatusUnprocessableEntity, rejected.StatusCode)
+		assert.Equal(t, []string{"reused"}, violatedRules(t, env, "new_password"))
+		assert.Equal(t, http.StatusOK, accepted.StatusCode)
+		srv.SignIn(t, "ana@test.com", "nueva456")
+	})
+}
+
+// enableTwoFactor activa el segundo factor de la cuenta con un código del
+// paso actual y devuelve el secreto y los códigos de recuperación.
+func enableTwoFactor(t *testing.T, srv *apitest.Server, token string) (string, []string) {
+	t.Helper()
+
+	res, env := srv.JSON(t, http.MethodPost, "/api/v1/auth/2fa/enroll", nil, token)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	var enrollment auth.TwoFactorEnrollment
+	env.Decode(t, &enrollment)
+
+	code, err := totp.Code(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	res, env = srv.JSON(t, http.MethodPost, "/api/v1/auth/2fa/confirm", map[string]string{"code": code}, token)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var codes auth.RecoveryCodesResponse
+	env.Decode(t, &codes)
+
+	return enrollment.Secret, codes.RecoveryCodes
+}
+
+// challengeToken inicia sesión en una cuenta con segundo factor y devuelve
+// el reto.
+func challengeToken(t *testing.T, srv *apitest.Server, email, password string) string {
+	t.Helper()
+
+	result := srv.SignIn(t, email, password)
+	require.True(t, result.TwoFactorRequired)
+	require.Empty(t, result.AccessToken)
+	require.NotEmpty(t, result.ChallengeToken)
+	return result.ChallengeToken
+}
+
+func verifyTwoFactor(t *testing.T, srv *apitest.Server, challenge, code string) (*http.Response, apitest.Envelope) {
+	t.Helper()
+
+	return srv.JSON(t, http.MethodPost, "/api/v1/auth/2fa/verify", map[string]string{
+		"challenge_token": challenge, "code": code,
+	}, "")
+}
+
+func TestRouter_TwoFactor(t *testing.T) {
+	t.Run("Debe devolver la URI otpauth y su QR en PNG al inscribirse", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		token := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodPost, "/api/v1/auth/2fa/enroll", nil, token)
+
+		// THEN
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		var enrollment auth.TwoFactorEnrollment
+		env.Decode(t, &enrollment)
+		assert.NotEmpty(t, enrollment.Secret)
+		assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/"))
+		assert.Contains(t, enrollment.URI, "ana@test.com")
+		assert.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(enrollment.QRCode, "data:image/png;base64,"))
+		require.NoError(t, err)
+		_, err = png.Decode(bytes.NewReader(raw))
+		assert.NoError(t, err)
+	})
+
+	t.Run("Debe seguir devolviendo los tokens si la inscripción no se confirma", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		token := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+		res, _ := srv.JSON(t, http.MethodPost, "/api/v1/auth/2fa/enroll", nil, token)
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodPost, "/api/v1/auth/2fa/confirm", map[string]string{"code": "000000"}, token)
+
+		// THEN
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		assert.Equal(t, "INVALID_TWO_FACTOR_CODE", env.Error.Code)
+		result := srv.SignIn(t, "ana@test.com", "secreto123")
+		assert.False(t, result.TwoFactorRequired)
+		assert.NotEmpty(t, result.AccessToken)
+	})
+
+	t.Run("Debe pedir el segundo factor al iniciar sesión una vez activado", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		secret, recovery := enableTwoFactor(t, srv, srv.SignIn(t, "ana@test.com", "secreto123").AccessToken)
+		require.Len(t, recovery, 10)
+		challenge := challengeToken(t, srv, "ana@test.com", "secreto123")
+
+		// WHEN: el código de la confirmación ya se usó, así que vale el
+		// del paso siguiente, dentro del margen
+		code, err := totp.Code(secret, time.Now().Add(totp.Period))
+		require.NoError(t, err)
+		res, env := verifyTwoFactor(t, srv, challenge, code)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var tokens auth.Auth
+		env.Decode(t, &tokens)
+		assert.NotEmpty(t, tokens.RefreshToken)
+		sessions, _ := srv.JSON(t, http.MethodGet, "/api/v1/auth/sessions", nil, tokens.AccessToken)
+		assert.Equal(t, http.StatusOK, sessions.StatusCode)
+		reused, env := verifyTwoFactor(t, srv, challenge, recovery[0])
+		assert.Equal(t, http.StatusUnauthorized, reused.StatusCode)
+		assert.Equal(t, "INVALID_CHALLENGE", env.Error.Code)
+	})
+
+	t.Run("Debe rechazar un código TOTP ya usado", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		secret, _ := enableTwoFactor(t, srv, srv.SignIn(t, "ana@test.com", "secreto123").AccessToken)
+		challenge := challengeToken(t, srv, "ana@test.com", "secreto123")
+		code, err := totp.Code(secret, time.Now().Add(-totp.Period))
+		require.NoError(t, err)
+
+		// WHEN
+		res, env := verifyTwoFactor(t, srv, challenge, code)
+
+		// THEN
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		assert.Equal(t, "INVALID_TWO_FACTOR_CODE", env.Error.Code)
+	})
+
+	t.Run("Debe aceptar cada código de recuperación una sola vez", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		_, recovery := enableTwoFactor(t, srv, srv.SignIn(t, "ana@test.com", "secreto123").AccessToken)
+
+		// WHEN
+		res, env := verifyTwoFactor(t, srv, challengeToken(t, srv, "ana@test.com", "secreto123"), strings.ToUpper(recovery[0]))
+		again, againEnv := verifyTwoFactor(t, srv, challengeToken(t, srv, "ana@test.com", "secreto123"), recovery[0])
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var tokens auth.Auth
+		env.Decode(t, &tokens)
+		assert.Equal(t, http.StatusUnauthorized, again.StatusCode)
+		assert.Equal(t, "INVALID_TWO_FACTOR_CODE", againEnv.Error.Code)
+		status, statusEnv := srv.JSON(t, http.MethodGet, "/api/v1/auth/2fa", nil, tokens.AccessToken)
+		require.Equal(t, http.StatusOK, status.StatusCode)
+		var body auth.TwoFactorStatus
+		statusEnv.Decode(t, &body)
+		assert.True(t, body.Enabled)
+		assert.EqualValues(t, 9, body.RecoveryCodesLeft)
+	})
+
+	t.Run("Debe descartar el reto tras demasiados códigos incorrectos", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		_, recovery := enableTwoFactor(t, srv, srv.SignIn(t, "ana@test.com", "secreto123").AccessToken)
+		challenge := challengeToken(t, srv, "ana@test.com", "secreto123")
+		for range 4 {
+			res, env := verifyTwoFactor(t, srv, challenge, "aaaaa-aaaaa")
+			require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+			require.Equal(t, "INVALID_TWO_FACTOR_CODE", env.Error.Code)
+		}
+
+		// WHEN
+		res, env := verifyTwoFactor(t, srv, challenge, "aaaaa-aaaaa")
+		valid, validEnv := verifyTwoFactor(t, srv, challenge, recovery[0])
+
+		// THEN
+		assert.Equal(t, "INVALID_CHALLENGE", env.Error.Code)
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, valid.StatusCode)
+		assert.Equal(t, "INVALID_CHALLENGE", validEnv.Error.Code)
+	})
+
+	t.Run("Debe limitar los intentos de un reto aunque lleguen en paralelo", func(t *testing.T) {
+		// GIVEN
+		const requests = 20
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		enableTwoFactor(t, srv, srv.SignIn(t, "ana@test.com", "secreto123").AccessToken)
+		challenge := challengeToken(t, srv, "ana@test.com", "secreto123")
+
+		// WHEN
+		codes := make([]string, requests)
+		var wg sync.WaitGroup
+		for i := range codes {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, env := verifyTwoFactor(t, srv, challenge, "aaaaa-aaaaa")
+				codes[i] = env.Error.Code
+			}(i)
+		}
+		wg.Wait()
+
+		// THEN: solo los cuatro primeros códigos dejan el reto abierto
+		checked := 0
+		for _, code := range codes {
+			if code == "INVALID_TWO_FACTOR_CODE" {
+				checked++
+			} else {
+				assert.Equal(t, "INVALID_CHALLENGE", code)
+			}
+		}
+		assert.Equal(t, 4, checked)
+		var throttle auth.Throttle
+		require.NoError(t, srv.DB.Where("key = ?", "account:ana@test.com").First(&throttle).Error)
+		assert.Equal(t, 5, throttle.Failures)
+	})
+
+	t.Run("Debe bloquear la cuenta con los códigos incorrectos de varios retos", func(t *testing.T) {
+		// GIVEN: dos retos abiertos con la contraseña correcta
+		srv := apitest.New(t, apitest.WithLockout(auth.LockoutConfig{
+			Account: auth.LockoutPolicy{FreeAttempts: 10, MaxFailures: 3},
+		}))
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		_, recovery := enableTwoFactor(t, srv, srv.SignIn(t, "ana@test.com", "secreto123").AccessToken)
+		first := challengeToken(t, srv, "ana@test.com", "secreto123")
+		second := challengeToken(t, srv, "ana@test.com", "secreto123")
+		for range 3 {
+			res, _ := verifyTwoFactor(t, srv, first, "aaaaa-aaaaa")
+			require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		}
+
+		// WHEN
+		res, env := verifyTwoFactor(t, srv, second, recovery[0])
+
+		// THEN
+		assert.Equal(t, http.StatusLocked, res.StatusCode)
+		assert.Equal(t, "ACCOUNT_LOCKED", env.Error.Code)
+	})
+
+	t.Run("Debe rechazar inscribirse otra vez con el segundo factor activado", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		token := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+		enableTwoFactor(t, srv, token)
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodPost, "/api/v1/auth/2fa/enroll", nil, token)
+
+		// THEN
+		assert.Equal(t, http.StatusConflict, res.StatusCode)
+		assert.Equal(t, "TWO_FACTOR_ALREADY_ENABLED", env.Error.Code)
+	})
+
+	t.Run("Debe volver a devolver los tokens al desactivarlo", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		token := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+		_, recovery := enableTwoFactor(t, srv, token)
+
+		// WHEN
+		wrong, wrongEnv := srv.JSON(t, http.MethodPost, "/api/v1/auth/2fa/disable", map[string]string{"code": "bbbbb-bbbbb"}, token)
+		res, _ := srv.JSON(t, http.MethodPost, "/api/v1/auth/2fa/disable", map[string]string{"code": recovery[1]}, token)
+
+		// THEN
+		assert.Equal(t, http.StatusUnauthorized, wrong.StatusCode)
+		assert.Equal(t, "INVALID_TWO_FACTOR_CODE", wrongEnv.Error.Code)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		result := srv.SignIn(t, "ana@test.com", "secreto123")
+		assert.False(t, result.TwoFactorRequired)
+		assert.NotEmpty(t, result.AccessToken)
+	})
+
+	t.Run("Debe invalidar los códigos de recuperación anteriores al regenerarlos", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		token := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+		_, recovery := enableTwoFactor(t, srv, token)
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodPost, "/api/v1/auth/2fa/recovery-codes", map[string]string{"code": recovery[0]}, token)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var codes auth.RecoveryCodesResponse
+		env.Decode(t, &codes)
+		assert.Len(t, codes.RecoveryCodes, 10)
+		old, _ := verifyTwoFactor(t, srv, challengeToken(t, srv, "ana@test.com", "secreto123"), recovery[1])
+		assert.Equal(t, http.StatusUnauthorized, old.StatusCode)
+		fresh, _ := verifyTwoFactor(t, srv, challengeToken(t, srv, "ana@test.com", "secreto123"), codes.RecoveryCodes[0])
+		assert.Equal(t, http.StatusOK, fresh.StatusCode)
+	})
+}
+
+func TestRouter_JWKS(t *testing.T) {
+	t.Run("Debe publicar la clave con la que se firman los access tokens", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		token := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+
+		// WHEN
+		res := srv.Do(t, http.MethodGet, "/.well-known/jwks.json", nil, "", "")
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "public, max-age=300", res.Header.Get("Cache-Control"))
+		var jwks sharedAuth.JWKS
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&jwks))
+		require.Len(t, jwks.Keys, 2, "la activa y la siguiente")
+
+		// La firma se comprueba solo con lo publicado, como haría otro
+		// servicio.
+		parts := strings.Split(token, ".")
+		require.Len(t, parts, 3)
+		key := publishedKey(t, jwks, parts[0])
+		assert.Equal(t, "EdDSA", key.Algorithm)
+		public, err := base64.RawURLEncoding.DecodeString(key.X)
+		require.NoError(t, err)
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		require.NoError(t, err)
+		assert.True(t, ed25519.Verify(public, []byte(parts[0]+"."+parts[1]), signature))
+	})
+
+	t.Run("Debe aceptar los tokens de la clave anterior tras rotar", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		old := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+
+		// WHEN
+		require.NoError(t, srv.Keyring.Rotate(time.Now().Add(sharedAuth.DefaultKeyRotationInterval)))
+
+		// THEN
+		fresh := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+		for _, token := range []string{old, fresh} {
+			res, _ := srv.JSON(t, http.MethodGet, "/api/v1/auth/sessions", nil, token)
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+		}
+		res := srv.Do(t, http.MethodGet, "/.well-known/jwks.json", nil, "", "")
+		var jwks sharedAuth.JWKS
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&jwks))
+		assert.Len(t, jwks.Keys, 3, "la anterior, la activa y la siguiente")
+	})
+
+	t.Run("Debe publicar la clave nueva antes de firmar con ella", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		res := srv.Do(t, http.MethodGet, "/.well-known/jwks.json", nil, "", "")
+		var cached sharedAuth.JWKS
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&cached))
+
+		// WHEN
+		require.NoError(t, srv.Keyring.Rotate(time.Now().Add(sharedAuth.DefaultKeyRotationInterval)))
+
+		// THEN
+		// Un servicio con el JWKS de antes de rotar ya tiene la clave que
+		// firma ahora.
+		fresh := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+		publishedKey(t, cached, strings.Split(fresh, ".")[0])
+	})
+}
+
+// publishedKey devuelve la clave del JWKS con el kid de la cabecera del
+// token.
+func publishedKey(t *testing.T, jwks sharedAuth.JWKS, header string) sharedAuth.JWK {
+	t.Helper()
+
+	decoded, err := base64.RawURLEncoding.DecodeString(header)
+	require.NoError(t, err)
+	var parsed struct {
+		KeyID string `json:"kid"`
+	}
+	require.NoError(t, json.Unmarshal(decoded, &parsed))
+	for _, key := range jwks.Keys {
+		if key.KeyID == parsed.KeyID {
+			return key
+		}
+	}
+	t.Fatalf("el JWKS no publica la clave %s", parsed.KeyID)
+	return sharedAuth.JWK{}
+}
+
+const oidcRedirectURL = "http://localhost:5173/oidc/mock/callback"
+
+var oidcAna = oidctest.User{Subject: "sub-ana", Email: "ana@test.com", EmailVerified: true, Name: "Ana García"}
+
+// newOIDCServer levanta un proveedor de prueba y un servidor que lo tiene
+// configurado como "mock".
+func newOIDCServer(t *testing.T) (*apitest.Server, *oidctest.Provider) {
+	t.Helper()
+
+	provider := oidctest.New(t)
+	return apitest.New(t, apitest.WithOIDCProvider("mock", provider.Config(oidcRedirectURL))), provider
+}
+
+// authorizeOIDC inicia sesión con el proveedor como lo haría el frontend y
+// devuelve el code y el state con los que vuelve.
+func authorizeOIDC(t *testing.T, srv *apitest.Server, provider *oidctest.Provider, u oidctest.User) (string, string) {
+	t.Helper()
+
+	res, env := srv.JSON(t, http.MethodPost, "/api/v1/auth/oidc/mock/start", nil, "")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var start auth.OIDCStartResponse
+	env.Decode(t, &start)
+
+	code, state := provider.Authorize(t, start.AuthorizationURL, u)
+	require.Equal(t, start.State, state)
+	return code, state
+}
+
+func oidcCallback(t *testing.T, srv *apitest.Server, code, state string) (*http.Response, apitest.Envelope) {
+	t.Helper()
+
+	return srv.JSON(t, http.MethodPost, "/api/v1/auth/oidc/mock/callback", map[string]string{
+		"code": code, "state": state,
+	}, "")
+}
+
+func TestRouter_OIDC(t *testing.T) {
+	t.Run("Debe listar los proveedores configurados", func(t *testing.T) {
+		// GIVEN
+		srv, _ := newOIDCServer(t)
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodGet, "/api/v1/auth/oidc/providers", nil, "")
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var providers []string
+		env.Decode(t, &providers)
+		assert.Equal(t, []string{"mock"}, providers)
+	})
+
+	t.Run("Debe crear la cuenta verificada en el primer inicio de sesión y reutilizarla después", func(t *testing.T) {
+		// GIVEN
+		srv, provider := newOIDCServer(t)
+		code, state := authorizeOIDC(t, srv, provider, oidcAna)
+
+		// WHEN
+		res, env := oidcCallback(t, srv, code, state)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var tokens auth.Auth
+		env.Decode(t, &tokens)
+		assert.NotEmpty(t, tokens.RefreshToken)
+		sessions, _ := srv.JSON(t, http.MethodGet, "/api/v1/auth/sessions", nil, tokens.AccessToken)
+		assert.Equal(t, http.StatusOK, sessions.StatusCode)
+		var created user.User
+		require.NoError(t, srv.DB.Where("email = ?", "ana@test.com").First(&created).Error)
+		assert.Equal(t, "Ana García", created.Name)
+		assert.NotNil(t, created.EmailVerifiedAt)
+
+		code, state = authorizeOIDC(t, srv, provider, oidcAna)
+		again, _ := oidcCallback(t, srv, code, state)
+		assert.Equal(t, http.StatusOK, again.StatusCode)
+		var count int64
+		require.NoError(t, srv.DB.Model(&user.User{}).Count(&count).Error)
+		assert.EqualValues(t, 1, count)
+	})
+
+	t.Run("Debe vincular la identidad a la cuenta con el mismo email verificado", func(t *testing.T) {
+		// GIVEN
+		srv, provider := newOIDCServer(t)
+		srv.SignUp(t, "Ana García", "ana@test.com", "secreto123")
+		token := mailToken(t, srv, "ana@test.com", "Verifica tu email")
+		res, _ := srv.JSON(t, http.MethodPost, "/api/v1/auth/verify-email", map[string]string{"token": token}, "")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		code, state := authorizeOIDC(t, srv, provider, oidcAna)
+
+		// WHEN
+		res, _ = oidcCallback(t, srv, code, state)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var existing user.User
+		require.NoError(t, srv.DB.Where("email = ?", "ana@test.com").First(&existing).Error)
+		var identity auth.ExternalIdentity
+		require.NoError(t, srv.DB.Where("provider = ? AND subject = ?", "mock", "sub-ana").First(&identity).Error)
+		assert.Equal(t, existing.ID, identity.UserID)
+	})
+
+	t.Run("Debe negarse a vincular una cuenta con el email sin verificar", func(t *testing.T) {
+		// GIVEN
+		srv, provider := newOIDCServer(t)
+		srv.SignUp(t, "Impostor", "ana@test.com", "secreto123")
+		code, state := authorizeOIDC(t, srv, provider, oidcAna)
+
+		// WHEN
+		res, env := oidcCallback(t, srv, code, state)
+
+		// THEN
+		assert.Equal(t, http.StatusConflict, res.StatusCode)
+		assert.Equal(t, "ACCOUNT_EMAIL_NOT_VERIFIED", env.Error.Code)
+		var count int64
+		require.NoError(t, srv.DB.Model(&auth.ExternalIdentity{}).Count(&count).Error)
+		assert.Zero(t, count)
+	})
+
+	t.Run("Debe rechazar una identidad sin email verificado por el proveedor", func(t *testing.T) {
+		// GIVEN
+		srv, provider := newOIDCServer(t)
+		unverified := oidcAna
+		unverified.EmailVerified = false
+		code, state := authorizeOIDC(t, srv, provider, unverified)
+
+		// WHEN
+		res, env := oidcCallback(t, srv, code, state)
+
+		// THEN
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+		assert.Equal(t, "OIDC_EMAIL_NOT_VERIFIED", env.Error.Code)
+	})
+
+	t.Run("Debe aceptar cada state una sola vez", func(t *testing.T) {
+		// GIVEN
+		srv, provider := newOIDCServer(t)
+		code, state := authorizeOIDC(t, srv, provider, oidcAna)
+		res, _ := oidcCallback(t, srv, code, state)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		// WHEN
+		res, env := oidcCallback(t, srv, code, state)
+
+		// THEN
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		assert.Equal(t, "INVALID_OIDC_STATE", env.Error.Code)
+	})
+
+	t.Run("Debe rechazar un ID token manipulado", func(t *testing.T) {
+		// GIVEN
+		srv, provider := newOIDCServer(t)
+		provider.Tamper = func(c jwt.MapClaims) { c["aud"] = "otro-cliente" }
+		code, state := authorizeOIDC(t, srv, provider, oidcAna)
+
+		// WHEN
+		res, env := oidcCallback(t, srv, code, state)
+
+		// THEN
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		assert.Equal(t, "OIDC_LOGIN_FAILED", env.Error.Code)
+	})
+
+	t.Run("Debe pedir el segundo factor si la cuenta lo tiene activado", func(t *testing.T) {
+		// GIVEN
+		srv, provider := newOIDCServer(t)
+		code, state := authorizeOIDC(t, srv, provider, oidcAna)
+		res, env := oidcCallback(t, srv, code, state)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var tokens auth.Auth
+		env.Decode(t, &tokens)
+		enableTwoFactor(t, srv, tokens.AccessToken)
+		code, state = authorizeOIDC(t, srv, provider, oidcAna)
+
+		// WHEN
+		res, env = oidcCallback(t, srv, code, state)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var result auth.Auth
+		env.Decode(t, &result)
+		assert.True(t, result.TwoFactorRequired)
+		assert.Empty(t, result.AccessToken)
+		assert.NotEmpty(t, result.ChallengeToken)
+	})
+
+	t.Run("Debe retornar 404 con un proveedor no configurado", func(t *testing.T) {
+		// GIVEN
+		srv, _ := newOIDCServer(t)
+
+		// WHEN
+		res, env := srv.JSON(t, http.MethodPost, "/api/v1/auth/oidc/otro/start", nil, "")
+
+		// THEN
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+		assert.Equal(t, "OIDC_PROVIDER_NOT_FOUND", env.Error.Code)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Borrado y exportación de la cuenta
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRouter_DeleteAccount(t *testing.T) {
+	t.Run("Debe cerrar las sesiones y revocar las API keys al borrar la cuenta", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		anaID, _ := newMember(t, srv, "ana@test.com")
+		tokens := srv.SignIn(t, "ana@test.com", "secreto123")
+		key := createAPIKey(t, srv, tokens.AccessToken, "files:read").Key
+		res, _ := srv.JSON(t, http.MethodGet, "/api/v1/files/", nil, key)
+		require.Equal(t, http.StatusOK, res.StatusCode, "la clave queda en la caché")
+
+		// WHEN
+		res, _ = srv.JSON(t, http.MethodDelete, "/api/v1/users/"+anaID, nil, tokens.AccessToken)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		access, _ := srv.JSON(t, http.MethodGet, "/api/v1/users/me/usage", nil, tokens.AccessToken)
+		assert.Equal(t, http.StatusUnauthorized, access.StatusCode)
+		apiKey, _ := srv.JSON(t, http.MethodGet, "/api/v1/files/", nil, key)
+		assert.Equal(t, http.StatusUnauthorized, apiKey.StatusCode)
+		renewed, _ := renew(t, srv, tokens.RefreshToken)
+		assert.NotEqual(t, http.StatusOK, renewed.StatusCode)
+	})
+
+	t.Run("Debe borrar en segundo plano los archivos y sus objetos", func(t *testing.T) {
+		// GIVEN: un archivo propio y otro con el mismo contenido de otro usuario
+		srv := apitest.New(t, apitest.WithFileConfig(file.ServiceConfig{DedupAcrossUsers: true}))
+		anaID, ana := newMember(t, srv, "ana@test.com")
+		content := apitest.NewPNG(t, 40, 40)
+		uploadPNGContent(t, srv, ana, content)
+		own := uploadPNG(t, srv, ana, 30, 30)
+		luis := srv.NewUser(t)
+		shared := uploadPNGContent(t, srv, luis, content)
+		var ownBlob file.Blob
+		require.NoError(t, srv.DB.Where("id = (?)", srv.DB.Model(&file.File{}).Select("blob_id").Where("id = ?", own.ID)).First(&ownBlob).Error)
+
+		// WHEN
+		res, _ := srv.JSON(t, http.MethodDelete, "/api/v1/users/"+anaID, nil, ana)
+		srv.WaitForJobs(t)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var remaining int64
+		require.NoError(t, srv.DB.Model(&file.File{}).Where("user_id = ?", anaID).Count(&remaining).Error)
+		assert.Zero(t, remaining)
+		assert.Equal(t, int64(1), countBlobs(t, srv))
+		_, err := srv.Storage.Get(ownBlob.ObjectKey)
+		assert.Error(t, err)
+		_, err = srv.Storage.Get(ownBlob.ThumbnailObjectKey)
+		assert.Error(t, err)
+		kept := srv.Do(t, http.MethodGet, pathOf(srv, shared.URL), nil, "", luis)
+		assert.Equal(t, http.StatusOK, kept.StatusCode)
+	})
+
+	t.Run("Debe borrar los webhooks y el segundo factor sin entregar los eventos de la purga", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		anaID, ana := newMember(t, srv, "ana@test.com")
+		rcv := newWebhookReceiver(t, alwaysOK)
+		createWebhook(t, srv, ana, rcv.URL, "file.deleted")
+		uploadPNG(t, srv, ana, 30, 30)
+		enableTwoFactor(t, srv, ana)
+		require.NoError(t, srv.DB.Create(&auth.ExternalIdentity{
+			ID: "ext-ana", UserID: anaID, Provider: "google", Subject: "sub-ana",
+		}).Error)
+
+		// WHEN
+		res, _ := srv.JSON(t, http.MethodDelete, "/api/v1/users/"+anaID, nil, ana)
+		srv.WaitForJobs(t)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var remaining int64
+		require.NoError(t, srv.DB.Model(&file.File{}).Where("user_id = ?", anaID).Count(&remaining).Error)
+		assert.Zero(t, remaining)
+		assert.Zero(t, rcv.count())
+		for _, model := range []interface{}{&webhook.Endpoint{}, &auth.TwoFactor{}, &auth.RecoveryCode{}, &auth.ExternalIdentity{}} {
+			var count int64
+			require.NoError(t, srv.DB.Model(model).Where("user_id = ?", anaID).Count(&count).Error)
+			assert.Zero(t, count, "%T", model)
+		}
+	})
+}
+
+// readExport descarga la exportación de la cuenta y devuelve el contenido de
+// cada entrada del ZIP por su nombre.
+func readExport(t *testing.T, srv *apitest.Server, token string) map[string][]byte {
+	t.Helper()
+
+	res := srv.Do(t, http.MethodGet, "/api/v1/users/me/export", nil, "", token)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/zip", res.Header.Get("Content-Type"))
+	assert.Contains(t, res.Header.Get("Content-Disposition"), "attachment")
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	require.NoError(t, err)
+
+	entries := make(map[string][]byte, len(archive.File))
+	for _, f := range archive.File {
+		r, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(r)
+		r.Close()
+		require.NoError(t, err)
+		entries[f.Name] = content
+	}
+	return entries
+}
+
+func TestRouter_Export(t *testing.T) {
+	t.Run("Debe exportar el perfil, los metadatos y los originales", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		anaID, ana := newMember(t, srv, "ana@test.com")
+		content := apitest.NewPNG(t, 40, 30)
+		uploaded := uploadPNGContent(t, srv, ana, content)
+
+		// WHEN
+		entries := readExport(t, srv, ana)
+
+		// THEN
+		var profile export.Profile
+		require.NoError(t, json.Unmarshal(entries["profile.json"], &profile))
+		assert.Equal(t, anaID, profile.ID)
+		assert.Equal(t, "ana@test.com", profile.Email)
+
+		var files []export.FileMetadata
+		require.NoError(t, json.Unmarshal(entries["files.json"], &files))
+		require.Len(t, files, 1)
+		assert.Equal(t, uploaded.ID, files[0].ID)
+		assert.Equal(t, int64(40), files[0].Width)
+		assert.Equal(t, "originals/"+uploaded.ID+"-foto.png", files[0].Path)
+		assert.Equal(t, content, entries[files[0].Path])
+		assert.Len(t, entries, 3)
+	})
+
+	t.Run("Debe exportar solo los archivos propios", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		uploadPNG(t, srv, srv.NewUser(t), 20, 20)
+		token := srv.NewUser(t)
+
+		// WHEN
+		entries := readExport(t, srv, token)
+
+		// THEN
+		var files []export.FileMetadata
+		require.NoError(t, json.Unmarshal(entries["files.json"], &files))
+		assert.Empty(t, files)
+		assert.Len(t, entries, 2)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Modo cookie
+// ─────────────────────────────────────────────────────────────────────────────
+
+var cookieMode = http.Header{sharedAuth.SessionModeHeader: {sharedAuth.SessionModeCookie}}
+
+// cookiesOf devuelve las cookies que fija la respuesta, por nombre.
+func cookiesOf(res *http.Response) map[string]*http.Cookie {
+	cookies := make(map[string]*http.Cookie)
+	for _, c := range res.Cookies() {
+		cookies[c.Name] = c
+	}
+	return cookies
+}
+
+// withCookies envía las cookies y, si csrf no está vacío, el token CSRF.
+func withCookies(cookies map[string]*http.Cookie, csrf string) http.Header {
+	header := http.Header{}
+	for _, c := range cookies {
+		header.Add("Cookie", c.Name+"="+c.Value)
+	}
+	if csrf != "" {
+		header.Set(sharedAuth.CSRFHeader, csrf)
+	}
+	return header
+}
+
+// cookieSignIn inicia sesión en modo cookie y devuelve las cookies fijadas
+// y el token CSRF del cuerpo.
+func cookieSignIn(t *testing.T, srv *apitest.Server, email, password string) (map[string]*http.Cookie, string) {
+	t.Helper()
+
+	body := strings.NewReader(`{"email":"` + email + `","password":"` + password + `"}`)
+	res := srv.Do(t, http.MethodPost, "/api/v1/auth/signin", body, "application/json", "", cookieMode)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var result auth.Auth
+	apitest.DecodeEnvelope(t, res).Decode(t, &result)
+	return cookiesOf(res), result.CSRFToken
+}
+
+func TestRouter_CookieSession(t *testing.T) {
+	t.Run("Debe entregar los tokens en cookies HttpOnly y no en el cuerpo", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana", "ana@test.com", "secreto123")
+
+		// WHEN
+		body := strings.NewReader(`{"email":"ana@test.com","password":"secreto123"}`)
+		res := srv.Do(t, http.MethodPost, "/api/v1/auth/signin", body, "application/json", "", cookieMode)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var result auth.Auth
+		apitest.DecodeEnvelope(t, res).Decode(t, &result)
+		assert.Empty(t, result.AccessToken)
+		assert.Empty(t, result.RefreshToken)
+		assert.NotEmpty(t, result.CSRFToken)
+
+		cookies := cookiesOf(res)
+		for _, name := range []string{sharedAuth.AccessCookie, sharedAuth.RefreshCookie} {
+			require.Contains(t, cookies, name)
+			assert.True(t, cookies[name].HttpOnly, name)
+			assert.True(t, cookies[name].Secure, name)
+			assert.Equal(t, http.SameSiteStrictMode, cookies[name].SameSite, name)
+		}
+		assert.Equal(t, "/api/v1/auth", cookies[sharedAuth.RefreshCookie].Path)
+		require.Contains(t, cookies, sharedAuth.CSRFCookie)
+		assert.False(t, cookies[sharedAuth.CSRFCookie].HttpOnly)
+		assert.Equal(t, result.CSRFToken, cookies[sharedAuth.CSRFCookie].Value)
+	})
+
+	t.Run("Debe autenticar con la cookie sin token CSRF en los métodos seguros", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana", "ana@test.com", "secreto123")
+		cookies, _ := cookieSignIn(t, srv, "ana@test.com", "secreto123")
+
+		// WHEN
+		res := srv.Do(t, http.MethodGet, "/api/v1/users/me/usage", nil, "", "", withCookies(cookies, ""))
+
+		// THEN
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	})
+
+	t.Run("Debe exigir el token CSRF en los métodos que modifican algo", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana", "ana@test.com", "secreto123")
+		cookies, csrf := cookieSignIn(t, srv, "ana@test.com", "secreto123")
+
+		for _, header := range []http.Header{withCookies(cookies, ""), withCookies(cookies, "otro-token")} {
+			// WHEN
+			res := srv.Do(t, http.MethodDelete, "/api/v1/auth/sessions", nil, "", "", header)
+
+			// THEN
+			assert.Equal(t, http.StatusForbidden, res.StatusCode)
+			assert.Equal(t, "INVALID_CSRF_TOKEN", apitest.DecodeEnvelope(t, res).Error.Code)
+		}
+		res := srv.Do(t, http.MethodPost, "/api/v1/auth/signout", nil, "", "", withCookies(cookies, csrf))
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	})
+
+	t.Run("Debe borrar las cookies al cerrar sesión", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana", "ana@test.com", "secreto123")
+		cookies, csrf := cookieSignIn(t, srv, "ana@test.com", "secreto123")
+
+		// WHEN
+		res := srv.Do(t, http.MethodPost, "/api/v1/auth/signout", nil, "", "", withCookies(cookies, csrf))
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		cleared := cookiesOf(res)
+		for _, name := range []string{sharedAuth.AccessCookie, sharedAuth.RefreshCookie, sharedAuth.CSRFCookie} {
+			require.Contains(t, cleared, name)
+			assert.Empty(t, cleared[name].Value, name)
+			assert.Negative(t, cleared[name].MaxAge, name)
+		}
+		after := srv.Do(t, http.MethodGet, "/api/v1/users/me/usage", nil, "", "", withCookies(cookies, ""))
+		assert.Equal(t, http.StatusUnauthorized, after.StatusCode)
+	})
+
+	t.Run("Debe renovar la sesión con la cookie del refresh token", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana", "ana@test.com", "secreto123")
+		cookies, csrf := cookieSignIn(t, srv, "ana@test.com", "secreto123")
+		renewHeader := withCookies(cookies, csrf)
+		renewHeader.Set(sharedAuth.SessionModeHeader, sharedAuth.SessionModeCookie)
+
+		// WHEN
+		res := srv.Do(t, http.MethodPost, "/api/v1/auth/renew-session", nil, "", "", renewHeader)
+
+		// THEN
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var result auth.Auth
+		apitest.DecodeEnvelope(t, res).Decode(t, &result)
+		assert.Empty(t, result.AccessToken)
+		assert.NotEqual(t, csrf, result.CSRFToken)
+		renewed := cookiesOf(res)
+		require.Contains(t, renewed, sharedAuth.RefreshCookie)
+		assert.NotEqual(t, cookies[sharedAuth.RefreshCookie].Value, renewed[sharedAuth.RefreshCookie].Value)
+
+		usage := srv.Do(t, http.MethodGet, "/api/v1/users/me/usage", nil, "", "", withCookies(renewed, ""))
+		assert.Equal(t, http.StatusOK, usage.StatusCode)
+	})
+
+	t.Run("Debe rechazar la renovación por cookie sin token CSRF", func(t *testing.T) {
+		// GIVEN
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana", "ana@test.com", "secreto123")
+		cookies, _ := cookieSignIn(t, srv, "ana@test.com", "secreto123")
+		renewHeader := withCookies(cookies, "")
+		renewHeader.Set(sharedAuth.SessionModeHeader, sharedAuth.SessionModeCookie)
+
+		// WHEN
+		res := srv.Do(t, http.MethodPost, "/api/v1/auth/renew-session", nil, "", "", renewHeader)
+
+		// THEN
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+		assert.Equal(t, "INVALID_CSRF_TOKEN", apitest.DecodeEnvelope(t, res).Error.Code)
+	})
+
+	t.Run("Debe seguir aceptando el bearer token sin token CSRF", func(t *testing.T) {
+		// GIVEN: un cliente de API que además arrastra una cookie de sesión
+		srv := apitest.New(t)
+		srv.SignUp(t, "Ana", "ana@test.com", "secreto123")
+		cookies, _ := cookieSignIn(t, srv, "ana@test.com", "secreto123")
+		token := srv.SignIn(t, "ana@test.com", "secreto123").AccessToken
+
+		// WHEN
+		res := srv.Do(t, http.MethodPost, "/api/v1/auth/signout", nil, "", token, withCookies(cookies, ""))
+
+		// THEN
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	})
+}
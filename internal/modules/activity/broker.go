@@ -0,0 +1,49 @@
+package activity
+
+import "sync"
+
+// broker avisa a los streams abiertos de un usuario de que hay eventos
+// nuevos. Solo despierta: el stream lee los eventos de la base de datos, así
+// que un aviso perdido o repetido no cambia lo que recibe el cliente.
+type broker struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan struct{}]struct{}
+}
+
+func newBroker() *broker {
+	return &broker{subscribers: make(map[string]map[chan struct{}]struct{})}
+}
+
+func (b *broker) subscribe(userID string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[chan struct{}]struct{})
+	}
+	b.subscribers[userID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.subscribers[userID], ch)
+		if len(b.subscribers[userID]) == 0 {
+			delete(b.subscribers, userID)
+		}
+	}
+}
+
+func (b *broker) notify(userID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[userID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// Ya tiene un aviso pendiente.
+		}
+	}
+}
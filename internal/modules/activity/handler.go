@@ -0,0 +1,153 @@
+package activity
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"image-processing-service/internal/shared/auth"
+	"image-processing-service/internal/shared/utils"
+)
+
+var (
+	ErrInvalidLastEventID = utils.NewError(400, "INVALID_LAST_EVENT_ID", "Last-Event-ID debe ser un número entero positivo", nil)
+	ErrStreamUnsupported  = utils.NewError(500, "STREAM_UNSUPPORTED", "El servidor no admite respuestas en streaming", nil)
+)
+
+// batchSize es el máximo de eventos que se leen por consulta.
+const batchSize = 100
+
+type Handler interface {
+	Stream(w http.ResponseWriter, r *http.Request)
+}
+
+// StreamConfig agrupa los intervalos del stream. Los valores a cero toman un
+// valor por defecto.
+type StreamConfig struct {
+	// PollInterval es cada cuánto se consulta el log aunque no llegue un
+	// aviso, para ver los eventos publicados por otras instancias.
+	PollInterval time.Duration
+	// HeartbeatInterval es cada cuánto se envía un comentario para que los
+	// proxies no cierren la conexión por inactividad.
+	HeartbeatInterval time.Duration
+}
+
+type handler struct {
+	service Service
+	config  StreamConfig
+}
+
+func NewHandler(s Service, cfg StreamConfig) Handler {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 2 * time.Second
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = 15 * time.Second
+	}
+	return &handler{service: s, config: cfg}
+}
+
+// Stream envía por SSE los eventos del usuario. Sin Last-Event-ID empieza
+// por los eventos nuevos; con él, reanuda desde el siguiente al indicado.
+// Como EventSource no permite cabeceras en la primera conexión, también se
+// acepta el parámetro last_event_id.
+func (h *handler) Stream(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.HandleError(w, ErrStreamUnsupported)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	// La suscripción va antes de leer la posición inicial para no perder
+	// un evento publicado entre ambas cosas.
+	notify, unsubscribe := h.service.Subscribe(authUser.UserID)
+	defer unsubscribe()
+
+	var last int64
+	if lastEventID != "" {
+		parsed, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || parsed < 0 {
+			utils.HandleError(w, ErrInvalidLastEventID)
+			return
+		}
+		last = parsed
+	} else {
+		latest, err := h.service.LatestSeq(authUser.UserID)
+		if err != nil {
+			utils.HandleError(w, err)
+			return
+		}
+		last = latest
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	poll := time.NewTicker(h.config.PollInterval)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(h.config.HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		pending, err := h.service.After(authUser.UserID, last, batchSize)
+		if err != nil {
+			// La respuesta ya empezó: solo queda cortar y dejar que el
+			// cliente se reconecte con su Last-Event-ID.
+			return
+		}
+
+		for _, event := range pending {
+			if err := writeEvent(w, &event); err != nil {
+				return
+			}
+			last = event.Seq
+		}
+		if len(pending) > 0 {
+			flusher.Flush()
+		}
+		if len(pending) == batchSize {
+			continue
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-notify:
+		case <-poll.C:
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event *Event) error {
+	data, err := json.Marshal(message{
+		ID:         event.EventID,
+		Type:       event.Type,
+		OccurredAt: event.OccurredAt,
+		Data:       json.RawMessage(event.Data),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data)
+	return err
+}
@@ -0,0 +1,43 @@
+package activity
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event es un evento de dominio guardado para el stream del usuario. Seq es
+// el número del evento dentro de los de su usuario y hace de id SSE: un
+// cliente que se reconecta con Last-Event-ID recibe todo lo que tenga un Seq
+// mayor. EventID es único porque el relay puede entregar el mismo evento más
+// de una vez.
+//
+// Seq sale del Cursor del usuario en la misma transacción que el insert, y
+// el cursor queda bloqueado hasta confirmarla: los eventos de un usuario se
+// confirman en el orden de su Seq aunque los guarden varios relays a la vez,
+// así que un cliente que ya vio N+1 no puede perderse N.
+type Event struct {
+	UserID     string    `gorm:"primaryKey" json:"user_id"`
+	Seq        int64     `gorm:"primaryKey;autoIncrement:false" json:"seq"`
+	EventID    string    `gorm:"size:24;not null;uniqueIndex" json:"event_id"`
+	Type       string    `gorm:"not null" json:"type"`
+	Data       string    `gorm:"type:text;not null" json:"data"`
+	OccurredAt time.Time `gorm:"not null;index" json:"occurred_at"`
+}
+
+func (Event) TableName() string { return "activity_events" }
+
+// Cursor guarda el último Seq asignado a los eventos de un usuario.
+type Cursor struct {
+	UserID  string `gorm:"primaryKey"`
+	LastSeq int64  `gorm:"not null;default:0"`
+}
+
+func (Cursor) TableName() string { return "activity_cursors" }
+
+// message es el campo data de cada mensaje SSE.
+type message struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
@@ -0,0 +1,107 @@
+package activity
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Repository interface {
+	Create(event *Event) (bool, error)
+	FindAfter(userID string, afterSeq int64, limit int) ([]Event, error)
+	LatestSeq(userID string) (int64, error)
+	DeleteBefore(before time.Time) (int64, error)
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+// errDuplicate deshace la transacción de Create cuando el evento ya estaba
+// guardado, para no gastar un Seq.
+var errDuplicate = errors.New("evento ya guardado")
+
+// Create asigna al evento el siguiente Seq de su usuario y lo guarda.
+// Devuelve false si ya estaba guardado.
+func (r *repository) Create(event *Event) (bool, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"last_seq": gorm.Expr("activity_cursors.last_seq + 1"),
+			}),
+		}).Create(&Cursor{UserID: event.UserID, LastSeq: 1}).Error
+		if err != nil {
+			return err
+		}
+
+		var cursor Cursor
+		if err := tx.Where("user_id = ?", event.UserID).First(&cursor).Error; err != nil {
+			return err
+		}
+		event.Seq = cursor.LastSeq
+
+		result := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "event_id"}},
+			DoNothing: true,
+		}).Create(event)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errDuplicate
+		}
+		return nil
+	})
+	if err != nil {
+		event.Seq = 0
+		if errors.Is(err, errDuplicate) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// FindAfter devuelve los eventos del usuario con Seq mayor que afterSeq, en
+// orden.
+func (r *repository) FindAfter(userID string, afterSeq int64, limit int) ([]Event, error) {
+	var events []Event
+
+	err := r.db.Where("user_id = ? AND seq > ?", userID, afterSeq).
+		Order("seq").
+		Limit(limit).
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// LatestSeq devuelve el Seq del último evento del usuario, o 0 si no tiene.
+func (r *repository) LatestSeq(userID string) (int64, error) {
+	var seq int64
+
+	err := r.db.Model(&Event{}).
+		Select("COALESCE(MAX(seq), 0)").
+		Where("user_id = ?", userID).
+		Scan(&seq).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return seq, nil
+}
+
+func (r *repository) DeleteBefore(before time.Time) (int64, error) {
+	result := r.db.Where("occurred_at < ?", before).Delete(&Event{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,212 @@
+package activity_test
+
+import (
+	"image-processing-service/internal/modules/activity"
+	"image-processing-service/internal/shared/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newMemoryDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err, "no se pudo abrir la base de datos en memoria")
+
+	err = db.AutoMigrate(&activity.Event{}, &activity.Cursor{})
+	require.NoError(t, err, "no se pudo migrar el esquema")
+
+	return db
+}
+
+func newEvent(userID, eventType string, occurredAt time.Time) *activity.Event {
+	return &activity.Event{
+		EventID:    utils.GenerateID(),
+		UserID:     userID,
+		Type:       eventType,
+		Data:       "{}",
+		OccurredAt: occurredAt,
+	}
+}
+
+func requireCreated(t *testing.T, repo activity.Repository, event *activity.Event) {
+	t.Helper()
+
+	created, err := repo.Create(event)
+	require.NoError(t, err)
+	require.True(t, created)
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Create
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_Create(t *testing.T) {
+	t.Run("Debe ignorar un evento ya guardado", func(t *testing.T) {
+		// GIVEN: el relay entrega el mismo evento dos veces
+		repo := activity.NewRepository(newMemoryDB(t))
+		event := newEvent("user-1", "file.uploaded", time.Now().UTC())
+		requireCreated(t, repo, event)
+
+		// WHEN
+		duplicate := *event
+		duplicate.Seq = 0
+		created, err := repo.Create(&duplicate)
+
+		// THEN
+		require.NoError(t, err)
+		assert.False(t, created)
+		found, err := repo.FindAfter("user-1", 0, 10)
+		require.NoError(t, err)
+		assert.Len(t, found, 1)
+	})
+
+	t.Run("Debe numerar los eventos de cada usuario por separado", func(t *testing.T) {
+		// GIVEN
+		repo := activity.NewRepository(newMemoryDB(t))
+		now := time.Now().UTC()
+		first := newEvent("user-1", "file.uploaded", now)
+		other := newEvent("user-2", "file.uploaded", now)
+		second := newEvent("user-1", "file.deleted", now)
+
+		// WHEN
+		requireCreated(t, repo, first)
+		requireCreated(t, repo, other)
+		requireCreated(t, repo, second)
+
+		// THEN
+		assert.Equal(t, int64(1), first.Seq)
+		assert.Equal(t, int64(1), other.Seq)
+		assert.Equal(t, int64(2), second.Seq)
+	})
+
+	t.Run("No debe gastar un Seq con un evento repetido", func(t *testing.T) {
+		// GIVEN
+		repo := activity.NewRepository(newMemoryDB(t))
+		now := time.Now().UTC()
+		first := newEvent("user-1", "file.uploaded", now)
+		requireCreated(t, repo, first)
+		duplicate := *first
+		created, err := repo.Create(&duplicate)
+		require.NoError(t, err)
+		require.False(t, created)
+
+		// WHEN
+		next := newEvent("user-1", "file.deleted", now)
+		requireCreated(t, repo, next)
+
+		// THEN
+		assert.Equal(t, int64(2), next.Seq)
+		latest, err := repo.LatestSeq("user-1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), latest)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// FindAfter
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_FindAfter(t *testing.T) {
+	now := time.Now().UTC()
+
+	t.Run("Debe devolver solo los eventos del usuario posteriores al Seq indicado, en orden", func(t *testing.T) {
+		// GIVEN
+		repo := activity.NewRepository(newMemoryDB(t))
+		first := newEvent("user-1", "file.uploaded", now)
+		requireCreated(t, repo, first)
+		requireCreated(t, repo, newEvent("user-2", "file.uploaded", now))
+		second := newEvent("user-1", "job.completed", now.Add(time.Second))
+		requireCreated(t, repo, second)
+		third := newEvent("user-1", "file.deleted", now.Add(2*time.Second))
+		requireCreated(t, repo, third)
+
+		// WHEN
+		found, err := repo.FindAfter("user-1", first.Seq, 10)
+
+		// THEN
+		require.NoError(t, err)
+		require.Len(t, found, 2)
+		assert.Equal(t, second.Seq, found[0].Seq)
+		assert.Equal(t, third.Seq, found[1].Seq)
+	})
+
+	t.Run("Debe respetar el límite", func(t *testing.T) {
+		// GIVEN
+		repo := activity.NewRepository(newMemoryDB(t))
+		for i := 0; i < 3; i++ {
+			requireCreated(t, repo, newEvent("user-1", "file.uploaded", now.Add(time.Duration(i)*time.Second)))
+		}
+
+		// WHEN
+		found, err := repo.FindAfter("user-1", 0, 2)
+
+		// THEN
+		require.NoError(t, err)
+		assert.Len(t, found, 2)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// LatestSeq
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_LatestSeq(t *testing.T) {
+	t.Run("Debe devolver 0 si el usuario no tiene eventos", func(t *testing.T) {
+		// GIVEN
+		repo := activity.NewRepository(newMemoryDB(t))
+
+		// WHEN
+		seq, err := repo.LatestSeq("user-1")
+
+		// THEN
+		require.NoError(t, err)
+		assert.Zero(t, seq)
+	})
+
+	t.Run("Debe devolver el Seq del último evento del usuario", func(t *testing.T) {
+		// GIVEN
+		repo := activity.NewRepository(newMemoryDB(t))
+		last := newEvent("user-1", "file.uploaded", time.Now().UTC())
+		requireCreated(t, repo, last)
+		requireCreated(t, repo, newEvent("user-2", "file.uploaded", time.Now().UTC()))
+
+		// WHEN
+		seq, err := repo.LatestSeq("user-1")
+
+		// THEN
+		require.NoError(t, err)
+		assert.Equal(t, last.Seq, seq)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// DeleteBefore
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_DeleteBefore(t *testing.T) {
+	t.Run("Debe borrar solo los eventos anteriores a la fecha", func(t *testing.T) {
+		// GIVEN
+		now := time.Now().UTC()
+		repo := activity.NewRepository(newMemoryDB(t))
+		requireCreated(t, repo, newEvent("user-1", "file.uploaded", now.Add(-48*time.Hour)))
+		recent := newEvent("user-1", "file.deleted", now)
+		requireCreated(t, repo, recent)
+
+		// WHEN
+		deleted, err := repo.DeleteBefore(now.Add(-24 * time.Hour))
+
+		// THEN
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+		found, err := repo.FindAfter("user-1", 0, 10)
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+		assert.Equal(t, recent.Seq, found[0].Seq)
+	})
+}
@@ -0,0 +1,75 @@
+package activity
+
+import (
+	"encoding/json"
+	"time"
+
+	"image-processing-service/internal/shared/events"
+)
+
+type Service interface {
+	events.Publisher
+	LatestSeq(userID string) (int64, error)
+	After(userID string, afterSeq int64, limit int) ([]Event, error)
+	Subscribe(userID string) (<-chan struct{}, func())
+	Prune(before time.Time) (int64, error)
+}
+
+type service struct {
+	repo   Repository
+	broker *broker
+}
+
+func NewService(r Repository) Service {
+	return &service{repo: r, broker: newBroker()}
+}
+
+// Publish guarda el evento en el log del usuario y despierta a sus streams
+// abiertos en esta instancia. Las demás instancias lo verán en su siguiente
+// sondeo. Un evento repetido se ignora.
+func (s *service) Publish(event events.Event) error {
+	if event.UserID == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+
+	created, err := s.repo.Create(&Event{
+		EventID:    event.ID,
+		UserID:     event.UserID,
+		Type:       event.Type,
+		Data:       string(data),
+		OccurredAt: event.OccurredAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	if created {
+		s.broker.notify(event.UserID)
+	}
+	return nil
+}
+
+func (s *service) LatestSeq(userID string) (int64, error) {
+	return s.repo.LatestSeq(userID)
+}
+
+func (s *service) After(userID string, afterSeq int64, limit int) ([]Event, error) {
+	return s.repo.FindAfter(userID, afterSeq, limit)
+}
+
+// Subscribe devuelve un canal que recibe un aviso cuando el usuario tiene
+// eventos nuevos, y la función que cancela la suscripción.
+func (s *service) Subscribe(userID string) (<-chan struct{}, func()) {
+	return s.broker.subscribe(userID)
+}
+
+// Prune borra los eventos anteriores a before. Un cliente desconectado más
+// tiempo que la retención ya no puede reanudar sin perder eventos.
+func (s *service) Prune(before time.Time) (int64, error) {
+	return s.repo.DeleteBefore(before)
+}
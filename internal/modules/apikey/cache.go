@@ -0,0 +1,162 @@
+package apikey
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCacheSize es cuántas API keys guarda la caché como mucho.
+	DefaultCacheSize = 10000
+	// DefaultCacheTTL es cuánto se reutiliza una API key leída de la base
+	// de datos. Sin Invalidator es también lo que puede tardar un borrado
+	// en llegar a las demás instancias.
+	DefaultCacheTTL = 10 * time.Second
+)
+
+// CacheConfig configura la caché de API keys válidas. Los valores a cero
+// toman los por defecto.
+type CacheConfig struct {
+	Size int
+	TTL  time.Duration
+}
+
+type cacheEntry struct {
+	key       APIKey
+	expiresAt time.Time
+}
+
+// cache es una LRU con caducidad de las API keys por el hash de la clave,
+// para no consultar la base de datos en cada petición de CI. Se puede
+// invalidar por clave o por usuario.
+type cache struct {
+	size int
+	ttl  time.Duration
+
+	mu sync.Mutex
+	// generation cuenta las invalidaciones. Una clave leída de la base de
+	// datos antes de una invalidación no se guarda: podría ser la borrada.
+	generation uint64
+	order      *list.List
+	entries    map[string]*list.Element
+	byID       map[string]string
+	byUser     map[string]map[string]struct{}
+}
+
+func newCache(cfg CacheConfig) *cache {
+	if cfg.Size <= 0 {
+		cfg.Size = DefaultCacheSize
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultCacheTTL
+	}
+
+	return &cache{
+		size:    cfg.Size,
+		ttl:     cfg.TTL,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		byID:    make(map[string]string),
+		byUser:  make(map[string]map[string]struct{}),
+	}
+}
+
+// get devuelve una copia de la clave del hash si está y no ha caducado.
+func (c *cache) get(keyHash string, now time.Time) (*APIKey, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[keyHash]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if !now.Before(entry.expiresAt) {
+		c.remove(elem)
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	key := entry.key
+	return &key, true
+}
+
+// currentGeneration se lee antes de consultar la base de datos y se pasa
+// a add.
+func (c *cache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// add guarda la clave hasta que pase el TTL o caduque la clave, lo que
+// antes ocurra. No hace nada si ha habido invalidaciones desde generation.
+func (c *cache) add(key *APIKey, generation uint64, now time.Time) {
+	expiresAt := now.Add(c.ttl)
+	if key.ExpiresAt != nil && key.ExpiresAt.Before(expiresAt) {
+		expiresAt = *key.ExpiresAt
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if elem, ok := c.entries[key.KeyHash]; ok {
+		c.remove(elem)
+	}
+
+	elem := c.order.PushFront(&cacheEntry{key: *key, expiresAt: expiresAt})
+	c.entries[key.KeyHash] = elem
+	c.byID[key.ID] = key.KeyHash
+	if c.byUser[key.UserID] == nil {
+		c.byUser[key.UserID] = make(map[string]struct{})
+	}
+	c.byUser[key.UserID][key.KeyHash] = struct{}{}
+
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// touched anota el último uso de la clave del hash para que Authenticate
+// no vuelva a escribirlo antes de tiempo.
+func (c *cache) touched(keyHash string, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[keyHash]; ok {
+		elem.Value.(*cacheEntry).key.LastUsedAt = &at
+	}
+}
+
+// invalidate borra las claves que coinciden con cualquiera de los campos
+// no vacíos.
+func (c *cache) invalidate(inv Invalidation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if keyHash, ok := c.byID[inv.ID]; ok {
+		c.remove(c.entries[keyHash])
+	}
+	for keyHash := range c.byUser[inv.UserID] {
+		c.remove(c.entries[keyHash])
+	}
+}
+
+func (c *cache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*cacheEntry)
+	key := entry.key
+
+	delete(c.entries, key.KeyHash)
+	delete(c.byID, key.ID)
+	delete(c.byUser[key.UserID], key.KeyHash)
+	if len(c.byUser[key.UserID]) == 0 {
+		delete(c.byUser, key.UserID)
+	}
+}
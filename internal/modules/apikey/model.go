@@ -0,0 +1,58 @@
+package apikey
+
+import (
+	"strings"
+	"time"
+
+	"image-processing-service/internal/shared/auth"
+)
+
+// APIKey da acceso a la API sin inicio de sesión interactivo, para CI y
+// otros servicios. Solo se guarda el hash de la clave: la clave en claro se
+// muestra una vez al crearla. Prefix son sus primeros caracteres, para que
+// el usuario la reconozca en el listado.
+type APIKey struct {
+	ID         string     `gorm:"primaryKey;size:24" json:"id"`
+	UserID     string     `gorm:"size:24;not null;index" json:"user_id"`
+	Name       string     `gorm:"size:100;not null" json:"name"`
+	Prefix     string     `gorm:"size:16;not null" json:"prefix"`
+	KeyHash    string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Scopes     string     `gorm:"not null" json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (APIKey) TableName() string { return "api_keys" }
+
+// ScopeList devuelve los permisos de la clave. Scopes se guarda como lista
+// separada por comas.
+func (k *APIKey) ScopeList() []auth.Scope {
+	if k.Scopes == "" {
+		return []auth.Scope{}
+	}
+	parts := strings.Split(k.Scopes, ",")
+	scopes := make([]auth.Scope, 0, len(parts))
+	for _, p := range parts {
+		scopes = append(scopes, auth.Scope(p))
+	}
+	return scopes
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string       `json:"name" validate:"required,min=1,max=100"`
+	Scopes    []auth.Scope `json:"scopes" validate:"required,min=1,dive,oneof=files:read files:write files:delete"`
+	ExpiresAt *time.Time   `json:"expires_at"`
+}
+
+type APIKeyResponse struct {
+	ID     string       `json:"id"`
+	Name   string       `json:"name"`
+	Prefix string       `json:"prefix"`
+	Scopes []auth.Scope `json:"scopes"`
+	// Key solo se devuelve al crear la clave.
+	Key        string     `json:"key,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
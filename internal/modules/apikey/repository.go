@@ -0,0 +1,68 @@
+package apikey
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	Create(key *APIKey) error
+	FindByHash(keyHash string) (*APIKey, error)
+	FindByUserID(userID string) ([]APIKey, error)
+	Touch(id string, now, staleBefore time.Time) error
+	Delete(id string, userID string) (bool, error)
+	DeleteByUserID(userID string) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) Create(key *APIKey) error {
+	return r.db.Create(key).Error
+}
+
+func (r *repository) FindByHash(keyHash string) (*APIKey, error) {
+	var key APIKey
+
+	if err := r.db.Where("key_hash = ?", keyHash).First(&key).Error; err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (r *repository) FindByUserID(userID string) ([]APIKey, error) {
+	var keys []APIKey
+
+	if err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Touch actualiza LastUsedAt solo si es anterior a staleBefore, para no
+// escribir en cada petición.
+func (r *repository) Touch(id string, now, staleBefore time.Time) error {
+	return r.db.Model(&APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, staleBefore).
+		Update("last_used_at", now).Error
+}
+
+func (r *repository) Delete(id string, userID string) (bool, error) {
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&APIKey{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *repository) DeleteByUserID(userID string) error {
+	return r.db.Delete(&APIKey{}, "user_id = ?", userID).Error
+}
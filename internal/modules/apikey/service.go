@@ -0,0 +1,223 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"image-processing-service/internal/shared/auth"
+	"image-processing-service/internal/shared/utils"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotFound     = utils.NewError(404, "API_KEY_NOT_FOUND", "API key no encontrada", nil)
+	ErrInvalidKey   = utils.NewError(401, "INVALID_API_KEY", "API key inválida o caducada", nil)
+	ErrExpiryInPast = utils.NewError(400, "INVALID_EXPIRY", "La fecha de caducidad debe ser futura", nil)
+	ErrTooManyKeys  = utils.NewError(409, "TOO_MANY_API_KEYS", "Has alcanzado el máximo de API keys", nil)
+)
+
+// KeyPrefix distingue las API keys de los JWT en la cabecera Authorization.
+const KeyPrefix = "ipk_"
+
+// maxKeysPerUser limita las claves que puede tener un usuario a la vez.
+const maxKeysPerUser = 25
+
+// touchInterval es cada cuánto se actualiza LastUsedAt como mucho.
+const touchInterval = time.Minute
+
+type Service interface {
+	Create(userID string, req CreateAPIKeyRequest) (*APIKeyResponse, error)
+	List(userID string) ([]APIKeyResponse, error)
+	Delete(id string, userID string) error
+	// DeleteByUserID borra todas las claves del usuario, al borrar su cuenta.
+	DeleteByUserID(userID string) error
+	Authenticate(key string) (*APIKey, error)
+}
+
+// ServiceConfig configura la caché de las API keys que valida
+// Authenticate. Con Invalidator, los borrados llegan a la caché de todas
+// las instancias; sin él, cada instancia solo invalida la suya y las demás
+// tardan como mucho Cache.TTL.
+type ServiceConfig struct {
+	Cache       CacheConfig
+	Invalidator Invalidator
+}
+
+type service struct {
+	repo        Repository
+	cache       *cache
+	invalidator Invalidator
+}
+
+func NewService(r Repository, cfg ServiceConfig) Service {
+	s := &service{
+		repo:        r,
+		cache:       newCache(cfg.Cache),
+		invalidator: cfg.Invalidator,
+	}
+	if s.invalidator != nil {
+		s.invalidator.Subscribe(context.Background(), s.cache.invalidate)
+	}
+	return s
+}
+
+func (s *service) Create(userID string, req CreateAPIKeyRequest) (*APIKeyResponse, error) {
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, ErrExpiryInPast
+	}
+
+	existing, err := s.repo.FindByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= maxKeysPerUser {
+		return nil, ErrTooManyKeys
+	}
+
+	plain, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	key := &APIKey{
+		ID:        utils.GenerateID(),
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    plain[:len(KeyPrefix)+8],
+		KeyHash:   utils.GenerateSHA256(plain),
+		Scopes:    joinScopes(req.Scopes),
+		ExpiresAt: req.ExpiresAt,
+	}
+
+	if err := s.repo.Create(key); err != nil {
+		return nil, err
+	}
+
+	res := mapAPIKeyResponse(key)
+	res.Key = plain
+	return &res, nil
+}
+
+func (s *service) List(userID string) ([]APIKeyResponse, error) {
+	keys, err := s.repo.FindByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]APIKeyResponse, 0, len(keys))
+	for i := range keys {
+		res = append(res, mapAPIKeyResponse(&keys[i]))
+	}
+	return res, nil
+}
+
+func (s *service) Delete(id string, userID string) error {
+	deleted, err := s.repo.Delete(id, userID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrNotFound
+	}
+	s.invalidate(Invalidation{ID: id})
+	return nil
+}
+
+func (s *service) DeleteByUserID(userID string) error {
+	if err := s.repo.DeleteByUserID(userID); err != nil {
+		return err
+	}
+	s.invalidate(Invalidation{UserID: userID})
+	return nil
+}
+
+// Authenticate devuelve la API key que corresponde a la clave en claro si
+// existe y no ha caducado, y registra su uso. Las claves se sirven de la
+// caché por su hash.
+func (s *service) Authenticate(plain string) (*APIKey, error) {
+	if !strings.HasPrefix(plain, KeyPrefix) {
+		return nil, ErrInvalidKey
+	}
+
+	keyHash := utils.GenerateSHA256(plain)
+	now := time.Now()
+	key, ok := s.cache.get(keyHash, now)
+	if !ok {
+		generation := s.cache.currentGeneration()
+		found, err := s.repo.FindByHash(keyHash)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrInvalidKey
+			}
+			return nil, err
+		}
+		key = found
+		s.cache.add(key, generation, now)
+	}
+
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		return nil, ErrInvalidKey
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= touchInterval {
+		if err := s.repo.Touch(key.ID, now, now.Add(-touchInterval)); err != nil {
+			log.Printf("Error actualizando el último uso de la API key %s: %v", key.ID, err)
+		} else {
+			s.cache.touched(keyHash, now)
+		}
+	}
+
+	return key, nil
+}
+
+// invalidate borra las claves de la caché local y avisa a las demás
+// instancias. Si el aviso falla, en ellas caducan con el TTL.
+func (s *service) invalidate(inv Invalidation) {
+	s.cache.invalidate(inv)
+
+	if s.invalidator == nil {
+		return
+	}
+	if err := s.invalidator.Publish(context.Background(), inv); err != nil {
+		log.Printf("Error publicando la invalidación de API keys %+v: %v", inv, err)
+	}
+}
+
+func generateKey() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return KeyPrefix + hex.EncodeToString(raw), nil
+}
+
+// joinScopes une los scopes sin repetidos en el formato en que se guardan.
+func joinScopes(scopes []auth.Scope) string {
+	seen := make(map[auth.Scope]bool, len(scopes))
+	unique := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if !seen[scope] {
+			seen[scope] = true
+			unique = append(unique, string(scope))
+		}
+	}
+	return strings.Join(unique, ",")
+}
+
+func mapAPIKeyResponse(key *APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.ScopeList(),
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		CreatedAt:  key.CreatedAt,
+	}
+}
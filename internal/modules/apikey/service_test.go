@@ -0,0 +1,155 @@
+package apikey_test
+
+// Los tests del servicio usan el repositorio real sobre SQLite en memoria,
+// envuelto para contar las búsquedas por hash, y un Invalidator en proceso
+// que hace de Redis entre varias instancias.
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"image-processing-service/internal/modules/apikey"
+	"image-processing-service/internal/shared/auth"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newMemoryDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err, "no se pudo abrir la base de datos en memoria")
+	require.NoError(t, db.AutoMigrate(&apikey.APIKey{}), "no se pudo migrar el esquema")
+
+	return db
+}
+
+type countingRepository struct {
+	apikey.Repository
+	lookups atomic.Int64
+}
+
+func (r *countingRepository) FindByHash(keyHash string) (*apikey.APIKey, error) {
+	r.lookups.Add(1)
+	return r.Repository.FindByHash(keyHash)
+}
+
+// memoryInvalidator reparte las invalidaciones entre los servicios
+// suscritos, como el pub/sub de Redis.
+type memoryInvalidator struct {
+	mu       sync.Mutex
+	handlers []func(apikey.Invalidation)
+}
+
+func (i *memoryInvalidator) Publish(_ context.Context, inv apikey.Invalidation) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, handle := range i.handlers {
+		handle(inv)
+	}
+	return nil
+}
+
+func (i *memoryInvalidator) Subscribe(_ context.Context, handle func(apikey.Invalidation)) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.handlers = append(i.handlers, handle)
+}
+
+// createKey crea una API key de solo lectura del usuario.
+func createKey(t *testing.T, svc apikey.Service, userID string) *apikey.APIKeyResponse {
+	t.Helper()
+
+	created, err := svc.Create(userID, apikey.CreateAPIKeyRequest{Name: "CI", Scopes: []auth.Scope{auth.ScopeFilesRead}})
+	require.NoError(t, err)
+	return created
+}
+
+func TestService_Authenticate(t *testing.T) {
+	t.Run("Debe servir de la caché las peticiones repetidas con la misma clave", func(t *testing.T) {
+		// GIVEN
+		repo := &countingRepository{Repository: apikey.NewRepository(newMemoryDB(t))}
+		svc := apikey.NewService(repo, apikey.ServiceConfig{})
+		created := createKey(t, svc, "user-1")
+
+		// WHEN
+		for range 5 {
+			_, err := svc.Authenticate(created.Key)
+			require.NoError(t, err)
+		}
+
+		// THEN
+		assert.EqualValues(t, 1, repo.lookups.Load())
+	})
+
+	t.Run("Debe volver a consultar la base de datos al pasar el TTL", func(t *testing.T) {
+		// GIVEN
+		repo := &countingRepository{Repository: apikey.NewRepository(newMemoryDB(t))}
+		svc := apikey.NewService(repo, apikey.ServiceConfig{Cache: apikey.CacheConfig{TTL: time.Millisecond}})
+		created := createKey(t, svc, "user-1")
+		_, err := svc.Authenticate(created.Key)
+		require.NoError(t, err)
+
+		// WHEN
+		time.Sleep(5 * time.Millisecond)
+		_, err = svc.Authenticate(created.Key)
+
+		// THEN
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, repo.lookups.Load())
+	})
+
+	t.Run("Debe rechazar al momento en otra instancia una clave borrada", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		invalidator := &memoryInvalidator{}
+		cfg := apikey.ServiceConfig{Cache: apikey.CacheConfig{TTL: time.Hour}, Invalidator: invalidator}
+		a := apikey.NewService(apikey.NewRepository(db), cfg)
+		b := apikey.NewService(apikey.NewRepository(db), cfg)
+		created := createKey(t, a, "user-1")
+		_, err := b.Authenticate(created.Key)
+		require.NoError(t, err)
+
+		// WHEN
+		require.NoError(t, a.Delete(created.ID, "user-1"))
+
+		// THEN
+		_, err = b.Authenticate(created.Key)
+		assert.ErrorIs(t, err, apikey.ErrInvalidKey)
+	})
+
+	t.Run("Debe rechazar al momento en otra instancia las claves de un usuario borrado", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		invalidator := &memoryInvalidator{}
+		cfg := apikey.ServiceConfig{Cache: apikey.CacheConfig{TTL: time.Hour}, Invalidator: invalidator}
+		a := apikey.NewService(apikey.NewRepository(db), cfg)
+		b := apikey.NewService(apikey.NewRepository(db), cfg)
+		first := createKey(t, a, "user-1")
+		second := createKey(t, a, "user-1")
+		other := createKey(t, a, "user-2")
+		for _, created := range []*apikey.APIKeyResponse{first, second, other} {
+			_, err := b.Authenticate(created.Key)
+			require.NoError(t, err)
+		}
+
+		// WHEN
+		require.NoError(t, a.DeleteByUserID("user-1"))
+
+		// THEN
+		for _, created := range []*apikey.APIKeyResponse{first, second} {
+			_, err := b.Authenticate(created.Key)
+			assert.ErrorIs(t, err, apikey.ErrInvalidKey)
+		}
+		_, err := b.Authenticate(other.Key)
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"image-processing-service/internal/modules/user"
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/mail"
+	"image-processing-service/internal/shared/utils"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidEmailToken    = utils.NewError(400, "INVALID_OR_EXPIRED_TOKEN", "El enlace no es válido o ha caducado", nil)
+	ErrEmailAlreadyVerified = utils.NewError(409, "EMAIL_ALREADY_VERIFIED", "El email ya está verificado", nil)
+)
+
+// ResendVerification envía otra vez el enlace de verificación. El anterior
+// deja de valer.
+func (s *service) ResendVerification(userID string) error {
+	existingUser, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	if existingUser.EmailVerifiedAt != nil {
+		return ErrEmailAlreadyVerified
+	}
+
+	return s.sendVerification(existingUser)
+}
+
+// VerifyEmail consume el token de verificación y marca el email como
+// verificado.
+func (s *service) VerifyEmail(req VerifyEmailRequest) error {
+	token, err := s.consumeToken(req.Token, PurposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+
+	return s.userRepo.MarkEmailVerified(token.UserID, s.now().UTC())
+}
+
+// ForgotPassword envía un enlace para restablecer la contraseña. Responde
+// igual exista o no la cuenta, para no delatar qué emails están
+// registrados; por eso un fallo al enviar el correo solo se registra.
+func (s *service) ForgotPassword(req ForgotPasswordRequest) error {
+	existingUser, err := s.userRepo.GetByEmail(req.Email)
+	if err != nil {
+		return err
+	}
+	if existingUser == nil || existingUser.DeletedAt.Valid {
+		return nil
+	}
+
+	plain, err := s.issueToken(existingUser.ID, PurposeResetPassword, s.config.ResetPasswordTTL)
+	if err != nil {
+		return err
+	}
+
+	err = s.mailer.Send(mail.Message{
+		To:      existingUser.Email,
+		Subject: "Restablece tu contraseña",
+		Body: fmt.Sprintf("Hola %s:\n\nPara elegir una contraseña nueva, abre este enlace:\n\n%s\n\n"+
+			"El enlace caduca en %s y solo puede usarse una vez. Si no lo has pedido tú, ignora este correo.\n",
+			existingUser.Name, s.link("/reset-password", plain), s.config.ResetPasswordTTL),
+	})
+	if err != nil {
+		log.Printf("Error enviando el restablecimiento de contraseña a %s: %v", existingUser.Email, err)
+	}
+
+	return nil
+}
+
+// ResetPassword consume el token y cambia la contraseña. Cierra todas las
+// sesiones y levanta el bloqueo de la cuenta; además, seguir el enlace
+// demuestra que el email es del usuario, así que queda verificado.
+//
+// La política de contraseñas se comprueba antes de consumir el token, para
+// que si la contraseña no la cumple se pueda corregir con el mismo enlace.
+func (s *service) ResetPassword(req ResetPasswordRequest) error {
+	pending, err := s.repo.FindEmailToken(utils.GenerateSHA256(req.Token), PurposeResetPassword, s.now().UTC())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidEmailToken
+		}
+		return err
+	}
+
+	existingUser, err := s.userRepo.GetByID(pending.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidEmailToken
+		}
+		return err
+	}
+
+	if err := s.config.Passwords.Check(existingUser, "new_password", req.NewPassword); err != nil {
+		return err
+	}
+
+	if _, err := s.consumeToken(req.Token, PurposeResetPassword); err != nil {
+		return err
+	}
+
+	hashedPassword, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		return err
+	}
+	existingUser.Password = hashedPassword
+
+	changed := events.New(events.PasswordChanged, existingUser.ID, user.NewEventData(existingUser))
+	if err := s.userRepo.UpdatePassword(existingUser, changed); err != nil {
+		return err
+	}
+
+	if err := s.userRepo.MarkEmailVerified(existingUser.ID, s.now().UTC()); err != nil {
+		return err
+	}
+
+	if err := s.sessionSrv.DeleteByUserID(existingUser.ID); err != nil {
+		return err
+	}
+
+	return s.repo.ResetThrottle(accountThrottleKey(existingUser.Email))
+}
+
+// sendVerification genera un token de verificación y lo envía al usuario.
+func (s *service) sendVerification(u *user.User) error {
+	plain, err := s.issueToken(u.ID, PurposeVerifyEmail, s.config.VerifyEmailTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(mail.Message{
+		To:      u.Email,
+		Subject: "Verifica tu email",
+		Body: fmt.Sprintf("Hola %s:\n\nPara verificar tu email, abre este enlace:\n\n%s\n\nEl enlace caduca en %s.\n",
+			u.Name, s.link("/verify-email", plain), s.config.VerifyEmailTTL),
+	})
+}
+
+// issueToken crea un token de un solo uso y devuelve su valor en claro.
+func (s *service) issueToken(userID string, purpose TokenPurpose, ttl time.Duration) (string, error) {
+	plain, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := s.now().UTC()
+	err = s.repo.CreateEmailToken(&EmailToken{
+		ID:        utils.GenerateID(),
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: utils.GenerateSHA256(plain),
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return plain, nil
+}
+
+func (s *service) consumeToken(plain string, purpose TokenPurpose) (*EmailToken, error) {
+	token, err := s.repo.ConsumeEmailToken(utils.GenerateSHA256(plain), purpose, s.now().UTC())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidEmailToken
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+// link construye el enlace del frontend con el token.
+func (s *service) link(path, token string) string {
+	return s.config.BaseURL + path + "?token=" + url.QueryEscape(token)
+}
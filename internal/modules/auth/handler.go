@@ -2,24 +2,68 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
 	"image-processing-service/internal/shared/auth"
 	"image-processing-service/internal/shared/utils"
 	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
 )
 
 type Handler interface {
 	SignUp(w http.ResponseWriter, r *http.Request)
 	SignIn(w http.ResponseWriter, r *http.Request)
 	SignOut(w http.ResponseWriter, r *http.Request)
+	SignOutEverywhere(w http.ResponseWriter, r *http.Request)
+	ListSessions(w http.ResponseWriter, r *http.Request)
+	RevokeSession(w http.ResponseWriter, r *http.Request)
 	RenewSession(w http.ResponseWriter, r *http.Request)
+	Unlock(w http.ResponseWriter, r *http.Request)
+	UnlockUser(w http.ResponseWriter, r *http.Request)
+	ListAttempts(w http.ResponseWriter, r *http.Request)
+	VerifyEmail(w http.ResponseWriter, r *http.Request)
+	ResendVerification(w http.ResponseWriter, r *http.Request)
+	ForgotPassword(w http.ResponseWriter, r *http.Request)
+	ResetPassword(w http.ResponseWriter, r *http.Request)
+	TwoFactorStatus(w http.ResponseWriter, r *http.Request)
+	EnrollTwoFactor(w http.ResponseWriter, r *http.Request)
+	ConfirmTwoFactor(w http.ResponseWriter, r *http.Request)
+	DisableTwoFactor(w http.ResponseWriter, r *http.Request)
+	RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request)
+	VerifyTwoFactor(w http.ResponseWriter, r *http.Request)
+	ListOIDCProviders(w http.ResponseWriter, r *http.Request)
+	StartOIDCLogin(w http.ResponseWriter, r *http.Request)
+	CompleteOIDCLogin(w http.ResponseWriter, r *http.Request)
 }
 
 type handler struct {
 	service Service
+	cookies auth.CookieConfig
+}
+
+// NewHandler recibe cómo se emiten las cookies de los clientes que piden el
+// modo cookie con auth.SessionModeHeader.
+func NewHandler(s Service, cookies auth.CookieConfig) Handler {
+	return &handler{service: s, cookies: cookies}
 }
 
-func NewHandler(s Service) Handler {
-	return &handler{service: s}
+// respondWithTokens entrega los tokens en el cuerpo o, en el modo cookie, en
+// cookies HttpOnly: el cuerpo lleva entonces solo el token CSRF. Un reto del
+// segundo factor aún no tiene tokens y va siempre en el cuerpo.
+func (h *handler) respondWithTokens(w http.ResponseWriter, r *http.Request, result *Auth) {
+	if !auth.WantsCookies(r) || result.AccessToken == "" {
+		utils.Success(w, http.StatusOK, result)
+		return
+	}
+
+	csrf, err := h.cookies.SetSessionCookies(w, result.AccessToken, result.RefreshToken)
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, Auth{CSRFToken: csrf})
 }
 
 func (h *handler) SignUp(w http.ResponseWriter, r *http.Request) {
@@ -57,13 +101,22 @@ func (h *handler) SignIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := h.service.SignIn(req)
+	result, err := h.service.SignIn(req, ClientInfo{
+		IP:        auth.GetClientIP(r.Context()),
+		UserAgent: r.UserAgent(),
+	})
 	if err != nil {
+		var appErr *utils.AppError
+		if errors.As(err, &appErr) {
+			if details, ok := appErr.Details.(lockoutDetails); ok {
+				w.Header().Set("Retry-After", strconv.Itoa(details.RetryAfter))
+			}
+		}
 		utils.HandleError(w, err)
 		return
 	}
 
-	utils.Success(w, http.StatusOK, result)
+	h.respondWithTokens(w, r, result)
 }
 
 func (h *handler) SignOut(w http.ResponseWriter, r *http.Request) {
@@ -73,13 +126,205 @@ func (h *handler) SignOut(w http.ResponseWriter, r *http.Request) {
 		utils.HandleError(w, err)
 		return
 	}
+	h.cookies.ClearSessionCookies(w)
 
 	utils.Success(w, 200, map[string]string{"message": "Sesión cerrada correctamente"})
 }
 
+// SignOutEverywhere cierra todas las sesiones del usuario autenticado,
+// incluida la actual.
+func (h *handler) SignOutEverywhere(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	if err := h.service.SignOutEverywhere(authUser.UserID); err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+	h.cookies.ClearSessionCookies(w)
+
+	utils.Success(w, http.StatusOK, map[string]string{"message": "Sesiones cerradas correctamente"})
+}
+
+func (h *handler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	sessions, err := h.service.ListSessions(authUser.UserID, authUser.JTI)
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, sessions)
+}
+
+func (h *handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	id := chi.URLParam(r, "id")
+	if !utils.IsValidID(id) {
+		utils.HandleError(w, utils.ErrInvalidIDFormat)
+		return
+	}
+
+	if err := h.service.RevokeSession(authUser.UserID, id); err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, map[string]string{"message": "Sesión cerrada correctamente"})
+}
+
+// RenewSession lee el refresh token del cuerpo o, en el modo cookie, de su
+// cookie. Como el navegador la envía solo, en ese caso exige el token CSRF.
 func (h *handler) RenewSession(w http.ResponseWriter, r *http.Request) {
+	refreshToken, err := h.refreshToken(r)
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	result, err := h.service.RenewSession(refreshToken, ClientInfo{
+		IP:        auth.GetClientIP(r.Context()),
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	h.respondWithTokens(w, r, result)
+}
+
+func (h *handler) refreshToken(r *http.Request) (string, error) {
+	if auth.WantsCookies(r) {
+		cookie, err := r.Cookie(auth.RefreshCookie)
+		if err != nil || cookie.Value == "" {
+			return "", auth.ErrInvalidToken
+		}
+		if !auth.ValidCSRF(r) {
+			return "", auth.ErrInvalidCSRFToken
+		}
+		return cookie.Value, nil
+	}
+
 	var req RenewSessionRequest
 
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return "", utils.ErrInvalidJSON
+	}
+
+	if errs := utils.Validate(req); errs != nil {
+		return "", utils.ValidationError(errs)
+	}
+
+	return req.RefreshToken, nil
+}
+
+// Unlock levanta el bloqueo de la cuenta del usuario autenticado, por
+// ejemplo desde otra sesión abierta después de que alguien la haya bloqueado
+// probando contraseñas.
+func (h *handler) Unlock(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	if err := h.service.Unlock(authUser.UserID); err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, map[string]string{"message": "Cuenta desbloqueada correctamente"})
+}
+
+// UnlockUser levanta el bloqueo de la cuenta del usuario {id}. Es una
+// operación de administración.
+func (h *handler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	if !utils.IsValidID(id) {
+		utils.HandleError(w, utils.ErrInvalidIDFormat)
+		return
+	}
+
+	if err := h.service.Unlock(id); err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, map[string]string{"message": "Cuenta desbloqueada correctamente"})
+}
+
+// ListAttempts devuelve los últimos intentos de inicio de sesión en la
+// cuenta del usuario autenticado.
+func (h *handler) ListAttempts(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	attempts, err := h.service.ListAttempts(authUser.UserID)
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, attempts)
+}
+
+func (h *handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req VerifyEmailRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.HandleError(w, utils.ErrInvalidJSON)
+		return
+	}
+
+	if errs := utils.Validate(req); errs != nil {
+		utils.HandleError(w, utils.ValidationError(errs))
+		return
+	}
+
+	if err := h.service.VerifyEmail(req); err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, map[string]string{"message": "Email verificado correctamente"})
+}
+
+// ResendVerification envía otro enlace de verificación al usuario
+// autenticado.
+func (h *handler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	if err := h.service.ResendVerification(authUser.UserID); err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusAccepted, map[string]string{"message": "Te hemos enviado un nuevo enlace de verificación"})
+}
+
+// ForgotPassword responde siempre lo mismo, exista o no la cuenta.
+func (h *handler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.HandleError(w, utils.ErrInvalidJSON)
+		return
+	}
+
+	if errs := utils.Validate(req); errs != nil {
+		utils.HandleError(w, utils.ValidationError(errs))
+		return
+	}
+
+	if err := h.service.ForgotPassword(req); err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusAccepted, map[string]string{"message": "Si el email está registrado, recibirás un enlace para restablecer la contraseña"})
+}
+
+func (h *handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		utils.HandleError(w, utils.ErrInvalidJSON)
 		return
@@ -90,7 +335,140 @@ func (h *handler) RenewSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := h.service.RenewSession(req.RefreshToken)
+	if err := h.service.ResetPassword(req); err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, map[string]string{"message": "Contraseña restablecida correctamente"})
+}
+
+func (h *handler) TwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	status, err := h.service.TwoFactorStatus(authUser.UserID)
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, status)
+}
+
+// EnrollTwoFactor devuelve un secreto nuevo con su QR. El segundo factor
+// no se activa hasta confirmarlo con un código.
+func (h *handler) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	enrollment, err := h.service.EnrollTwoFactor(authUser.UserID)
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusCreated, enrollment)
+}
+
+func (h *handler) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	var req ConfirmTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.HandleError(w, utils.ErrInvalidJSON)
+		return
+	}
+
+	if errs := utils.Validate(req); errs != nil {
+		utils.HandleError(w, utils.ValidationError(errs))
+		return
+	}
+
+	codes, err := h.service.ConfirmTwoFactor(authUser.UserID, req)
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, codes)
+}
+
+func (h *handler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.HandleError(w, utils.ErrInvalidJSON)
+		return
+	}
+
+	if errs := utils.Validate(req); errs != nil {
+		utils.HandleError(w, utils.ValidationError(errs))
+		return
+	}
+
+	if err := h.service.DisableTwoFactor(authUser.UserID, req); err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, map[string]string{"message": "Segundo factor desactivado correctamente"})
+}
+
+func (h *handler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.HandleError(w, utils.ErrInvalidJSON)
+		return
+	}
+
+	if errs := utils.Validate(req); errs != nil {
+		utils.HandleError(w, utils.ValidationError(errs))
+		return
+	}
+
+	codes, err := h.service.RegenerateRecoveryCodes(authUser.UserID, req)
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, codes)
+}
+
+// VerifyTwoFactor canjea el reto de SignIn y un código por los tokens.
+func (h *handler) VerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req VerifyTwoFactorRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.HandleError(w, utils.ErrInvalidJSON)
+		return
+	}
+
+	if errs := utils.Validate(req); errs != nil {
+		utils.HandleError(w, utils.ValidationError(errs))
+		return
+	}
+
+	result, err := h.service.VerifyTwoFactor(req, ClientInfo{
+		IP:        auth.GetClientIP(r.Context()),
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	h.respondWithTokens(w, r, result)
+}
+
+func (h *handler) ListOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	utils.Success(w, http.StatusOK, h.service.ListOIDCProviders())
+}
+
+func (h *handler) StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	result, err := h.service.StartOIDCLogin(r.Context(), chi.URLParam(r, "provider"))
 	if err != nil {
 		utils.HandleError(w, err)
 		return
@@ -98,3 +476,28 @@ func (h *handler) RenewSession(w http.ResponseWriter, r *http.Request) {
 
 	utils.Success(w, http.StatusOK, result)
 }
+
+func (h *handler) CompleteOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	var req OIDCCallbackRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.HandleError(w, utils.ErrInvalidJSON)
+		return
+	}
+
+	if errs := utils.Validate(req); errs != nil {
+		utils.HandleError(w, utils.ValidationError(errs))
+		return
+	}
+
+	result, err := h.service.CompleteOIDCLogin(r.Context(), chi.URLParam(r, "provider"), req, ClientInfo{
+		IP:        auth.GetClientIP(r.Context()),
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	h.respondWithTokens(w, r, result)
+}
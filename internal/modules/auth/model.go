@@ -1,21 +1,377 @@
 package auth
 
+import "time"
+
+// Auth es el resultado de iniciar sesión. Si la cuenta tiene activado el
+// segundo factor, SignIn no devuelve tokens sino TwoFactorRequired y un
+// ChallengeToken que se canjea por ellos junto con un código.
 type Auth struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+	AccessToken       string `json:"access_token,omitempty"`
+	RefreshToken      string `json:"refresh_token,omitempty"`
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
+	// CSRFToken sustituye a los tokens en el modo cookie: el cliente lo
+	// envía en auth.CSRFHeader en las peticiones que modifican algo.
+	CSRFToken string `json:"csrf_token,omitempty"`
 }
 
 type RegisterRequest struct {
 	Name     string `json:"name" validate:"required,min=2,max=100"`
 	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=6,max=32"`
+	Password string `json:"password" validate:"required,min=6,max=128"`
 }
 
 type LoginRequest struct {
 	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=6,max=100"`
+	Password string `json:"password" validate:"required,min=6,max=128"`
+	// DeviceName es opcional; si no llega, la sesión se etiqueta a partir
+	// del user agent.
+	DeviceName string `json:"device_name" validate:"omitempty,max=100"`
+}
+
+// ClientInfo describe desde dónde se inicia sesión.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// SessionResponse es una sesión activa tal como la ve su usuario. Current
+// marca la sesión con la que se hizo la petición.
+type SessionResponse struct {
+	ID          string     `json:"id"`
+	DeviceLabel string     `json:"device_label"`
+	UserAgent   string     `json:"user_agent"`
+	IP          string     `json:"ip"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	Current     bool       `json:"current"`
 }
 
 type RenewSessionRequest struct {
 	RefreshToken string `json:"refresh_token" validate:"required"`
 }
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,min=6,max=128"`
+}
+
+// TokenPurpose es para qué sirve un EmailToken.
+type TokenPurpose string
+
+const (
+	PurposeVerifyEmail   TokenPurpose = "verify_email"
+	PurposeResetPassword TokenPurpose = "reset_password"
+)
+
+// EmailToken es un token de un solo uso enviado por correo. Se guarda su
+// SHA-256; UsedAt se rellena al consumirlo.
+type EmailToken struct {
+	ID        string       `gorm:"primaryKey;size:24"`
+	UserID    string       `gorm:"size:24;not null;index"`
+	Purpose   TokenPurpose `gorm:"size:32;not null"`
+	TokenHash string       `gorm:"size:64;not null;uniqueIndex"`
+	ExpiresAt time.Time    `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (EmailToken) TableName() string {
+	return "email_tokens"
+}
+
+// ConfirmTwoFactorRequest lleva el primer código generado por la app.
+type ConfirmTwoFactorRequest struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+// TwoFactorCodeRequest lleva un código de la app o un código de
+// recuperación.
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+type VerifyTwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required,max=32"`
+}
+
+// TwoFactorEnrollment es lo que necesita la app para añadir la cuenta: el
+// secreto, la URI otpauth y el QR que la contiene como data URL PNG.
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+	QRCode string `json:"qr_code"`
+}
+
+// RecoveryCodesResponse devuelve los códigos de recuperación en claro. Es
+// la única vez que se muestran.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TwoFactorStatus struct {
+	Enabled           bool       `json:"enabled"`
+	EnabledAt         *time.Time `json:"enabled_at"`
+	RecoveryCodesLeft int64      `json:"recovery_codes_left"`
+}
+
+// TwoFactor es el segundo factor TOTP de un usuario. Mientras ConfirmedAt
+// sea nil la inscripción está pendiente y no se pide al iniciar sesión.
+// LastStep es el último paso TOTP aceptado, para que un código no sirva dos
+// veces.
+type TwoFactor struct {
+	UserID      string `gorm:"primaryKey;size:24"`
+	Secret      string `gorm:"size:64;not null"`
+	ConfirmedAt *time.Time
+	LastStep    int64 `gorm:"not null;default:0"`
+	CreatedAt   time.Time
+}
+
+func (TwoFactor) TableName() string {
+	return "two_factors"
+}
+
+// RecoveryCode sustituye al código de la app si el usuario pierde el
+// dispositivo. Cada uno vale una vez; se guarda su SHA-256.
+type RecoveryCode struct {
+	ID        string `gorm:"primaryKey;size:24"`
+	UserID    string `gorm:"size:24;not null;index"`
+	CodeHash  string `gorm:"size:64;not null;uniqueIndex"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
+
+// LoginChallenge es un inicio de sesión con la contraseña ya comprobada que
+// espera el segundo factor. Se guarda el SHA-256 del token; DeviceLabel es
+// la etiqueta que llevará la sesión. Tras maxChallengeAttempts códigos
+// incorrectos se descarta.
+type LoginChallenge struct {
+	ID          string    `gorm:"primaryKey;size:24"`
+	UserID      string    `gorm:"size:24;not null;index"`
+	TokenHash   string    `gorm:"size:64;not null;uniqueIndex"`
+	DeviceLabel string    `gorm:"size:100"`
+	Attempts    int       `gorm:"not null;default:0"`
+	ExpiresAt   time.Time `gorm:"not null;index"`
+	CreatedAt   time.Time
+}
+
+func (LoginChallenge) TableName() string {
+	return "login_challenges"
+}
+
+// OIDCStartResponse lleva la URL del proveedor a la que se envía al
+// usuario. El frontend guarda State y comprueba que el proveedor lo
+// devuelve igual antes de llamar al callback.
+type OIDCStartResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+	State            string `json:"state"`
+}
+
+// OIDCCallbackRequest lleva el code y el state con los que el proveedor
+// redirigió al frontend.
+type OIDCCallbackRequest struct {
+	Code       string `json:"code" validate:"required,max=2048"`
+	State      string `json:"state" validate:"required,max=128"`
+	DeviceName string `json:"device_name" validate:"omitempty,max=100"`
+}
+
+// OIDCLogin es un inicio de sesión con un proveedor OIDC a la espera del
+// callback. Se guarda el SHA-256 del state; el verificador PKCE y el nonce
+// se guardan en claro porque hacen falta para el canje.
+type OIDCLogin struct {
+	StateHash    string    `gorm:"primaryKey;size:64"`
+	Provider     string    `gorm:"size:64;not null"`
+	Nonce        string    `gorm:"size:64;not null"`
+	CodeVerifier string    `gorm:"size:128;not null"`
+	ExpiresAt    time.Time `gorm:"not null;index"`
+	CreatedAt    time.Time
+}
+
+func (OIDCLogin) TableName() string {
+	return "oidc_logins"
+}
+
+// ExternalIdentity vincula una cuenta con su usuario (sub) en un proveedor
+// OIDC. Email es el que tenía al vincularse, solo informativo.
+type ExternalIdentity struct {
+	ID        string `gorm:"primaryKey;size:24"`
+	UserID    string `gorm:"size:24;not null;index"`
+	Provider  string `gorm:"size:64;not null;uniqueIndex:idx_external_identities_provider_subject,priority:1"`
+	Subject   string `gorm:"size:255;not null;uniqueIndex:idx_external_identities_provider_subject,priority:2"`
+	Email     string
+	CreatedAt time.Time
+}
+
+func (ExternalIdentity) TableName() string {
+	return "external_identities"
+}
+
+type AttemptResult string
+
+const (
+	AttemptSucceeded          AttemptResult = "succeeded"
+	AttemptInvalidCredentials AttemptResult = "invalid_credentials"
+	// AttemptThrottled es un intento rechazado sin comprobar la contraseña
+	// porque la cuenta o la IP estaban penalizadas.
+	AttemptThrottled AttemptResult = "throttled"
+	AttemptLocked    AttemptResult = "locked"
+)
+
+// LoginAttempt registra cada intento de inicio de sesión para auditoría.
+// UserID queda vacío si el email no corresponde a ninguna cuenta.
+type LoginAttempt struct {
+	ID        string        `gorm:"primaryKey;size:24" json:"id"`
+	UserID    *string       `gorm:"size:24;index:idx_login_attempts_user_created,priority:1" json:"-"`
+	Email     string        `gorm:"not null;index" json:"email"`
+	IP        string        `gorm:"size:45;not null" json:"ip"`
+	Result    AttemptResult `gorm:"size:32;not null" json:"result"`
+	CreatedAt time.Time     `gorm:"not null;index;index:idx_login_attempts_user_created,priority:2" json:"created_at"`
+}
+
+func (LoginAttempt) TableName() string { return "login_attempts" }
+
+// Throttle cuenta los fallos seguidos de una cuenta ("account:<email>") o de
+// una IP ("ip:<ip>"). Mientras LockedUntil no haya pasado se rechazan los
+// intentos sin comprobar la contraseña.
+type Throttle struct {
+	Key           string    `gorm:"primaryKey;size:320"`
+	Failures      int       `gorm:"not null;default:0"`
+	LastFailureAt time.Time `gorm:"not null"`
+	LockedUntil   *time.Time
+}
+
+func (Throttle) TableName() string { return "login_throttles" }
+
+// LockoutPolicy define la penalización por fallos seguidos. Los primeros
+// FreeAttempts no se penalizan; a partir de ahí cada fallo obliga a esperar
+// BaseDelay, duplicándose hasta MaxDelay. Al llegar a MaxFailures la clave
+// queda bloqueada LockoutDuration. El contador vuelve a cero tras Window sin
+// fallos o con un inicio de sesión correcto.
+type LockoutPolicy struct {
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	MaxFailures     int
+	LockoutDuration time.Duration
+	Window          time.Duration
+}
+
+// ServiceConfig configura el servicio de autenticación. RefreshTokenTTL es
+// cuánto dura cada refresh token, y con él la sesión si no se renueva; a
+// cero toma DefaultRefreshTokenTTL. La duración del access token la fija
+// el TokenManager.
+//
+// BaseURL es la URL del frontend a la que apuntan los enlaces de los
+// correos; VerifyEmailTTL y ResetPasswordTTL, cuánto valen esos enlaces.
+//
+// TOTPIssuer es el nombre con el que aparece la cuenta en la app de
+// autenticación.
+//
+// IdentityProviders son los proveedores OIDC con los que se puede iniciar
+// sesión, por nombre.
+//
+// Passwords valida las contraseñas nuevas al registrarse y al
+// restablecerlas; sin ella solo se aplican las etiquetas de validación.
+type ServiceConfig struct {
+	RefreshTokenTTL   time.Duration
+	BaseURL           string
+	VerifyEmailTTL    time.Duration
+	ResetPasswordTTL  time.Duration
+	TOTPIssuer        string
+	IdentityProviders map[string]IdentityProvider
+	Passwords         PasswordChecker
+	Lockout           LockoutConfig
+}
+
+const (
+	DefaultRefreshTokenTTL  = 7 * 24 * time.Hour
+	DefaultVerifyEmailTTL   = 48 * time.Hour
+	DefaultResetPasswordTTL = time.Hour
+	DefaultTOTPIssuer       = "Image Processing Service"
+)
+
+// LockoutConfig agrupa las políticas por cuenta y por IP. Los valores a cero
+// toman los de DefaultAccountPolicy y DefaultIPPolicy.
+type LockoutConfig struct {
+	Account LockoutPolicy
+	// IP es más permisiva: frena a quien prueba muchas cuentas distintas
+	// desde la misma IP sin penalizar a usuarios detrás de una NAT.
+	IP LockoutPolicy
+}
+
+var (
+	DefaultAccountPolicy = LockoutPolicy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        30 * time.Second,
+		MaxFailures:     10,
+		LockoutDuration: 15 * time.Minute,
+		Window:          15 * time.Minute,
+	}
+	DefaultIPPolicy = LockoutPolicy{
+		FreeAttempts:    20,
+		BaseDelay:       time.Second,
+		MaxDelay:        30 * time.Second,
+		MaxFailures:     100,
+		LockoutDuration: 15 * time.Minute,
+		Window:          15 * time.Minute,
+	}
+)
+
+func (p LockoutPolicy) withDefaults(d LockoutPolicy) LockoutPolicy {
+	if p.FreeAttempts <= 0 {
+		p.FreeAttempts = d.FreeAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = d.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = d.MaxDelay
+	}
+	if p.MaxFailures <= 0 {
+		p.MaxFailures = d.MaxFailures
+	}
+	if p.LockoutDuration <= 0 {
+		p.LockoutDuration = d.LockoutDuration
+	}
+	if p.Window <= 0 {
+		p.Window = d.Window
+	}
+	return p
+}
+
+// penalty devuelve cuánto hay que esperar tras failures fallos seguidos y si
+// es un bloqueo.
+func (p LockoutPolicy) penalty(failures int) (time.Duration, bool) {
+	if failures >= p.MaxFailures {
+		return p.LockoutDuration, true
+	}
+	if failures <= p.FreeAttempts {
+		return 0, false
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay), false
+}
+
+// lockoutDetails acompaña a los errores ACCOUNT_LOCKED y TOO_MANY_ATTEMPTS.
+type lockoutDetails struct {
+	RetryAfter int `json:"retry_after"`
+}
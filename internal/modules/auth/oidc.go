@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"image-processing-service/internal/modules/session"
+	"image-processing-service/internal/modules/user"
+	"image-processing-service/internal/shared/auth"
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/oidc"
+	"image-processing-service/internal/shared/utils"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrOIDCProviderNotFound    = utils.NewError(404, "OIDC_PROVIDER_NOT_FOUND", "Proveedor de identidad no encontrado", nil)
+	ErrInvalidOIDCState        = utils.NewError(400, "INVALID_OIDC_STATE", "El inicio de sesión ha caducado o no es válido, vuelve a empezar", nil)
+	ErrOIDCLoginFailed         = utils.NewError(401, "OIDC_LOGIN_FAILED", "El proveedor de identidad no ha autenticado al usuario", nil)
+	ErrOIDCProviderUnavailable = utils.NewError(502, "OIDC_PROVIDER_UNAVAILABLE", "El proveedor de identidad no está disponible", nil)
+	ErrOIDCEmailNotVerified    = utils.NewError(403, "OIDC_EMAIL_NOT_VERIFIED", "El proveedor de identidad no ha verificado el email", nil)
+	ErrAccountEmailNotVerified = utils.NewError(409, "ACCOUNT_EMAIL_NOT_VERIFIED", "Ya existe una cuenta con este email sin verificar: inicia sesión con la contraseña y verifica el email para vincularla", nil)
+)
+
+// oidcLoginTTL es el tiempo para volver del proveedor con el código.
+const oidcLoginTTL = 10 * time.Minute
+
+// IdentityProvider es un proveedor OpenID Connect con el que iniciar
+// sesión. Lo implementa *oidc.Provider.
+type IdentityProvider interface {
+	AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error)
+	Authenticate(ctx context.Context, code, codeVerifier, nonce string) (*oidc.Identity, error)
+}
+
+// ListOIDCProviders devuelve los nombres de los proveedores configurados en
+// orden alfabético.
+func (s *service) ListOIDCProviders() []string {
+	names := make([]string, 0, len(s.config.IdentityProviders))
+	for name := range s.config.IdentityProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StartOIDCLogin genera el state, el nonce y el verificador PKCE del inicio
+// de sesión y devuelve la URL del proveedor. Solo se guarda el hash del
+// state: quien no lo tenga no puede completar el inicio de sesión.
+func (s *service) StartOIDCLogin(ctx context.Context, provider string) (*OIDCStartResponse, error) {
+	idp, ok := s.config.IdentityProviders[provider]
+	if !ok {
+		return nil, ErrOIDCProviderNotFound
+	}
+
+	state, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		return nil, err
+	}
+
+	authURL, err := idp.AuthCodeURL(ctx, state, nonce, challenge)
+	if err != nil {
+		log.Printf("Error preparando el inicio de sesión con %s: %v", provider, err)
+		return nil, ErrOIDCProviderUnavailable
+	}
+
+	now := s.now().UTC()
+	err = s.repo.CreateOIDCLogin(&OIDCLogin{
+		StateHash:    utils.GenerateSHA256(state),
+		Provider:     provider,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    now.Add(oidcLoginTTL),
+		CreatedAt:    now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &OIDCStartResponse{AuthorizationURL: authURL, State: state}, nil
+}
+
+// CompleteOIDCLogin canjea el código del proveedor y abre la sesión de la
+// cuenta vinculada a la identidad, igual que SignIn: si la cuenta tiene el
+// segundo factor activado devuelve un reto. El state solo sirve una vez.
+func (s *service) CompleteOIDCLogin(ctx context.Context, provider string, req OIDCCallbackRequest, client ClientInfo) (*Auth, error) {
+	idp, ok := s.config.IdentityProviders[provider]
+	if !ok {
+		return nil, ErrOIDCProviderNotFound
+	}
+
+	login, err := s.repo.ConsumeOIDCLogin(utils.GenerateSHA256(req.State), provider, s.now().UTC())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidOIDCState
+		}
+		return nil, err
+	}
+
+	identity, err := idp.Authenticate(ctx, req.Code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		log.Printf("Error completando el inicio de sesión con %s: %v", provider, err)
+		if errors.Is(err, oidc.ErrUnavailable) {
+			return nil, ErrOIDCProviderUnavailable
+		}
+		return nil, ErrOIDCLoginFailed
+	}
+
+	account, err := s.resolveIdentity(provider, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordAttempt(&LoginAttempt{UserID: &account.ID, Email: account.Email, IP: client.IP}, AttemptSucceeded)
+
+	deviceLabel := session.DeviceLabel(req.DeviceName, client.UserAgent)
+
+	enabled, err := s.twoFactorEnabled(account.ID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return s.challenge(account, deviceLabel)
+	}
+
+	return s.startSession(account, client, deviceLabel)
+}
+
+// resolveIdentity devuelve la cuenta de la identidad. Si aún no está
+// vinculada, la vincula con la cuenta de su email o crea una cuenta nueva.
+//
+// Solo se vincula por email si el proveedor lo ha verificado y la cuenta
+// local también: si no, quien registrase antes una cuenta con el email de
+// otro se quedaría con el acceso de su dueño.
+func (s *service) resolveIdentity(provider string, identity *oidc.Identity) (*user.User, error) {
+	linked, err := s.repo.FindIdentity(provider, identity.Subject)
+	if err == nil {
+		account, err := s.userRepo.GetByID(linked.UserID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrOIDCLoginFailed
+			}
+			return nil, err
+		}
+		return account, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, ErrOIDCEmailNotVerified
+	}
+
+	account, err := s.userRepo.GetByEmail(identity.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case account != nil && account.DeletedAt.Valid:
+		return nil, ErrOIDCLoginFailed
+	case account != nil && account.EmailVerifiedAt == nil:
+		return nil, ErrAccountEmailNotVerified
+	case account == nil:
+		account, err = s.createExternalUser(identity)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.repo.CreateIdentity(&ExternalIdentity{
+		ID:        utils.GenerateID(),
+		UserID:    account.ID,
+		Provider:  provider,
+		Subject:   identity.Subject,
+		Email:     identity.Email,
+		CreatedAt: s.now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// createExternalUser crea la cuenta de quien inicia sesión por primera vez
+// con un proveedor. No tiene contraseña, así que solo puede entrar con el
+// proveedor hasta que la restablezca; el email ya viene verificado.
+func (s *service) createExternalUser(identity *oidc.Identity) (*user.User, error) {
+	name := strings.TrimSpace(identity.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	verifiedAt := s.now().UTC()
+	newUser := &user.User{
+		ID:              utils.GenerateID(),
+		Name:            name,
+		Email:           identity.Email,
+		Role:            auth.RoleMember,
+		EmailVerifiedAt: &verifiedAt,
+	}
+
+	signedUp := events.New(events.UserSignedUp, newUser.ID, user.NewEventData(newUser))
+	if err := s.userRepo.Create(newUser, signedUp); err != nil {
+		return nil, err
+	}
+
+	return newUser, nil
+}
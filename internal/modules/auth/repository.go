@@ -0,0 +1,419 @@
+package auth
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Repository interface {
+	CreateAttempt(attempt *LoginAttempt) error
+	FindAttemptsByUserID(userID string, limit int) ([]LoginAttempt, error)
+	DeleteAttemptsBefore(before time.Time) (int64, error)
+	FindThrottle(key string) (*Throttle, error)
+	RegisterFailure(key string, now, windowStart time.Time) (*Throttle, error)
+	Lock(key string, until time.Time) error
+	ResetThrottle(key string) error
+	DeleteThrottlesBefore(windowStart, now time.Time) (int64, error)
+	CreateEmailToken(token *EmailToken) error
+	FindEmailToken(tokenHash string, purpose TokenPurpose, now time.Time) (*EmailToken, error)
+	ConsumeEmailToken(tokenHash string, purpose TokenPurpose, now time.Time) (*EmailToken, error)
+	FindTwoFactor(userID string) (*TwoFactor, error)
+	SaveTwoFactor(tf *TwoFactor) error
+	ConfirmTwoFactor(userID string, confirmedAt time.Time, step int64, codes []RecoveryCode) error
+	AdvanceTwoFactorStep(userID string, step int64) error
+	DeleteTwoFactor(userID string) error
+	ReplaceRecoveryCodes(userID string, codes []RecoveryCode) error
+	UseRecoveryCode(userID, codeHash string, now time.Time) error
+	CountRecoveryCodes(userID string) (int64, error)
+	CreateChallenge(challenge *LoginChallenge) error
+	FindChallenge(tokenHash string, now time.Time) (*LoginChallenge, error)
+	ClaimChallengeAttempt(id string, max int) (int, error)
+	DeleteChallenge(id string) error
+	CreateOIDCLogin(login *OIDCLogin) error
+	ConsumeOIDCLogin(stateHash, provider string, now time.Time) (*OIDCLogin, error)
+	FindIdentity(provider, subject string) (*ExternalIdentity, error)
+	CreateIdentity(identity *ExternalIdentity) error
+	DeleteIdentitiesByUserID(userID string) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) CreateAttempt(attempt *LoginAttempt) error {
+	return r.db.Create(attempt).Error
+}
+
+// FindAttemptsByUserID devuelve los últimos intentos del usuario, del más
+// reciente al más antiguo.
+func (r *repository) FindAttemptsByUserID(userID string, limit int) ([]LoginAttempt, error) {
+	var attempts []LoginAttempt
+
+	err := r.db.Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&attempts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+func (r *repository) DeleteAttemptsBefore(before time.Time) (int64, error) {
+	result := r.db.Where("created_at < ?", before).Delete(&LoginAttempt{})
+	return result.RowsAffected, result.Error
+}
+
+// FindThrottle devuelve gorm.ErrRecordNotFound si la clave no tiene fallos.
+func (r *repository) FindThrottle(key string) (*Throttle, error) {
+	var throttle Throttle
+
+	if err := r.db.Where("key = ?", key).First(&throttle).Error; err != nil {
+		return nil, err
+	}
+
+	return &throttle, nil
+}
+
+// RegisterFailure suma un fallo a la clave de forma atómica. Si el último
+// fallo es anterior a windowStart el contador empieza de nuevo.
+func (r *repository) RegisterFailure(key string, now, windowStart time.Time) (*Throttle, error) {
+	var throttle Throttle
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"failures": gorm.Expr(
+					"CASE WHEN login_throttles.last_failure_at < ? THEN 1 ELSE login_throttles.failures + 1 END",
+					windowStart,
+				),
+				"last_failure_at": now,
+			}),
+		}).Create(&Throttle{Key: key, Failures: 1, LastFailureAt: now}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Where("key = ?", key).First(&throttle).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &throttle, nil
+}
+
+func (r *repository) Lock(key string, until time.Time) error {
+	return r.db.Model(&Throttle{}).
+		Where("key = ?", key).
+		Update("locked_until", until).Error
+}
+
+func (r *repository) ResetThrottle(key string) error {
+	return r.db.Where("key = ?", key).Delete(&Throttle{}).Error
+}
+
+// DeleteThrottlesBefore borra las claves cuyo último fallo es anterior a
+// windowStart y que no siguen bloqueadas en now: su contador ya se
+// reiniciaría en el siguiente fallo. Sin esto cada email o IP que falla
+// alguna vez deja su fila para siempre.
+func (r *repository) DeleteThrottlesBefore(windowStart, now time.Time) (int64, error) {
+	result := r.db.
+		Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until <= ?)", windowStart, now).
+		Delete(&Throttle{})
+	return result.RowsAffected, result.Error
+}
+
+// CreateEmailToken guarda el token y borra los anteriores del usuario con
+// el mismo propósito: solo vale el último enlace enviado. Así la tabla no
+// crece más de un token por usuario y propósito.
+func (r *repository) CreateEmailToken(token *EmailToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND purpose = ?", token.UserID, token.Purpose).
+			Delete(&EmailToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+// FindEmailToken devuelve el token sin consumirlo si existe, es del
+// propósito, no ha caducado y no se ha usado. Si no, devuelve
+// gorm.ErrRecordNotFound.
+func (r *repository) FindEmailToken(tokenHash string, purpose TokenPurpose, now time.Time) (*EmailToken, error) {
+	var token EmailToken
+	if err := r.db.Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", tokenHash, purpose, now).
+		First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ConsumeEmailToken marca como usado el token si existe, es del propósito,
+// no ha caducado y no se había usado. Si no, devuelve
+// gorm.ErrRecordNotFound. El UPDATE condicional impide que dos peticiones
+// consuman el mismo token.
+func (r *repository) ConsumeEmailToken(tokenHash string, purpose TokenPurpose, now time.Time) (*EmailToken, error) {
+	var token EmailToken
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", tokenHash, purpose, now).
+			First(&token).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(&EmailToken{}).
+			Where("id = ? AND used_at IS NULL", token.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		token.UsedAt = &now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// FindTwoFactor devuelve gorm.ErrRecordNotFound si el usuario no ha
+// empezado a inscribir un segundo factor.
+func (r *repository) FindTwoFactor(userID string) (*TwoFactor, error) {
+	var tf TwoFactor
+
+	if err := r.db.Where("user_id = ?", userID).First(&tf).Error; err != nil {
+		return nil, err
+	}
+
+	return &tf, nil
+}
+
+// SaveTwoFactor crea la inscripción o sustituye la pendiente.
+func (r *repository) SaveTwoFactor(tf *TwoFactor) error {
+	return r.db.Save(tf).Error
+}
+
+// ConfirmTwoFactor activa la inscripción pendiente y guarda sus códigos de
+// recuperación. Si ya estaba confirmada devuelve gorm.ErrRecordNotFound.
+func (r *repository) ConfirmTwoFactor(userID string, confirmedAt time.Time, step int64, codes []RecoveryCode) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&TwoFactor{}).
+			Where("user_id = ? AND confirmed_at IS NULL", userID).
+			Updates(map[string]interface{}{"confirmed_at": confirmedAt, "last_step": step})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return replaceRecoveryCodes(tx, userID, codes)
+	})
+}
+
+// AdvanceTwoFactorStep guarda step como último paso usado. Si otro código
+// igual o posterior se aceptó antes, devuelve gorm.ErrRecordNotFound: el
+// UPDATE condicional impide usar el mismo código en dos peticiones.
+func (r *repository) AdvanceTwoFactorStep(userID string, step int64) error {
+	result := r.db.Model(&TwoFactor{}).
+		Where("user_id = ? AND last_step < ?", userID, step).
+		Update("last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteTwoFactor borra el segundo factor del usuario junto con sus códigos
+// de recuperación y los inicios de sesión que lo esperaban.
+func (r *repository) DeleteTwoFactor(userID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&LoginChallenge{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&TwoFactor{}).Error
+	})
+}
+
+// ReplaceRecoveryCodes invalida los códigos de recuperación del usuario y
+// guarda los nuevos.
+func (r *repository) ReplaceRecoveryCodes(userID string, codes []RecoveryCode) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, codes)
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID string, codes []RecoveryCode) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	return tx.Create(&codes).Error
+}
+
+// UseRecoveryCode marca como usado el código si es del usuario y no se
+// había usado. Si no, devuelve gorm.ErrRecordNotFound.
+func (r *repository) UseRecoveryCode(userID, codeHash string, now time.Time) error {
+	result := r.db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// CountRecoveryCodes cuenta los códigos de recuperación sin usar.
+func (r *repository) CountRecoveryCodes(userID string) (int64, error) {
+	var count int64
+
+	err := r.db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&count).Error
+
+	return count, err
+}
+
+// CreateChallenge guarda el reto y, de paso, borra los caducados: duran
+// minutos, así que la tabla se mantiene pequeña sin otra limpieza.
+func (r *repository) CreateChallenge(challenge *LoginChallenge) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at <= ?", challenge.CreatedAt).Delete(&LoginChallenge{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(challenge).Error
+	})
+}
+
+// FindChallenge devuelve gorm.ErrRecordNotFound si el reto no existe o ha
+// caducado.
+func (r *repository) FindChallenge(tokenHash string, now time.Time) (*LoginChallenge, error) {
+	var challenge LoginChallenge
+
+	if err := r.db.Where("token_hash = ? AND expires_at > ?", tokenHash, now).First(&challenge).Error; err != nil {
+		return nil, err
+	}
+
+	return &challenge, nil
+}
+
+// ClaimChallengeAttempt gasta uno de los max intentos del reto antes de
+// comprobar el código y devuelve cuántos lleva. La condición va en el UPDATE
+// para que peticiones en paralelo no puedan pasar del límite. Devuelve
+// gorm.ErrRecordNotFound si el reto ya no existe o agotó sus intentos.
+func (r *repository) ClaimChallengeAttempt(id string, max int) (int, error) {
+	var challenge LoginChallenge
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&LoginChallenge{}).
+			Where("id = ? AND attempts < ?", id, max).
+			Update("attempts", gorm.Expr("attempts + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return tx.Select("attempts").Where("id = ?", id).First(&challenge).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return challenge.Attempts, nil
+}
+
+// DeleteChallenge consume el reto. Devuelve gorm.ErrRecordNotFound si otra
+// petición lo consumió antes.
+func (r *repository) DeleteChallenge(id string) error {
+	result := r.db.Where("id = ?", id).Delete(&LoginChallenge{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// CreateOIDCLogin guarda el inicio de sesión pendiente y borra los
+// caducados, como CreateChallenge.
+func (r *repository) CreateOIDCLogin(login *OIDCLogin) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at <= ?", login.CreatedAt).Delete(&OIDCLogin{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(login).Error
+	})
+}
+
+// ConsumeOIDCLogin borra y devuelve el inicio de sesión del state si es del
+// proveedor y no ha caducado. Si no, o si otra petición lo consumió antes,
+// devuelve gorm.ErrRecordNotFound.
+func (r *repository) ConsumeOIDCLogin(stateHash, provider string, now time.Time) (*OIDCLogin, error) {
+	var login OIDCLogin
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("state_hash = ? AND provider = ? AND expires_at > ?", stateHash, provider, now).
+			First(&login).Error; err != nil {
+			return err
+		}
+
+		result := tx.Where("state_hash = ?", stateHash).Delete(&OIDCLogin{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &login, nil
+}
+
+// FindIdentity devuelve gorm.ErrRecordNotFound si el usuario del proveedor
+// no está vinculado a ninguna cuenta.
+func (r *repository) FindIdentity(provider, subject string) (*ExternalIdentity, error) {
+	var identity ExternalIdentity
+
+	if err := r.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error; err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+func (r *repository) CreateIdentity(identity *ExternalIdentity) error {
+	return r.db.Create(identity).Error
+}
+
+func (r *repository) DeleteIdentitiesByUserID(userID string) error {
+	return r.db.Delete(&ExternalIdentity{}, "user_id = ?", userID).Error
+}
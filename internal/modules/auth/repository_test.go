@@ -0,0 +1,477 @@
+package auth_test
+
+// Los tests de repositorio usan SQLite en memoria para ejecutar el upsert
+// real con el que se cuentan los fallos seguidos.
+
+import (
+	"image-processing-service/internal/modules/auth"
+	"image-processing-service/internal/shared/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newMemoryDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err, "no se pudo abrir la base de datos en memoria")
+
+	err = db.AutoMigrate(&auth.LoginAttempt{}, &auth.Throttle{}, &auth.EmailToken{}, &auth.TwoFactor{}, &auth.RecoveryCode{}, &auth.LoginChallenge{}, &auth.OIDCLogin{}, &auth.ExternalIdentity{})
+	require.NoError(t, err, "no se pudo migrar el esquema")
+
+	return db
+}
+
+var base = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// ─────────────────────────────────────────────────────────────────────────────
+// RegisterFailure
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_RegisterFailure(t *testing.T) {
+	t.Run("Debe sumar los fallos seguidos dentro de la ventana", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		_, err := repo.RegisterFailure("account:ana@test.com", base, base.Add(-time.Minute))
+		require.NoError(t, err)
+
+		// WHEN
+		now := base.Add(30 * time.Second)
+		throttle, err := repo.RegisterFailure("account:ana@test.com", now, now.Add(-time.Minute))
+
+		// THEN
+		require.NoError(t, err)
+		assert.Equal(t, 2, throttle.Failures)
+		assert.True(t, throttle.LastFailureAt.Equal(now))
+	})
+
+	t.Run("Debe reiniciar el contador cuando el último fallo quedó fuera de la ventana", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		for range 3 {
+			_, err := repo.RegisterFailure("account:ana@test.com", base, base.Add(-time.Minute))
+			require.NoError(t, err)
+		}
+
+		// WHEN
+		now := base.Add(2 * time.Minute)
+		throttle, err := repo.RegisterFailure("account:ana@test.com", now, now.Add(-time.Minute))
+
+		// THEN
+		require.NoError(t, err)
+		assert.Equal(t, 1, throttle.Failures)
+	})
+
+	t.Run("Debe llevar un contador independiente por clave", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		_, err := repo.RegisterFailure("account:ana@test.com", base, base.Add(-time.Minute))
+		require.NoError(t, err)
+
+		// WHEN
+		throttle, err := repo.RegisterFailure("ip:203.0.113.1", base, base.Add(-time.Minute))
+
+		// THEN
+		require.NoError(t, err)
+		assert.Equal(t, 1, throttle.Failures)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Lock / ResetThrottle
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_Lock(t *testing.T) {
+	t.Run("Debe guardar hasta cuándo queda bloqueada la clave", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		_, err := repo.RegisterFailure("account:ana@test.com", base, base.Add(-time.Minute))
+		require.NoError(t, err)
+
+		// WHEN
+		err = repo.Lock("account:ana@test.com", base.Add(time.Hour))
+
+		// THEN
+		require.NoError(t, err)
+		throttle, err := repo.FindThrottle("account:ana@test.com")
+		require.NoError(t, err)
+		require.NotNil(t, throttle.LockedUntil)
+		assert.True(t, throttle.LockedUntil.Equal(base.Add(time.Hour)))
+	})
+
+	t.Run("Debe borrar los fallos y el bloqueo al reiniciar la clave", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		_, err := repo.RegisterFailure("account:ana@test.com", base, base.Add(-time.Minute))
+		require.NoError(t, err)
+		require.NoError(t, repo.Lock("account:ana@test.com", base.Add(time.Hour)))
+
+		// WHEN
+		err = repo.ResetThrottle("account:ana@test.com")
+
+		// THEN
+		require.NoError(t, err)
+		_, err = repo.FindThrottle("account:ana@test.com")
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("Debe borrar solo las claves fuera de la ventana y sin bloqueo vigente", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		old, recent := base.Add(-time.Hour), base.Add(-time.Minute)
+		for _, key := range []string{"ip:antigua", "ip:bloqueada", "ip:desbloqueada"} {
+			_, err := repo.RegisterFailure(key, old, old.Add(-time.Minute))
+			require.NoError(t, err)
+		}
+		require.NoError(t, repo.Lock("ip:bloqueada", base.Add(time.Minute)))
+		require.NoError(t, repo.Lock("ip:desbloqueada", base.Add(-time.Minute)))
+		_, err := repo.RegisterFailure("ip:reciente", recent, recent.Add(-time.Minute))
+		require.NoError(t, err)
+
+		// WHEN
+		deleted, err := repo.DeleteThrottlesBefore(base.Add(-15*time.Minute), base)
+
+		// THEN
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, deleted)
+		for key, kept := range map[string]bool{"ip:antigua": false, "ip:bloqueada": true, "ip:desbloqueada": false, "ip:reciente": true} {
+			_, err := repo.FindThrottle(key)
+			if kept {
+				assert.NoError(t, err, key)
+			} else {
+				assert.ErrorIs(t, err, gorm.ErrRecordNotFound, key)
+			}
+		}
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Intentos
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_Attempts(t *testing.T) {
+	t.Run("Debe devolver los intentos del usuario del más reciente al más antiguo", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		userID := utils.GenerateID()
+		for i, result := range []auth.AttemptResult{auth.AttemptInvalidCredentials, auth.AttemptSucceeded} {
+			require.NoError(t, repo.CreateAttempt(&auth.LoginAttempt{
+				ID: utils.GenerateID(), UserID: &userID, Email: "ana@test.com", IP: "203.0.113.1",
+				Result: result, CreatedAt: base.Add(time.Duration(i) * time.Second),
+			}))
+		}
+		require.NoError(t, repo.CreateAttempt(&auth.LoginAttempt{
+			ID: utils.GenerateID(), Email: "nadie@test.com", IP: "203.0.113.1",
+			Result: auth.AttemptInvalidCredentials, CreatedAt: base,
+		}))
+
+		// WHEN
+		attempts, err := repo.FindAttemptsByUserID(userID, 10)
+
+		// THEN
+		require.NoError(t, err)
+		require.Len(t, attempts, 2)
+		assert.Equal(t, auth.AttemptSucceeded, attempts[0].Result)
+		assert.Equal(t, auth.AttemptInvalidCredentials, attempts[1].Result)
+	})
+
+	t.Run("Debe borrar solo los intentos anteriores a la fecha dada", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		userID := utils.GenerateID()
+		for _, at := range []time.Time{base.Add(-time.Hour), base} {
+			require.NoError(t, repo.CreateAttempt(&auth.LoginAttempt{
+				ID: utils.GenerateID(), UserID: &userID, Email: "ana@test.com", IP: "203.0.113.1",
+				Result: auth.AttemptSucceeded, CreatedAt: at,
+			}))
+		}
+
+		// WHEN
+		deleted, err := repo.DeleteAttemptsBefore(base.Add(-time.Minute))
+
+		// THEN
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+		attempts, err := repo.FindAttemptsByUserID(userID, 10)
+		require.NoError(t, err)
+		assert.Len(t, attempts, 1)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// EmailToken
+// ─────────────────────────────────────────────────────────────────────────────
+
+func newEmailToken(userID, hash string, purpose auth.TokenPurpose) *auth.EmailToken {
+	return &auth.EmailToken{
+		ID:        utils.GenerateID(),
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hash,
+		ExpiresAt: base.Add(time.Hour),
+		CreatedAt: base,
+	}
+}
+
+func TestRepository_ConsumeEmailToken(t *testing.T) {
+	t.Run("Debe consumir el token una sola vez", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		require.NoError(t, repo.CreateEmailToken(newEmailToken("user-1", "hash-1", auth.PurposeVerifyEmail)))
+
+		// WHEN
+		first, err := repo.ConsumeEmailToken("hash-1", auth.PurposeVerifyEmail, base)
+		require.NoError(t, err)
+		_, err = repo.ConsumeEmailToken("hash-1", auth.PurposeVerifyEmail, base)
+
+		// THEN
+		assert.Equal(t, "user-1", first.UserID)
+		require.NotNil(t, first.UsedAt)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("Debe rechazar un token caducado", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		require.NoError(t, repo.CreateEmailToken(newEmailToken("user-1", "hash-1", auth.PurposeVerifyEmail)))
+
+		// WHEN
+		_, err := repo.ConsumeEmailToken("hash-1", auth.PurposeVerifyEmail, base.Add(2*time.Hour))
+
+		// THEN
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("Debe rechazar un token de otro propósito", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		require.NoError(t, repo.CreateEmailToken(newEmailToken("user-1", "hash-1", auth.PurposeVerifyEmail)))
+
+		// WHEN
+		_, err := repo.ConsumeEmailToken("hash-1", auth.PurposeResetPassword, base)
+
+		// THEN
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+}
+
+func TestRepository_CreateEmailToken(t *testing.T) {
+	t.Run("Debe invalidar el token anterior del mismo propósito", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		require.NoError(t, repo.CreateEmailToken(newEmailToken("user-1", "reset-1", auth.PurposeResetPassword)))
+		require.NoError(t, repo.CreateEmailToken(newEmailToken("user-1", "verify-1", auth.PurposeVerifyEmail)))
+
+		// WHEN
+		require.NoError(t, repo.CreateEmailToken(newEmailToken("user-1", "reset-2", auth.PurposeResetPassword)))
+
+		// THEN
+		_, err := repo.ConsumeEmailToken("reset-1", auth.PurposeResetPassword, base)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		_, err = repo.ConsumeEmailToken("reset-2", auth.PurposeResetPassword, base)
+		assert.NoError(t, err)
+		_, err = repo.ConsumeEmailToken("verify-1", auth.PurposeVerifyEmail, base)
+		assert.NoError(t, err)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Segundo factor
+// ─────────────────────────────────────────────────────────────────────────────
+
+func newRecoveryCodes(userID string, hashes ...string) []auth.RecoveryCode {
+	codes := make([]auth.RecoveryCode, 0, len(hashes))
+	for _, hash := range hashes {
+		codes = append(codes, auth.RecoveryCode{ID: utils.GenerateID(), UserID: userID, CodeHash: hash, CreatedAt: base})
+	}
+	return codes
+}
+
+func TestRepository_ConfirmTwoFactor(t *testing.T) {
+	t.Run("Debe activar la inscripción pendiente una sola vez", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		require.NoError(t, repo.SaveTwoFactor(&auth.TwoFactor{UserID: "user-1", Secret: "SECRET", CreatedAt: base}))
+
+		// WHEN
+		err := repo.ConfirmTwoFactor("user-1", base, 100, newRecoveryCodes("user-1", "code-1", "code-2"))
+		again := repo.ConfirmTwoFactor("user-1", base, 101, newRecoveryCodes("user-1", "code-3"))
+
+		// THEN
+		require.NoError(t, err)
+		assert.ErrorIs(t, again, gorm.ErrRecordNotFound)
+		tf, err := repo.FindTwoFactor("user-1")
+		require.NoError(t, err)
+		require.NotNil(t, tf.ConfirmedAt)
+		assert.EqualValues(t, 100, tf.LastStep)
+		left, err := repo.CountRecoveryCodes("user-1")
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, left)
+	})
+}
+
+func TestRepository_AdvanceTwoFactorStep(t *testing.T) {
+	t.Run("Debe rechazar pasos iguales o anteriores al último usado", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		require.NoError(t, repo.SaveTwoFactor(&auth.TwoFactor{UserID: "user-1", Secret: "SECRET", LastStep: 100, CreatedAt: base}))
+
+		// WHEN
+		same := repo.AdvanceTwoFactorStep("user-1", 100)
+		next := repo.AdvanceTwoFactorStep("user-1", 101)
+		older := repo.AdvanceTwoFactorStep("user-1", 99)
+
+		// THEN
+		assert.ErrorIs(t, same, gorm.ErrRecordNotFound)
+		assert.NoError(t, next)
+		assert.ErrorIs(t, older, gorm.ErrRecordNotFound)
+	})
+}
+
+func TestRepository_UseRecoveryCode(t *testing.T) {
+	t.Run("Debe aceptar cada código una vez y solo para su usuario", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		require.NoError(t, repo.ReplaceRecoveryCodes("user-1", newRecoveryCodes("user-1", "code-1", "code-2")))
+
+		// WHEN
+		other := repo.UseRecoveryCode("user-2", "code-1", base)
+		first := repo.UseRecoveryCode("user-1", "code-1", base)
+		second := repo.UseRecoveryCode("user-1", "code-1", base)
+
+		// THEN
+		assert.ErrorIs(t, other, gorm.ErrRecordNotFound)
+		assert.NoError(t, first)
+		assert.ErrorIs(t, second, gorm.ErrRecordNotFound)
+		left, err := repo.CountRecoveryCodes("user-1")
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, left)
+	})
+}
+
+func TestRepository_Challenges(t *testing.T) {
+	t.Run("Debe consumir el reto una sola vez", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		challenge := &auth.LoginChallenge{ID: utils.GenerateID(), UserID: "user-1", TokenHash: "hash-1", ExpiresAt: base.Add(5 * time.Minute), CreatedAt: base}
+		require.NoError(t, repo.CreateChallenge(challenge))
+
+		// WHEN
+		found, err := repo.FindChallenge("hash-1", base)
+		require.NoError(t, err)
+		first := repo.DeleteChallenge(found.ID)
+		second := repo.DeleteChallenge(found.ID)
+
+		// THEN
+		assert.NoError(t, first)
+		assert.ErrorIs(t, second, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("Debe ignorar los retos caducados y borrarlos al crear otro", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := auth.NewRepository(db)
+		require.NoError(t, repo.CreateChallenge(&auth.LoginChallenge{ID: utils.GenerateID(), UserID: "user-1", TokenHash: "hash-1", ExpiresAt: base.Add(5 * time.Minute), CreatedAt: base}))
+
+		// WHEN
+		later := base.Add(10 * time.Minute)
+		_, err := repo.FindChallenge("hash-1", later)
+		require.NoError(t, repo.CreateChallenge(&auth.LoginChallenge{ID: utils.GenerateID(), UserID: "user-2", TokenHash: "hash-2", ExpiresAt: later.Add(5 * time.Minute), CreatedAt: later}))
+
+		// THEN
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		var count int64
+		require.NoError(t, db.Model(&auth.LoginChallenge{}).Count(&count).Error)
+		assert.EqualValues(t, 1, count)
+	})
+
+	t.Run("Debe dejar de conceder intentos al llegar al máximo", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		challenge := &auth.LoginChallenge{ID: utils.GenerateID(), UserID: "user-1", TokenHash: "hash-1", ExpiresAt: base.Add(5 * time.Minute), CreatedAt: base}
+		require.NoError(t, repo.CreateChallenge(challenge))
+
+		// WHEN
+		var claimed []int
+		for range 3 {
+			attempts, err := repo.ClaimChallengeAttempt(challenge.ID, 2)
+			if err != nil {
+				assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+				continue
+			}
+			claimed = append(claimed, attempts)
+		}
+
+		// THEN
+		assert.Equal(t, []int{1, 2}, claimed)
+	})
+}
+
+func TestRepository_ConsumeOIDCLogin(t *testing.T) {
+	t.Run("Debe consumir el inicio de sesión una sola vez", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		require.NoError(t, repo.CreateOIDCLogin(&auth.OIDCLogin{StateHash: "state-1", Provider: "mock", Nonce: "nonce", CodeVerifier: "verifier", ExpiresAt: base.Add(10 * time.Minute), CreatedAt: base}))
+
+		// WHEN
+		first, err := repo.ConsumeOIDCLogin("state-1", "mock", base)
+		_, second := repo.ConsumeOIDCLogin("state-1", "mock", base)
+
+		// THEN
+		require.NoError(t, err)
+		assert.Equal(t, "verifier", first.CodeVerifier)
+		assert.ErrorIs(t, second, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("Debe rechazar el state de otro proveedor", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		require.NoError(t, repo.CreateOIDCLogin(&auth.OIDCLogin{StateHash: "state-1", Provider: "mock", Nonce: "nonce", CodeVerifier: "verifier", ExpiresAt: base.Add(10 * time.Minute), CreatedAt: base}))
+
+		// WHEN
+		_, err := repo.ConsumeOIDCLogin("state-1", "otro", base)
+
+		// THEN
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("Debe ignorar los caducados y borrarlos al crear otro", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := auth.NewRepository(db)
+		require.NoError(t, repo.CreateOIDCLogin(&auth.OIDCLogin{StateHash: "state-1", Provider: "mock", Nonce: "nonce", CodeVerifier: "verifier", ExpiresAt: base.Add(10 * time.Minute), CreatedAt: base}))
+
+		// WHEN
+		later := base.Add(15 * time.Minute)
+		_, err := repo.ConsumeOIDCLogin("state-1", "mock", later)
+		require.NoError(t, repo.CreateOIDCLogin(&auth.OIDCLogin{StateHash: "state-2", Provider: "mock", Nonce: "nonce", CodeVerifier: "verifier", ExpiresAt: later.Add(10 * time.Minute), CreatedAt: later}))
+
+		// THEN
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		var count int64
+		require.NoError(t, db.Model(&auth.OIDCLogin{}).Count(&count).Error)
+		assert.EqualValues(t, 1, count)
+	})
+}
+
+func TestRepository_CreateIdentity(t *testing.T) {
+	t.Run("Debe rechazar vincular dos veces el mismo usuario del proveedor", func(t *testing.T) {
+		// GIVEN
+		repo := auth.NewRepository(newMemoryDB(t))
+		require.NoError(t, repo.CreateIdentity(&auth.ExternalIdentity{ID: utils.GenerateID(), UserID: "user-1", Provider: "mock", Subject: "sub-1"}))
+
+		// WHEN
+		err := repo.CreateIdentity(&auth.ExternalIdentity{ID: utils.GenerateID(), UserID: "user-2", Provider: "mock", Subject: "sub-1"})
+
+		// THEN
+		assert.Error(t, err)
+		found, err := repo.FindIdentity("mock", "sub-1")
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", found.UserID)
+	})
+}
@@ -1,12 +1,20 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"image-processing-service/internal/modules/session"
 	"image-processing-service/internal/modules/user"
+	"log"
+	"math"
+	"strings"
+	"sync"
 	"time"
 
 	"image-processing-service/internal/shared/auth"
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/mail"
+	"image-processing-service/internal/shared/password"
 	"image-processing-service/internal/shared/utils"
 
 	"gorm.io/gorm"
@@ -15,23 +23,108 @@ import (
 var (
 	ErrNotFound           = utils.NewError(404, "SESSION_NOT_FOUND", "Sesión no encontrada", nil)
 	ErrInvalidCredentials = utils.NewError(401, "INVALID_CREDENTIALS", "Credenciales inválidas", nil)
+	ErrUserNotFound       = utils.NewError(404, "USER_NOT_FOUND", "Usuario no encontrado", nil)
 )
 
+// maxListedAttempts es cuántos intentos devuelve ListAttempts.
+const maxListedAttempts = 50
+
+// errAccountLocked y errTooManyAttempts llevan en details los segundos que
+// quedan hasta poder volver a intentarlo.
+func errAccountLocked(wait time.Duration) error {
+	return utils.NewError(423, "ACCOUNT_LOCKED", "La cuenta está bloqueada temporalmente por demasiados intentos fallidos", lockoutDetails{RetryAfter: ceilSeconds(wait)})
+}
+
+func errTooManyAttempts(wait time.Duration) error {
+	return utils.NewError(429, "TOO_MANY_ATTEMPTS", "Demasiados intentos fallidos, espera antes de volver a intentarlo", lockoutDetails{RetryAfter: ceilSeconds(wait)})
+}
+
+// dummyHash se compara cuando el email no existe para que la respuesta tarde
+// lo mismo que con una contraseña incorrecta y no delate qué emails están
+// registrados.
+var dummyHash = sync.OnceValue(func() string {
+	hash, err := utils.HashPassword(utils.GenerateID())
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
 type Service interface {
 	SignUp(req RegisterRequest) (*user.User, error)
-	SignIn(req LoginRequest) (*Auth, error)
+	SignIn(req LoginRequest, client ClientInfo) (*Auth, error)
 	SignOut(jti string) error
-	RenewSession(refreshToken string) (*Auth, error)
+	SignOutEverywhere(userID string) error
+	ListSessions(userID, currentJTI string) ([]SessionResponse, error)
+	RevokeSession(userID, sessionID string) error
+	RenewSession(refreshToken string, client ClientInfo) (*Auth, error)
+	ResendVerification(userID string) error
+	VerifyEmail(req VerifyEmailRequest) error
+	ForgotPassword(req ForgotPasswordRequest) error
+	ResetPassword(req ResetPasswordRequest) error
+	EnrollTwoFactor(userID string) (*TwoFactorEnrollment, error)
+	ConfirmTwoFactor(userID string, req ConfirmTwoFactorRequest) (*RecoveryCodesResponse, error)
+	DisableTwoFactor(userID string, req TwoFactorCodeRequest) error
+	RegenerateRecoveryCodes(userID string, req TwoFactorCodeRequest) (*RecoveryCodesResponse, error)
+	TwoFactorStatus(userID string) (*TwoFactorStatus, error)
+	VerifyTwoFactor(req VerifyTwoFactorRequest, client ClientInfo) (*Auth, error)
+	ListOIDCProviders() []string
+	StartOIDCLogin(ctx context.Context, provider string) (*OIDCStartResponse, error)
+	CompleteOIDCLogin(ctx context.Context, provider string, req OIDCCallbackRequest, client ClientInfo) (*Auth, error)
+	Unlock(userID string) error
+	ListAttempts(userID string) ([]LoginAttempt, error)
+	PruneAttempts(before time.Time) (int64, error)
+	PruneThrottles(now time.Time) (int64, error)
+	DeleteByUserID(userID string) error
+}
+
+// PasswordChecker comprueba la política de contraseñas. Lo implementa
+// *user.PasswordPolicy.
+type PasswordChecker interface {
+	Check(u *user.User, field, plain string) error
 }
 
 type service struct {
+	repo         Repository
 	userRepo     user.Repository
 	sessionSrv   session.Service
 	tokenManager *auth.TokenManager
+	mailer       mail.Mailer
+	config       ServiceConfig
+	lockout      LockoutConfig
+	now          func() time.Time
 }
 
-func NewService(r user.Repository, sSrv session.Service, m *auth.TokenManager) Service {
-	return &service{userRepo: r, sessionSrv: sSrv, tokenManager: m}
+func NewService(r Repository, ur user.Repository, sSrv session.Service, m *auth.TokenManager, mailer mail.Mailer, cfg ServiceConfig) Service {
+	if cfg.RefreshTokenTTL <= 0 {
+		cfg.RefreshTokenTTL = DefaultRefreshTokenTTL
+	}
+	if cfg.VerifyEmailTTL <= 0 {
+		cfg.VerifyEmailTTL = DefaultVerifyEmailTTL
+	}
+	if cfg.ResetPasswordTTL <= 0 {
+		cfg.ResetPasswordTTL = DefaultResetPasswordTTL
+	}
+	if cfg.TOTPIssuer == "" {
+		cfg.TOTPIssuer = DefaultTOTPIssuer
+	}
+	if cfg.Passwords == nil {
+		cfg.Passwords = user.NewPasswordPolicy(ur, password.Policy{})
+	}
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	cfg.Lockout.Account = cfg.Lockout.Account.withDefaults(DefaultAccountPolicy)
+	cfg.Lockout.IP = cfg.Lockout.IP.withDefaults(DefaultIPPolicy)
+
+	return &service{
+		repo:         r,
+		userRepo:     ur,
+		sessionSrv:   sSrv,
+		tokenManager: m,
+		mailer:       mailer,
+		config:       cfg,
+		lockout:      cfg.Lockout,
+		now:          time.Now,
+	}
 }
 
 func (s *service) SignUp(req RegisterRequest) (*user.User, error) {
@@ -40,47 +133,144 @@ func (s *service) SignUp(req RegisterRequest) (*user.User, error) {
 		return nil, utils.ErrAlreadyExists
 	}
 
+	newUser := &user.User{
+		Name:  req.Name,
+		Email: req.Email,
+		Role:  auth.RoleMember,
+	}
+	if err := s.config.Passwords.Check(newUser, "password", req.Password); err != nil {
+		return nil, err
+	}
+
 	hashedPassword, err := utils.HashPassword(req.Password)
 	if err != nil {
 		return nil, err
 	}
+	newUser.ID = utils.GenerateID()
+	newUser.Password = hashedPassword
 
-	newUser := &user.User{
-		ID:       utils.GenerateID(),
-		Name:     req.Name,
-		Email:    req.Email,
-		Password: hashedPassword,
+	signedUp := events.New(events.UserSignedUp, newUser.ID, user.NewEventData(newUser))
+	if err := s.userRepo.Create(newUser, signedUp); err != nil {
+		return nil, err
 	}
 
-	if err := s.userRepo.Create(newUser); err != nil {
-		return nil, err
+	// La cuenta ya existe: si el correo falla, se puede pedir otro.
+	if err := s.sendVerification(newUser); err != nil {
+		log.Printf("Error enviando la verificación de email a %s: %v", newUser.Email, err)
 	}
 
 	return newUser, nil
 }
 
-func (s *service) SignIn(req LoginRequest) (*Auth, error) {
+// SignIn comprueba las credenciales aplicando las penalizaciones por fallos
+// seguidos de la cuenta y de la IP. Todos los intentos quedan registrados.
+// Si la cuenta tiene el segundo factor activado, en lugar de los tokens
+// devuelve un reto que se completa con VerifyTwoFactor.
+//
+// Un email que no existe se trata igual que una contraseña incorrecta:
+// cuenta como fallo, puede bloquearse y tarda lo mismo en responder.
+func (s *service) SignIn(req LoginRequest, client ClientInfo) (*Auth, error) {
+	now := s.now().UTC()
+	ip := client.IP
+	accountKey := accountThrottleKey(req.Email)
+	ipKey := "ip:" + ip
+
 	existingUser, _ := s.userRepo.GetByEmail(req.Email)
-	if existingUser == nil || existingUser.DeletedAt.Valid {
-		return nil, ErrInvalidCredentials
+	if existingUser != nil && existingUser.DeletedAt.Valid {
+		existingUser = nil
+	}
+
+	attempt := &LoginAttempt{Email: req.Email, IP: ip}
+	if existingUser != nil {
+		attempt.UserID = &existingUser.ID
+	}
+
+	// Una IP penalizada recibe siempre TOO_MANY_ATTEMPTS: solo las cuentas
+	// se bloquean con ACCOUNT_LOCKED.
+	wait, _, err := s.throttled(ipKey, s.lockout.IP, now)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		s.recordAttempt(attempt, AttemptThrottled)
+		return nil, errTooManyAttempts(wait)
+	}
+
+	wait, locked, err := s.throttled(accountKey, s.lockout.Account, now)
+	if err != nil {
+		return nil, err
+	}
+	if locked {
+		s.recordAttempt(attempt, AttemptLocked)
+		return nil, errAccountLocked(wait)
+	}
+	if wait > 0 {
+		s.recordAttempt(attempt, AttemptThrottled)
+		return nil, errTooManyAttempts(wait)
+	}
+
+	var isValid bool
+	if existingUser == nil {
+		utils.CheckPasswordHash(req.Password, dummyHash())
+	} else {
+		isValid = utils.CheckPasswordHash(req.Password, existingUser.Password)
 	}
 
-	isValid := utils.CheckPasswordHash(req.Password, existingUser.Password)
 	if !isValid {
+		s.recordAttempt(attempt, AttemptInvalidCredentials)
+		if err := s.registerFailure(accountKey, s.lockout.Account, now); err != nil {
+			return nil, err
+		}
+		if err := s.registerFailure(ipKey, s.lockout.IP, now); err != nil {
+			return nil, err
+		}
 		return nil, ErrInvalidCredentials
 	}
 
-	result, err := s.tokenManager.GeneratePair(existingUser.ID)
+	s.recordAttempt(attempt, AttemptSucceeded)
+	s.rehashPassword(existingUser, req.Password)
+
+	deviceLabel := session.DeviceLabel(req.DeviceName, client.UserAgent)
+
+	// Con segundo factor los fallos de la cuenta se reinician al completar
+	// el reto: si no, quien conoce la contraseña podría reiniciarlos entre
+	// códigos.
+	enabled, err := s.twoFactorEnabled(existingUser.ID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return s.challenge(existingUser, deviceLabel)
+	}
+
+	// El contador de la IP no se reinicia: si no, bastaría con iniciar
+	// sesión en una cuenta propia entre intentos para no penalizarla nunca.
+	if err := s.repo.ResetThrottle(accountKey); err != nil {
+		return nil, err
+	}
+
+	return s.startSession(existingUser, client, deviceLabel)
+}
+
+// startSession emite el par de tokens y crea la sesión que los respalda.
+func (s *service) startSession(u *user.User, client ClientInfo, deviceLabel string) (*Auth, error) {
+	result, err := s.tokenManager.GeneratePair(u.ID, u.Role)
 	if err != nil {
 		return nil, err
 	}
 
 	_, err = s.sessionSrv.Create(session.CreateSessionRequest{
-		TokenHash: result.RefreshToken,
-		AccessJti: result.JTI,
-		UserID:    existingUser.ID,
-		ExpiresAt: time.Now().Add(time.Hour * 24 * 7),
+		TokenHash:   result.RefreshToken,
+		AccessJti:   result.JTI,
+		UserID:      u.ID,
+		UserAgent:   client.UserAgent,
+		IP:          client.IP,
+		DeviceLabel: deviceLabel,
+		ExpiresAt:   time.Now().Add(s.config.RefreshTokenTTL),
 	})
+	if err != nil {
+		return nil, err
+	}
 
 	return &Auth{
 		AccessToken:  result.AccessToken,
@@ -99,26 +289,86 @@ func (s *service) SignOut(jti string) error {
 	return nil
 }
 
-func (s *service) RenewSession(refreshToken string) (*Auth, error) {
+// SignOutEverywhere cierra todas las sesiones del usuario, incluida la
+// actual.
+func (s *service) SignOutEverywhere(userID string) error {
+	return s.sessionSrv.DeleteByUserID(userID)
+}
+
+// ListSessions devuelve las sesiones activas del usuario marcando la que
+// corresponde al access token currentJTI.
+func (s *service) ListSessions(userID, currentJTI string) ([]SessionResponse, error) {
+	sessions, err := s.sessionSrv.ListActive(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, sess := range sessions {
+		response = append(response, SessionResponse{
+			ID:          sess.ID,
+			DeviceLabel: sess.DeviceLabel,
+			UserAgent:   sess.UserAgent,
+			IP:          sess.IP,
+			CreatedAt:   sess.CreatedAt,
+			LastUsedAt:  sess.LastUsedAt,
+			ExpiresAt:   sess.ExpiresAt,
+			Current:     sess.AccessJti == currentJTI,
+		})
+	}
+
+	return response, nil
+}
+
+// RevokeSession cierra una sesión del usuario. Devuelve ErrNotFound si la
+// sesión no existe o es de otro usuario.
+func (s *service) RevokeSession(userID, sessionID string) error {
+	if err := s.sessionSrv.Revoke(sessionID, userID); err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// RenewSession cambia el refresh token por un par nuevo. El token
+// presentado deja de ser válido: si vuelve a aparecer, la sesión entera se
+// revoca porque alguien más lo tiene.
+func (s *service) RenewSession(refreshToken string, client ClientInfo) (*Auth, error) {
 	sess, err := s.sessionSrv.IsValid(refreshToken, session.Refresh)
 
 	if err != nil {
+		if errors.Is(err, session.ErrRefreshTokenReused) {
+			log.Printf("Refresh token reutilizado desde %s (%s)", client.IP, client.UserAgent)
+		}
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrNotFound
 		}
 		return nil, err
 	}
 
-	result, err := s.tokenManager.GeneratePair(sess.UserID)
+	// El rol se lee de nuevo para que el token renovado lleve el actual.
+	sessionUser, err := s.userRepo.GetByID(sess.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	result, err := s.tokenManager.GeneratePair(sess.UserID, sessionUser.Role)
 	if err != nil {
 		return nil, err
 	}
 
 	_, err = s.sessionSrv.RenewSession(session.UpdateSessionRequest{
-		SessionID:    sess.ID,
-		NewTokenHash: result.RefreshToken,
-		NewAccessJti: result.JTI,
-		ExpiresAt:    time.Now().Add(time.Hour * 24 * 7),
+		SessionID:        sess.ID,
+		CurrentTokenHash: sess.TokenHash,
+		NewTokenHash:     result.RefreshToken,
+		NewAccessJti:     result.JTI,
+		ExpiresAt:        time.Now().Add(s.config.RefreshTokenTTL),
 	})
 	if err != nil {
 		return nil, err
@@ -129,3 +379,123 @@ func (s *service) RenewSession(refreshToken string) (*Auth, error) {
 		RefreshToken: result.RefreshToken,
 	}, nil
 }
+
+// Unlock borra los fallos acumulados por la cuenta del usuario y levanta el
+// bloqueo, si lo hay.
+func (s *service) Unlock(userID string) error {
+	existingUser, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	return s.repo.ResetThrottle(accountThrottleKey(existingUser.Email))
+}
+
+// ListAttempts devuelve los últimos intentos de inicio de sesión en la
+// cuenta del usuario.
+func (s *service) ListAttempts(userID string) ([]LoginAttempt, error) {
+	return s.repo.FindAttemptsByUserID(userID, maxListedAttempts)
+}
+
+// PruneAttempts borra los intentos registrados antes de before.
+func (s *service) PruneAttempts(before time.Time) (int64, error) {
+	return s.repo.DeleteAttemptsBefore(before)
+}
+
+// PruneThrottles borra los contadores de fallos que ya no penalizan: sin
+// bloqueo vigente y con el último fallo fuera de la ventana más larga.
+func (s *service) PruneThrottles(now time.Time) (int64, error) {
+	window := max(s.lockout.Account.Window, s.lockout.IP.Window)
+	return s.repo.DeleteThrottlesBefore(now.Add(-window), now)
+}
+
+// DeleteByUserID borra el segundo factor, los códigos de recuperación y las
+// identidades OIDC del usuario, al borrar su cuenta.
+func (s *service) DeleteByUserID(userID string) error {
+	if err := s.repo.DeleteTwoFactor(userID); err != nil {
+		return err
+	}
+	return s.repo.DeleteIdentitiesByUserID(userID)
+}
+
+// throttled devuelve cuánto falta para que la clave pueda volver a
+// intentarlo y si se trata de un bloqueo. Una clave sin penalizar devuelve 0.
+func (s *service) throttled(key string, policy LockoutPolicy, now time.Time) (time.Duration, bool, error) {
+	throttle, err := s.repo.FindThrottle(key)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	if throttle.LockedUntil == nil || !now.Before(*throttle.LockedUntil) {
+		return 0, false, nil
+	}
+
+	_, locked := policy.penalty(throttle.Failures)
+	return throttle.LockedUntil.Sub(now), locked, nil
+}
+
+// registerFailure suma el fallo y, si la política lo indica, penaliza la
+// clave hasta que pase la espera correspondiente.
+func (s *service) registerFailure(key string, policy LockoutPolicy, now time.Time) error {
+	throttle, err := s.repo.RegisterFailure(key, now, now.Add(-policy.Window))
+	if err != nil {
+		return err
+	}
+
+	wait, _ := policy.penalty(throttle.Failures)
+	if wait <= 0 {
+		return nil
+	}
+	return s.repo.Lock(key, now.Add(wait))
+}
+
+// rehashPassword recalcula con argon2id y los parámetros actuales el hash
+// de una contraseña que se acaba de comprobar, si se guardó con bcrypt o
+// con otros parámetros. Un fallo no impide iniciar sesión: se reintentará
+// en el siguiente.
+func (s *service) rehashPassword(u *user.User, plain string) {
+	if !utils.PasswordNeedsRehash(u.Password) {
+		return
+	}
+
+	hash, err := utils.HashPassword(plain)
+	if err != nil {
+		log.Printf("Error recalculando el hash de la contraseña de %s: %v", u.ID, err)
+		return
+	}
+
+	// ErrRecordNotFound: la contraseña cambió mientras tanto y el hash que
+	// hay ya es de la nueva.
+	err = s.userRepo.RehashPassword(u.ID, u.Password, hash)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("Error recalculando el hash de la contraseña de %s: %v", u.ID, err)
+	}
+}
+
+// recordAttempt guarda el intento para auditoría. Un fallo al guardarlo no
+// impide iniciar sesión.
+func (s *service) recordAttempt(attempt *LoginAttempt, result AttemptResult) {
+	attempt.ID = utils.GenerateID()
+	attempt.Result = result
+	attempt.CreatedAt = s.now().UTC()
+
+	if err := s.repo.CreateAttempt(attempt); err != nil {
+		log.Printf("Error registrando el intento de inicio de sesión de %s: %v", attempt.Email, err)
+	}
+}
+
+// accountThrottleKey es la clave con la que se cuentan los fallos de la
+// cuenta del email.
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,382 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"image-processing-service/internal/modules/user"
+	"image-processing-service/internal/shared/totp"
+	"image-processing-service/internal/shared/utils"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrTwoFactorAlreadyEnabled = utils.NewError(409, "TWO_FACTOR_ALREADY_ENABLED", "El segundo factor ya está activado", nil)
+	ErrTwoFactorNotPending     = utils.NewError(409, "TWO_FACTOR_NOT_PENDING", "No hay ninguna activación del segundo factor pendiente", nil)
+	ErrTwoFactorNotEnabled     = utils.NewError(409, "TWO_FACTOR_NOT_ENABLED", "El segundo factor no está activado", nil)
+	ErrInvalidTwoFactorCode    = utils.NewError(401, "INVALID_TWO_FACTOR_CODE", "El código no es válido", nil)
+	ErrInvalidChallenge        = utils.NewError(401, "INVALID_CHALLENGE", "El inicio de sesión ha caducado, vuelve a introducir la contraseña", nil)
+)
+
+const (
+	// challengeTTL es el tiempo para introducir el código tras la
+	// contraseña.
+	challengeTTL = 5 * time.Minute
+	// maxChallengeAttempts es cuántos códigos incorrectos admite un reto.
+	maxChallengeAttempts = 5
+	recoveryCodeCount    = 10
+	qrScale              = 6
+)
+
+// EnrollTwoFactor genera un secreto nuevo y lo deja pendiente de confirmar.
+// Repetirlo sustituye la inscripción pendiente.
+func (s *service) EnrollTwoFactor(userID string) (*TwoFactorEnrollment, error) {
+	existingUser, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	current, err := s.repo.FindTwoFactor(userID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if current != nil && current.ConfirmedAt != nil {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repo.SaveTwoFactor(&TwoFactor{
+		UserID:    userID,
+		Secret:    secret,
+		CreatedAt: s.now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	uri := totp.URI(s.config.TOTPIssuer, existingUser.Email, secret)
+	qr, err := totp.QRCode(uri, qrScale)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorEnrollment{
+		Secret: secret,
+		URI:    uri,
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(qr),
+	}, nil
+}
+
+// ConfirmTwoFactor activa el segundo factor si el código corresponde al
+// secreto pendiente y devuelve los códigos de recuperación.
+func (s *service) ConfirmTwoFactor(userID string, req ConfirmTwoFactorRequest) (*RecoveryCodesResponse, error) {
+	tf, err := s.repo.FindTwoFactor(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTwoFactorNotPending
+		}
+		return nil, err
+	}
+	if tf.ConfirmedAt != nil {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	now := s.now().UTC()
+	step, ok := totp.Validate(tf.Secret, req.Code, now, tf.LastStep)
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	plain, codes, err := s.newRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.ConfirmTwoFactor(userID, now, step, codes); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTwoFactorAlreadyEnabled
+		}
+		return nil, err
+	}
+
+	return &RecoveryCodesResponse{RecoveryCodes: plain}, nil
+}
+
+// DisableTwoFactor desactiva el segundo factor. Pide un código para que
+// una sesión robada no baste para quitarlo.
+func (s *service) DisableTwoFactor(userID string, req TwoFactorCodeRequest) error {
+	tf, err := s.enabledTwoFactor(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkSecondFactor(tf, req.Code); err != nil {
+		return err
+	}
+
+	return s.repo.DeleteTwoFactor(userID)
+}
+
+// RegenerateRecoveryCodes invalida los códigos de recuperación y devuelve
+// otros nuevos.
+func (s *service) RegenerateRecoveryCodes(userID string, req TwoFactorCodeRequest) (*RecoveryCodesResponse, error) {
+	tf, err := s.enabledTwoFactor(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkSecondFactor(tf, req.Code); err != nil {
+		return nil, err
+	}
+
+	plain, codes, err := s.newRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.ReplaceRecoveryCodes(userID, codes); err != nil {
+		return nil, err
+	}
+
+	return &RecoveryCodesResponse{RecoveryCodes: plain}, nil
+}
+
+func (s *service) TwoFactorStatus(userID string) (*TwoFactorStatus, error) {
+	tf, err := s.repo.FindTwoFactor(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &TwoFactorStatus{}, nil
+		}
+		return nil, err
+	}
+	if tf.ConfirmedAt == nil {
+		return &TwoFactorStatus{}, nil
+	}
+
+	left, err := s.repo.CountRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorStatus{
+		Enabled:           true,
+		EnabledAt:         tf.ConfirmedAt,
+		RecoveryCodesLeft: left,
+	}, nil
+}
+
+// VerifyTwoFactor completa el inicio de sesión del reto con un código de la
+// app o de recuperación. El reto se descarta al usarlo o tras
+// maxChallengeAttempts códigos. Cada código incorrecto cuenta además como
+// fallo de la cuenta, igual que una contraseña incorrecta, y con la cuenta
+// bloqueada no se acepta ninguno: abrir retos nuevos no da más intentos.
+func (s *service) VerifyTwoFactor(req VerifyTwoFactorRequest, client ClientInfo) (*Auth, error) {
+	now := s.now().UTC()
+
+	challenge, err := s.repo.FindChallenge(utils.GenerateSHA256(req.ChallengeToken), now)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidChallenge
+		}
+		return nil, err
+	}
+
+	existingUser, err := s.userRepo.GetByID(challenge.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidChallenge
+		}
+		return nil, err
+	}
+	accountKey := accountThrottleKey(existingUser.Email)
+
+	wait, locked, err := s.throttled(accountKey, s.lockout.Account, now)
+	if err != nil {
+		return nil, err
+	}
+	if locked {
+		return nil, errAccountLocked(wait)
+	}
+
+	tf, err := s.enabledTwoFactor(challenge.UserID)
+	if err != nil {
+		if errors.Is(err, ErrTwoFactorNotEnabled) {
+			return nil, ErrInvalidChallenge
+		}
+		return nil, err
+	}
+
+	attempts, err := s.repo.ClaimChallengeAttempt(challenge.ID, maxChallengeAttempts)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, s.discardChallenge(challenge.ID)
+		}
+		return nil, err
+	}
+
+	if err := s.checkSecondFactor(tf, req.Code); err != nil {
+		if !errors.Is(err, ErrInvalidTwoFactorCode) {
+			return nil, err
+		}
+		if err := s.registerFailure(accountKey, s.lockout.Account, now); err != nil {
+			return nil, err
+		}
+		if attempts >= maxChallengeAttempts {
+			return nil, s.discardChallenge(challenge.ID)
+		}
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	if err := s.repo.DeleteChallenge(challenge.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidChallenge
+		}
+		return nil, err
+	}
+
+	if err := s.repo.ResetThrottle(accountKey); err != nil {
+		return nil, err
+	}
+
+	return s.startSession(existingUser, client, challenge.DeviceLabel)
+}
+
+// discardChallenge borra el reto que agotó sus intentos y devuelve el error
+// con el que se rechaza.
+func (s *service) discardChallenge(id string) error {
+	if err := s.repo.DeleteChallenge(id); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return ErrInvalidChallenge
+}
+
+// challenge crea el reto que sustituye a los tokens cuando la cuenta tiene
+// el segundo factor activado.
+func (s *service) challenge(u *user.User, deviceLabel string) (*Auth, error) {
+	plain, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now().UTC()
+	err = s.repo.CreateChallenge(&LoginChallenge{
+		ID:          utils.GenerateID(),
+		UserID:      u.ID,
+		TokenHash:   utils.GenerateSHA256(plain),
+		DeviceLabel: deviceLabel,
+		ExpiresAt:   now.Add(challengeTTL),
+		CreatedAt:   now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Auth{TwoFactorRequired: true, ChallengeToken: plain}, nil
+}
+
+// twoFactorEnabled indica si el inicio de sesión del usuario necesita
+// segundo factor.
+func (s *service) twoFactorEnabled(userID string) (bool, error) {
+	_, err := s.enabledTwoFactor(userID)
+	if err != nil {
+		if errors.Is(err, ErrTwoFactorNotEnabled) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *service) enabledTwoFactor(userID string) (*TwoFactor, error) {
+	tf, err := s.repo.FindTwoFactor(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTwoFactorNotEnabled
+		}
+		return nil, err
+	}
+	if tf.ConfirmedAt == nil {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	return tf, nil
+}
+
+// checkSecondFactor acepta un código TOTP no usado antes o un código de
+// recuperación sin usar, que queda gastado.
+func (s *service) checkSecondFactor(tf *TwoFactor, code string) error {
+	now := s.now().UTC()
+	code = strings.TrimSpace(code)
+
+	if len(code) == totp.Digits {
+		step, ok := totp.Validate(tf.Secret, code, now, tf.LastStep)
+		if !ok {
+			return ErrInvalidTwoFactorCode
+		}
+		if err := s.repo.AdvanceTwoFactorStep(tf.UserID, step); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidTwoFactorCode
+			}
+			return err
+		}
+		return nil
+	}
+
+	err := s.repo.UseRecoveryCode(tf.UserID, utils.GenerateSHA256(normalizeRecoveryCode(code)), now)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidTwoFactorCode
+		}
+		return err
+	}
+	return nil
+}
+
+// newRecoveryCodes genera recoveryCodeCount códigos con la forma
+// xxxxx-xxxxx y devuelve sus valores en claro y los registros a guardar.
+func (s *service) newRecoveryCodes(userID string) ([]string, []RecoveryCode, error) {
+	now := s.now().UTC()
+	plain := make([]string, 0, recoveryCodeCount)
+	codes := make([]RecoveryCode, 0, recoveryCodeCount)
+
+	for range recoveryCodeCount {
+		raw, err := randomHex(5)
+		if err != nil {
+			return nil, nil, err
+		}
+		plain = append(plain, raw[:5]+"-"+raw[5:])
+		codes = append(codes, RecoveryCode{
+			ID:        utils.GenerateID(),
+			UserID:    userID,
+			CodeHash:  utils.GenerateSHA256(raw),
+			CreatedAt: now,
+		})
+	}
+
+	return plain, codes, nil
+}
+
+// normalizeRecoveryCode quita el guion y los espacios y pasa a minúsculas,
+// para aceptar el código como lo escriba el usuario.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func randomHex(n int) (string, error) {
+	raw := make([]byte, n)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
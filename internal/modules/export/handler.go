@@ -0,0 +1,47 @@
+package export
+
+import (
+	"log"
+	"net/http"
+
+	"image-processing-service/internal/shared/auth"
+	"image-processing-service/internal/shared/utils"
+)
+
+type Handler interface {
+	Export(w http.ResponseWriter, r *http.Request)
+}
+
+type handler struct {
+	service Service
+}
+
+func NewHandler(s Service) Handler {
+	return &handler{service: s}
+}
+
+func (h *handler) Export(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	if !utils.IsValidID(authUser.UserID) {
+		utils.HandleError(w, utils.ErrInvalidIDFormat)
+		return
+	}
+
+	archive, err := h.service.Prepare(authUser.UserID)
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="export.zip"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	// Con la respuesta empezada ya no se puede devolver un error: el
+	// cliente recibe un ZIP incompleto que no se abre.
+	if err := archive.Write(w); err != nil {
+		log.Printf("No se pudo completar la exportación del usuario %s: %v", authUser.UserID, err)
+	}
+}
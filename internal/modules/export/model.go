@@ -0,0 +1,62 @@
+package export
+
+import (
+	"time"
+
+	"image-processing-service/internal/modules/file"
+	"image-processing-service/internal/modules/user"
+	"image-processing-service/internal/shared/auth"
+)
+
+// Profile es el contenido de profile.json.
+type Profile struct {
+	ID              string     `json:"id"`
+	Name            string     `json:"name"`
+	Email           string     `json:"email"`
+	Role            auth.Role  `json:"role"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+func mapProfile(u *user.User) Profile {
+	return Profile{
+		ID:              u.ID,
+		Name:            u.Name,
+		Email:           u.Email,
+		Role:            u.Role,
+		EmailVerifiedAt: u.EmailVerifiedAt,
+		CreatedAt:       u.CreatedAt,
+		UpdatedAt:       u.UpdatedAt,
+	}
+}
+
+// FileMetadata es cada entrada de files.json. Path es la ruta del original
+// dentro del ZIP.
+type FileMetadata struct {
+	ID          string    `json:"id"`
+	FileName    string    `json:"file_name"`
+	MimeType    string    `json:"mime_type"`
+	Format      string    `json:"format"`
+	FileSize    int64     `json:"file_size"`
+	Width       int64     `json:"width"`
+	Height      int64     `json:"height"`
+	ContentHash string    `json:"content_hash"`
+	CreatedAt   time.Time `json:"created_at"`
+	Path        string    `json:"path"`
+}
+
+func mapFileMetadata(f *file.File, path string) FileMetadata {
+	return FileMetadata{
+		ID:          f.ID,
+		FileName:    f.FileName,
+		MimeType:    f.MimeType,
+		Format:      f.Format,
+		FileSize:    f.FileSize,
+		Width:       f.Width,
+		Height:      f.Height,
+		ContentHash: f.ContentHash,
+		CreatedAt:   f.CreatedAt,
+		Path:        path,
+	}
+}
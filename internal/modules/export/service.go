@@ -0,0 +1,145 @@
+// Package export genera la copia de los datos de una cuenta que el usuario
+// puede descargar: su perfil, los metadatos de sus archivos y los
+// originales.
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"image-processing-service/internal/modules/file"
+	"image-processing-service/internal/modules/user"
+)
+
+// UserReader lee el perfil del usuario. Lo implementa user.Service.
+type UserReader interface {
+	GetByID(id string) (*user.User, error)
+}
+
+// FileReader lista y lee los archivos del usuario. Lo implementa
+// file.Service.
+type FileReader interface {
+	ListByUserID(userID string) ([]file.File, error)
+	Stat(storageKey string, userID string) (*file.StoredObject, error)
+	Open(object *file.StoredObject, offset int64, length int64) (io.ReadCloser, error)
+}
+
+type Service interface {
+	// Prepare reúne todo lo que va en la exportación sin escribir nada, para
+	// que los errores se puedan devolver antes de empezar la respuesta.
+	Prepare(userID string) (*Archive, error)
+}
+
+type service struct {
+	users UserReader
+	files FileReader
+}
+
+func NewService(u UserReader, f FileReader) Service {
+	return &service{users: u, files: f}
+}
+
+// Archive es una exportación preparada. Write la escribe como ZIP con
+// profile.json, files.json y los originales en originals/.
+type Archive struct {
+	profile Profile
+	files   []FileMetadata
+	objects []*file.StoredObject
+	reader  FileReader
+}
+
+func (s *service) Prepare(userID string) (*Archive, error) {
+	u, err := s.users.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := s.files.ListByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &Archive{
+		profile: mapProfile(u),
+		files:   make([]FileMetadata, 0, len(files)),
+		objects: make([]*file.StoredObject, 0, len(files)),
+		reader:  s.files,
+	}
+	for i := range files {
+		object, err := s.files.Stat(files[i].StorageKey, userID)
+		if err != nil {
+			return nil, err
+		}
+		archive.files = append(archive.files, mapFileMetadata(&files[i], originalPath(&files[i])))
+		archive.objects = append(archive.objects, object)
+	}
+
+	return archive, nil
+}
+
+// originalPath antepone el ID al nombre para que dos archivos con el mismo
+// nombre no choquen, y se queda solo con la base para que un nombre con
+// barras no salga de originals/.
+func originalPath(f *file.File) string {
+	return fmt.Sprintf("originals/%s-%s", f.ID, path.Base(path.Clean("/"+f.FileName)))
+}
+
+func (a *Archive) Write(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	if err := writeJSON(zw, "profile.json", a.profile); err != nil {
+		return err
+	}
+	if err := writeJSON(zw, "files.json", a.files); err != nil {
+		return err
+	}
+
+	for i, object := range a.objects {
+		if err := a.writeOriginal(zw, a.files[i], object); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func writeJSON(zw *zip.Writer, name string, v interface{}) error {
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// writeOriginal guarda la imagen sin comprimir: los formatos de imagen ya
+// lo están y volver a comprimirlos solo gasta CPU.
+func (a *Archive) writeOriginal(zw *zip.Writer, meta FileMetadata, object *file.StoredObject) error {
+	content, err := a.reader.Open(object, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     meta.Path,
+		Method:   zip.Store,
+		Modified: meta.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(entry, content)
+	return err
+}
@@ -0,0 +1,79 @@
+package file
+
+// bkTree es un árbol BK sobre la distancia de Hamming entre hashes de 64
+// bits. Cada hijo cuelga de su padre según la distancia entre ambos, así que
+// la desigualdad triangular permite descartar ramas enteras en la búsqueda.
+type bkTree struct {
+	root *bkNode
+	size int
+}
+
+type bkNode struct {
+	hash     uint64
+	ids      []string
+	children map[int]*bkNode
+}
+
+type bkMatch struct {
+	id       string
+	distance int
+}
+
+func (t *bkTree) add(hash uint64, id string) {
+	t.size++
+
+	if t.root == nil {
+		t.root = &bkNode{hash: hash, ids: []string{id}}
+		return
+	}
+
+	node := t.root
+	for {
+		distance := hammingDistance(node.hash, hash)
+		if distance == 0 {
+			// Hashes idénticos comparten nodo.
+			node.ids = append(node.ids, id)
+			return
+		}
+
+		child, ok := node.children[distance]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[int]*bkNode)
+			}
+			node.children[distance] = &bkNode{hash: hash, ids: []string{id}}
+			return
+		}
+		node = child
+	}
+}
+
+// search devuelve todos los ids cuyo hash está a distancia <= threshold.
+func (t *bkTree) search(hash uint64, threshold int) []bkMatch {
+	if t.root == nil {
+		return nil
+	}
+
+	var matches []bkMatch
+	pending := []*bkNode{t.root}
+
+	for len(pending) > 0 {
+		node := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		distance := hammingDistance(node.hash, hash)
+		if distance <= threshold {
+			for _, id := range node.ids {
+				matches = append(matches, bkMatch{id: id, distance: distance})
+			}
+		}
+
+		for childDistance, child := range node.children {
+			if childDistance >= distance-threshold && childDistance <= distance+threshold {
+				pending = append(pending, child)
+			}
+		}
+	}
+
+	return matches
+}
@@ -0,0 +1,92 @@
+package file_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"image-processing-service/internal/modules/file"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flip cambia los n bits más bajos de hash.
+func flip(hash uint64, n int) uint64 {
+	return hash ^ (1<<uint(n) - 1)
+}
+
+func TestBKTree_Search(t *testing.T) {
+	const base = uint64(0xF0F0_1234_ABCD_0F0F)
+	const threshold = 10
+
+	tests := []struct {
+		name     string
+		distance int
+		found    bool
+	}{
+		{name: "Debe encontrar un hash idéntico", distance: 0, found: true},
+		{name: "Debe encontrar un hash justo en el umbral", distance: threshold, found: true},
+		{name: "Debe descartar un hash a un bit más del umbral", distance: threshold + 1, found: false},
+		{name: "Debe descartar un hash muy distinto", distance: 64, found: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// GIVEN
+			var tree file.BKTree
+			tree.Add(base, "base")
+
+			// WHEN
+			found := tree.Search(flip(base, tt.distance), threshold)
+
+			// THEN
+			distance, ok := found["base"]
+			assert.Equal(t, tt.found, ok)
+			if tt.found {
+				assert.Equal(t, tt.distance, distance)
+			}
+		})
+	}
+
+	t.Run("Debe agrupar los ids con el mismo hash", func(t *testing.T) {
+		// GIVEN
+		var tree file.BKTree
+		tree.Add(base, "a")
+		tree.Add(base, "b")
+
+		// WHEN
+		found := tree.Search(base, 0)
+
+		// THEN
+		assert.Equal(t, map[string]int{"a": 0, "b": 0}, found)
+	})
+
+	t.Run("Debe encontrar lo mismo que una búsqueda lineal", func(t *testing.T) {
+		// GIVEN: la poda por desigualdad triangular no debe perder resultados
+		rng := rand.New(rand.NewSource(1))
+		var tree file.BKTree
+		hashes := make(map[string]uint64)
+		for i := range 2000 {
+			// Variaciones de pocos bits sobre unos pocos hashes, como las
+			// imágenes parecidas de un mismo usuario.
+			hash := flip(base, i%7) ^ uint64(rng.Int63n(1<<12))<<uint(rng.Intn(52))
+			id := fmt.Sprintf("f%d", i)
+			hashes[id] = hash
+			tree.Add(hash, id)
+		}
+		target := flip(base, 3)
+
+		// WHEN
+		found := tree.Search(target, threshold)
+
+		// THEN
+		expected := make(map[string]int)
+		for id, hash := range hashes {
+			if d := file.HammingDistance(hash, target); d <= threshold {
+				expected[id] = d
+			}
+		}
+		assert.NotEmpty(t, expected)
+		assert.Equal(t, expected, found)
+	})
+}
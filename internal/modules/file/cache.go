@@ -0,0 +1,139 @@
+package file
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CachePolicy define el valor de Cache-Control con el que se sirven los
+// originales y las derivadas (miniaturas, transformaciones).
+type CachePolicy struct {
+	Originals   string
+	Derivatives string
+}
+
+func (p CachePolicy) headerFor(object *StoredObject) string {
+	if object.Derivative {
+		return p.Derivatives
+	}
+	return p.Originals
+}
+
+var errRangeNotSatisfiable = errors.New("rango no satisfacible")
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (br byteRange) contentRange(size int64) string {
+	return "bytes " + strconv.FormatInt(br.start, 10) + "-" + strconv.FormatInt(br.start+br.length-1, 10) + "/" + strconv.FormatInt(size, 10)
+}
+
+// etagFor devuelve un ETag fuerte basado en el hash del contenido, o vacío
+// para archivos subidos antes de que se guardara el hash.
+func etagFor(object *StoredObject) string {
+	if object.ContentHash == "" {
+		return ""
+	}
+	return `"` + object.ContentHash + `"`
+}
+
+// isNotModified aplica las precondiciones de RFC 9110: If-None-Match tiene
+// prioridad y, si está presente, If-Modified-Since se ignora.
+func isNotModified(r *http.Request, object *StoredObject) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := etagFor(object)
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		return !object.ModifiedAt.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
+
+// requestedRange interpreta la cabecera Range. Devuelve nil si debe servirse
+// el objeto completo: sin cabecera, tamaño desconocido, varios rangos o un
+// If-Range que ya no coincide con la versión actual.
+func requestedRange(r *http.Request, object *StoredObject) (*byteRange, error) {
+	header := r.Header.Get("Range")
+	if header == "" || object.Size <= 0 {
+		return nil, nil
+	}
+
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && !ifRangeMatches(ifRange, object) {
+		return nil, nil
+	}
+
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, errRangeNotSatisfiable
+	}
+
+	size := object.Size
+
+	if startStr == "" {
+		suffix, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffix <= 0 {
+			return nil, errRangeNotSatisfiable
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return &byteRange{start: size - suffix, length: suffix}, nil
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return nil, errRangeNotSatisfiable
+	}
+
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return nil, errRangeNotSatisfiable
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+
+	return &byteRange{start: start, length: end - start + 1}, nil
+}
+
+func ifRangeMatches(ifRange string, object *StoredObject) bool {
+	if strings.HasPrefix(ifRange, `"`) {
+		etag := etagFor(object)
+		return etag != "" && ifRange == etag
+	}
+
+	since, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	return object.ModifiedAt.Truncate(time.Second).Equal(since)
+}
@@ -0,0 +1,55 @@
+package file
+
+import "image"
+
+// Expone a los tests de file_test el árbol BK y los hashes perceptuales, que
+// no forman parte de la API del paquete.
+
+func HammingDistance(a, b uint64) int { return hammingDistance(a, b) }
+
+func AverageHash(img image.Image) uint64    { return averageHash(img) }
+func DifferenceHash(img image.Image) uint64 { return differenceHash(img) }
+func DCTHash(img image.Image) uint64        { return dctHash(img) }
+
+type BKTree struct {
+	tree bkTree
+}
+
+func (t *BKTree) Add(hash uint64, id string) {
+	t.tree.add(hash, id)
+}
+
+// Search devuelve la distancia de cada id encontrado.
+func (t *BKTree) Search(hash uint64, threshold int) map[string]int {
+	found := make(map[string]int)
+	for _, m := range t.tree.search(hash, threshold) {
+		found[m.id] = m.distance
+	}
+	return found
+}
+
+type SimilarityIndex struct {
+	index *similarityIndex
+}
+
+func NewSimilarityIndex(r Repository) *SimilarityIndex {
+	return &SimilarityIndex{index: newSimilarityIndex(r)}
+}
+
+func (i *SimilarityIndex) Add(userID, fileID string, pHash int64) {
+	i.index.add(userID, fileID, &pHash)
+}
+
+func (i *SimilarityIndex) Invalidate(userID string) {
+	i.index.invalidate(userID)
+}
+
+// Search devuelve los ids encontrados.
+func (i *SimilarityIndex) Search(userID string, hash uint64, threshold int) ([]string, error) {
+	matches, err := i.index.search(userID, hash, threshold)
+	ids := make([]string, 0, len(matches))
+	for _, m := range matches {
+		ids = append(ids, m.id)
+	}
+	return ids, err
+}
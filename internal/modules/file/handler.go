@@ -2,6 +2,7 @@ package file
 
 import (
 	"errors"
+	"fmt"
 	"image"
 	"image-processing-service/internal/shared/auth"
 	"image-processing-service/internal/shared/utils"
@@ -12,6 +13,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -24,10 +26,13 @@ type Handler interface {
 	Upload(w http.ResponseWriter, r *http.Request)
 	GetOne(w http.ResponseWriter, r *http.Request)
 	ListMine(w http.ResponseWriter, r *http.Request)
+	Delete(w http.ResponseWriter, r *http.Request)
+	Similar(w http.ResponseWriter, r *http.Request)
 }
 
 type handler struct {
-	service Service
+	service     Service
+	cachePolicy CachePolicy
 }
 
 var (
@@ -36,6 +41,8 @@ var (
 	ErrInvalidFileType = utils.NewError(415, "UNSUPPORTED_FILE_TYPE", "El tipo de archivo no está permitido (solo JPG, PNG, GIF o WEBP)", nil)
 	ErrFileRead        = utils.NewError(500, "FILE_READ_ERROR", "Error al procesar el archivo en el servidor", nil)
 	ErrStorageUpload   = utils.NewError(502, "STORAGE_UPLOAD_FAILED", "No se pudo subir el archivo al almacenamiento remoto", nil)
+	ErrStorageRead     = utils.NewError(502, "STORAGE_READ_FAILED", "No se pudo leer el archivo del almacenamiento remoto", nil)
+	ErrRangeInvalid    = utils.NewError(416, "RANGE_NOT_SATISFIABLE", "El rango solicitado no es válido para este archivo", nil)
 	ErrUnauthorized    = utils.NewError(401, "UNAUTHORIZED", "Debes iniciar sesión para subir imágenes", nil)
 )
 
@@ -51,11 +58,21 @@ type uploadFileResponse struct {
 	URL          string `json:"url"`
 	ThumbnailURL string `json:"thumbnailUrl"`
 	UserID       string `json:"userId,omitempty"`
-	CreatedAt    string `json:"createdAt"`
+	// Status es "processing" mientras se generan la miniatura y los hashes,
+	// "ready" después y "failed" si el contenido no se pudo procesar. JobID
+	// es el trabajo que lo hace, solo en la subida.
+	Status    string `json:"status"`
+	JobID     string `json:"jobId,omitempty"`
+	CreatedAt string `json:"createdAt"`
 }
 
-func NewHandler(s Service) Handler {
-	return &handler{service: s}
+type similarFileResponse struct {
+	uploadFileResponse
+	Distance int `json:"distance"`
+}
+
+func NewHandler(s Service, cachePolicy CachePolicy) Handler {
+	return &handler{service: s, cachePolicy: cachePolicy}
 }
 
 func (h *handler) Upload(w http.ResponseWriter, r *http.Request) {
@@ -147,6 +164,11 @@ func (h *handler) Upload(w http.ResponseWriter, r *http.Request) {
 
 	uploadedFile, err := h.service.Upload(file, req)
 	if err != nil {
+		var appErr *utils.AppError
+		if errors.As(err, &appErr) {
+			utils.HandleError(w, appErr)
+			return
+		}
 		utils.HandleError(w, ErrStorageUpload)
 		return
 	}
@@ -172,15 +194,65 @@ func (h *handler) GetOne(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fileMetadata, file, err := h.service.GetFile(decodedStorageKey, authUser.UserID)
+	object, err := h.service.Stat(decodedStorageKey, authUser.UserID)
 	if err != nil {
 		utils.HandleError(w, err)
 		return
 	}
-	defer file.Close()
 
-	w.Header().Set("Content-Type", fileMetadata.MimeType)
+	header := w.Header()
+	header.Set("Cache-Control", h.cachePolicy.headerFor(object))
+	header.Set("Last-Modified", object.ModifiedAt.UTC().Format(http.TimeFormat))
+	if etag := etagFor(object); etag != "" {
+		header.Set("ETag", etag)
+	}
+	if object.Size > 0 {
+		header.Set("Accept-Ranges", "bytes")
+	}
+
+	if isNotModified(r, object) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	byteRange, err := requestedRange(r, object)
+	if err != nil {
+		header.Set("Content-Range", "bytes */"+strconv.FormatInt(object.Size, 10))
+		utils.HandleError(w, ErrRangeInvalid)
+		return
+	}
+
+	status := http.StatusOK
+	offset, length := int64(0), object.Size
+	if byteRange != nil {
+		status = http.StatusPartialContent
+		offset, length = byteRange.start, byteRange.length
+		header.Set("Content-Range", byteRange.contentRange(object.Size))
+	}
+
+	header.Set("Content-Type", object.MimeType)
+	if length > 0 {
+		header.Set("Content-Length", strconv.FormatInt(length, 10))
+	}
 
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+
+	file, err := h.service.Open(object, offset, length)
+	if err != nil {
+		// Las cabeceras de caché y de longitud describen la imagen, no el
+		// cuerpo de error que se va a enviar.
+		for _, name := range []string{"Cache-Control", "Last-Modified", "ETag", "Accept-Ranges", "Content-Range", "Content-Length"} {
+			header.Del(name)
+		}
+		utils.HandleError(w, err)
+		return
+	}
+	defer file.Close()
+
+	w.WriteHeader(status)
 	io.Copy(w, file)
 }
 
@@ -210,6 +282,72 @@ func (h *handler) ListMine(w http.ResponseWriter, r *http.Request) {
 	utils.Success(w, http.StatusOK, response)
 }
 
+func (h *handler) Delete(w http.ResponseWriter, r *http.Request) {
+	authUser, ok := auth.GetAuthUser(r.Context())
+	if !ok || authUser.UserID == "" {
+		utils.HandleError(w, ErrUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if !utils.IsValidID(id) {
+		utils.HandleError(w, utils.ErrInvalidIDFormat)
+		return
+	}
+
+	if err := h.service.Delete(id, authUser.UserID); err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, map[string]string{"message": "Archivo eliminado correctamente"})
+}
+
+func (h *handler) Similar(w http.ResponseWriter, r *http.Request) {
+	const defaultThreshold = 10
+	const maxThreshold = 32
+
+	authUser, ok := auth.GetAuthUser(r.Context())
+	if !ok || authUser.UserID == "" {
+		utils.HandleError(w, ErrUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if !utils.IsValidID(id) {
+		utils.HandleError(w, utils.ErrInvalidIDFormat)
+		return
+	}
+
+	threshold := defaultThreshold
+	if thresholdStr := r.URL.Query().Get("threshold"); thresholdStr != "" {
+		t, err := strconv.Atoi(thresholdStr)
+		if err != nil || t < 0 || t > maxThreshold {
+			utils.HandleError(w, utils.ValidationError(map[string]string{
+				"threshold": fmt.Sprintf("Debe ser un entero entre 0 y %d", maxThreshold),
+			}))
+			return
+		}
+		threshold = t
+	}
+
+	similar, err := h.service.FindSimilar(id, authUser.UserID, threshold)
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	response := make([]similarFileResponse, 0, len(similar))
+	for i := range similar {
+		response = append(response, similarFileResponse{
+			uploadFileResponse: mapUploadFileResponse(&similar[i].File, r),
+			Distance:           similar[i].Distance,
+		})
+	}
+
+	utils.Success(w, http.StatusOK, response)
+}
+
 func mapUploadFileResponse(file *File, r *http.Request) uploadFileResponse {
 	fileURL := buildFileURL(r, file.StorageKey)
 	thumbnailURL := ""
@@ -217,6 +355,13 @@ func mapUploadFileResponse(file *File, r *http.Request) uploadFileResponse {
 		thumbnailURL = buildFileURL(r, file.ThumbnailStorageKey)
 	}
 
+	status := "ready"
+	if file.processing() {
+		status = "processing"
+	} else if file.ProcessingFailed {
+		status = "failed"
+	}
+
 	return uploadFileResponse{
 		ID:           file.ID,
 		OriginalName: file.FileName,
@@ -229,6 +374,8 @@ func mapUploadFileResponse(file *File, r *http.Request) uploadFileResponse {
 		URL:          fileURL,
 		ThumbnailURL: thumbnailURL,
 		UserID:       file.UserID,
+		Status:       status,
+		JobID:        file.ProcessingJobID,
 		CreatedAt:    file.CreatedAt.UTC().Format(time.RFC3339),
 	}
 }
@@ -44,3 +44,32 @@ func (l *localStorage) Get(storageKey string) (io.ReadCloser, error) {
 
 	return file, nil
 }
+
+func (l *localStorage) GetRange(storageKey string, offset int64, length int64) (io.ReadCloser, error) {
+	fullPath := filepath.Join(l.uploadDir, filepath.FromSlash(storageKey))
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
+func (l *localStorage) Delete(storageKey string) error {
+	fullPath := filepath.Join(l.uploadDir, filepath.FromSlash(storageKey))
+
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
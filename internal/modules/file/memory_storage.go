@@ -0,0 +1,83 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+type memoryObject struct {
+	content     []byte
+	contentType string
+}
+
+type memoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+// NewMemoryStorage crea un StorageProvider en memoria, seguro para uso
+// concurrente. Pensado para pruebas y desarrollo local sin MinIO.
+func NewMemoryStorage() StorageProvider {
+	return &memoryStorage{objects: make(map[string]memoryObject)}
+}
+
+func (m *memoryStorage) Save(content io.Reader, objectKey string, contentType string) (string, error) {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.objects[objectKey] = memoryObject{content: data, contentType: contentType}
+
+	return objectKey, nil
+}
+
+func (m *memoryStorage) Get(storageKey string) (io.ReadCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	object, ok := m.objects[storageKey]
+	if !ok {
+		return nil, fmt.Errorf("objeto no encontrado: %s", storageKey)
+	}
+
+	// Se devuelve un lector sobre el slice guardado; Save siempre reemplaza
+	// el slice completo, por lo que no hay escrituras concurrentes sobre él.
+	return io.NopCloser(bytes.NewReader(object.content)), nil
+}
+
+func (m *memoryStorage) GetRange(storageKey string, offset int64, length int64) (io.ReadCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	object, ok := m.objects[storageKey]
+	if !ok {
+		return nil, fmt.Errorf("objeto no encontrado: %s", storageKey)
+	}
+
+	size := int64(len(object.content))
+	if offset < 0 || offset >= size || length <= 0 {
+		return nil, fmt.Errorf("rango fuera del objeto: %s", storageKey)
+	}
+
+	end := offset + length
+	if end > size {
+		end = size
+	}
+
+	return io.NopCloser(bytes.NewReader(object.content[offset:end])), nil
+}
+
+func (m *memoryStorage) Delete(storageKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.objects, storageKey)
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package file_test
+
+import (
+	"bytes"
+	"fmt"
+	"image-processing-service/internal/modules/file"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ─────────────────────────────────────────────────────────────────────────────
+// MemoryStorage
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestMemoryStorage_SaveAndGet(t *testing.T) {
+	t.Run("Debe devolver el mismo contenido que se guardó", func(t *testing.T) {
+		// GIVEN
+		storage := file.NewMemoryStorage()
+		_, err := storage.Save(bytes.NewReader([]byte("contenido")), "u/images/a.png", "image/png")
+		require.NoError(t, err)
+
+		// WHEN
+		reader, err := storage.Get("u/images/a.png")
+
+		// THEN
+		require.NoError(t, err)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "contenido", string(data))
+	})
+
+	t.Run("Debe retornar error cuando la clave no existe", func(t *testing.T) {
+		// GIVEN
+		storage := file.NewMemoryStorage()
+
+		// WHEN
+		reader, err := storage.Get("no-existe")
+
+		// THEN
+		assert.Error(t, err)
+		assert.Nil(t, reader)
+	})
+
+	t.Run("Debe soportar escrituras y lecturas concurrentes", func(t *testing.T) {
+		// GIVEN
+		storage := file.NewMemoryStorage()
+		var wg sync.WaitGroup
+
+		// WHEN: 50 goroutines guardan y leen su propia clave a la vez
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				key := fmt.Sprintf("u/images/%d.png", i)
+				storage.Save(bytes.NewReader([]byte(key)), key, "image/png")
+				storage.Get(key)
+			}(i)
+		}
+		wg.Wait()
+
+		// THEN: todas las claves quedan guardadas con su contenido
+		for i := 0; i < 50; i++ {
+			key := fmt.Sprintf("u/images/%d.png", i)
+			reader, err := storage.Get(key)
+			require.NoError(t, err)
+			data, _ := io.ReadAll(reader)
+			assert.Equal(t, key, string(data))
+		}
+	})
+}
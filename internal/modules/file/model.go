@@ -1,6 +1,10 @@
 package file
 
-import "time"
+import (
+	"time"
+
+	"image-processing-service/internal/shared/events"
+)
 
 type File struct {
 	ID                  string    `gorm:"primaryKey;size=24" json:"id"`
@@ -9,11 +13,74 @@ type File struct {
 	ThumbnailStorageKey string    `gorm:"uniqueIndex" json:"thumbnail_storage_key"`
 	MimeType            string    `gorm:"not null" json:"mime_type"`
 	FileSize            int64     `gorm:"not null" json:"file_size"`
+	ContentHash         string    `json:"content_hash"`
+	ThumbnailSize       int64     `json:"thumbnail_size"`
+	ThumbnailHash       string    `json:"thumbnail_hash"`
+	BlobID              string    `gorm:"index" json:"blob_id"`
 	UserID              string    `gorm:"index" json:"user_id"`
 	Format              string    `gorm:"not null" json:"format"`
 	Width               int64     `gorm:"not null" json:"width"`
 	Height              int64     `gorm:"not null" json:"height"`
 	CreatedAt           time.Time `json:"created_at"`
+	PerceptualHashes    `gorm:"embedded"`
+	// ProcessingFailed indica que el contenido no se pudo procesar: el
+	// archivo no tendrá miniatura ni hashes.
+	ProcessingFailed bool `gorm:"not null;default:false" json:"processing_failed"`
+	// ProcessingJobID es el trabajo que genera la miniatura y los hashes de
+	// una subida recién aceptada. No se persiste.
+	ProcessingJobID string `gorm:"-" json:"-"`
+}
+
+// Blob es el objeto físico (original y miniatura) compartido por todos los
+// File con el mismo contenido dentro de un Scope. RefCount cuenta cuántos
+// File lo referencian; el objeto se borra del storage al llegar a cero.
+// ThumbnailObjectKey está vacío hasta que el trabajo de procesamiento genera
+// la miniatura. Un blob con ProcessingFailed no se reutiliza: sale del índice
+// único para que una nueva subida del mismo contenido cree otro.
+type Blob struct {
+	ID                 string    `gorm:"primaryKey;size=24" json:"id"`
+	Scope              string    `gorm:"not null;uniqueIndex:idx_blobs_scope_content_hash,where:processing_failed = false" json:"scope"`
+	ContentHash        string    `gorm:"not null;uniqueIndex:idx_blobs_scope_content_hash,where:processing_failed = false" json:"content_hash"`
+	ObjectKey          string    `gorm:"not null" json:"object_key"`
+	ThumbnailObjectKey string    `gorm:"not null" json:"thumbnail_object_key"`
+	ThumbnailSize      int64     `gorm:"not null" json:"thumbnail_size"`
+	ThumbnailHash      string    `gorm:"not null" json:"thumbnail_hash"`
+	RefCount           int64     `gorm:"not null;default:0" json:"ref_count"`
+	ProcessingFailed   bool      `gorm:"not null;default:false" json:"processing_failed"`
+	CreatedAt          time.Time `json:"created_at"`
+	PerceptualHashes   `gorm:"embedded"`
+}
+
+// processing indica que la miniatura y los hashes del archivo todavía no se
+// han generado. Los archivos anteriores a los blobs se procesaban al subir.
+func (f *File) processing() bool {
+	return f.BlobID != "" && f.ThumbnailHash == "" && !f.ProcessingFailed
+}
+
+// attachBlob copia en el File los datos que dependen del contenido y que ya
+// están calculados en el blob compartido.
+func (f *File) attachBlob(blob *Blob) {
+	f.BlobID = blob.ID
+	f.ThumbnailSize = blob.ThumbnailSize
+	f.ThumbnailHash = blob.ThumbnailHash
+	f.PerceptualHashes = blob.PerceptualHashes
+}
+
+// PerceptualHashes son huellas de 64 bits del contenido visual de la imagen:
+// dos imágenes parecidas (redimensionadas, recomprimidas) quedan a poca
+// distancia de Hamming. Se guardan como int64 porque Postgres no tiene
+// enteros sin signo; son nil en archivos subidos antes de calcularlos.
+type PerceptualHashes struct {
+	AHash *int64 `json:"a_hash"`
+	DHash *int64 `json:"d_hash"`
+	PHash *int64 `json:"p_hash"`
+}
+
+// SimilarFile es un archivo del usuario parecido a otro, junto con la
+// distancia de Hamming entre sus pHash.
+type SimilarFile struct {
+	File     File
+	Distance int
 }
 
 type FileUploadRequest struct {
@@ -25,3 +92,46 @@ type FileUploadRequest struct {
 	Width    int64
 	Height   int64
 }
+
+// StoredObject describe el objeto que se sirve para una clave de
+// almacenamiento: el original de un File o una de sus derivadas.
+type StoredObject struct {
+	File        *File
+	StorageKey  string
+	MimeType    string
+	Size        int64
+	ContentHash string
+	Derivative  bool
+	ModifiedAt  time.Time
+}
+
+// FileEventData es el contenido de los eventos file.uploaded y file.deleted.
+type FileEventData struct {
+	FileID     string `json:"file_id"`
+	FileName   string `json:"file_name"`
+	StorageKey string `json:"storage_key"`
+	MimeType   string `json:"mime_type"`
+	Size       int64  `json:"size"`
+	Processing bool   `json:"processing"`
+	JobID      string `json:"job_id,omitempty"`
+}
+
+// uploadedEvent crea el evento file.uploaded. processing se indica aparte
+// porque el evento se construye antes de enlazar el File con su blob.
+func uploadedEvent(f *File, processing bool) events.Event {
+	data := newFileEventData(f)
+	data.Processing = processing
+	return events.New(events.FileUploaded, f.UserID, data)
+}
+
+func newFileEventData(f *File) FileEventData {
+	return FileEventData{
+		FileID:     f.ID,
+		FileName:   f.FileName,
+		StorageKey: f.StorageKey,
+		MimeType:   f.MimeType,
+		Size:       f.FileSize,
+		Processing: f.processing(),
+		JobID:      f.ProcessingJobID,
+	}
+}
@@ -0,0 +1,159 @@
+package file
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// computePerceptualHashes calcula aHash, dHash y pHash de la imagen. Se
+// llama con la miniatura ya decodificada: reducirla a 32x32 es barato y el
+// resultado es el mismo que partiendo del original.
+func computePerceptualHashes(img image.Image) PerceptualHashes {
+	aHash := int64(averageHash(img))
+	dHash := int64(differenceHash(img))
+	pHash := int64(dctHash(img))
+
+	return PerceptualHashes{AHash: &aHash, DHash: &dHash, PHash: &pHash}
+}
+
+// hammingDistance cuenta los bits distintos entre dos hashes.
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// grayscale reduce la imagen a width x height en escala de grises.
+func grayscale(img image.Image, width, height int) *image.Gray {
+	gray := image.NewGray(image.Rect(0, 0, width, height))
+	xdraw.ApproxBiLinear.Scale(gray, gray.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+	return gray
+}
+
+// averageHash marca con 1 los píxeles de una reducción 8x8 más claros que
+// la media.
+func averageHash(img image.Image) uint64 {
+	gray := grayscale(img, 8, 8)
+
+	var sum int
+	for _, p := range gray.Pix {
+		sum += int(p)
+	}
+	mean := sum / len(gray.Pix)
+
+	var hash uint64
+	for i, p := range gray.Pix {
+		if int(p) > mean {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// differenceHash compara cada píxel con su vecino derecho en una reducción
+// 9x8, capturando el sentido de los gradientes horizontales.
+func differenceHash(img image.Image) uint64 {
+	gray := grayscale(img, 9, 8)
+
+	var hash uint64
+	bit := 0
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if gray.GrayAt(x, y).Y < gray.GrayAt(x+1, y).Y {
+				hash |= 1 << uint(bit)
+			}
+			bit++
+		}
+	}
+	return hash
+}
+
+// dctHash es el pHash clásico: DCT de una reducción 32x32 y comparación de
+// las 8x8 frecuencias más bajas con su mediana (sin el término DC).
+func dctHash(img image.Image) uint64 {
+	const size = 32
+	const low = 8
+
+	gray := grayscale(img, size, size)
+
+	pixels := make([][]float64, size)
+	for y := 0; y < size; y++ {
+		pixels[y] = make([]float64, size)
+		for x := 0; x < size; x++ {
+			pixels[y][x] = float64(gray.GrayAt(x, y).Y)
+		}
+	}
+
+	coefficients := dct2D(pixels, low)
+
+	values := make([]float64, 0, low*low-1)
+	for y := 0; y < low; y++ {
+		for x := 0; x < low; x++ {
+			if x == 0 && y == 0 {
+				continue
+			}
+			values = append(values, coefficients[y][x])
+		}
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	// Son 63 valores: la mediana es el central.
+	median := sorted[len(sorted)/2]
+
+	// El bit 0, el del término DC, queda siempre a cero: solo mide el brillo
+	// medio.
+	var hash uint64
+	for y := 0; y < low; y++ {
+		for x := 0; x < low; x++ {
+			if x == 0 && y == 0 {
+				continue
+			}
+			if coefficients[y][x] > median {
+				hash |= 1 << uint(y*low+x)
+			}
+		}
+	}
+	return hash
+}
+
+// dct2D calcula solo los primeros keep x keep coeficientes de la DCT-II de
+// una matriz cuadrada, que son los únicos que usa el pHash.
+func dct2D(pixels [][]float64, keep int) [][]float64 {
+	n := len(pixels)
+
+	cosines := make([][]float64, keep)
+	for u := 0; u < keep; u++ {
+		cosines[u] = make([]float64, n)
+		for x := 0; x < n; x++ {
+			cosines[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / float64(2*n))
+		}
+	}
+
+	rows := make([][]float64, n)
+	for y := 0; y < n; y++ {
+		rows[y] = make([]float64, keep)
+		for u := 0; u < keep; u++ {
+			var sum float64
+			for x := 0; x < n; x++ {
+				sum += pixels[y][x] * cosines[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+
+	result := make([][]float64, keep)
+	for v := 0; v < keep; v++ {
+		result[v] = make([]float64, keep)
+		for u := 0; u < keep; u++ {
+			var sum float64
+			for y := 0; y < n; y++ {
+				sum += rows[y][u] * cosines[v][y]
+			}
+			result[v][u] = sum
+		}
+	}
+
+	return result
+}
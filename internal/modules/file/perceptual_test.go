@@ -0,0 +1,129 @@
+package file_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"image-processing-service/internal/modules/file"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	xdraw "golang.org/x/image/draw"
+)
+
+// similarityThreshold es el umbral por defecto de GET /files/{id}/similar.
+const similarityThreshold = 10
+
+// scene dibuja un degradado con un círculo claro y un rectángulo oscuro,
+// con suficiente estructura para que los hashes no sean triviales.
+func scene(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x)/float64(width), float64(y)/float64(height)
+
+			v := uint8(60 + 120*fx)
+			if (fx-0.3)*(fx-0.3)+(fy-0.4)*(fy-0.4) < 0.04 {
+				v = 240
+			}
+			if fx > 0.6 && fx < 0.9 && fy > 0.55 && fy < 0.85 {
+				v = 20
+			}
+
+			img.Set(x, y, color.RGBA{R: v, G: v / 2, B: 255 - v, A: 255})
+		}
+	}
+	return img
+}
+
+// checkerboard es visualmente muy distinto de scene.
+func checkerboard(size int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	cell := size / 8
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if (x/cell+y/cell)%2 == 0 {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return img
+}
+
+func resize(img image.Image, width, height int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+	return dst
+}
+
+func reencodeJPEG(t *testing.T, img image.Image, quality int) image.Image {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}))
+	decoded, err := jpeg.Decode(&buf)
+	require.NoError(t, err)
+	return decoded
+}
+
+func TestPerceptualHashes(t *testing.T) {
+	hashes := []struct {
+		name string
+		hash func(image.Image) uint64
+	}{
+		{name: "aHash", hash: file.AverageHash},
+		{name: "dHash", hash: file.DifferenceHash},
+		{name: "pHash", hash: file.DCTHash},
+	}
+
+	original := scene(400, 300)
+
+	for _, h := range hashes {
+		t.Run("Debe mantener "+h.name+" dentro del umbral al redimensionar y recomprimir", func(t *testing.T) {
+			// GIVEN
+			copies := map[string]image.Image{
+				"reducida":    resize(original, 200, 150),
+				"ampliada":    resize(original, 800, 600),
+				"JPEG al 60%": reencodeJPEG(t, original, 60),
+			}
+
+			for name, img := range copies {
+				// WHEN
+				distance := file.HammingDistance(h.hash(original), h.hash(img))
+
+				// THEN
+				assert.LessOrEqual(t, distance, similarityThreshold, name)
+			}
+		})
+
+		t.Run("Debe separar con "+h.name+" una imagen distinta", func(t *testing.T) {
+			// WHEN
+			distance := file.HammingDistance(h.hash(original), h.hash(checkerboard(400)))
+
+			// THEN
+			assert.Greater(t, distance, similarityThreshold)
+		})
+	}
+
+	t.Run("Debe dejar a cero el bit del término DC en pHash", func(t *testing.T) {
+		// GIVEN: el brillo medio no debe influir en el hash
+		for _, img := range []image.Image{original, checkerboard(256), image.NewGray(image.Rect(0, 0, 64, 64))} {
+			// WHEN
+			hash := file.DCTHash(img)
+
+			// THEN
+			assert.Zero(t, hash&1)
+		}
+	})
+
+	t.Run("Debe marcar la mitad de los coeficientes AC en pHash", func(t *testing.T) {
+		// WHEN: con la mediana de los 63 coeficientes, 31 quedan por encima
+		hash := file.DCTHash(original)
+
+		// THEN
+		assert.Equal(t, 31, file.HammingDistance(hash, 0))
+	})
+}
@@ -0,0 +1,101 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+
+	"image-processing-service/internal/modules/job"
+	"image-processing-service/internal/shared/utils"
+
+	"gorm.io/gorm"
+)
+
+// JobProcessBlob genera la miniatura y los hashes perceptuales de un blob
+// recién subido, fuera de la petición HTTP.
+const JobProcessBlob = "file.process"
+
+type processBlobPayload struct {
+	BlobID       string `json:"blob_id"`
+	ThumbnailKey string `json:"thumbnail_key"`
+}
+
+// ProcessBlob es el handler del trabajo JobProcessBlob. Es idempotente: si el
+// blob ya está procesado, falló o se eliminó mientras esperaba, no hace nada.
+func (s *service) ProcessBlob(ctx context.Context, j *job.Job, progress job.ProgressFunc) (*job.Result, error) {
+	var payload processBlobPayload
+	if err := json.Unmarshal([]byte(j.Payload), &payload); err != nil {
+		return nil, job.Permanent(err)
+	}
+
+	blob, err := s.repo.FindBlobByID(payload.BlobID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &job.Result{}, nil
+		}
+		return nil, err
+	}
+	if blob.ThumbnailObjectKey != "" || blob.ProcessingFailed {
+		return &job.Result{}, nil
+	}
+
+	original, err := s.storage.Get(blob.ObjectKey)
+	if err != nil {
+		return nil, err
+	}
+	content, err := io.ReadAll(original)
+	original.Close()
+	if err != nil {
+		return nil, err
+	}
+	progress(25)
+
+	thumbnailBytes, thumbnail, err := generateThumbnail(content, 200, 200)
+	if err != nil {
+		// El contenido no va a cambiar: reintentar no sirve de nada. Sus
+		// archivos dejan de estar en proceso.
+		if err := s.repo.FailBlob(blob.ID); err != nil {
+			return nil, err
+		}
+		return nil, job.Permanent(err)
+	}
+	progress(50)
+
+	storedThumbnailKey, err := s.storage.Save(bytes.NewReader(thumbnailBytes), payload.ThumbnailKey, "image/jpeg")
+	if err != nil {
+		return nil, err
+	}
+	progress(75)
+
+	blob.ThumbnailObjectKey = storedThumbnailKey
+	blob.ThumbnailSize = int64(len(thumbnailBytes))
+	blob.ThumbnailHash = utils.GenerateSHA256FromBytes(thumbnailBytes)
+	blob.PerceptualHashes = computePerceptualHashes(thumbnail)
+
+	applied, files, err := s.repo.CompleteBlob(blob)
+	if err != nil {
+		return nil, err
+	}
+	if !applied {
+		// Si el blob se liberó mientras se procesaba, nadie borrará ya la
+		// miniatura recién subida.
+		if _, err := s.repo.FindBlobByID(blob.ID); errors.Is(err, gorm.ErrRecordNotFound) {
+			s.deleteObjects(storedThumbnailKey)
+		}
+		return &job.Result{}, nil
+	}
+
+	fileIDs := make([]string, 0, len(files))
+	for _, f := range files {
+		if err := s.quota.Charge(f.UserID, blob.ThumbnailSize); err != nil {
+			log.Printf("No se pudo cobrar la miniatura del archivo %s: %v", f.ID, err)
+		}
+		s.index.add(f.UserID, f.ID, blob.PHash)
+		fileIDs = append(fileIDs, f.ID)
+	}
+
+	return &job.Result{FileIDs: fileIDs}, nil
+}
@@ -0,0 +1,64 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"image-processing-service/internal/modules/job"
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/utils"
+
+	"gorm.io/gorm"
+)
+
+// JobPurgeUser borra todos los archivos de una cuenta eliminada, con sus
+// objetos del storage.
+const JobPurgeUser = "file.purge_user"
+
+type purgeUserPayload struct {
+	UserID string `json:"user_id"`
+}
+
+// HandleUserDeleted se suscribe al bus y programa JobPurgeUser cuando se
+// borra una cuenta. Si el relay repite el evento se encola otra purga, que
+// no encuentra nada que borrar.
+func (s *service) HandleUserDeleted(event events.Event) error {
+	if event.Type != events.UserDeleted {
+		return nil
+	}
+
+	_, err := s.jobs.EnqueueWithID(utils.GenerateID(), JobPurgeUser, event.UserID, purgeUserPayload{UserID: event.UserID})
+	return err
+}
+
+// PurgeUser es el handler del trabajo JobPurgeUser. Cada archivo se borra
+// como en Delete, con su evento file.deleted; si el trabajo se interrumpe,
+// el reintento sigue con los que quedan.
+func (s *service) PurgeUser(ctx context.Context, j *job.Job, progress job.ProgressFunc) (*job.Result, error) {
+	var payload purgeUserPayload
+	if err := json.Unmarshal([]byte(j.Payload), &payload); err != nil {
+		return nil, job.Permanent(err)
+	}
+
+	files, err := s.repo.FindByUserID(payload.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer s.index.invalidate(payload.UserID)
+
+	fileIDs := make([]string, 0, len(files))
+	for i := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if err := s.remove(&files[i]); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		fileIDs = append(fileIDs, files[i].ID)
+		progress((i + 1) * 100 / len(files))
+	}
+
+	return &job.Result{FileIDs: fileIDs}, nil
+}
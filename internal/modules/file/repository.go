@@ -1,13 +1,30 @@
 package file
 
-import "gorm.io/gorm"
+import (
+	"errors"
+
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/outbox"
+
+	"gorm.io/gorm"
+)
 
 type Repository interface {
 	Create(file *File) error
+	CreateWithBlob(file *File, blob *Blob, evts ...events.Event) error
+	CreateDeduplicated(file *File, scope string, contentHash string, evts ...events.Event) (*Blob, error)
+	FindBlobByID(id string) (*Blob, error)
+	FindBlobByContentHash(scope string, contentHash string) (*Blob, error)
+	CompleteBlob(blob *Blob) (bool, []File, error)
+	FailBlob(id string) error
+	FindOneByIDAndUserID(id string, userID string) (*File, error)
+	DeleteAndRelease(file *File, evts ...events.Event) (*Blob, error)
 	FindOne(storageKey string) (*File, error)
 	FindOneByUserID(storageKey string, userID string) (*File, error)
 	FindOneByAnyKeyAndUserID(objectKey string, userID string) (*File, error)
 	FindByUserID(userID string) ([]File, error)
+	FindByIDsAndUserID(ids []string, userID string) ([]File, error)
+	FindHashedByUserID(userID string) ([]File, error)
 }
 
 type repository struct {
@@ -22,6 +39,218 @@ func (r *repository) Create(file *File) error {
 	return r.db.Create(file).Error
 }
 
+// CreateWithBlob guarda un blob nuevo con una referencia, el File que lo usa y
+// evts en la misma transacción. Falla si otro upload creó antes el mismo
+// blob.
+func (r *repository) CreateWithBlob(file *File, blob *Blob, evts ...events.Event) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		blob.RefCount = 1
+		if err := tx.Create(blob).Error; err != nil {
+			return err
+		}
+
+		file.attachBlob(blob)
+		if err := tx.Create(file).Error; err != nil {
+			return err
+		}
+
+		return outbox.Write(tx, evts...)
+	})
+}
+
+// CreateDeduplicated suma una referencia al blob con el mismo contenido y
+// crea el File apuntando a él, junto con evts. Devuelve nil sin crear nada si
+// no existe.
+func (r *repository) CreateDeduplicated(file *File, scope string, contentHash string, evts ...events.Event) (*Blob, error) {
+	var blob Blob
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// ref_count > 0 evita resucitar un blob que otra transacción está
+		// liberando: su objeto físico está a punto de borrarse. Un blob que no
+		// se pudo procesar tampoco se reutiliza.
+		result := tx.Model(&Blob{}).
+			Where("scope = ? AND content_hash = ? AND ref_count > 0 AND processing_failed = ?", scope, contentHash, false).
+			UpdateColumn("ref_count", gorm.Expr("ref_count + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if err := tx.Where("scope = ? AND content_hash = ? AND processing_failed = ?", scope, contentHash, false).First(&blob).Error; err != nil {
+			return err
+		}
+
+		file.attachBlob(&blob)
+		if err := tx.Create(file).Error; err != nil {
+			return err
+		}
+
+		return outbox.Write(tx, evts...)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &blob, nil
+}
+
+func (r *repository) FindBlobByID(id string) (*Blob, error) {
+	var blob Blob
+
+	if err := r.db.Where("id = ?", id).First(&blob).Error; err != nil {
+		return nil, err
+	}
+
+	return &blob, nil
+}
+
+// FindBlobByContentHash devuelve el blob vivo con ese contenido, o nil si no
+// hay ninguno.
+func (r *repository) FindBlobByContentHash(scope string, contentHash string) (*Blob, error) {
+	var blob Blob
+
+	err := r.db.Where("scope = ? AND content_hash = ? AND ref_count > 0 AND processing_failed = ?", scope, contentHash, false).First(&blob).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &blob, nil
+}
+
+// CompleteBlob guarda la miniatura y los hashes perceptuales de un blob recién
+// procesado y los copia a los File que lo referencian. Devuelve false si el
+// blob ya estaba procesado o ya no existe, y los File que se actualizaron.
+//
+// El blob se actualiza primero: CreateDeduplicated también lo bloquea, así
+// que un File que se enlace en paralelo o bien copia los datos ya procesados
+// o bien está confirmado cuando se actualizan los File.
+func (r *repository) CompleteBlob(blob *Blob) (bool, []File, error) {
+	var files []File
+	applied := false
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Blob{}).
+			Where("id = ? AND thumbnail_object_key = ''", blob.ID).
+			Updates(map[string]interface{}{
+				"thumbnail_object_key": blob.ThumbnailObjectKey,
+				"thumbnail_size":       blob.ThumbnailSize,
+				"thumbnail_hash":       blob.ThumbnailHash,
+				"a_hash":               blob.AHash,
+				"d_hash":               blob.DHash,
+				"p_hash":               blob.PHash,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		applied = true
+
+		const pending = "blob_id = ? AND (thumbnail_hash IS NULL OR thumbnail_hash = '')"
+		if err := tx.Select("id", "user_id").Where(pending, blob.ID).Find(&files).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&File{}).Where(pending, blob.ID).Updates(map[string]interface{}{
+			"thumbnail_size": blob.ThumbnailSize,
+			"thumbnail_hash": blob.ThumbnailHash,
+			"a_hash":         blob.AHash,
+			"d_hash":         blob.DHash,
+			"p_hash":         blob.PHash,
+		}).Error
+	})
+	if err != nil {
+		return false, nil, err
+	}
+
+	return applied, files, nil
+}
+
+// FailBlob marca como fallido un blob sin procesar y los File que lo
+// referencian. Como en CompleteBlob, el blob se actualiza primero para que
+// CreateDeduplicated no enlace ningún File nuevo a medias.
+func (r *repository) FailBlob(id string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Blob{}).
+			Where("id = ? AND thumbnail_object_key = ''", id).
+			Update("processing_failed", true)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		return tx.Model(&File{}).Where("blob_id = ?", id).Update("processing_failed", true).Error
+	})
+}
+
+func (r *repository) FindOneByIDAndUserID(id string, userID string) (*File, error) {
+	var file File
+
+	if err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&file).Error; err != nil {
+		return nil, err
+	}
+
+	return &file, nil
+}
+
+// DeleteAndRelease borra el File, guarda evts y resta su referencia al blob.
+// Si era la última, borra también el blob y lo devuelve para eliminar sus
+// objetos.
+func (r *repository) DeleteAndRelease(file *File, evts ...events.Event) (*Blob, error) {
+	var orphan *Blob
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&File{}, "id = ?", file.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if err := outbox.Write(tx, evts...); err != nil {
+			return err
+		}
+
+		if file.BlobID == "" {
+			return nil
+		}
+
+		if err := tx.Model(&Blob{}).
+			Where("id = ?", file.BlobID).
+			UpdateColumn("ref_count", gorm.Expr("ref_count - 1")).Error; err != nil {
+			return err
+		}
+
+		var blob Blob
+		if err := tx.Where("id = ?", file.BlobID).First(&blob).Error; err != nil {
+			return err
+		}
+		if blob.RefCount > 0 {
+			return nil
+		}
+
+		if err := tx.Delete(&Blob{}, "id = ?", blob.ID).Error; err != nil {
+			return err
+		}
+		orphan = &blob
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return orphan, nil
+}
+
 func (r *repository) FindOne(storageKey string) (*File, error) {
 	var file File
 
@@ -61,3 +290,31 @@ func (r *repository) FindByUserID(userID string) ([]File, error) {
 
 	return files, nil
 }
+
+func (r *repository) FindByIDsAndUserID(ids []string, userID string) ([]File, error) {
+	var files []File
+
+	if len(ids) == 0 {
+		return files, nil
+	}
+
+	if err := r.db.Where("id IN ? AND user_id = ?", ids, userID).Find(&files).Error; err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// FindHashedByUserID devuelve solo el ID y el pHash de los archivos del
+// usuario que lo tienen calculado, para construir el índice de similitud.
+func (r *repository) FindHashedByUserID(userID string) ([]File, error) {
+	var files []File
+
+	if err := r.db.Select("id", "p_hash").
+		Where("user_id = ? AND p_hash IS NOT NULL", userID).
+		Find(&files).Error; err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
@@ -0,0 +1,230 @@
+package file_test
+
+// Los tests de repositorio usan SQLite en memoria para ejecutar las queries
+// reales, en particular el conteo de referencias de los blobs compartidos.
+
+import (
+	"image-processing-service/internal/modules/file"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// newMemoryDB crea una base de datos SQLite en memoria con el esquema del
+// módulo file ya migrado.
+func newMemoryDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err, "no se pudo abrir la base de datos en memoria")
+
+	err = db.AutoMigrate(&file.File{}, &file.Blob{})
+	require.NoError(t, err, "no se pudo migrar el esquema")
+
+	return db
+}
+
+func newFile(id string) *file.File {
+	return &file.File{
+		ID:                  id,
+		FileName:            id + ".png",
+		StorageKey:          "user-1/images/" + id + ".png",
+		ThumbnailStorageKey: "user-1/thumbnails/" + id + ".jpg",
+		MimeType:            "image/png",
+		FileSize:            10,
+		ContentHash:         "hash-1",
+		UserID:              "user-1",
+		Format:              "PNG",
+		Width:               1,
+		Height:              1,
+	}
+}
+
+func newBlob(id string) *file.Blob {
+	return &file.Blob{
+		ID:                 id,
+		Scope:              "user-1",
+		ContentHash:        "hash-1",
+		ObjectKey:          "user-1/images/file-1.png",
+		ThumbnailObjectKey: "user-1/thumbnails/file-1.jpg",
+		ThumbnailSize:      5,
+		ThumbnailHash:      "thumb-hash",
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// CreateWithBlob
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_CreateWithBlob(t *testing.T) {
+	t.Run("Debe crear el blob con una referencia y enlazarlo al archivo", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := file.NewRepository(db)
+		f := newFile("file-1")
+
+		// WHEN
+		err := repo.CreateWithBlob(f, newBlob("blob-1"))
+
+		// THEN
+		require.NoError(t, err)
+		var blob file.Blob
+		require.NoError(t, db.First(&blob, "id = ?", "blob-1").Error)
+		assert.Equal(t, int64(1), blob.RefCount)
+		assert.Equal(t, "blob-1", f.BlobID)
+		assert.Equal(t, int64(5), f.ThumbnailSize)
+	})
+
+	t.Run("Debe fallar sin crear el archivo cuando el blob ya existe", func(t *testing.T) {
+		// GIVEN: un blob con el mismo scope y hash
+		db := newMemoryDB(t)
+		repo := file.NewRepository(db)
+		require.NoError(t, repo.CreateWithBlob(newFile("file-1"), newBlob("blob-1")))
+
+		// WHEN
+		err := repo.CreateWithBlob(newFile("file-2"), newBlob("blob-2"))
+
+		// THEN
+		assert.Error(t, err)
+		var count int64
+		db.Model(&file.File{}).Where("id = ?", "file-2").Count(&count)
+		assert.Zero(t, count)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// CreateDeduplicated
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_CreateDeduplicated(t *testing.T) {
+	t.Run("Debe retornar nil sin crear el archivo cuando no hay blob", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := file.NewRepository(db)
+
+		// WHEN
+		blob, err := repo.CreateDeduplicated(newFile("file-1"), "user-1", "hash-1")
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Nil(t, blob)
+		var count int64
+		db.Model(&file.File{}).Count(&count)
+		assert.Zero(t, count)
+	})
+
+	t.Run("Debe sumar una referencia y reutilizar el blob existente", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := file.NewRepository(db)
+		require.NoError(t, repo.CreateWithBlob(newFile("file-1"), newBlob("blob-1")))
+		second := newFile("file-2")
+
+		// WHEN
+		blob, err := repo.CreateDeduplicated(second, "user-1", "hash-1")
+
+		// THEN
+		require.NoError(t, err)
+		require.NotNil(t, blob)
+		assert.Equal(t, int64(2), blob.RefCount)
+		assert.Equal(t, "blob-1", second.BlobID)
+		assert.Equal(t, "thumb-hash", second.ThumbnailHash)
+	})
+
+	t.Run("Debe ignorar blobs de otro scope", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := file.NewRepository(db)
+		require.NoError(t, repo.CreateWithBlob(newFile("file-1"), newBlob("blob-1")))
+
+		// WHEN
+		blob, err := repo.CreateDeduplicated(newFile("file-2"), "user-2", "hash-1")
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Nil(t, blob)
+	})
+
+	t.Run("Debe ignorar un blob que no se pudo procesar y dejar crear otro", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := file.NewRepository(db)
+		failed := newBlob("blob-1")
+		failed.ThumbnailObjectKey = ""
+		require.NoError(t, repo.CreateWithBlob(newFile("file-1"), failed))
+		require.NoError(t, repo.FailBlob("blob-1"))
+
+		// WHEN
+		blob, err := repo.CreateDeduplicated(newFile("file-2"), "user-1", "hash-1")
+		createErr := repo.CreateWithBlob(newFile("file-3"), newBlob("blob-2"))
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Nil(t, blob)
+		assert.NoError(t, createErr)
+		var first file.File
+		require.NoError(t, db.Where("id = ?", "file-1").First(&first).Error)
+		assert.True(t, first.ProcessingFailed)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// DeleteAndRelease
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_DeleteAndRelease(t *testing.T) {
+	t.Run("Debe conservar el blob mientras queden referencias", func(t *testing.T) {
+		// GIVEN: dos archivos que comparten blob
+		db := newMemoryDB(t)
+		repo := file.NewRepository(db)
+		first := newFile("file-1")
+		require.NoError(t, repo.CreateWithBlob(first, newBlob("blob-1")))
+		_, err := repo.CreateDeduplicated(newFile("file-2"), "user-1", "hash-1")
+		require.NoError(t, err)
+
+		// WHEN
+		orphan, err := repo.DeleteAndRelease(first)
+
+		// THEN
+		require.NoError(t, err)
+		assert.Nil(t, orphan)
+		var blob file.Blob
+		require.NoError(t, db.First(&blob, "id = ?", "blob-1").Error)
+		assert.Equal(t, int64(1), blob.RefCount)
+	})
+
+	t.Run("Debe borrar y devolver el blob al liberar la última referencia", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := file.NewRepository(db)
+		f := newFile("file-1")
+		require.NoError(t, repo.CreateWithBlob(f, newBlob("blob-1")))
+
+		// WHEN
+		orphan, err := repo.DeleteAndRelease(f)
+
+		// THEN
+		require.NoError(t, err)
+		require.NotNil(t, orphan)
+		assert.Equal(t, "user-1/images/file-1.png", orphan.ObjectKey)
+		var count int64
+		db.Model(&file.Blob{}).Count(&count)
+		assert.Zero(t, count)
+	})
+
+	t.Run("Debe retornar ErrRecordNotFound cuando el archivo no existe", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := file.NewRepository(db)
+
+		// WHEN
+		orphan, err := repo.DeleteAndRelease(newFile("no-existe"))
+
+		// THEN
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		assert.Nil(t, orphan)
+	})
+}
@@ -50,3 +50,28 @@ func (s *s3Storage) Get(storageKey string) (io.ReadCloser, error) {
 
 	return output.Body, nil
 }
+
+func (s *s3Storage) GetRange(storageKey string, offset int64, length int64) (io.ReadCloser, error) {
+	output, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(storageKey),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error al descargar de S3: %w", err)
+	}
+
+	return output.Body, nil
+}
+
+func (s *s3Storage) Delete(storageKey string) error {
+	_, err := s.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(storageKey),
+	})
+	if err != nil {
+		return fmt.Errorf("error al eliminar de S3: %w", err)
+	}
+
+	return nil
+}
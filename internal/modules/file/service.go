@@ -2,14 +2,19 @@ package file
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"image"
+	"image-processing-service/internal/modules/job"
+	"image-processing-service/internal/shared/events"
 	"image-processing-service/internal/shared/utils"
 	"image/jpeg"
 	"io"
+	"log"
 	"math"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	xdraw "golang.org/x/image/draw"
@@ -18,24 +23,82 @@ import (
 
 type Service interface {
 	Upload(content io.Reader, req FileUploadRequest) (*File, error)
-	GetFile(storageKey string, userID string) (*File, io.ReadCloser, error)
+	Stat(storageKey string, userID string) (*StoredObject, error)
+	Open(object *StoredObject, offset int64, length int64) (io.ReadCloser, error)
 	ListByUserID(userID string) ([]File, error)
+	Delete(fileID string, userID string) error
+	FindSimilar(fileID string, userID string, threshold int) ([]SimilarFile, error)
+	ProcessBlob(ctx context.Context, j *job.Job, progress job.ProgressFunc) (*job.Result, error)
+	HandleUserDeleted(event events.Event) error
+	PurgeUser(ctx context.Context, j *job.Job, progress job.ProgressFunc) (*job.Result, error)
+}
+
+// ServiceConfig agrupa las opciones de comportamiento del servicio.
+type ServiceConfig struct {
+	// DedupAcrossUsers comparte el objeto físico entre usuarios distintos que
+	// suben el mismo contenido. Por defecto solo se comparte dentro de un
+	// mismo usuario.
+	DedupAcrossUsers bool
+	// RequireVerifiedEmail rechaza las subidas de usuarios que no han
+	// verificado su email.
+	RequireVerifiedEmail bool
+}
+
+const globalDedupScope = "global"
+
+// QuotaReserver descuenta y devuelve espacio de la cuota de cada usuario.
+// Reserve debe fallar con un AppError cuando no queda espacio; Charge suma
+// las derivadas generadas después de aceptar la subida.
+type QuotaReserver interface {
+	Reserve(userID string, bytes int64) error
+	Release(userID string, bytes int64) error
+	Charge(userID string, bytes int64) error
+}
+
+// JobEnqueuer encola trabajos asíncronos.
+type JobEnqueuer interface {
+	EnqueueWithID(id string, jobType string, userID string, payload interface{}) (*job.Job, error)
+}
+
+// EmailVerifier indica si un usuario verificó su email. Lo implementa
+// user.Service.
+type EmailVerifier interface {
+	IsEmailVerified(userID string) (bool, error)
 }
 
 type service struct {
 	repo    Repository
 	storage StorageProvider
+	quota   QuotaReserver
+	jobs    JobEnqueuer
+	users   EmailVerifier
+	config  ServiceConfig
+	index   *similarityIndex
 }
 
 var (
-	ErrNotFound = utils.NewError(404, "FILE_NOT_FOUND", "Archivo no encontrado", nil)
+	ErrNotFound         = utils.NewError(404, "FILE_NOT_FOUND", "Archivo no encontrado", nil)
+	ErrHashUnavailable  = utils.NewError(409, "PERCEPTUAL_HASH_UNAVAILABLE", "La imagen no tiene hash perceptual calculado", nil)
+	ErrProcessing       = utils.NewError(409, "FILE_PROCESSING", "La miniatura de la imagen todavía se está generando", nil)
+	ErrProcessingFailed = utils.NewError(422, "FILE_PROCESSING_FAILED", "No se pudo generar la miniatura de la imagen", nil)
+	ErrEmailNotVerified = utils.NewError(403, "EMAIL_NOT_VERIFIED", "Verifica tu email antes de subir imágenes", nil)
 )
 
-func NewService(r Repository, s StorageProvider) Service {
-	return &service{repo: r, storage: s}
+func NewService(r Repository, s StorageProvider, q QuotaReserver, j JobEnqueuer, u EmailVerifier, cfg ServiceConfig) Service {
+	return &service{repo: r, storage: s, quota: q, jobs: j, users: u, config: cfg, index: newSimilarityIndex(r)}
 }
 
 func (s *service) Upload(content io.Reader, req FileUploadRequest) (*File, error) {
+	if s.config.RequireVerifiedEmail {
+		verified, err := s.users.IsEmailVerified(req.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if !verified {
+			return nil, ErrEmailNotVerified
+		}
+	}
+
 	contentBytes, err := io.ReadAll(content)
 	if err != nil {
 		return nil, err
@@ -48,40 +111,143 @@ func (s *service) Upload(content io.Reader, req FileUploadRequest) (*File, error
 	fileID := utils.GenerateID()
 	originalKey := buildOriginalObjectKey(req.UserID, fileID, req.MimeType, req.FileName)
 	thumbnailKey := fmt.Sprintf("%s/thumbnails/%s.jpg", req.UserID, fileID)
+	contentHash := utils.GenerateSHA256FromBytes(contentBytes)
+	scope := s.dedupScope(req.UserID)
+
+	file := &File{
+		ID:                  fileID,
+		FileName:            req.FileName,
+		StorageKey:          originalKey,
+		ThumbnailStorageKey: thumbnailKey,
+		MimeType:            req.MimeType,
+		FileSize:            int64(len(contentBytes)),
+		ContentHash:         contentHash,
+		UserID:              req.UserID,
+		Format:              req.Format,
+		Width:               req.Width,
+		Height:              req.Height,
+	}
+
+	// La cuota cuenta el original más la miniatura. Si el contenido ya está
+	// almacenado la miniatura es la del blob; si no, se cobra cuando el
+	// trabajo de procesamiento la genere.
+	var thumbnailSize int64
+	existing, err := s.repo.FindBlobByContentHash(scope, contentHash)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		thumbnailSize = existing.ThumbnailSize
+	}
 
-	storageKey, err := s.storage.Save(bytes.NewReader(contentBytes), originalKey, req.MimeType)
+	charged := file.FileSize + thumbnailSize
+	if err := s.quota.Reserve(file.UserID, charged); err != nil {
+		return nil, err
+	}
+
+	blob, err := s.store(file, scope, contentBytes, existing)
 	if err != nil {
+		s.releaseQuota(file.UserID, charged)
 		return nil, err
 	}
 
-	thumbnailBytes, err := generateThumbnail(contentBytes, 200, 200)
+	// El blob pudo terminar de procesarse (o liberarse) entre la consulta y
+	// el enlace: se ajusta la diferencia de la miniatura.
+	if delta := file.ThumbnailSize - thumbnailSize; delta != 0 {
+		if err := s.quota.Charge(file.UserID, delta); err != nil {
+			log.Printf("No se pudo ajustar la cuota del archivo %s: %v", file.ID, err)
+		}
+	}
+
+	if blob != nil {
+		_, err := s.jobs.EnqueueWithID(file.ProcessingJobID, JobProcessBlob, file.UserID, processBlobPayload{
+			BlobID:       blob.ID,
+			ThumbnailKey: file.ThumbnailStorageKey,
+		})
+		if err != nil {
+			// Sin trabajo el archivo se quedaría sin miniatura para siempre.
+			s.discard(file)
+			return nil, err
+		}
+	}
+
+	s.index.add(file.UserID, file.ID, file.PHash)
+	return file, nil
+}
+
+// store persiste el archivo. Si el mismo contenido ya está almacenado solo se
+// suma una referencia; StorageKey sigue siendo propia del File porque es la
+// clave de su URL. Si no, sube el original y crea el blob sin procesar, que
+// se devuelve para encolar su procesamiento.
+//
+// file.uploaded se guarda en la misma transacción que el File, así que se
+// construye antes: si el contenido ya existía, processing se toma del blob
+// consultado por Upload (existing).
+func (s *service) store(file *File, scope string, content []byte, existing *Blob) (*Blob, error) {
+	shared, err := s.repo.CreateDeduplicated(file, scope, file.ContentHash, uploadedEvent(file, existing == nil || existing.ThumbnailHash == ""))
 	if err != nil {
 		return nil, err
 	}
+	if shared != nil {
+		return nil, nil
+	}
 
-	storedThumbnailKey, err := s.storage.Save(bytes.NewReader(thumbnailBytes), thumbnailKey, "image/jpeg")
+	storageKey, err := s.storage.Save(bytes.NewReader(content), file.StorageKey, file.MimeType)
 	if err != nil {
 		return nil, err
 	}
 
-	file := &File{
-		ID:                  fileID,
-		FileName:            req.FileName,
-		StorageKey:          storageKey,
-		ThumbnailStorageKey: storedThumbnailKey,
-		MimeType:            req.MimeType,
-		FileSize:            req.FileSize,
-		UserID:              req.UserID,
-		Format:              req.Format,
-		Width:               req.Width,
-		Height:              req.Height,
+	blob := &Blob{
+		ID:          utils.GenerateID(),
+		Scope:       scope,
+		ContentHash: file.ContentHash,
+		ObjectKey:   storageKey,
 	}
 
-	if err := s.repo.Create(file); err != nil {
+	// El ID del trabajo de procesamiento se elige ya para incluirlo en el
+	// evento; el trabajo se encola después de confirmar el File.
+	file.ProcessingJobID = utils.GenerateID()
+	if err := s.repo.CreateWithBlob(file, blob, uploadedEvent(file, true)); err != nil {
+		file.ProcessingJobID = ""
+		// Un upload concurrente del mismo contenido pudo crear el blob
+		// primero: se reutiliza el suyo y se descarta el objeto subido.
+		if shared, dedupErr := s.repo.CreateDeduplicated(file, scope, file.ContentHash, uploadedEvent(file, true)); dedupErr == nil && shared != nil {
+			s.deleteObjects(storageKey)
+			return nil, nil
+		}
+		// Ningún blob apunta al objeto recién subido.
+		s.deleteObjects(storageKey)
 		return nil, err
 	}
 
-	return file, nil
+	return blob, nil
+}
+
+// discard deshace una subida que no se pudo completar. file.uploaded ya está
+// en el outbox, así que se compensa con file.deleted.
+func (s *service) discard(file *File) {
+	orphan, err := s.repo.DeleteAndRelease(file, events.New(events.FileDeleted, file.UserID, newFileEventData(file)))
+	if err != nil {
+		log.Printf("No se pudo descartar el archivo %s: %v", file.ID, err)
+		return
+	}
+	if orphan != nil {
+		s.deleteObjects(orphan.ObjectKey, orphan.ThumbnailObjectKey)
+	}
+	s.releaseQuota(file.UserID, file.FileSize+file.ThumbnailSize)
+}
+
+func (s *service) releaseQuota(userID string, bytes int64) {
+	if err := s.quota.Release(userID, bytes); err != nil {
+		log.Printf("No se pudo liberar la cuota del usuario %s: %v", userID, err)
+	}
+}
+
+func (s *service) dedupScope(userID string) string {
+	if s.config.DedupAcrossUsers {
+		return globalDedupScope
+	}
+	return userID
 }
 
 func buildOriginalObjectKey(userID string, fileID string, mimeType string, fileName string) string {
@@ -112,17 +278,20 @@ func extensionFromMimeType(mimeType string) string {
 	}
 }
 
-func generateThumbnail(content []byte, maxWidth int, maxHeight int) ([]byte, error) {
+// generateThumbnail devuelve la miniatura codificada en JPEG y también la
+// imagen decodificada, para calcular sobre ella los hashes perceptuales sin
+// volver a decodificar.
+func generateThumbnail(content []byte, maxWidth int, maxHeight int) ([]byte, image.Image, error) {
 	img, _, err := image.Decode(bytes.NewReader(content))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	bounds := img.Bounds()
 	originalWidth := bounds.Dx()
 	originalHeight := bounds.Dy()
 	if originalWidth <= 0 || originalHeight <= 0 {
-		return nil, fmt.Errorf("dimensiones de imagen inválidas")
+		return nil, nil, fmt.Errorf("dimensiones de imagen inválidas")
 	}
 
 	scale := math.Min(float64(maxWidth)/float64(originalWidth), float64(maxHeight)/float64(originalHeight))
@@ -144,37 +313,193 @@ func generateThumbnail(content []byte, maxWidth int, maxHeight int) ([]byte, err
 
 	var output bytes.Buffer
 	if err := jpeg.Encode(&output, thumb, &jpeg.Options{Quality: 80}); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return output.Bytes(), nil
+	return output.Bytes(), thumb, nil
 }
 
-func (s *service) GetFile(storageKey string, userID string) (*File, io.ReadCloser, error) {
+func (s *service) Stat(storageKey string, userID string) (*StoredObject, error) {
 	fileMetadata, err := s.repo.FindOneByAnyKeyAndUserID(storageKey, userID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, nil, ErrNotFound
+			return nil, ErrNotFound
 		}
-		return nil, nil, err
+		return nil, err
 	}
 
 	if fileMetadata == nil {
-		return nil, nil, ErrNotFound
+		return nil, ErrNotFound
 	}
 
-	file, err := s.storage.Get(storageKey)
-	if err != nil {
-		return nil, nil, ErrStorageUpload
+	// Los archivos deduplicados comparten el objeto físico del blob; los
+	// anteriores a la deduplicación guardan su objeto en su propia clave.
+	originalObjectKey := fileMetadata.StorageKey
+	thumbnailObjectKey := fileMetadata.ThumbnailStorageKey
+	if fileMetadata.BlobID != "" {
+		blob, err := s.repo.FindBlobByID(fileMetadata.BlobID)
+		if err != nil {
+			return nil, err
+		}
+		originalObjectKey = blob.ObjectKey
+		thumbnailObjectKey = blob.ThumbnailObjectKey
 	}
 
 	if storageKey == fileMetadata.ThumbnailStorageKey {
-		fileMetadata.MimeType = "image/jpeg"
+		if fileMetadata.ProcessingFailed {
+			return nil, ErrProcessingFailed
+		}
+		if thumbnailObjectKey == "" {
+			return nil, ErrProcessing
+		}
+		return &StoredObject{
+			File:        fileMetadata,
+			StorageKey:  thumbnailObjectKey,
+			MimeType:    "image/jpeg",
+			Size:        fileMetadata.ThumbnailSize,
+			ContentHash: fileMetadata.ThumbnailHash,
+			Derivative:  true,
+			ModifiedAt:  fileMetadata.CreatedAt,
+		}, nil
 	}
 
-	return fileMetadata, file, nil
+	return &StoredObject{
+		File:        fileMetadata,
+		StorageKey:  originalObjectKey,
+		MimeType:    fileMetadata.MimeType,
+		Size:        fileMetadata.FileSize,
+		ContentHash: fileMetadata.ContentHash,
+		ModifiedAt:  fileMetadata.CreatedAt,
+	}, nil
+}
+
+// Open abre el objeto completo o, si se pide un rango parcial, solo los bytes
+// [offset, offset+length) para no descargar el objeto entero del storage.
+func (s *service) Open(object *StoredObject, offset int64, length int64) (io.ReadCloser, error) {
+	var (
+		content io.ReadCloser
+		err     error
+	)
+
+	if offset == 0 && (length <= 0 || length == object.Size) {
+		content, err = s.storage.Get(object.StorageKey)
+	} else {
+		content, err = s.storage.GetRange(object.StorageKey, offset, length)
+	}
+	if err != nil {
+		return nil, ErrStorageRead
+	}
+
+	return content, nil
 }
 
 func (s *service) ListByUserID(userID string) ([]File, error) {
 	return s.repo.FindByUserID(userID)
 }
+
+func (s *service) Delete(fileID string, userID string) error {
+	file, err := s.repo.FindOneByIDAndUserID(fileID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	if err := s.remove(file); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	s.index.invalidate(userID)
+
+	return nil
+}
+
+// remove borra el archivo con su evento file.deleted, devuelve su cuota y
+// borra del storage los objetos que ya nadie referencia.
+func (s *service) remove(file *File) error {
+	orphan, err := s.repo.DeleteAndRelease(file, events.New(events.FileDeleted, file.UserID, newFileEventData(file)))
+	if err != nil {
+		return err
+	}
+
+	s.releaseQuota(file.UserID, file.FileSize+file.ThumbnailSize)
+
+	switch {
+	case file.BlobID == "":
+		s.deleteObjects(file.StorageKey, file.ThumbnailStorageKey)
+	case orphan != nil:
+		s.deleteObjects(orphan.ObjectKey, orphan.ThumbnailObjectKey)
+	}
+
+	return nil
+}
+
+// deleteObjects borra objetos del storage sin hacer fallar la operación: la
+// base de datos ya no los referencia, así que un error solo deja basura.
+func (s *service) deleteObjects(keys ...string) {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := s.storage.Delete(key); err != nil {
+			log.Printf("No se pudo eliminar el objeto %s del storage: %v", key, err)
+		}
+	}
+}
+
+// FindSimilar busca entre los archivos del usuario los que tienen un pHash a
+// distancia de Hamming <= threshold del archivo dado, ordenados de más a
+// menos parecido.
+func (s *service) FindSimilar(fileID string, userID string, threshold int) ([]SimilarFile, error) {
+	target, err := s.repo.FindOneByIDAndUserID(fileID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if target.PHash == nil {
+		return nil, ErrHashUnavailable
+	}
+
+	matches, err := s.index.search(userID, uint64(*target.PHash), threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	distances := make(map[string]int, len(matches))
+	ids := make([]string, 0, len(matches))
+	for _, match := range matches {
+		if match.id == target.ID {
+			continue
+		}
+		distances[match.id] = match.distance
+		ids = append(ids, match.id)
+	}
+
+	// Los archivos borrados por otra réplica pueden seguir en el índice;
+	// la consulta solo devuelve los que existen.
+	files, err := s.repo.FindByIDsAndUserID(ids, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	similar := make([]SimilarFile, 0, len(files))
+	for _, f := range files {
+		similar = append(similar, SimilarFile{File: f, Distance: distances[f.ID]})
+	}
+
+	sort.SliceStable(similar, func(i, j int) bool {
+		if similar[i].Distance != similar[j].Distance {
+			return similar[i].Distance < similar[j].Distance
+		}
+		return similar[i].File.CreatedAt.After(similar[j].File.CreatedAt)
+	})
+
+	return similar, nil
+}
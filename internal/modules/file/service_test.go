@@ -0,0 +1,80 @@
+package file_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"image-processing-service/internal/modules/file"
+	"image-processing-service/internal/modules/job"
+	"image-processing-service/internal/shared/events"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingRepo falla al crear el blob, como una caída de la base de datos
+// después de subir el original.
+type failingRepo struct {
+	file.Repository
+	err error
+}
+
+func (r *failingRepo) CreateWithBlob(f *file.File, blob *file.Blob, evts ...events.Event) error {
+	return r.err
+}
+
+// recordingStorage anota qué objetos se guardan y cuáles se borran.
+type recordingStorage struct {
+	file.StorageProvider
+	saved   []string
+	deleted []string
+}
+
+func (s *recordingStorage) Save(content io.Reader, objectKey string, contentType string) (string, error) {
+	key, err := s.StorageProvider.Save(content, objectKey, contentType)
+	if err == nil {
+		s.saved = append(s.saved, key)
+	}
+	return key, err
+}
+
+func (s *recordingStorage) Delete(storageKey string) error {
+	s.deleted = append(s.deleted, storageKey)
+	return s.StorageProvider.Delete(storageKey)
+}
+
+type mockQuota struct{}
+
+func (mockQuota) Reserve(userID string, bytes int64) error { return nil }
+func (mockQuota) Release(userID string, bytes int64) error { return nil }
+func (mockQuota) Charge(userID string, bytes int64) error  { return nil }
+
+type mockJobs struct{}
+
+func (mockJobs) EnqueueWithID(id string, jobType string, userID string, payload interface{}) (*job.Job, error) {
+	return &job.Job{ID: id}, nil
+}
+
+func TestService_Upload(t *testing.T) {
+	t.Run("Debe borrar el original subido si no se puede crear el blob", func(t *testing.T) {
+		// GIVEN
+		dbErr := errors.New("db error")
+		repo := &failingRepo{Repository: file.NewRepository(newMemoryDB(t)), err: dbErr}
+		storage := &recordingStorage{StorageProvider: file.NewMemoryStorage()}
+		service := file.NewService(repo, storage, mockQuota{}, mockJobs{}, nil, file.ServiceConfig{})
+
+		// WHEN
+		_, err := service.Upload(bytes.NewReader([]byte("contenido")), file.FileUploadRequest{
+			FileName: "foto.png",
+			MimeType: "image/png",
+			UserID:   "user-1",
+		})
+
+		// THEN
+		assert.ErrorIs(t, err, dbErr)
+		require.Len(t, storage.saved, 1)
+		assert.Equal(t, storage.saved, storage.deleted)
+	})
+}
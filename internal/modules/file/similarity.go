@@ -0,0 +1,120 @@
+package file
+
+import (
+	"sync"
+	"time"
+)
+
+// similarityIndexTTL limita cuánto tiempo se reutiliza el árbol de un
+// usuario. Cada instancia solo ve sus propias subidas; recargar el árbol
+// periódicamente incorpora las que atendieron otras réplicas.
+const similarityIndexTTL = 5 * time.Minute
+
+// similarityIndex mantiene en memoria un árbol BK de pHash por usuario. Se
+// carga de la base de datos en la primera búsqueda y después se actualiza
+// con las subidas y borrados de esta instancia.
+type similarityIndex struct {
+	repo  Repository
+	mu    sync.Mutex
+	trees map[string]*userTree
+}
+
+type userTree struct {
+	tree     bkTree
+	loadedAt time.Time
+	// loading indica que el árbol se está leyendo de la base de datos.
+	// Mientras tanto, add guarda en pending lo que llega, que puede no
+	// estar en lo leído.
+	loading bool
+	pending []pendingFile
+}
+
+type pendingFile struct {
+	id   string
+	hash uint64
+}
+
+func newSimilarityIndex(repo Repository) *similarityIndex {
+	return &similarityIndex{repo: repo, trees: make(map[string]*userTree)}
+}
+
+// add incorpora un archivo recién subido si el árbol del usuario ya está
+// cargado o cargándose; si no, se incluirá al cargarlo.
+func (i *similarityIndex) add(userID string, fileID string, pHash *int64) {
+	if pHash == nil {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	tree, ok := i.trees[userID]
+	switch {
+	case !ok:
+	case tree.loading:
+		tree.pending = append(tree.pending, pendingFile{id: fileID, hash: uint64(*pHash)})
+	default:
+		tree.tree.add(uint64(*pHash), fileID)
+	}
+}
+
+// invalidate descarta el árbol del usuario. Los árboles BK no admiten
+// borrados, así que tras eliminar un archivo se reconstruye en la siguiente
+// búsqueda. Una carga en curso tampoco se guarda.
+func (i *similarityIndex) invalidate(userID string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	delete(i.trees, userID)
+}
+
+func (i *similarityIndex) search(userID string, hash uint64, threshold int) ([]bkMatch, error) {
+	i.mu.Lock()
+	tree, ok := i.trees[userID]
+	if ok && !tree.loading && time.Since(tree.loadedAt) <= similarityIndexTTL {
+		matches := tree.tree.search(hash, threshold)
+		i.mu.Unlock()
+		return matches, nil
+	}
+	// La entrada se registra antes de cargar para que add e invalidate la
+	// vean; si otra búsqueda la sustituye, esta carga no se guarda.
+	entry := &userTree{loading: true}
+	i.trees[userID] = entry
+	i.mu.Unlock()
+
+	// La carga se hace sin el lock para no bloquear a otros usuarios
+	// mientras se consulta la base de datos.
+	files, err := i.repo.FindHashedByUserID(userID)
+	if err != nil {
+		i.mu.Lock()
+		if i.trees[userID] == entry {
+			delete(i.trees, userID)
+		}
+		i.mu.Unlock()
+		return nil, err
+	}
+
+	loaded := bkTree{}
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		loaded.add(uint64(*f.PHash), f.ID)
+		seen[f.ID] = true
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, p := range entry.pending {
+		if !seen[p.id] {
+			loaded.add(p.hash, p.id)
+		}
+	}
+	if i.trees[userID] == entry {
+		entry.tree = loaded
+		entry.loadedAt = time.Now()
+		entry.loading = false
+		entry.pending = nil
+	}
+
+	return loaded.search(hash, threshold), nil
+}
@@ -0,0 +1,75 @@
+package file_test
+
+import (
+	"testing"
+
+	"image-processing-service/internal/modules/file"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loadingRepo ejecuta duringLoad en medio de la carga del índice, después de
+// leer la base de datos, para reproducir lo que ocurre si otra petición
+// llega mientras tanto.
+type loadingRepo struct {
+	file.Repository
+	duringLoad func()
+}
+
+func (r *loadingRepo) FindHashedByUserID(userID string) ([]file.File, error) {
+	files, err := r.Repository.FindHashedByUserID(userID)
+	if r.duringLoad != nil {
+		hook := r.duringLoad
+		r.duringLoad = nil
+		hook()
+	}
+	return files, err
+}
+
+func TestSimilarityIndex(t *testing.T) {
+	const hash = int64(0x0F0F)
+
+	newIndex := func(t *testing.T) (*file.SimilarityIndex, *loadingRepo) {
+		db := newMemoryDB(t)
+		stored := newFile("file-1")
+		stored.PHash = new(int64)
+		*stored.PHash = hash
+		require.NoError(t, db.Create(stored).Error)
+
+		repo := &loadingRepo{Repository: file.NewRepository(db)}
+		return file.NewSimilarityIndex(repo), repo
+	}
+
+	t.Run("Debe conservar una subida que llega mientras se carga el árbol", func(t *testing.T) {
+		// GIVEN
+		index, repo := newIndex(t)
+		repo.duringLoad = func() { index.Add("user-1", "file-2", hash) }
+
+		// WHEN
+		first, err := index.Search("user-1", uint64(hash), 0)
+		require.NoError(t, err)
+		second, err := index.Search("user-1", uint64(hash), 0)
+		require.NoError(t, err)
+
+		// THEN
+		assert.ElementsMatch(t, []string{"file-1", "file-2"}, first)
+		assert.ElementsMatch(t, []string{"file-1", "file-2"}, second)
+	})
+
+	t.Run("Debe descartar la carga si se invalida mientras tanto", func(t *testing.T) {
+		// GIVEN: un borrado durante la carga hace que lo leído quede obsoleto
+		index, repo := newIndex(t)
+		repo.duringLoad = func() { index.Invalidate("user-1") }
+		_, err := index.Search("user-1", uint64(hash), 0)
+		require.NoError(t, err)
+		repo.duringLoad = func() { index.Add("user-1", "file-3", hash) }
+
+		// WHEN: la siguiente búsqueda vuelve a cargar
+		found, err := index.Search("user-1", uint64(hash), 0)
+
+		// THEN
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"file-1", "file-3"}, found)
+	})
+}
@@ -5,4 +5,7 @@ import "io"
 type StorageProvider interface {
 	Save(content io.Reader, objectKey string, contentType string) (string, error)
 	Get(storageKey string) (io.ReadCloser, error)
+	// GetRange devuelve length bytes del objeto a partir de offset.
+	GetRange(storageKey string, offset int64, length int64) (io.ReadCloser, error)
+	Delete(storageKey string) error
 }
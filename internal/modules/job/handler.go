@@ -0,0 +1,40 @@
+package job
+
+import (
+	"net/http"
+
+	"image-processing-service/internal/shared/auth"
+	"image-processing-service/internal/shared/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type Handler interface {
+	GetByID(w http.ResponseWriter, r *http.Request)
+}
+
+type handler struct {
+	service Service
+}
+
+func NewHandler(s Service) Handler {
+	return &handler{service: s}
+}
+
+func (h *handler) GetByID(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	id := chi.URLParam(r, "id")
+	if !utils.IsValidID(id) {
+		utils.HandleError(w, utils.ErrInvalidIDFormat)
+		return
+	}
+
+	job, err := h.service.GetByID(id, authUser.UserID)
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, job)
+}
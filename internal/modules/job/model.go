@@ -0,0 +1,55 @@
+package job
+
+import "time"
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	// StatusDead es la cola de mensajes muertos: el trabajo agotó sus
+	// intentos o falló con un error permanente y no se vuelve a ejecutar.
+	StatusDead Status = "dead"
+)
+
+// Job es un trabajo en cola. Payload y Result se guardan como JSON.
+// RunAt es el momento a partir del cual puede ejecutarse; tras un fallo se
+// pospone según el backoff exponencial.
+type Job struct {
+	ID          string     `gorm:"primaryKey;size=24" json:"id"`
+	Type        string     `gorm:"not null" json:"type"`
+	UserID      string     `gorm:"index" json:"user_id"`
+	Payload     string     `gorm:"type:text;not null" json:"payload"`
+	Status      Status     `gorm:"not null;index:idx_jobs_status_run_at,priority:1" json:"status"`
+	Progress    int        `gorm:"not null;default:0" json:"progress"`
+	Attempts    int        `gorm:"not null;default:0" json:"attempts"`
+	MaxAttempts int        `gorm:"not null" json:"max_attempts"`
+	LastError   string     `json:"last_error"`
+	Result      string     `gorm:"type:text" json:"result"`
+	RunAt       time.Time  `gorm:"not null;index:idx_jobs_status_run_at,priority:2" json:"run_at"`
+	LockedBy    string     `json:"locked_by"`
+	LockedAt    *time.Time `json:"locked_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Result es lo que produce un trabajo terminado.
+type Result struct {
+	FileIDs []string `json:"file_ids"`
+}
+
+type JobResponse struct {
+	ID            string     `json:"id"`
+	Type          string     `json:"type"`
+	Status        Status     `json:"status"`
+	Progress      int        `json:"progress"`
+	Attempts      int        `json:"attempts"`
+	MaxAttempts   int        `json:"max_attempts"`
+	Error         *string    `json:"error"`
+	ResultFileIDs []string   `json:"result_file_ids"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	FinishedAt    *time.Time `json:"finished_at"`
+}
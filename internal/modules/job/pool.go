@@ -0,0 +1,250 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/utils"
+)
+
+// ProgressFunc informa del avance de un trabajo, de 0 a 100.
+type ProgressFunc func(progress int)
+
+// HandlerFunc ejecuta un trabajo de un tipo concreto. Un error provoca un
+// reintento con backoff, salvo que se envuelva con Permanent.
+type HandlerFunc func(ctx context.Context, job *Job, progress ProgressFunc) (*Result, error)
+
+// PoolConfig agrupa las opciones del pool de workers. Los valores a cero
+// toman un valor por defecto razonable.
+type PoolConfig struct {
+	Workers      int
+	PollInterval time.Duration
+	// BaseBackoff es la espera tras el primer fallo; se duplica en cada
+	// intento hasta MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// LeaseTimeout es cuánto puede pasar un trabajo running sin informar
+	// progreso antes de que otro worker lo recupere.
+	LeaseTimeout time.Duration
+}
+
+// Pool es un conjunto acotado de workers que reclaman trabajos de la cola y
+// los despachan al handler registrado para su tipo.
+type Pool struct {
+	repo     Repository
+	config   PoolConfig
+	handlers map[string]HandlerFunc
+	internal map[string]bool
+	name     string
+	wg       sync.WaitGroup
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marca un error como no recuperable: el trabajo va directo a la
+// cola de muertos sin agotar los reintentos.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// NewPool crea el pool. Al terminar cada trabajo, con éxito o en la cola de
+// muertos, se guarda job.completed o job.failed en el outbox junto con el
+// resultado.
+func NewPool(r Repository, cfg PoolConfig) *Pool {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.BaseBackoff <= 0 {
+		cfg.BaseBackoff = 2 * time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 5 * time.Minute
+	}
+	if cfg.LeaseTimeout <= 0 {
+		cfg.LeaseTimeout = 5 * time.Minute
+	}
+
+	hostname, _ := os.Hostname()
+	return &Pool{
+		repo:     r,
+		config:   cfg,
+		handlers: make(map[string]HandlerFunc),
+		internal: make(map[string]bool),
+		name:     fmt.Sprintf("%s-%s", hostname, utils.GenerateID()),
+	}
+}
+
+// Register asocia un handler a un tipo de trabajo. Debe llamarse antes de
+// Start; solo se reclaman los tipos registrados.
+func (p *Pool) Register(jobType string, handler HandlerFunc) {
+	p.handlers[jobType] = handler
+}
+
+// RegisterInternal registra un tipo de trabajo que no publica eventos al
+// terminar. Es para trabajos que son a su vez consecuencia de un evento,
+// como las entregas de webhooks: publicar su resultado generaría otra
+// entrega, y así indefinidamente.
+func (p *Pool) RegisterInternal(jobType string, handler HandlerFunc) {
+	p.Register(jobType, handler)
+	p.internal[jobType] = true
+}
+
+// Start arranca los workers. Se detienen al cancelar ctx, después de terminar
+// el trabajo que tengan en curso.
+func (p *Pool) Start(ctx context.Context) {
+	types := make([]string, 0, len(p.handlers))
+	for jobType := range p.handlers {
+		types = append(types, jobType)
+	}
+
+	for i := 0; i < p.config.Workers; i++ {
+		p.wg.Add(1)
+		go p.work(ctx, fmt.Sprintf("%s-%d", p.name, i), types)
+	}
+}
+
+// Wait bloquea hasta que todos los workers se hayan detenido.
+func (p *Pool) Wait() {
+	p.wg.Wait()
+}
+
+func (p *Pool) work(ctx context.Context, workerID string, types []string) {
+	defer p.wg.Done()
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		now := time.Now().UTC()
+		job, err := p.repo.Claim(types, workerID, now, now.Add(-p.config.LeaseTimeout))
+		if err != nil {
+			log.Printf("Error reclamando trabajos: %v", err)
+		}
+
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(p.config.PollInterval):
+			}
+			continue
+		}
+
+		p.run(ctx, workerID, job)
+	}
+}
+
+func (p *Pool) run(ctx context.Context, workerID string, job *Job) {
+	progress := func(value int) {
+		if err := p.repo.UpdateProgress(job.ID, workerID, value); err != nil {
+			log.Printf("No se pudo actualizar el progreso del trabajo %s: %v", job.ID, err)
+		}
+	}
+
+	result, err := p.execute(ctx, job, progress)
+	now := time.Now().UTC()
+
+	if err == nil {
+		raw, _ := json.Marshal(result)
+		completed := p.finishEvents(events.JobCompleted, job, StatusSucceeded, result.FileIDs, "")
+		if err := p.repo.Complete(job.ID, workerID, string(raw), now, completed...); err != nil {
+			log.Printf("No se pudo completar el trabajo %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	var permanent *permanentError
+	if errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts {
+		log.Printf("El trabajo %s (%s) pasa a la cola de muertos: %v", job.ID, job.Type, err)
+		failed := p.finishEvents(events.JobFailed, job, StatusDead, nil, err.Error())
+		if buryErr := p.repo.Bury(job.ID, workerID, err.Error(), now, failed...); buryErr != nil {
+			log.Printf("No se pudo marcar como muerto el trabajo %s: %v", job.ID, buryErr)
+		}
+		return
+	}
+
+	if err := p.repo.Retry(job.ID, workerID, err.Error(), now.Add(p.backoff(job.Attempts))); err != nil {
+		log.Printf("No se pudo reprogramar el trabajo %s: %v", job.ID, err)
+	}
+}
+
+// EventData es el contenido de los eventos job.completed y job.failed.
+type EventData struct {
+	JobID         string   `json:"job_id"`
+	Type          string   `json:"type"`
+	Status        Status   `json:"status"`
+	Attempts      int      `json:"attempts"`
+	Error         string   `json:"error,omitempty"`
+	ResultFileIDs []string `json:"result_file_ids"`
+}
+
+// finishEvents devuelve el evento que se guarda al terminar el trabajo, o
+// ninguno si su tipo es interno.
+func (p *Pool) finishEvents(eventType string, job *Job, status Status, fileIDs []string, errMsg string) []events.Event {
+	if p.internal[job.Type] {
+		return nil
+	}
+
+	if fileIDs == nil {
+		fileIDs = []string{}
+	}
+
+	return []events.Event{events.New(eventType, job.UserID, EventData{
+		JobID:         job.ID,
+		Type:          job.Type,
+		Status:        status,
+		Attempts:      job.Attempts,
+		Error:         errMsg,
+		ResultFileIDs: fileIDs,
+	})}
+}
+
+// execute llama al handler convirtiendo un panic en un error, para que un
+// trabajo defectuoso no tumbe el worker.
+func (p *Pool) execute(ctx context.Context, job *Job, progress ProgressFunc) (result *Result, err error) {
+	handler, ok := p.handlers[job.Type]
+	if !ok {
+		return nil, Permanent(fmt.Errorf("no hay handler para el tipo %q", job.Type))
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	result, err = handler(ctx, job, progress)
+	if err == nil && result == nil {
+		result = &Result{}
+	}
+	return result, err
+}
+
+// backoff devuelve la espera antes del siguiente intento: BaseBackoff tras el
+// primero, el doble tras el segundo, y así hasta MaxBackoff.
+func (p *Pool) backoff(attempts int) time.Duration {
+	delay := p.config.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= p.config.MaxBackoff {
+			return p.config.MaxBackoff
+		}
+	}
+	return delay
+}
@@ -0,0 +1,222 @@
+package job_test
+
+// Los tests del pool ejecutan workers reales contra SQLite en memoria y
+// esperan a que cada trabajo llegue a un estado final.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"image-processing-service/internal/modules/job"
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/outbox"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// startPool arranca un pool con tiempos cortos y lo detiene al terminar.
+func startPool(t *testing.T, db *gorm.DB, handler job.HandlerFunc) {
+	t.Helper()
+
+	startPoolWith(t, db, func(pool *job.Pool) { pool.Register("test", handler) })
+}
+
+func startPoolWith(t *testing.T, db *gorm.DB, register func(pool *job.Pool)) {
+	t.Helper()
+
+	pool := job.NewPool(job.NewRepository(db), job.PoolConfig{
+		Workers:      2,
+		PollInterval: 5 * time.Millisecond,
+		BaseBackoff:  5 * time.Millisecond,
+		MaxBackoff:   20 * time.Millisecond,
+	})
+	register(pool)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pool.Start(ctx)
+	t.Cleanup(func() {
+		cancel()
+		pool.Wait()
+	})
+}
+
+// outboxMessages devuelve los eventos guardados en el outbox, en orden.
+func outboxMessages(t *testing.T, db *gorm.DB) []outbox.Message {
+	t.Helper()
+
+	var messages []outbox.Message
+	require.NoError(t, db.Order("id").Find(&messages).Error)
+	return messages
+}
+
+// waitFinished espera a que el trabajo termine, con éxito o en la cola de
+// muertos, y lo devuelve.
+func waitFinished(t *testing.T, svc job.Service, id string) *job.JobResponse {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		res, err := svc.GetByID(id, "user-1")
+		require.NoError(t, err)
+		if res.Status == job.StatusSucceeded || res.Status == job.StatusDead {
+			return res
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("el trabajo %s no terminó a tiempo", id)
+	return nil
+}
+
+func TestPool(t *testing.T) {
+	t.Run("Debe ejecutar el trabajo y guardar su resultado", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		svc := job.NewService(job.NewRepository(db), job.ServiceConfig{})
+		startPool(t, db, func(ctx context.Context, j *job.Job, progress job.ProgressFunc) (*job.Result, error) {
+			progress(50)
+			return &job.Result{FileIDs: []string{"file-1"}}, nil
+		})
+
+		// WHEN
+		enqueued, err := svc.Enqueue("test", "user-1", map[string]string{"a": "b"})
+		require.NoError(t, err)
+		res := waitFinished(t, svc, enqueued.ID)
+
+		// THEN
+		assert.Equal(t, job.StatusSucceeded, res.Status)
+		assert.Equal(t, 100, res.Progress)
+		assert.Equal(t, []string{"file-1"}, res.ResultFileIDs)
+		assert.Nil(t, res.Error)
+	})
+
+	t.Run("Debe reintentar los fallos temporales", func(t *testing.T) {
+		// GIVEN: el handler falla dos veces antes de funcionar
+		db := newMemoryDB(t)
+		svc := job.NewService(job.NewRepository(db), job.ServiceConfig{MaxAttempts: 5})
+		var calls int32
+		startPool(t, db, func(ctx context.Context, j *job.Job, progress job.ProgressFunc) (*job.Result, error) {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				return nil, errors.New("storage no disponible")
+			}
+			return nil, nil
+		})
+
+		// WHEN
+		enqueued, err := svc.Enqueue("test", "user-1", nil)
+		require.NoError(t, err)
+		res := waitFinished(t, svc, enqueued.ID)
+
+		// THEN
+		assert.Equal(t, job.StatusSucceeded, res.Status)
+		assert.Equal(t, 3, res.Attempts)
+	})
+
+	t.Run("Debe mandar a la cola de muertos al agotar los intentos", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		svc := job.NewService(job.NewRepository(db), job.ServiceConfig{MaxAttempts: 2})
+		startPool(t, db, func(ctx context.Context, j *job.Job, progress job.ProgressFunc) (*job.Result, error) {
+			return nil, errors.New("storage no disponible")
+		})
+
+		// WHEN
+		enqueued, err := svc.Enqueue("test", "user-1", nil)
+		require.NoError(t, err)
+		res := waitFinished(t, svc, enqueued.ID)
+
+		// THEN
+		assert.Equal(t, job.StatusDead, res.Status)
+		assert.Equal(t, 2, res.Attempts)
+		require.NotNil(t, res.Error)
+		assert.Equal(t, "storage no disponible", *res.Error)
+	})
+
+	t.Run("No debe reintentar un error permanente ni caer por un panic", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		svc := job.NewService(job.NewRepository(db), job.ServiceConfig{MaxAttempts: 5})
+		startPool(t, db, func(ctx context.Context, j *job.Job, progress job.ProgressFunc) (*job.Result, error) {
+			if j.Payload == `"panic"` {
+				panic("handler defectuoso")
+			}
+			return nil, job.Permanent(errors.New("imagen corrupta"))
+		})
+
+		// WHEN
+		permanent, err := svc.Enqueue("test", "user-1", "permanente")
+		require.NoError(t, err)
+		panicked, err := svc.Enqueue("test", "user-1", "panic")
+		require.NoError(t, err)
+
+		// THEN
+		res := waitFinished(t, svc, permanent.ID)
+		assert.Equal(t, job.StatusDead, res.Status)
+		assert.Equal(t, 1, res.Attempts)
+
+		res = waitFinished(t, svc, panicked.ID)
+		assert.Equal(t, job.StatusDead, res.Status)
+		assert.Equal(t, 5, res.Attempts)
+	})
+
+	t.Run("Debe guardar job.completed y job.failed en el outbox al terminar", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		svc := job.NewService(job.NewRepository(db), job.ServiceConfig{MaxAttempts: 1})
+		startPool(t, db, func(ctx context.Context, j *job.Job, progress job.ProgressFunc) (*job.Result, error) {
+			if j.Payload == `"falla"` {
+				return nil, errors.New("imagen corrupta")
+			}
+			return &job.Result{FileIDs: []string{"file-1"}}, nil
+		})
+
+		// WHEN
+		ok, err := svc.Enqueue("test", "user-1", "ok")
+		require.NoError(t, err)
+		failed, err := svc.Enqueue("test", "user-1", "falla")
+		require.NoError(t, err)
+		waitFinished(t, svc, ok.ID)
+		waitFinished(t, svc, failed.ID)
+
+		// THEN: el evento se guarda en la misma transacción que el estado final
+		byJob := make(map[string]job.EventData)
+		types := make(map[string]string)
+		for _, m := range outboxMessages(t, db) {
+			assert.Equal(t, "user-1", m.UserID)
+			var data job.EventData
+			require.NoError(t, json.Unmarshal([]byte(m.Data), &data))
+			byJob[data.JobID] = data
+			types[data.JobID] = m.Type
+		}
+		require.Len(t, byJob, 2)
+		assert.Equal(t, events.JobCompleted, types[ok.ID])
+		assert.Equal(t, []string{"file-1"}, byJob[ok.ID].ResultFileIDs)
+		assert.Equal(t, events.JobFailed, types[failed.ID])
+		assert.Equal(t, "imagen corrupta", byJob[failed.ID].Error)
+	})
+
+	t.Run("No debe guardar eventos de los trabajos internos", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		svc := job.NewService(job.NewRepository(db), job.ServiceConfig{})
+		startPoolWith(t, db, func(pool *job.Pool) {
+			pool.RegisterInternal("test", func(ctx context.Context, j *job.Job, progress job.ProgressFunc) (*job.Result, error) {
+				return nil, nil
+			})
+		})
+
+		// WHEN
+		created, err := svc.Enqueue("test", "user-1", nil)
+		require.NoError(t, err)
+		res := waitFinished(t, svc, created.ID)
+
+		// THEN
+		assert.Equal(t, job.StatusSucceeded, res.Status)
+		assert.Empty(t, outboxMessages(t, db))
+	})
+}
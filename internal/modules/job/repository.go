@@ -0,0 +1,167 @@
+package job
+
+import (
+	"errors"
+	"time"
+
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/outbox"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Repository interface {
+	Create(job *Job) error
+	FindByIDAndUserID(id string, userID string) (*Job, error)
+	Claim(types []string, workerID string, now time.Time, staleBefore time.Time) (*Job, error)
+	UpdateProgress(id string, workerID string, progress int) error
+	Complete(id string, workerID string, result string, now time.Time, evts ...events.Event) error
+	Retry(id string, workerID string, lastError string, runAt time.Time) error
+	Bury(id string, workerID string, lastError string, now time.Time, evts ...events.Event) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) Create(job *Job) error {
+	return r.db.Create(job).Error
+}
+
+func (r *repository) FindByIDAndUserID(id string, userID string) (*Job, error) {
+	var job Job
+
+	if err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&job).Error; err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// ready selecciona los trabajos pendientes cuyo RunAt ya pasó y los running
+// con el lease vencido.
+const ready = "(status = ? AND run_at <= ?) OR (status = ? AND locked_at < ?)"
+
+// Claim toma el siguiente trabajo listo de alguno de los tipos dados y lo
+// marca como running. FOR UPDATE SKIP LOCKED deja que varios workers
+// reclamen en paralelo sin bloquearse ni tomar el mismo trabajo. También se
+// recuperan los trabajos running cuyo worker dejó de dar señales antes de
+// staleBefore. Devuelve nil si no hay nada que hacer.
+func (r *repository) Claim(types []string, workerID string, now time.Time, staleBefore time.Time) (*Job, error) {
+	var claimed *Job
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var job Job
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("type IN ?", types).
+			Where(ready, StatusPending, now, StatusRunning, staleBefore).
+			Order("run_at").
+			First(&job).Error
+		if err != nil {
+			return err
+		}
+
+		// Repetir la condición protege a las bases de datos que ignoran
+		// SKIP LOCKED: si otro worker ganó, no se actualiza nada.
+		result := tx.Model(&Job{}).
+			Where("id = ?", job.ID).
+			Where(ready, StatusPending, now, StatusRunning, staleBefore).
+			Updates(map[string]interface{}{
+				"status":    StatusRunning,
+				"attempts":  gorm.Expr("attempts + 1"),
+				"locked_by": workerID,
+				"locked_at": now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		job.Status = StatusRunning
+		job.Attempts++
+		job.LockedBy = workerID
+		job.LockedAt = &now
+		claimed = &job
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return claimed, nil
+}
+
+// UpdateProgress también renueva locked_at, que hace de latido del worker.
+func (r *repository) UpdateProgress(id string, workerID string, progress int) error {
+	return owned(r.db, id, workerID).Updates(map[string]interface{}{
+		"progress":  progress,
+		"locked_at": time.Now().UTC(),
+	}).Error
+}
+
+// Complete marca el trabajo como terminado y guarda evts en el outbox en la
+// misma transacción. Si el worker ya no lo tenía reservado no hace nada.
+func (r *repository) Complete(id string, workerID string, result string, now time.Time, evts ...events.Event) error {
+	return r.finish(id, workerID, map[string]interface{}{
+		"status":      StatusSucceeded,
+		"progress":    100,
+		"result":      result,
+		"last_error":  "",
+		"locked_by":   "",
+		"locked_at":   nil,
+		"finished_at": now,
+	}, evts)
+}
+
+func (r *repository) Retry(id string, workerID string, lastError string, runAt time.Time) error {
+	return owned(r.db, id, workerID).Updates(map[string]interface{}{
+		"status":     StatusPending,
+		"last_error": lastError,
+		"run_at":     runAt,
+		"locked_by":  "",
+		"locked_at":  nil,
+	}).Error
+}
+
+// Bury manda el trabajo a la cola de muertos, con las mismas garantías que
+// Complete.
+func (r *repository) Bury(id string, workerID string, lastError string, now time.Time, evts ...events.Event) error {
+	return r.finish(id, workerID, map[string]interface{}{
+		"status":      StatusDead,
+		"last_error":  lastError,
+		"locked_by":   "",
+		"locked_at":   nil,
+		"finished_at": now,
+	}, evts)
+}
+
+func (r *repository) finish(id string, workerID string, updates map[string]interface{}, evts []events.Event) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := owned(tx, id, workerID).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		// Quien recuperó el trabajo publicará su propio resultado.
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		return outbox.Write(tx, evts...)
+	})
+}
+
+// owned limita la actualización al worker que reclamó el trabajo, para que
+// uno que perdió el lease no pise el resultado de quien lo recuperó.
+func owned(db *gorm.DB, id string, workerID string) *gorm.DB {
+	return db.Model(&Job{}).Where("id = ? AND status = ? AND locked_by = ?", id, StatusRunning, workerID)
+}
@@ -0,0 +1,199 @@
+package job_test
+
+// Los tests de repositorio usan SQLite en memoria. SQLite ignora SKIP LOCKED,
+// pero la reclamación repite su condición en el UPDATE, de modo que las
+// reglas de qué trabajo está listo se pueden verificar igual.
+
+import (
+	"image-processing-service/internal/modules/job"
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/outbox"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// newMemoryDB abre SQLite en memoria con una sola conexión: cada conexión
+// nueva vería una base de datos distinta, y el pool usa varias goroutines.
+func newMemoryDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err, "no se pudo abrir la base de datos en memoria")
+
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+
+	err = db.AutoMigrate(&job.Job{}, &outbox.Message{})
+	require.NoError(t, err, "no se pudo migrar el esquema")
+
+	return db
+}
+
+func newJob(id string, runAt time.Time) *job.Job {
+	return &job.Job{
+		ID:          id,
+		Type:        "test",
+		UserID:      "user-1",
+		Payload:     "{}",
+		Status:      job.StatusPending,
+		MaxAttempts: 3,
+		RunAt:       runAt,
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Claim
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_Claim(t *testing.T) {
+	now := time.Now().UTC()
+
+	t.Run("Debe reclamar el trabajo listo más antiguo y marcarlo como running", func(t *testing.T) {
+		// GIVEN
+		repo := job.NewRepository(newMemoryDB(t))
+		require.NoError(t, repo.Create(newJob("job-2", now.Add(-time.Second))))
+		require.NoError(t, repo.Create(newJob("job-1", now.Add(-time.Minute))))
+
+		// WHEN
+		claimed, err := repo.Claim([]string{"test"}, "worker-1", now, now.Add(-time.Hour))
+
+		// THEN
+		require.NoError(t, err)
+		require.NotNil(t, claimed)
+		assert.Equal(t, "job-1", claimed.ID)
+		assert.Equal(t, job.StatusRunning, claimed.Status)
+		assert.Equal(t, 1, claimed.Attempts)
+		assert.Equal(t, "worker-1", claimed.LockedBy)
+	})
+
+	t.Run("No debe reclamar trabajos programados a futuro ni de otros tipos", func(t *testing.T) {
+		// GIVEN
+		repo := job.NewRepository(newMemoryDB(t))
+		require.NoError(t, repo.Create(newJob("job-1", now.Add(time.Minute))))
+		other := newJob("job-2", now.Add(-time.Minute))
+		other.Type = "otro"
+		require.NoError(t, repo.Create(other))
+
+		// WHEN
+		claimed, err := repo.Claim([]string{"test"}, "worker-1", now, now.Add(-time.Hour))
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Nil(t, claimed)
+	})
+
+	t.Run("No debe reclamar dos veces el mismo trabajo", func(t *testing.T) {
+		// GIVEN
+		repo := job.NewRepository(newMemoryDB(t))
+		require.NoError(t, repo.Create(newJob("job-1", now.Add(-time.Minute))))
+		_, err := repo.Claim([]string{"test"}, "worker-1", now, now.Add(-time.Hour))
+		require.NoError(t, err)
+
+		// WHEN
+		claimed, err := repo.Claim([]string{"test"}, "worker-2", now, now.Add(-time.Hour))
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Nil(t, claimed)
+	})
+
+	t.Run("Debe recuperar un trabajo running con el lease vencido", func(t *testing.T) {
+		// GIVEN: worker-1 reclamó el trabajo hace una hora y no dio señales
+		repo := job.NewRepository(newMemoryDB(t))
+		require.NoError(t, repo.Create(newJob("job-1", now.Add(-2*time.Hour))))
+		_, err := repo.Claim([]string{"test"}, "worker-1", now.Add(-time.Hour), now.Add(-2*time.Hour))
+		require.NoError(t, err)
+
+		// WHEN
+		claimed, err := repo.Claim([]string{"test"}, "worker-2", now, now.Add(-time.Minute))
+
+		// THEN
+		require.NoError(t, err)
+		require.NotNil(t, claimed)
+		assert.Equal(t, "worker-2", claimed.LockedBy)
+		assert.Equal(t, 2, claimed.Attempts)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Complete / Retry / Bury
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_Finish(t *testing.T) {
+	now := time.Now().UTC()
+
+	t.Run("Debe devolver a pendiente un trabajo reintentado con su nuevo RunAt", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := job.NewRepository(db)
+		require.NoError(t, repo.Create(newJob("job-1", now.Add(-time.Minute))))
+		_, err := repo.Claim([]string{"test"}, "worker-1", now, now.Add(-time.Hour))
+		require.NoError(t, err)
+
+		// WHEN
+		err = repo.Retry("job-1", "worker-1", "fallo temporal", now.Add(time.Minute))
+
+		// THEN
+		require.NoError(t, err)
+		var stored job.Job
+		require.NoError(t, db.First(&stored, "id = ?", "job-1").Error)
+		assert.Equal(t, job.StatusPending, stored.Status)
+		assert.Equal(t, "fallo temporal", stored.LastError)
+		assert.Empty(t, stored.LockedBy)
+		claimed, err := repo.Claim([]string{"test"}, "worker-1", now, now.Add(-time.Hour))
+		assert.NoError(t, err)
+		assert.Nil(t, claimed)
+	})
+
+	t.Run("No debe aplicar el resultado de un worker que perdió el lease", func(t *testing.T) {
+		// GIVEN: worker-2 recuperó el trabajo de worker-1
+		db := newMemoryDB(t)
+		repo := job.NewRepository(db)
+		require.NoError(t, repo.Create(newJob("job-1", now.Add(-2*time.Hour))))
+		_, err := repo.Claim([]string{"test"}, "worker-1", now.Add(-time.Hour), now.Add(-2*time.Hour))
+		require.NoError(t, err)
+		_, err = repo.Claim([]string{"test"}, "worker-2", now, now.Add(-time.Minute))
+		require.NoError(t, err)
+
+		// WHEN
+		err = repo.Bury("job-1", "worker-1", "tarde", now, events.New(events.JobFailed, "user-1", nil))
+
+		// THEN: ni el estado ni el evento se guardan
+		require.NoError(t, err)
+		var stored job.Job
+		require.NoError(t, db.First(&stored, "id = ?", "job-1").Error)
+		assert.Equal(t, job.StatusRunning, stored.Status)
+		assert.Equal(t, "worker-2", stored.LockedBy)
+		assert.Empty(t, outboxMessages(t, db))
+	})
+
+	t.Run("Debe marcar como terminado un trabajo completado", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := job.NewRepository(db)
+		require.NoError(t, repo.Create(newJob("job-1", now.Add(-time.Minute))))
+		_, err := repo.Claim([]string{"test"}, "worker-1", now, now.Add(-time.Hour))
+		require.NoError(t, err)
+
+		// WHEN
+		completed := events.New(events.JobCompleted, "user-1", nil)
+		err = repo.Complete("job-1", "worker-1", `{"file_ids":["f1"]}`, now, completed)
+
+		// THEN
+		require.NoError(t, err)
+		var stored job.Job
+		require.NoError(t, db.First(&stored, "id = ?", "job-1").Error)
+		assert.Equal(t, job.StatusSucceeded, stored.Status)
+		assert.Equal(t, 100, stored.Progress)
+		assert.NotNil(t, stored.FinishedAt)
+		messages := outboxMessages(t, db)
+		require.Len(t, messages, 1)
+		assert.Equal(t, completed.ID, messages[0].EventID)
+	})
+}
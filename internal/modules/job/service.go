@@ -0,0 +1,109 @@
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"image-processing-service/internal/shared/utils"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotFound = utils.NewError(404, "JOB_NOT_FOUND", "Trabajo no encontrado", nil)
+)
+
+const defaultMaxAttempts = 5
+
+type Service interface {
+	Enqueue(jobType string, userID string, payload interface{}) (*Job, error)
+	EnqueueWithID(id string, jobType string, userID string, payload interface{}) (*Job, error)
+	GetByID(id string, userID string) (*JobResponse, error)
+}
+
+// ServiceConfig agrupa las opciones con las que se encolan los trabajos.
+type ServiceConfig struct {
+	// MaxAttempts es el número de ejecuciones antes de mandar el trabajo a
+	// la cola de muertos. Por defecto 5.
+	MaxAttempts int
+}
+
+type service struct {
+	repo   Repository
+	config ServiceConfig
+}
+
+func NewService(r Repository, cfg ServiceConfig) Service {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	return &service{repo: r, config: cfg}
+}
+
+func (s *service) Enqueue(jobType string, userID string, payload interface{}) (*Job, error) {
+	return s.EnqueueWithID(utils.GenerateID(), jobType, userID, payload)
+}
+
+// EnqueueWithID encola el trabajo con un ID elegido por quien lo crea, para
+// poder referenciarlo antes de encolarlo.
+func (s *service) EnqueueWithID(id string, jobType string, userID string, payload interface{}) (*Job, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		ID:          id,
+		Type:        jobType,
+		UserID:      userID,
+		Payload:     string(raw),
+		Status:      StatusPending,
+		MaxAttempts: s.config.MaxAttempts,
+		RunAt:       time.Now().UTC(),
+	}
+
+	if err := s.repo.Create(job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+func (s *service) GetByID(id string, userID string) (*JobResponse, error) {
+	job, err := s.repo.FindByIDAndUserID(id, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return mapJobResponse(job), nil
+}
+
+func mapJobResponse(job *Job) *JobResponse {
+	res := &JobResponse{
+		ID:            job.ID,
+		Type:          job.Type,
+		Status:        job.Status,
+		Progress:      job.Progress,
+		Attempts:      job.Attempts,
+		MaxAttempts:   job.MaxAttempts,
+		ResultFileIDs: []string{},
+		CreatedAt:     job.CreatedAt,
+		UpdatedAt:     job.UpdatedAt,
+		FinishedAt:    job.FinishedAt,
+	}
+
+	if job.LastError != "" {
+		res.Error = utils.Pointer(job.LastError)
+	}
+
+	var result Result
+	if job.Result != "" && json.Unmarshal([]byte(job.Result), &result) == nil && result.FileIDs != nil {
+		res.ResultFileIDs = result.FileIDs
+	}
+
+	return res
+}
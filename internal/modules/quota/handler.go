@@ -0,0 +1,37 @@
+package quota
+
+import (
+	"net/http"
+
+	"image-processing-service/internal/shared/auth"
+	"image-processing-service/internal/shared/utils"
+)
+
+type Handler interface {
+	GetMine(w http.ResponseWriter, r *http.Request)
+}
+
+type handler struct {
+	service Service
+}
+
+func NewHandler(s Service) Handler {
+	return &handler{service: s}
+}
+
+func (h *handler) GetMine(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	if !utils.IsValidID(authUser.UserID) {
+		utils.HandleError(w, utils.ErrInvalidIDFormat)
+		return
+	}
+
+	usage, err := h.service.GetUsage(authUser.UserID)
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, usage)
+}
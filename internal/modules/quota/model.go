@@ -0,0 +1,29 @@
+package quota
+
+import "time"
+
+// Usage acumula el espacio ocupado por un usuario: bytes de originales más
+// derivadas y número de archivos. MaxBytes y MaxFiles permiten fijar un
+// límite propio (por ejemplo, según el plan); si son nil se aplica el
+// límite global.
+type Usage struct {
+	UserID    string    `gorm:"primaryKey;size=24" json:"user_id"`
+	Bytes     int64     `gorm:"not null;default:0" json:"bytes"`
+	Files     int64     `gorm:"not null;default:0" json:"files"`
+	MaxBytes  *int64    `json:"max_bytes"`
+	MaxFiles  *int64    `json:"max_files"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Limits son los límites globales. Cero significa sin límite.
+type Limits struct {
+	MaxBytes int64
+	MaxFiles int64
+}
+
+type UsageResponse struct {
+	UsedBytes int64  `json:"used_bytes"`
+	UsedFiles int64  `json:"used_files"`
+	MaxBytes  *int64 `json:"max_bytes"`
+	MaxFiles  *int64 `json:"max_files"`
+}
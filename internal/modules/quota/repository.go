@@ -0,0 +1,94 @@
+package quota
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Repository interface {
+	Reserve(userID string, bytes int64, limits Limits) (bool, error)
+	Release(userID string, bytes int64) error
+	Charge(userID string, bytes int64) error
+	FindByUserID(userID string) (*Usage, error)
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+// Reserve suma bytes y un archivo al uso del usuario solo si el resultado no
+// supera sus límites. La comprobación y el incremento son un único UPDATE,
+// así que dos subidas concurrentes no pueden superar juntas la cuota.
+// Devuelve false si no hay espacio.
+func (r *repository) Reserve(userID string, bytes int64, limits Limits) (bool, error) {
+	if err := r.ensure(userID); err != nil {
+		return false, err
+	}
+
+	result := r.db.Model(&Usage{}).
+		Where("user_id = ?", userID).
+		Where("(COALESCE(max_bytes, ?) <= 0 OR bytes + ? <= COALESCE(max_bytes, ?))", limits.MaxBytes, bytes, limits.MaxBytes).
+		Where("(COALESCE(max_files, ?) <= 0 OR files + 1 <= COALESCE(max_files, ?))", limits.MaxFiles, limits.MaxFiles).
+		UpdateColumns(map[string]interface{}{
+			"bytes":      gorm.Expr("bytes + ?", bytes),
+			"files":      gorm.Expr("files + 1"),
+			"updated_at": gorm.Expr("CURRENT_TIMESTAMP"),
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// Release devuelve el espacio de un archivo eliminado. Nunca deja el uso en
+// negativo, aunque el archivo se hubiera subido antes de llevar la cuenta.
+func (r *repository) Release(userID string, bytes int64) error {
+	return r.db.Model(&Usage{}).
+		Where("user_id = ?", userID).
+		UpdateColumns(map[string]interface{}{
+			"bytes":      gorm.Expr("CASE WHEN bytes > ? THEN bytes - ? ELSE 0 END", bytes, bytes),
+			"files":      gorm.Expr("CASE WHEN files > 0 THEN files - 1 ELSE 0 END"),
+			"updated_at": gorm.Expr("CURRENT_TIMESTAMP"),
+		}).Error
+}
+
+// Charge suma bytes sin comprobar límites ni contar archivos. Se usa para
+// las derivadas que se generan después de aceptar la subida: el archivo ya
+// ocupa su hueco en la cuota.
+func (r *repository) Charge(userID string, bytes int64) error {
+	if err := r.ensure(userID); err != nil {
+		return err
+	}
+
+	return r.db.Model(&Usage{}).
+		Where("user_id = ?", userID).
+		UpdateColumns(map[string]interface{}{
+			"bytes":      gorm.Expr("CASE WHEN bytes + ? > 0 THEN bytes + ? ELSE 0 END", bytes, bytes),
+			"updated_at": gorm.Expr("CURRENT_TIMESTAMP"),
+		}).Error
+}
+
+// FindByUserID devuelve el uso del usuario, o un uso vacío si todavía no
+// ha subido nada.
+func (r *repository) FindByUserID(userID string) (*Usage, error) {
+	if err := r.ensure(userID); err != nil {
+		return nil, err
+	}
+
+	var usage Usage
+	if err := r.db.Where("user_id = ?", userID).First(&usage).Error; err != nil {
+		return nil, err
+	}
+
+	return &usage, nil
+}
+
+func (r *repository) ensure(userID string) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&Usage{UserID: userID}).Error
+}
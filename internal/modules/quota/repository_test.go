@@ -0,0 +1,161 @@
+package quota_test
+
+// Los tests de repositorio usan SQLite en memoria para ejecutar el UPDATE
+// condicional real con el que se reserva espacio.
+
+import (
+	"image-processing-service/internal/modules/quota"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newMemoryDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err, "no se pudo abrir la base de datos en memoria")
+
+	err = db.AutoMigrate(&quota.Usage{})
+	require.NoError(t, err, "no se pudo migrar el esquema")
+
+	return db
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Reserve
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_Reserve(t *testing.T) {
+	t.Run("Debe crear el uso y sumar bytes y archivo en la primera reserva", func(t *testing.T) {
+		// GIVEN
+		repo := quota.NewRepository(newMemoryDB(t))
+
+		// WHEN
+		ok, err := repo.Reserve("user-1", 100, quota.Limits{})
+
+		// THEN
+		require.NoError(t, err)
+		assert.True(t, ok)
+		usage, err := repo.FindByUserID("user-1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(100), usage.Bytes)
+		assert.Equal(t, int64(1), usage.Files)
+	})
+
+	t.Run("Debe rechazar la reserva que supera el límite de bytes sin modificar el uso", func(t *testing.T) {
+		// GIVEN
+		repo := quota.NewRepository(newMemoryDB(t))
+		limits := quota.Limits{MaxBytes: 150}
+		_, err := repo.Reserve("user-1", 100, limits)
+		require.NoError(t, err)
+
+		// WHEN
+		ok, err := repo.Reserve("user-1", 60, limits)
+
+		// THEN
+		require.NoError(t, err)
+		assert.False(t, ok)
+		usage, _ := repo.FindByUserID("user-1")
+		assert.Equal(t, int64(100), usage.Bytes)
+		assert.Equal(t, int64(1), usage.Files)
+	})
+
+	t.Run("Debe rechazar la reserva que supera el límite de archivos", func(t *testing.T) {
+		// GIVEN
+		repo := quota.NewRepository(newMemoryDB(t))
+		limits := quota.Limits{MaxFiles: 1}
+		_, err := repo.Reserve("user-1", 10, limits)
+		require.NoError(t, err)
+
+		// WHEN
+		ok, err := repo.Reserve("user-1", 10, limits)
+
+		// THEN
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("Debe aplicar el límite propio del usuario antes que el global", func(t *testing.T) {
+		// GIVEN: el usuario tiene un límite mayor que el global
+		db := newMemoryDB(t)
+		repo := quota.NewRepository(db)
+		require.NoError(t, db.Create(&quota.Usage{UserID: "user-1", MaxBytes: ptr(1000)}).Error)
+
+		// WHEN
+		ok, err := repo.Reserve("user-1", 500, quota.Limits{MaxBytes: 100})
+
+		// THEN
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Release
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_Release(t *testing.T) {
+	t.Run("Debe restar bytes y un archivo", func(t *testing.T) {
+		// GIVEN
+		repo := quota.NewRepository(newMemoryDB(t))
+		_, err := repo.Reserve("user-1", 100, quota.Limits{})
+		require.NoError(t, err)
+		_, err = repo.Reserve("user-1", 50, quota.Limits{})
+		require.NoError(t, err)
+
+		// WHEN
+		err = repo.Release("user-1", 100)
+
+		// THEN
+		require.NoError(t, err)
+		usage, _ := repo.FindByUserID("user-1")
+		assert.Equal(t, int64(50), usage.Bytes)
+		assert.Equal(t, int64(1), usage.Files)
+	})
+
+	t.Run("Debe dejar el uso en cero en lugar de negativo", func(t *testing.T) {
+		// GIVEN: un archivo anterior a la cuota que nunca se contabilizó
+		repo := quota.NewRepository(newMemoryDB(t))
+		_, err := repo.Reserve("user-1", 10, quota.Limits{})
+		require.NoError(t, err)
+
+		// WHEN
+		require.NoError(t, repo.Release("user-1", 10))
+		err = repo.Release("user-1", 500)
+
+		// THEN
+		require.NoError(t, err)
+		usage, _ := repo.FindByUserID("user-1")
+		assert.Zero(t, usage.Bytes)
+		assert.Zero(t, usage.Files)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Charge
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_Charge(t *testing.T) {
+	t.Run("Debe sumar bytes sin contar un archivo ni comprobar límites", func(t *testing.T) {
+		// GIVEN: el usuario ya está en su límite
+		repo := quota.NewRepository(newMemoryDB(t))
+		limits := quota.Limits{MaxBytes: 100}
+		_, err := repo.Reserve("user-1", 100, limits)
+		require.NoError(t, err)
+
+		// WHEN: se genera la miniatura de su archivo
+		err = repo.Charge("user-1", 20)
+
+		// THEN
+		require.NoError(t, err)
+		usage, _ := repo.FindByUserID("user-1")
+		assert.Equal(t, int64(120), usage.Bytes)
+		assert.Equal(t, int64(1), usage.Files)
+	})
+}
+
+func ptr(v int64) *int64 { return &v }
@@ -0,0 +1,74 @@
+package quota
+
+import (
+	"image-processing-service/internal/shared/utils"
+)
+
+var (
+	ErrQuotaExceeded = utils.NewError(403, "QUOTA_EXCEEDED", "Has alcanzado el límite de almacenamiento de tu plan", nil)
+)
+
+type Service interface {
+	Reserve(userID string, bytes int64) error
+	Release(userID string, bytes int64) error
+	Charge(userID string, bytes int64) error
+	GetUsage(userID string) (*UsageResponse, error)
+}
+
+type service struct {
+	repo   Repository
+	limits Limits
+}
+
+func NewService(r Repository, limits Limits) Service {
+	return &service{repo: r, limits: limits}
+}
+
+func (s *service) Reserve(userID string, bytes int64) error {
+	ok, err := s.repo.Reserve(userID, bytes, s.limits)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrQuotaExceeded
+	}
+
+	return nil
+}
+
+func (s *service) Release(userID string, bytes int64) error {
+	return s.repo.Release(userID, bytes)
+}
+
+func (s *service) Charge(userID string, bytes int64) error {
+	return s.repo.Charge(userID, bytes)
+}
+
+func (s *service) GetUsage(userID string) (*UsageResponse, error) {
+	usage, err := s.repo.FindByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UsageResponse{
+		UsedBytes: usage.Bytes,
+		UsedFiles: usage.Files,
+		MaxBytes:  effectiveLimit(usage.MaxBytes, s.limits.MaxBytes),
+		MaxFiles:  effectiveLimit(usage.MaxFiles, s.limits.MaxFiles),
+	}, nil
+}
+
+// effectiveLimit devuelve el límite que aplica al usuario, o nil si no tiene.
+func effectiveLimit(override *int64, global int64) *int64 {
+	limit := global
+	if override != nil {
+		limit = *override
+	}
+
+	if limit <= 0 {
+		return nil
+	}
+
+	return utils.Pointer(limit)
+}
@@ -0,0 +1,120 @@
+package quota_test
+
+import (
+	"errors"
+	"image-processing-service/internal/modules/quota"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Mock del repositorio
+// ─────────────────────────────────────────────────────────────────────────────
+
+type mockRepo struct {
+	ReserveFn      func(userID string, bytes int64, limits quota.Limits) (bool, error)
+	ReleaseFn      func(userID string, bytes int64) error
+	ChargeFn       func(userID string, bytes int64) error
+	FindByUserIDFn func(userID string) (*quota.Usage, error)
+}
+
+func (m *mockRepo) Reserve(userID string, bytes int64, limits quota.Limits) (bool, error) {
+	return m.ReserveFn(userID, bytes, limits)
+}
+func (m *mockRepo) Release(userID string, bytes int64) error { return m.ReleaseFn(userID, bytes) }
+func (m *mockRepo) Charge(userID string, bytes int64) error  { return m.ChargeFn(userID, bytes) }
+func (m *mockRepo) FindByUserID(userID string) (*quota.Usage, error) {
+	return m.FindByUserIDFn(userID)
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Reserve
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestService_Reserve(t *testing.T) {
+	repo := &mockRepo{}
+	limits := quota.Limits{MaxBytes: 1024, MaxFiles: 10}
+	service := quota.NewService(repo, limits)
+
+	t.Run("Debe pasar los límites globales al repositorio", func(t *testing.T) {
+		// GIVEN
+		var received quota.Limits
+		repo.ReserveFn = func(userID string, bytes int64, l quota.Limits) (bool, error) {
+			received = l
+			return true, nil
+		}
+
+		// WHEN
+		err := service.Reserve("user-1", 100)
+
+		// THEN
+		assert.NoError(t, err)
+		assert.Equal(t, limits, received)
+	})
+
+	t.Run("Debe retornar ErrQuotaExceeded cuando no hay espacio", func(t *testing.T) {
+		// GIVEN
+		repo.ReserveFn = func(string, int64, quota.Limits) (bool, error) { return false, nil }
+
+		// WHEN
+		err := service.Reserve("user-1", 100)
+
+		// THEN
+		assert.ErrorIs(t, err, quota.ErrQuotaExceeded)
+	})
+
+	t.Run("Debe propagar el error del repositorio", func(t *testing.T) {
+		// GIVEN
+		dbErr := errors.New("connection refused")
+		repo.ReserveFn = func(string, int64, quota.Limits) (bool, error) { return false, dbErr }
+
+		// WHEN
+		err := service.Reserve("user-1", 100)
+
+		// THEN
+		assert.ErrorIs(t, err, dbErr)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// GetUsage
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestService_GetUsage(t *testing.T) {
+	t.Run("Debe devolver límites nulos cuando no hay cuota", func(t *testing.T) {
+		// GIVEN
+		repo := &mockRepo{FindByUserIDFn: func(userID string) (*quota.Usage, error) {
+			return &quota.Usage{UserID: userID, Bytes: 10, Files: 1}, nil
+		}}
+		service := quota.NewService(repo, quota.Limits{})
+
+		// WHEN
+		usage, err := service.GetUsage("user-1")
+
+		// THEN
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), usage.UsedBytes)
+		assert.Nil(t, usage.MaxBytes)
+		assert.Nil(t, usage.MaxFiles)
+	})
+
+	t.Run("Debe preferir el límite propio del usuario", func(t *testing.T) {
+		// GIVEN
+		repo := &mockRepo{FindByUserIDFn: func(userID string) (*quota.Usage, error) {
+			return &quota.Usage{UserID: userID, MaxBytes: ptr(5000)}, nil
+		}}
+		service := quota.NewService(repo, quota.Limits{MaxBytes: 100, MaxFiles: 3})
+
+		// WHEN
+		usage, err := service.GetUsage("user-1")
+
+		// THEN
+		require.NoError(t, err)
+		require.NotNil(t, usage.MaxBytes)
+		assert.Equal(t, int64(5000), *usage.MaxBytes)
+		require.NotNil(t, usage.MaxFiles)
+		assert.Equal(t, int64(3), *usage.MaxFiles)
+	})
+}
@@ -0,0 +1,170 @@
+package session
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCacheSize es cuántas sesiones guarda la caché como mucho.
+	DefaultCacheSize = 10000
+	// DefaultCacheTTL es cuánto se reutiliza una sesión leída de la base de
+	// datos. Sin Invalidator es también lo que puede tardar una revocación
+	// en llegar a las demás instancias.
+	DefaultCacheTTL = 10 * time.Second
+)
+
+// CacheConfig configura la caché de sesiones válidas. Los valores a cero
+// toman los por defecto.
+type CacheConfig struct {
+	Size int
+	TTL  time.Duration
+}
+
+type cacheEntry struct {
+	session   Session
+	expiresAt time.Time
+}
+
+// cache es una LRU con caducidad de las sesiones válidas por el jti de su
+// access token, para no consultar la base de datos en cada petición
+// autenticada. Se puede invalidar por jti, por sesión o por usuario.
+type cache struct {
+	size int
+	ttl  time.Duration
+
+	mu sync.Mutex
+	// generation cuenta las invalidaciones. Una sesión leída de la base de
+	// datos antes de una invalidación no se guarda: podría ser la revocada.
+	generation uint64
+	order      *list.List
+	entries    map[string]*list.Element
+	bySession  map[string]string
+	byUser     map[string]map[string]struct{}
+}
+
+func newCache(cfg CacheConfig) *cache {
+	if cfg.Size <= 0 {
+		cfg.Size = DefaultCacheSize
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultCacheTTL
+	}
+
+	return &cache{
+		size:      cfg.Size,
+		ttl:       cfg.TTL,
+		order:     list.New(),
+		entries:   make(map[string]*list.Element),
+		bySession: make(map[string]string),
+		byUser:    make(map[string]map[string]struct{}),
+	}
+}
+
+// get devuelve una copia de la sesión del jti si está y no ha caducado.
+func (c *cache) get(jti string, now time.Time) (*Session, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[jti]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if !now.Before(entry.expiresAt) {
+		c.remove(elem)
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	sess := entry.session
+	return &sess, true
+}
+
+// currentGeneration se lee antes de consultar la base de datos y se pasa
+// a add.
+func (c *cache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// add guarda la sesión hasta que pase el TTL o caduque la sesión, lo que
+// antes ocurra. No hace nada si ha habido invalidaciones desde generation.
+func (c *cache) add(sess *Session, generation uint64, now time.Time) {
+	expiresAt := now.Add(c.ttl)
+	if sess.ExpiresAt.Before(expiresAt) {
+		expiresAt = sess.ExpiresAt
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if elem, ok := c.entries[sess.AccessJti]; ok {
+		c.remove(elem)
+	}
+	// Una sesión solo tiene un access token válido: el del jti anterior ya
+	// no sirve.
+	if jti, ok := c.bySession[sess.ID]; ok {
+		c.remove(c.entries[jti])
+	}
+
+	elem := c.order.PushFront(&cacheEntry{session: *sess, expiresAt: expiresAt})
+	c.entries[sess.AccessJti] = elem
+	c.bySession[sess.ID] = sess.AccessJti
+	if c.byUser[sess.UserID] == nil {
+		c.byUser[sess.UserID] = make(map[string]struct{})
+	}
+	c.byUser[sess.UserID][sess.AccessJti] = struct{}{}
+
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// touched anota el último uso de la sesión del jti para que Touch no vuelva
+// a escribirlo antes de tiempo.
+func (c *cache) touched(jti string, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[jti]; ok {
+		elem.Value.(*cacheEntry).session.LastUsedAt = &at
+	}
+}
+
+// invalidate borra las sesiones que coinciden con cualquiera de los campos
+// no vacíos.
+func (c *cache) invalidate(inv Invalidation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if elem, ok := c.entries[inv.JTI]; ok {
+		c.remove(elem)
+	}
+	if jti, ok := c.bySession[inv.SessionID]; ok {
+		c.remove(c.entries[jti])
+	}
+	for jti := range c.byUser[inv.UserID] {
+		c.remove(c.entries[jti])
+	}
+}
+
+func (c *cache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*cacheEntry)
+	sess := entry.session
+
+	delete(c.entries, sess.AccessJti)
+	delete(c.bySession, sess.ID)
+	delete(c.byUser[sess.UserID], sess.AccessJti)
+	if len(c.byUser[sess.UserID]) == 0 {
+		delete(c.byUser, sess.UserID)
+	}
+}
@@ -0,0 +1,76 @@
+package session
+
+import "strings"
+
+// maxDeviceLabel es la longitud máxima de DeviceLabel.
+const maxDeviceLabel = 100
+
+// DeviceLabel devuelve name recortado si el cliente lo envió o, si no, una
+// descripción legible del user agent, como "Chrome en macOS". No pretende
+// identificar el dispositivo con exactitud, solo que el usuario lo
+// reconozca en la lista de sesiones.
+func DeviceLabel(name, userAgent string) string {
+	if name = strings.TrimSpace(name); name != "" {
+		return truncate(name, maxDeviceLabel)
+	}
+
+	browser := detect(userAgent, browsers)
+	system := detect(userAgent, systems)
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " en " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	default:
+		return "Dispositivo desconocido"
+	}
+}
+
+type pattern struct {
+	token string
+	name  string
+}
+
+// El orden importa: Edge y Opera incluyen "Chrome" en su user agent, y
+// Chrome incluye "Safari".
+var browsers = []pattern{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Firefox/", "Firefox"},
+	{"Chrome/", "Chrome"},
+	{"CriOS/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"curl/", "curl"},
+	{"PostmanRuntime/", "Postman"},
+	{"Go-http-client/", "Cliente Go"},
+}
+
+// Android incluye "Linux" e iOS incluye "Mac OS X".
+var systems = []pattern{
+	{"Android", "Android"},
+	{"iPhone", "iOS"},
+	{"iPad", "iPadOS"},
+	{"Windows", "Windows"},
+	{"Mac OS X", "macOS"},
+	{"Linux", "Linux"},
+}
+
+func detect(userAgent string, patterns []pattern) string {
+	for _, p := range patterns {
+		if strings.Contains(userAgent, p.token) {
+			return p.name
+		}
+	}
+	return ""
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max])
+}
@@ -0,0 +1,47 @@
+package session_test
+
+import (
+	"image-processing-service/internal/modules/session"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeviceLabel(t *testing.T) {
+	t.Run("Debe usar el nombre enviado por el cliente", func(t *testing.T) {
+		// WHEN
+		label := session.DeviceLabel("  Portátil del trabajo ", "curl/8.0")
+
+		// THEN
+		assert.Equal(t, "Portátil del trabajo", label)
+	})
+
+	t.Run("Debe recortar los nombres demasiado largos", func(t *testing.T) {
+		// WHEN
+		label := session.DeviceLabel(strings.Repeat("ñ", 150), "")
+
+		// THEN
+		assert.Equal(t, 100, len([]rune(label)))
+	})
+
+	t.Run("Debe describir el navegador y el sistema del user agent", func(t *testing.T) {
+		cases := map[string]string{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0 Safari/537.36":         "Chrome en macOS",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0 Safari/537.36 Edg/129.0":     "Edge en Windows",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/604.1": "Safari en iOS",
+			"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0 Mobile Safari/537.36":                  "Chrome en Android",
+			"Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0":                                                    "Firefox en Linux",
+			"curl/8.4.0": "curl",
+			"":           "Dispositivo desconocido",
+		}
+
+		for userAgent, want := range cases {
+			// WHEN
+			label := session.DeviceLabel("", userAgent)
+
+			// THEN
+			assert.Equal(t, want, label, userAgent)
+		}
+	})
+}
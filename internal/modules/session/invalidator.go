@@ -0,0 +1,76 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Invalidation identifica las sesiones a borrar de la caché: las que
+// coinciden con cualquiera de los campos no vacíos.
+type Invalidation struct {
+	JTI       string `json:"jti,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	UserID    string `json:"user_id,omitempty"`
+}
+
+// Invalidator reparte las invalidaciones entre instancias para que una
+// sesión revocada en una deje de aceptarse en todas sin esperar al TTL de
+// la caché. Subscribe entrega a handle las de todas las instancias,
+// incluida la propia.
+type Invalidator interface {
+	Publish(ctx context.Context, inv Invalidation) error
+	Subscribe(ctx context.Context, handle func(Invalidation))
+}
+
+// invalidationChannel es el canal de Redis por el que se publican.
+const invalidationChannel = "sessions:invalidate"
+
+// RedisInvalidator publica las invalidaciones por pub/sub de Redis. Los
+// mensajes que se pierdan mientras una instancia está desconectada los
+// cubre el TTL de la caché.
+type RedisInvalidator struct {
+	client *redis.Client
+}
+
+func NewRedisInvalidator(client *redis.Client) *RedisInvalidator {
+	return &RedisInvalidator{client: client}
+}
+
+func (i *RedisInvalidator) Publish(ctx context.Context, inv Invalidation) error {
+	payload, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	return i.client.Publish(ctx, invalidationChannel, payload).Err()
+}
+
+// Subscribe escucha el canal hasta que se cancele ctx. go-redis se vuelve
+// a conectar solo si se pierde la conexión.
+func (i *RedisInvalidator) Subscribe(ctx context.Context, handle func(Invalidation)) {
+	pubsub := i.client.Subscribe(ctx, invalidationChannel)
+
+	go func() {
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var inv Invalidation
+				if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+					log.Printf("Invalidación de sesión no válida: %v", err)
+					continue
+				}
+				handle(inv)
+			}
+		}
+	}()
+}
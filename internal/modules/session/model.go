@@ -5,26 +5,67 @@ import (
 	"time"
 )
 
+// Session es un inicio de sesión: un par de tokens en un dispositivo.
+// UserAgent, IP y DeviceLabel se guardan al iniciar sesión para que el
+// usuario reconozca sus dispositivos; LastUsedAt se actualiza con el uso.
+//
+// Cada sesión es además una familia de refresh tokens: al renovarla, el
+// refresh token presentado pasa a RotatedToken y solo el nuevo es válido.
 type Session struct {
-	ID        string    `gorm:"primaryKey;size=24" json:"id"`
-	TokenHash string    `gorm:"not null;" json:"token_hash"`
-	AccessJti string    `gorm:"not null;" json:"accessJti"`
-	UserID    string    `gorm:"not null;" json:"user_id"`
-	User      user.User `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
-	ExpiresAt time.Time `gorm:"not null;" json:"expires_at"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          string     `gorm:"primaryKey;size=24" json:"id"`
+	TokenHash   string     `gorm:"not null;uniqueIndex" json:"token_hash"`
+	AccessJti   string     `gorm:"not null;uniqueIndex" json:"accessJti"`
+	UserID      string     `gorm:"not null;index" json:"user_id"`
+	User        user.User  `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	UserAgent   string     `gorm:"type:text" json:"user_agent"`
+	IP          string     `gorm:"size:45" json:"ip"`
+	DeviceLabel string     `gorm:"size:100" json:"device_label"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	ExpiresAt   time.Time  `gorm:"not null;index" json:"expires_at"`
+	CreatedAt   time.Time  `json:"created_at"`
 }
 
 type CreateSessionRequest struct {
-	TokenHash string    `json:"token_hash"`
-	AccessJti string    `json:"accessJti"`
-	UserID    string    `json:"user_id"`
-	ExpiresAt time.Time `json:"created_at"`
+	TokenHash   string    `json:"token_hash"`
+	AccessJti   string    `json:"accessJti"`
+	UserID      string    `json:"user_id"`
+	UserAgent   string    `json:"user_agent"`
+	IP          string    `json:"ip"`
+	DeviceLabel string    `json:"device_label"`
+	ExpiresAt   time.Time `json:"created_at"`
 }
 
+// RotatedToken es un refresh token de la familia SessionID que ya se cambió
+// por otro. Si alguien lo vuelve a presentar, el token lo tienen dos partes
+// y la familia entera se revoca. Se guarda hasta ExpiresAt, la caducidad
+// de la sesión en el momento de rotarlo.
+type RotatedToken struct {
+	TokenHash string    `gorm:"primaryKey;size:64"`
+	SessionID string    `gorm:"size:24;not null;index"`
+	RotatedAt time.Time `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+func (RotatedToken) TableName() string {
+	return "rotated_refresh_tokens"
+}
+
+// ReusedEventData es el payload de events.RefreshTokenReused: la sesión
+// revocada y cuándo se había rotado el token reutilizado.
+type ReusedEventData struct {
+	SessionID   string    `json:"session_id"`
+	DeviceLabel string    `json:"device_label"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	RotatedAt   time.Time `json:"rotated_at"`
+}
+
+// UpdateSessionRequest rota el refresh token de la sesión: CurrentTokenHash
+// es el hash del que se presenta y NewTokenHash el nuevo token en claro.
 type UpdateSessionRequest struct {
-	SessionID    string    `json:"session_id"`
-	NewTokenHash string    `json:"new_token_hash"`
-	NewAccessJti string    `json:"new_access_jti"`
-	ExpiresAt    time.Time `json:"expires_at"`
+	SessionID        string    `json:"session_id"`
+	CurrentTokenHash string    `json:"current_token_hash"`
+	NewTokenHash     string    `json:"new_token_hash"`
+	NewAccessJti     string    `json:"new_access_jti"`
+	ExpiresAt        time.Time `json:"expires_at"`
 }
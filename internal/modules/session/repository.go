@@ -1,12 +1,30 @@
 package session
 
-import "gorm.io/gorm"
+import (
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/outbox"
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Repository interface {
 	Create(session *Session) error
-	FindByOne(tokenHash string) (*Session, error)
-	Update(session *Session) error
+	FindByTokenHash(tokenHash string) (*Session, error)
+	FindByAccessJti(jti string) (*Session, error)
+	FindByID(id string) (*Session, error)
+	FindActiveByUserID(userID string, now time.Time) ([]Session, error)
+	Rotate(session *Session, rotated *RotatedToken) error
+	FindRotated(tokenHash string) (*RotatedToken, error)
+	DeleteFamily(id string, evts ...events.Event) error
+	DeleteRotatedBefore(t time.Time) (int64, error)
+	DeleteExpired(before time.Time, limit int) (int64, error)
+	DeleteOldest(userID string, keep int, now time.Time) ([]Session, error)
+	Touch(id string, now, staleBefore time.Time) error
 	Delete(jti string) error
+	DeleteByID(id, userID string) error
+	DeleteByUserID(userID string) error
+	DeleteByUserIDExcept(userID, jti string) error
 }
 
 type repository struct {
@@ -34,20 +52,178 @@ func (r *repository) Delete(jti string) error {
 	return nil
 }
 
-func (r *repository) FindByOne(data string) (*Session, error) {
+// FindByTokenHash busca la sesión por el hash de su refresh token actual.
+func (r *repository) FindByTokenHash(tokenHash string) (*Session, error) {
+	var session Session
+
+	if err := r.db.First(&session, "token_hash = ?", tokenHash).Error; err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// FindByAccessJti busca la sesión por el jti de su access token actual.
+func (r *repository) FindByAccessJti(jti string) (*Session, error) {
+	var session Session
+
+	if err := r.db.First(&session, "access_jti = ?", jti).Error; err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (r *repository) FindByID(id string) (*Session, error) {
 	var session Session
 
-	if err := r.db.Where("token_hash = ?", data).
-		Or("access_jti = ?", data).
-		First(&session).Error; err != nil {
+	if err := r.db.First(&session, "id = ?", id).Error; err != nil {
 		return nil, err
 	}
 
 	return &session, nil
 }
 
-func (r *repository) Update(session *Session) error {
-	return r.db.Model(session).
-		Select("token_hash", "access_jti", "expires_at").
-		Updates(session).Error
+// Rotate guarda los tokens nuevos de la sesión y archiva el anterior,
+// rotated.TokenHash, en la misma transacción. Solo actualiza la sesión si
+// ese sigue siendo su refresh token actual: si otra renovación se adelantó
+// devuelve gorm.ErrRecordNotFound.
+func (r *repository) Rotate(session *Session, rotated *RotatedToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(session).
+			Where("token_hash = ?", rotated.TokenHash).
+			Select("token_hash", "access_jti", "expires_at", "last_used_at").
+			Updates(session)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return tx.Create(rotated).Error
+	})
+}
+
+func (r *repository) FindRotated(tokenHash string) (*RotatedToken, error) {
+	var rotated RotatedToken
+
+	if err := r.db.First(&rotated, "token_hash = ?", tokenHash).Error; err != nil {
+		return nil, err
+	}
+
+	return &rotated, nil
+}
+
+// DeleteFamily revoca la sesión id y escribe evts en el outbox. Los tokens
+// rotados de la familia se conservan hasta caducar. Si la sesión ya no
+// existe devuelve gorm.ErrRecordNotFound sin escribir los eventos.
+func (r *repository) DeleteFamily(id string, evts ...events.Event) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&Session{}, "id = ?", id)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return outbox.Write(tx, evts...)
+	})
+}
+
+// DeleteRotatedBefore borra los tokens rotados que caducaron antes de t:
+// ya no se aceptarían aunque fueran los actuales.
+func (r *repository) DeleteRotatedBefore(t time.Time) (int64, error) {
+	result := r.db.Delete(&RotatedToken{}, "expires_at < ?", t)
+	return result.RowsAffected, result.Error
+}
+
+// DeleteExpired borra como mucho limit sesiones que caducaron antes de
+// before, para no bloquear la tabla con un único DELETE enorme.
+func (r *repository) DeleteExpired(before time.Time, limit int) (int64, error) {
+	expired := r.db.Model(&Session{}).
+		Select("id").
+		Where("expires_at < ?", before).
+		Limit(limit)
+
+	result := r.db.Where("id IN (?)", expired).Delete(&Session{})
+	return result.RowsAffected, result.Error
+}
+
+// DeleteOldest deja al usuario sus keep sesiones activas más recientes y
+// devuelve las que borra. Las caducadas no cuentan.
+func (r *repository) DeleteOldest(userID string, keep int, now time.Time) ([]Session, error) {
+	var evicted []Session
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND expires_at > ?", userID, now).
+			Order("created_at DESC, id DESC").
+			Offset(keep).
+			Find(&evicted).Error
+		if err != nil || len(evicted) == 0 {
+			return err
+		}
+
+		ids := make([]string, 0, len(evicted))
+		for _, sess := range evicted {
+			ids = append(ids, sess.ID)
+		}
+		return tx.Delete(&Session{}, "id IN ?", ids).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return evicted, nil
+}
+
+func (r *repository) DeleteByUserID(userID string) error {
+	return r.db.Delete(&Session{}, "user_id = ?", userID).Error
+}
+
+// FindActiveByUserID devuelve las sesiones sin caducar del usuario, de la
+// usada más recientemente a la menos.
+func (r *repository) FindActiveByUserID(userID string, now time.Time) ([]Session, error) {
+	var sessions []Session
+
+	err := r.db.Where("user_id = ? AND expires_at > ?", userID, now).
+		Order("COALESCE(last_used_at, created_at) DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Touch actualiza LastUsedAt solo si es anterior a staleBefore, para no
+// escribir en cada petición.
+func (r *repository) Touch(id string, now, staleBefore time.Time) error {
+	return r.db.Model(&Session{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, staleBefore).
+		Update("last_used_at", now).Error
+}
+
+// DeleteByID borra la sesión id si es del usuario. Devuelve
+// gorm.ErrRecordNotFound si no existe o es de otro.
+func (r *repository) DeleteByID(id, userID string) error {
+	result := r.db.Delete(&Session{}, "id = ? AND user_id = ?", id, userID)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteByUserIDExcept borra todas las sesiones del usuario salvo la del
+// access token jti.
+func (r *repository) DeleteByUserIDExcept(userID, jti string) error {
+	return r.db.Delete(&Session{}, "user_id = ? AND access_jti <> ?", userID, jti).Error
 }
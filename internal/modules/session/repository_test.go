@@ -0,0 +1,253 @@
+package session_test
+
+// Los tests de repositorio usan SQLite en memoria para comprobar la rotación
+// condicional y que la revocación de la familia escribe en el outbox dentro
+// de la misma transacción.
+
+import (
+	"image-processing-service/internal/modules/session"
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/outbox"
+	"image-processing-service/internal/shared/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newMemoryDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err, "no se pudo abrir la base de datos en memoria")
+
+	err = db.AutoMigrate(&session.Session{}, &session.RotatedToken{}, &outbox.Message{})
+	require.NoError(t, err, "no se pudo migrar el esquema")
+
+	return db
+}
+
+var base = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newSession(t *testing.T, repo session.Repository, tokenHash string) *session.Session {
+	t.Helper()
+
+	sess := &session.Session{
+		ID:        utils.GenerateID(),
+		TokenHash: tokenHash,
+		AccessJti: utils.GenerateID(),
+		UserID:    utils.GenerateID(),
+		ExpiresAt: base.Add(time.Hour),
+	}
+	require.NoError(t, repo.Create(sess))
+	return sess
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Rotate
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_Rotate(t *testing.T) {
+	t.Run("Debe cambiar el token de la sesión y archivar el anterior", func(t *testing.T) {
+		// GIVEN
+		repo := session.NewRepository(newMemoryDB(t))
+		sess := newSession(t, repo, "hash-1")
+
+		// WHEN
+		err := repo.Rotate(
+			&session.Session{ID: sess.ID, TokenHash: "hash-2", AccessJti: "jti-2", ExpiresAt: base.Add(2 * time.Hour)},
+			&session.RotatedToken{TokenHash: "hash-1", SessionID: sess.ID, RotatedAt: base, ExpiresAt: base.Add(2 * time.Hour)},
+		)
+
+		// THEN
+		require.NoError(t, err)
+		current, err := repo.FindByTokenHash("hash-2")
+		require.NoError(t, err)
+		assert.Equal(t, sess.ID, current.ID)
+		rotated, err := repo.FindRotated("hash-1")
+		require.NoError(t, err)
+		assert.Equal(t, sess.ID, rotated.SessionID)
+	})
+
+	t.Run("Debe fallar sin archivar nada cuando el token ya no es el actual", func(t *testing.T) {
+		// GIVEN: otra renovación ya cambió hash-1 por hash-2
+		repo := session.NewRepository(newMemoryDB(t))
+		sess := newSession(t, repo, "hash-2")
+
+		// WHEN
+		err := repo.Rotate(
+			&session.Session{ID: sess.ID, TokenHash: "hash-3", AccessJti: "jti-3", ExpiresAt: base.Add(2 * time.Hour)},
+			&session.RotatedToken{TokenHash: "hash-1", SessionID: sess.ID, RotatedAt: base, ExpiresAt: base.Add(2 * time.Hour)},
+		)
+
+		// THEN
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		_, err = repo.FindRotated("hash-1")
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		_, err = repo.FindByTokenHash("hash-2")
+		assert.NoError(t, err)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// DeleteFamily
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_DeleteFamily(t *testing.T) {
+	t.Run("Debe borrar la sesión y escribir los eventos en el outbox", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := session.NewRepository(db)
+		sess := newSession(t, repo, "hash-1")
+		event := events.New(events.RefreshTokenReused, sess.UserID, session.ReusedEventData{SessionID: sess.ID})
+
+		// WHEN
+		err := repo.DeleteFamily(sess.ID, event)
+
+		// THEN
+		require.NoError(t, err)
+		_, err = repo.FindByID(sess.ID)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		var messages []outbox.Message
+		require.NoError(t, db.Find(&messages).Error)
+		require.Len(t, messages, 1)
+		assert.Equal(t, events.RefreshTokenReused, messages[0].Type)
+	})
+
+	t.Run("Debe no escribir eventos cuando la sesión ya no existe", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := session.NewRepository(db)
+		event := events.New(events.RefreshTokenReused, "user-1", session.ReusedEventData{})
+
+		// WHEN
+		err := repo.DeleteFamily("inexistente", event)
+
+		// THEN
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		var count int64
+		require.NoError(t, db.Model(&outbox.Message{}).Count(&count).Error)
+		assert.Zero(t, count)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// DeleteRotatedBefore
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_DeleteRotatedBefore(t *testing.T) {
+	t.Run("Debe borrar solo los tokens rotados ya caducados", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := session.NewRepository(db)
+		require.NoError(t, db.Create(&[]session.RotatedToken{
+			{TokenHash: "caducado", SessionID: "s1", RotatedAt: base, ExpiresAt: base.Add(-time.Minute)},
+			{TokenHash: "vigente", SessionID: "s1", RotatedAt: base, ExpiresAt: base.Add(time.Minute)},
+		}).Error)
+
+		// WHEN
+		deleted, err := repo.DeleteRotatedBefore(base)
+
+		// THEN
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+		_, err = repo.FindRotated("vigente")
+		assert.NoError(t, err)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// DeleteExpired
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_DeleteExpired(t *testing.T) {
+	t.Run("Debe borrar como mucho limit sesiones caducadas", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := session.NewRepository(db)
+		for range 3 {
+			newSession(t, repo, utils.GenerateID())
+		}
+		active := newSession(t, repo, "hash-activa")
+		require.NoError(t, db.Model(active).Update("expires_at", base.Add(3*time.Hour)).Error)
+
+		// WHEN
+		first, err := repo.DeleteExpired(base.Add(2*time.Hour), 2)
+		require.NoError(t, err)
+		second, err := repo.DeleteExpired(base.Add(2*time.Hour), 2)
+		require.NoError(t, err)
+
+		// THEN
+		assert.EqualValues(t, 2, first)
+		assert.EqualValues(t, 1, second)
+		var remaining []session.Session
+		require.NoError(t, db.Find(&remaining).Error)
+		require.Len(t, remaining, 1)
+		assert.Equal(t, active.ID, remaining[0].ID)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// DeleteOldest
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_DeleteOldest(t *testing.T) {
+	t.Run("Debe dejar las sesiones activas más recientes del usuario", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := session.NewRepository(db)
+		var sessions []*session.Session
+		for i := range 3 {
+			sess := &session.Session{
+				ID:        utils.GenerateID(),
+				TokenHash: utils.GenerateID(),
+				AccessJti: utils.GenerateID(),
+				UserID:    "user-1",
+				ExpiresAt: base.Add(time.Hour),
+				CreatedAt: base.Add(time.Duration(i) * time.Minute),
+			}
+			require.NoError(t, repo.Create(sess))
+			sessions = append(sessions, sess)
+		}
+		other := newSession(t, repo, "hash-otro")
+
+		// WHEN
+		evicted, err := repo.DeleteOldest("user-1", 2, base)
+
+		// THEN
+		require.NoError(t, err)
+		require.Len(t, evicted, 1)
+		assert.Equal(t, sessions[0].ID, evicted[0].ID)
+		_, err = repo.FindByID(sessions[0].ID)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		for _, kept := range []*session.Session{sessions[1], sessions[2], other} {
+			_, err = repo.FindByID(kept.ID)
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("Debe no contar las sesiones caducadas", func(t *testing.T) {
+		// GIVEN
+		repo := session.NewRepository(newMemoryDB(t))
+		for i, expiresAt := range []time.Time{base.Add(time.Hour), base.Add(3 * time.Hour)} {
+			require.NoError(t, repo.Create(&session.Session{
+				ID:        utils.GenerateID(),
+				TokenHash: utils.GenerateID(),
+				AccessJti: utils.GenerateID(),
+				UserID:    "user-1",
+				ExpiresAt: expiresAt,
+				CreatedAt: base.Add(time.Duration(i) * time.Minute),
+			}))
+		}
+
+		// WHEN
+		evicted, err := repo.DeleteOldest("user-1", 1, base.Add(2*time.Hour))
+
+		// THEN
+		require.NoError(t, err)
+		assert.Empty(t, evicted)
+	})
+}
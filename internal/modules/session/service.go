@@ -1,8 +1,11 @@
 package session
 
 import (
+	"context"
 	"errors"
+	"image-processing-service/internal/shared/events"
 	"image-processing-service/internal/shared/utils"
+	"log"
 	"time"
 
 	"gorm.io/gorm"
@@ -18,31 +21,78 @@ const (
 var (
 	ErrNotFound       = utils.NewError(404, "SESSION_NOT_FOUND", "Session no encontrado", nil)
 	ErrInvalidSession = utils.NewError(401, "INVALID_SESSION", "Session Inválida", nil)
+	// ErrRefreshTokenReused indica que se presentó un refresh token ya
+	// rotado. La sesión a la que pertenecía queda revocada.
+	ErrRefreshTokenReused = utils.NewError(401, "REFRESH_TOKEN_REUSED", "El refresh token ya se había usado, la sesión se ha cerrado por seguridad", nil)
+)
+
+const (
+	// touchInterval es cada cuánto se actualiza LastUsedAt como mucho.
+	touchInterval = time.Minute
+	// pruneBatchSize es cuántas sesiones caducadas borra cada DELETE.
+	pruneBatchSize = 1000
 )
 
 type Service interface {
 	Create(req CreateSessionRequest) (*Session, error)
+	ListActive(userID string) ([]Session, error)
+	Touch(sess *Session) error
 	Delete(jti string) error
+	Revoke(id, userID string) error
+	DeleteByUserID(userID string) error
+	DeleteByUserIDExcept(userID, jti string) error
 	IsValid(req string, t TokenType) (*Session, error)
 	RenewSession(req UpdateSessionRequest) (*Session, error)
+	PruneRotated(before time.Time) (int64, error)
+	PruneExpired(before time.Time) (int64, error)
+}
+
+// ServiceConfig configura la caché de las sesiones que valida IsValid. Con
+// Invalidator, las revocaciones llegan a la caché de todas las instancias;
+// sin él, cada instancia solo invalida la suya y las demás tardan como
+// mucho Cache.TTL.
+//
+// MaxPerUser limita las sesiones activas de cada usuario: al crear una
+// que lo supera se cierran las más antiguas. A cero no hay límite.
+type ServiceConfig struct {
+	Cache       CacheConfig
+	Invalidator Invalidator
+	MaxPerUser  int
 }
 
 type service struct {
-	repo Repository
+	repo        Repository
+	cache       *cache
+	invalidator Invalidator
+	maxPerUser  int
 }
 
-func NewService(r Repository) Service {
-	return &service{repo: r}
+func NewService(r Repository, cfg ServiceConfig) Service {
+	s := &service{
+		repo:        r,
+		cache:       newCache(cfg.Cache),
+		invalidator: cfg.Invalidator,
+		maxPerUser:  cfg.MaxPerUser,
+	}
+	if s.invalidator != nil {
+		s.invalidator.Subscribe(context.Background(), s.cache.invalidate)
+	}
+	return s
 }
 
 func (s *service) Create(req CreateSessionRequest) (*Session, error) {
 	tokenHash := utils.GenerateSHA256(req.TokenHash)
+	now := time.Now()
 	newSession := &Session{
-		ID:        utils.GenerateID(),
-		TokenHash: tokenHash,
-		AccessJti: req.AccessJti,
-		UserID:    req.UserID,
-		ExpiresAt: req.ExpiresAt,
+		ID:          utils.GenerateID(),
+		TokenHash:   tokenHash,
+		AccessJti:   req.AccessJti,
+		UserID:      req.UserID,
+		UserAgent:   req.UserAgent,
+		IP:          req.IP,
+		DeviceLabel: req.DeviceLabel,
+		LastUsedAt:  &now,
+		ExpiresAt:   req.ExpiresAt,
 	}
 
 	err := s.repo.Create(newSession)
@@ -50,20 +100,103 @@ func (s *service) Create(req CreateSessionRequest) (*Session, error) {
 		return nil, err
 	}
 
+	// La sesión nueva ya está creada: si no se pueden cerrar las antiguas,
+	// el usuario se queda temporalmente por encima del límite.
+	if err := s.evictOldest(req.UserID, now); err != nil {
+		log.Printf("Error cerrando las sesiones más antiguas de %s: %v", req.UserID, err)
+	}
+
 	return newSession, nil
 }
 
+// evictOldest cierra las sesiones activas más antiguas del usuario que
+// superan MaxPerUser.
+func (s *service) evictOldest(userID string, now time.Time) error {
+	if s.maxPerUser <= 0 {
+		return nil
+	}
+
+	evicted, err := s.repo.DeleteOldest(userID, s.maxPerUser, now)
+	if err != nil {
+		return err
+	}
+	for _, sess := range evicted {
+		s.invalidate(Invalidation{SessionID: sess.ID})
+	}
+	return nil
+}
+
 func (s *service) Delete(jti string) error {
-	return s.repo.Delete(jti)
+	if err := s.repo.Delete(jti); err != nil {
+		return err
+	}
+	s.invalidate(Invalidation{JTI: jti})
+	return nil
+}
+
+// ListActive devuelve las sesiones sin caducar del usuario.
+func (s *service) ListActive(userID string) ([]Session, error) {
+	return s.repo.FindActiveByUserID(userID, time.Now())
+}
+
+// Touch registra que la sesión se acaba de usar. Solo escribe si el último
+// uso registrado tiene más de touchInterval.
+func (s *service) Touch(sess *Session) error {
+	now := time.Now()
+	if sess.LastUsedAt != nil && now.Sub(*sess.LastUsedAt) < touchInterval {
+		return nil
+	}
+	if err := s.repo.Touch(sess.ID, now, now.Add(-touchInterval)); err != nil {
+		return err
+	}
+	s.cache.touched(sess.AccessJti, now)
+	return nil
+}
+
+// Revoke cierra la sesión id del usuario.
+func (s *service) Revoke(id, userID string) error {
+	if err := s.repo.DeleteByID(id, userID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+	s.invalidate(Invalidation{SessionID: id})
+	return nil
+}
+
+// DeleteByUserID cierra todas las sesiones del usuario.
+func (s *service) DeleteByUserID(userID string) error {
+	if err := s.repo.DeleteByUserID(userID); err != nil {
+		return err
+	}
+	s.invalidate(Invalidation{UserID: userID})
+	return nil
+}
+
+// DeleteByUserIDExcept cierra todas las sesiones del usuario salvo la del
+// access token jti. La caché se vacía para todas: la que sigue abierta se
+// vuelve a leer en la siguiente petición.
+func (s *service) DeleteByUserIDExcept(userID, jti string) error {
+	if err := s.repo.DeleteByUserIDExcept(userID, jti); err != nil {
+		return err
+	}
+	s.invalidate(Invalidation{UserID: userID})
+	return nil
 }
 
+// IsValid busca la sesión de un refresh token o del jti de un access token.
+// Las de access tokens, que se validan en cada petición autenticada, se
+// sirven de la caché.
 func (s *service) IsValid(req string, t TokenType) (*Session, error) {
 	if t == Refresh {
 		hashedToken := utils.GenerateSHA256(req)
-		sess, err := s.repo.FindByOne(hashedToken)
-
+		sess, err := s.repo.FindByTokenHash(hashedToken)
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, ErrNotFound
+			return nil, s.checkReuse(hashedToken)
+		}
+		if err != nil {
+			return nil, err
 		}
 
 		if time.Now().After(sess.ExpiresAt) {
@@ -73,32 +206,128 @@ func (s *service) IsValid(req string, t TokenType) (*Session, error) {
 		return sess, nil
 	}
 
-	sess, err := s.repo.FindByOne(req)
+	now := time.Now()
+	if sess, ok := s.cache.get(req, now); ok {
+		return sess, nil
+	}
+
+	generation := s.cache.currentGeneration()
+	sess, err := s.repo.FindByAccessJti(req)
 	if err != nil {
 		return nil, err
 	}
 
-	if time.Now().After(sess.ExpiresAt) {
+	if now.After(sess.ExpiresAt) {
 		return nil, ErrInvalidSession
 	}
 
+	s.cache.add(sess, generation, now)
 	return sess, nil
 }
 
+// checkReuse se llama con un refresh token que no es el actual de ninguna
+// sesión. Si es uno ya rotado revoca su familia, registra el evento
+// RefreshTokenReused y devuelve ErrRefreshTokenReused; si no, ErrNotFound.
+func (s *service) checkReuse(tokenHash string) error {
+	rotated, err := s.repo.FindRotated(tokenHash)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if time.Now().After(rotated.ExpiresAt) {
+		return ErrNotFound
+	}
+
+	// La familia puede estar ya revocada por una reutilización anterior o
+	// por un cierre de sesión: el token sigue sin ser válido.
+	sess, err := s.repo.FindByID(rotated.SessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrRefreshTokenReused
+	}
+	if err != nil {
+		return err
+	}
+
+	reused := events.New(events.RefreshTokenReused, sess.UserID, ReusedEventData{
+		SessionID:   sess.ID,
+		DeviceLabel: sess.DeviceLabel,
+		IP:          sess.IP,
+		UserAgent:   sess.UserAgent,
+		RotatedAt:   rotated.RotatedAt,
+	})
+	if err := s.repo.DeleteFamily(sess.ID, reused); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	s.invalidate(Invalidation{SessionID: sess.ID})
+
+	return ErrRefreshTokenReused
+}
+
+// RenewSession rota el refresh token de la sesión. Si el token presentado
+// ya no es el actual porque otra renovación se adelantó, devuelve
+// ErrInvalidSession.
 func (s *service) RenewSession(req UpdateSessionRequest) (*Session, error) {
 	newHashedToken := utils.GenerateSHA256(req.NewTokenHash)
 
+	now := time.Now()
 	updatedSession := &Session{
-		ID:        req.SessionID,
-		TokenHash: newHashedToken,
-		AccessJti: req.NewAccessJti,
-		ExpiresAt: req.ExpiresAt,
+		ID:         req.SessionID,
+		TokenHash:  newHashedToken,
+		AccessJti:  req.NewAccessJti,
+		LastUsedAt: &now,
+		ExpiresAt:  req.ExpiresAt,
 	}
 
-	err := s.repo.Update(updatedSession)
+	err := s.repo.Rotate(updatedSession, &RotatedToken{
+		TokenHash: req.CurrentTokenHash,
+		SessionID: req.SessionID,
+		RotatedAt: now,
+		ExpiresAt: req.ExpiresAt,
+	})
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidSession
+		}
 		return nil, err
 	}
+	// El access token anterior deja de ser válido con la rotación.
+	s.invalidate(Invalidation{SessionID: req.SessionID})
 
 	return updatedSession, nil
 }
+
+// PruneExpired borra por lotes las sesiones que caducaron antes de before y
+// devuelve cuántas ha borrado. Ya no estaban en la caché: sus entradas
+// caducan con la sesión.
+func (s *service) PruneExpired(before time.Time) (int64, error) {
+	var total int64
+	for {
+		deleted, err := s.repo.DeleteExpired(before, pruneBatchSize)
+		total += deleted
+		if err != nil || deleted < pruneBatchSize {
+			return total, err
+		}
+	}
+}
+
+// PruneRotated borra los refresh tokens rotados que caducaron antes de
+// before.
+func (s *service) PruneRotated(before time.Time) (int64, error) {
+	return s.repo.DeleteRotatedBefore(before)
+}
+
+// invalidate borra las sesiones de la caché local y avisa a las demás
+// instancias. Si el aviso falla, en ellas caducan con el TTL.
+func (s *service) invalidate(inv Invalidation) {
+	s.cache.invalidate(inv)
+
+	if s.invalidator == nil {
+		return
+	}
+	if err := s.invalidator.Publish(context.Background(), inv); err != nil {
+		log.Printf("Error publicando la invalidación de sesiones %+v: %v", inv, err)
+	}
+}
@@ -0,0 +1,263 @@
+package session_test
+
+// Los tests del servicio usan el repositorio real sobre SQLite en memoria,
+// envuelto para contar las consultas de sesiones por jti, y un
+// Invalidator en proceso que hace de Redis entre varias instancias.
+
+import (
+	"context"
+	"image-processing-service/internal/modules/session"
+	"image-processing-service/internal/shared/utils"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type countingRepository struct {
+	session.Repository
+	lookups atomic.Int64
+}
+
+func (r *countingRepository) FindByAccessJti(jti string) (*session.Session, error) {
+	r.lookups.Add(1)
+	return r.Repository.FindByAccessJti(jti)
+}
+
+// memoryInvalidator reparte las invalidaciones entre los servicios
+// suscritos, como el pub/sub de Redis.
+type memoryInvalidator struct {
+	mu       sync.Mutex
+	handlers []func(session.Invalidation)
+}
+
+func (i *memoryInvalidator) Publish(_ context.Context, inv session.Invalidation) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, handle := range i.handlers {
+		handle(inv)
+	}
+	return nil
+}
+
+func (i *memoryInvalidator) Subscribe(_ context.Context, handle func(session.Invalidation)) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.handlers = append(i.handlers, handle)
+}
+
+func newCountingRepository(db *gorm.DB) *countingRepository {
+	return &countingRepository{Repository: session.NewRepository(db)}
+}
+
+// createSession abre una sesión con un refresh token y un jti nuevos.
+func createSession(t *testing.T, svc session.Service, userID, jti string) *session.Session {
+	t.Helper()
+
+	sess, err := svc.Create(session.CreateSessionRequest{
+		TokenHash: "refresh-" + jti,
+		AccessJti: jti,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+	return sess
+}
+
+func TestService_IsValid(t *testing.T) {
+	t.Run("Debe servir de la caché las validaciones repetidas del mismo jti", func(t *testing.T) {
+		// GIVEN
+		repo := newCountingRepository(newMemoryDB(t))
+		svc := session.NewService(repo, session.ServiceConfig{})
+		createSession(t, svc, "user-1", "jti-1")
+
+		// WHEN
+		for range 5 {
+			_, err := svc.IsValid("jti-1", session.Access)
+			require.NoError(t, err)
+		}
+
+		// THEN
+		assert.EqualValues(t, 1, repo.lookups.Load())
+	})
+
+	t.Run("Debe volver a consultar la base de datos al pasar el TTL", func(t *testing.T) {
+		// GIVEN
+		repo := newCountingRepository(newMemoryDB(t))
+		svc := session.NewService(repo, session.ServiceConfig{Cache: session.CacheConfig{TTL: time.Millisecond}})
+		createSession(t, svc, "user-1", "jti-1")
+		_, err := svc.IsValid("jti-1", session.Access)
+		require.NoError(t, err)
+
+		// WHEN
+		time.Sleep(5 * time.Millisecond)
+		_, err = svc.IsValid("jti-1", session.Access)
+
+		// THEN
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, repo.lookups.Load())
+	})
+
+	t.Run("Debe descartar la sesión menos usada al llenarse la caché", func(t *testing.T) {
+		// GIVEN
+		repo := newCountingRepository(newMemoryDB(t))
+		svc := session.NewService(repo, session.ServiceConfig{Cache: session.CacheConfig{Size: 1}})
+		createSession(t, svc, "user-1", "jti-1")
+		createSession(t, svc, "user-1", "jti-2")
+		_, err := svc.IsValid("jti-1", session.Access)
+		require.NoError(t, err)
+		_, err = svc.IsValid("jti-2", session.Access)
+		require.NoError(t, err)
+
+		// WHEN
+		_, err = svc.IsValid("jti-1", session.Access)
+
+		// THEN
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, repo.lookups.Load())
+	})
+
+	t.Run("Debe rechazar al momento un jti cuya sesión se ha cerrado", func(t *testing.T) {
+		// GIVEN
+		svc := session.NewService(session.NewRepository(newMemoryDB(t)), session.ServiceConfig{})
+		createSession(t, svc, "user-1", "jti-1")
+		_, err := svc.IsValid("jti-1", session.Access)
+		require.NoError(t, err)
+
+		// WHEN
+		require.NoError(t, svc.Delete("jti-1"))
+
+		// THEN
+		_, err = svc.IsValid("jti-1", session.Access)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("Debe rechazar al momento los jti de un usuario cuyas sesiones se han cerrado", func(t *testing.T) {
+		// GIVEN
+		svc := session.NewService(session.NewRepository(newMemoryDB(t)), session.ServiceConfig{})
+		first := createSession(t, svc, "user-1", "jti-1")
+		createSession(t, svc, "user-1", "jti-2")
+		for _, jti := range []string{"jti-1", "jti-2"} {
+			_, err := svc.IsValid(jti, session.Access)
+			require.NoError(t, err)
+		}
+
+		// WHEN
+		require.NoError(t, svc.Revoke(first.ID, "user-1"))
+		_, revoked := svc.IsValid("jti-1", session.Access)
+		require.NoError(t, svc.DeleteByUserID("user-1"))
+		_, closed := svc.IsValid("jti-2", session.Access)
+
+		// THEN
+		assert.ErrorIs(t, revoked, gorm.ErrRecordNotFound)
+		assert.ErrorIs(t, closed, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("Debe rechazar el jti anterior al renovar la sesión", func(t *testing.T) {
+		// GIVEN
+		svc := session.NewService(session.NewRepository(newMemoryDB(t)), session.ServiceConfig{})
+		createSession(t, svc, "user-1", "jti-1")
+		sess, err := svc.IsValid("jti-1", session.Access)
+		require.NoError(t, err)
+
+		// WHEN
+		_, err = svc.RenewSession(session.UpdateSessionRequest{
+			SessionID:        sess.ID,
+			CurrentTokenHash: sess.TokenHash,
+			NewTokenHash:     "refresh-2",
+			NewAccessJti:     "jti-2",
+			ExpiresAt:        time.Now().Add(time.Hour),
+		})
+		require.NoError(t, err)
+
+		// THEN
+		_, err = svc.IsValid("jti-1", session.Access)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		_, err = svc.IsValid("jti-2", session.Access)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Debe rechazar al momento en otra instancia una sesión revocada", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		invalidator := &memoryInvalidator{}
+		cfg := session.ServiceConfig{Cache: session.CacheConfig{TTL: time.Hour}, Invalidator: invalidator}
+		a := session.NewService(session.NewRepository(db), cfg)
+		b := session.NewService(session.NewRepository(db), cfg)
+		sess := createSession(t, a, "user-1", "jti-1")
+		_, err := b.IsValid("jti-1", session.Access)
+		require.NoError(t, err)
+
+		// WHEN
+		require.NoError(t, a.Revoke(sess.ID, "user-1"))
+
+		// THEN
+		_, err = b.IsValid("jti-1", session.Access)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+}
+
+func TestService_Create(t *testing.T) {
+	t.Run("Debe cerrar la sesión más antigua al superar el límite", func(t *testing.T) {
+		// GIVEN
+		svc := session.NewService(session.NewRepository(newMemoryDB(t)), session.ServiceConfig{MaxPerUser: 2})
+		createSession(t, svc, "user-1", "jti-1")
+		createSession(t, svc, "user-1", "jti-2")
+		_, err := svc.IsValid("jti-1", session.Access)
+		require.NoError(t, err)
+
+		// WHEN
+		createSession(t, svc, "user-1", "jti-3")
+
+		// THEN
+		_, err = svc.IsValid("jti-1", session.Access)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		for _, jti := range []string{"jti-2", "jti-3"} {
+			_, err = svc.IsValid(jti, session.Access)
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("Debe no limitar las sesiones sin MaxPerUser", func(t *testing.T) {
+		// GIVEN
+		svc := session.NewService(session.NewRepository(newMemoryDB(t)), session.ServiceConfig{})
+
+		// WHEN
+		for _, jti := range []string{"jti-1", "jti-2", "jti-3"} {
+			createSession(t, svc, "user-1", jti)
+		}
+
+		// THEN
+		sessions, err := svc.ListActive("user-1")
+		require.NoError(t, err)
+		assert.Len(t, sessions, 3)
+	})
+}
+
+func TestService_PruneExpired(t *testing.T) {
+	t.Run("Debe borrar todas las sesiones caducadas aunque superen un lote", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := session.NewRepository(db)
+		for range 1200 {
+			newSession(t, repo, utils.GenerateID())
+		}
+		svc := session.NewService(repo, session.ServiceConfig{})
+
+		// WHEN
+		deleted, err := svc.PruneExpired(base.Add(2 * time.Hour))
+
+		// THEN
+		require.NoError(t, err)
+		assert.EqualValues(t, 1200, deleted)
+		var count int64
+		require.NoError(t, db.Model(&session.Session{}).Count(&count).Error)
+		assert.Zero(t, count)
+	})
+}
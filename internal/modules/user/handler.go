@@ -16,6 +16,7 @@ type Handler interface {
 	GetAll(w http.ResponseWriter, r *http.Request)
 	Update(w http.ResponseWriter, r *http.Request)
 	UpdatePassword(w http.ResponseWriter, r *http.Request)
+	UpdateRole(w http.ResponseWriter, r *http.Request)
 	Delete(w http.ResponseWriter, r *http.Request)
 }
 
@@ -133,7 +134,7 @@ func (h *handler) UpdatePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.service.UpdatePassword(authUser.UserID, req)
+	user, err := h.service.UpdatePassword(authUser.UserID, req, authUser.JTI)
 	if err != nil {
 		utils.HandleError(w, err)
 		return
@@ -142,6 +143,41 @@ func (h *handler) UpdatePassword(w http.ResponseWriter, r *http.Request) {
 	utils.Success(w, 201, user)
 }
 
+// UpdateRole cambia el rol de otro usuario. Un administrador no puede
+// cambiarse el suyo, para que no se quite el acceso por error.
+func (h *handler) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	if !utils.IsValidID(id) {
+		utils.HandleError(w, utils.ErrInvalidIDFormat)
+		return
+	}
+
+	if authUser, _ := auth.GetAuthUser(r.Context()); authUser.UserID == id {
+		utils.HandleError(w, ErrOwnRole)
+		return
+	}
+
+	var req UpdateRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.HandleError(w, utils.ErrInvalidJSON)
+		return
+	}
+
+	if errs := utils.Validate(req); errs != nil {
+		utils.HandleError(w, utils.ValidationError(errs))
+		return
+	}
+
+	user, err := h.service.UpdateRole(id, req)
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, user)
+}
+
 func (h *handler) Delete(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
@@ -35,11 +35,14 @@ import (
 // mockService implementa user.Service con campos de tipo func para que cada
 // test pueda redefinir el comportamiento sin crear una nueva instancia.
 type mockService struct {
-	GetByIDFn        func(id string) (*user.User, error)
-	GetAllFn         func(page, limit int) ([]*user.User, int64, error)
-	UpdateFn         func(id string, req user.UpdateUserRequest) (*user.User, error)
-	UpdatePasswordFn func(id string, req user.UpdatePasswordUserRequest) (*user.User, error)
-	DeleteFn         func(id string) error
+	GetByIDFn         func(id string) (*user.User, error)
+	GetAllFn          func(page, limit int) ([]*user.User, int64, error)
+	UpdateFn          func(id string, req user.UpdateUserRequest) (*user.User, error)
+	UpdatePasswordFn  func(id string, req user.UpdatePasswordUserRequest, currentJTI string) (*user.User, error)
+	UpdateRoleFn      func(id string, req user.UpdateRoleRequest) (*user.User, error)
+	PromoteAdminsFn   func(emails []string) (int64, error)
+	IsEmailVerifiedFn func(id string) (bool, error)
+	DeleteFn          func(id string) error
 }
 
 func (m *mockService) GetByID(id string) (*user.User, error) {
@@ -51,8 +54,17 @@ func (m *mockService) GetAll(page, limit int) ([]*user.User, int64, error) {
 func (m *mockService) Update(id string, req user.UpdateUserRequest) (*user.User, error) {
 	return m.UpdateFn(id, req)
 }
-func (m *mockService) UpdatePassword(id string, req user.UpdatePasswordUserRequest) (*user.User, error) {
-	return m.UpdatePasswordFn(id, req)
+func (m *mockService) UpdatePassword(id string, req user.UpdatePasswordUserRequest, currentJTI string) (*user.User, error) {
+	return m.UpdatePasswordFn(id, req, currentJTI)
+}
+func (m *mockService) UpdateRole(id string, req user.UpdateRoleRequest) (*user.User, error) {
+	return m.UpdateRoleFn(id, req)
+}
+func (m *mockService) PromoteAdmins(emails []string) (int64, error) {
+	return m.PromoteAdminsFn(emails)
+}
+func (m *mockService) IsEmailVerified(id string) (bool, error) {
+	return m.IsEmailVerifiedFn(id)
 }
 func (m *mockService) Delete(id string) error {
 	return m.DeleteFn(id)
@@ -563,7 +575,7 @@ func TestHandler_UpdatePassword(t *testing.T) {
 	// ----------------------------------------------------------------
 	t.Run("Debe retornar 403 cuando la contraseña actual es incorrecta", func(t *testing.T) {
 		// GIVEN
-		svc.UpdatePasswordFn = func(id string, req user.UpdatePasswordUserRequest) (*user.User, error) {
+		svc.UpdatePasswordFn = func(id string, req user.UpdatePasswordUserRequest, currentJTI string) (*user.User, error) {
 			return nil, user.ErrInvalidPassword
 		}
 
@@ -586,7 +598,7 @@ func TestHandler_UpdatePassword(t *testing.T) {
 	// ----------------------------------------------------------------
 	t.Run("Debe retornar 201 cuando la contraseña se actualiza correctamente", func(t *testing.T) {
 		// GIVEN
-		svc.UpdatePasswordFn = func(id string, req user.UpdatePasswordUserRequest) (*user.User, error) {
+		svc.UpdatePasswordFn = func(id string, req user.UpdatePasswordUserRequest, currentJTI string) (*user.User, error) {
 			return &user.User{ID: validID, Name: "Ana", Email: "ana@test.com"}, nil
 		}
 
@@ -707,3 +719,59 @@ func TestHandler_Delete(t *testing.T) {
 // Aseguramos que bytes y utils se importan
 var _ = bytes.NewBuffer
 var _ = utils.Pointer[string]
+
+// ─────────────────────────────────────────────────────────────────────────────
+// UpdateRole
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestHandler_UpdateRole(t *testing.T) {
+	svc := &mockService{}
+	h := user.NewHandler(svc)
+	adminID := "ej55egzg4zdrs2zs6e6cxxzk"
+
+	t.Run("Debe retornar 409 cuando el administrador intenta cambiar su propio rol", func(t *testing.T) {
+		// GIVEN
+		req := newRequest(http.MethodPatch, "/users/"+adminID+"/role", `{"role":"member"}`, map[string]string{"id": adminID})
+		req = withAuthUser(req, adminID)
+		w := httptest.NewRecorder()
+
+		// WHEN
+		h.UpdateRole(w, req)
+
+		// THEN
+		assert.Equal(t, http.StatusConflict, w.Code)
+		errObj := decodeResponse(t, w)["error"].(map[string]interface{})
+		assert.Equal(t, "CANNOT_CHANGE_OWN_ROLE", errObj["code"])
+	})
+
+	t.Run("Debe retornar 422 cuando el rol no existe", func(t *testing.T) {
+		// GIVEN
+		req := newRequest(http.MethodPatch, "/users/"+validID+"/role", `{"role":"root"}`, map[string]string{"id": validID})
+		req = withAuthUser(req, adminID)
+		w := httptest.NewRecorder()
+
+		// WHEN
+		h.UpdateRole(w, req)
+
+		// THEN
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+
+	t.Run("Debe retornar 200 con el usuario actualizado", func(t *testing.T) {
+		// GIVEN
+		svc.UpdateRoleFn = func(id string, req user.UpdateRoleRequest) (*user.User, error) {
+			return &user.User{ID: id, Name: "Ana", Role: req.Role}, nil
+		}
+		req := newRequest(http.MethodPatch, "/users/"+validID+"/role", `{"role":"admin"}`, map[string]string{"id": validID})
+		req = withAuthUser(req, adminID)
+		w := httptest.NewRecorder()
+
+		// WHEN
+		h.UpdateRole(w, req)
+
+		// THEN
+		assert.Equal(t, http.StatusOK, w.Code)
+		data := decodeResponse(t, w)["data"].(map[string]interface{})
+		assert.Equal(t, "admin", data["role"])
+	})
+}
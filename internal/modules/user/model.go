@@ -1,19 +1,49 @@
 package user
 
 import (
+	"image-processing-service/internal/shared/auth"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// User es una cuenta. EmailVerifiedAt queda vacío hasta que el usuario
+// sigue el enlace de verificación, y vuelve a vaciarse si cambia el email.
 type User struct {
-	ID        string         `gorm:"primaryKey;size=24" json:"id"`
-	Name      string         `gorm:"not null;" json:"name"`
-	Email     string         `gorm:"uniqueIndex" json:"email"`
-	Password  string         `gorm:"not null" json:"-"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index"`
+	ID              string         `gorm:"primaryKey;size=24" json:"id"`
+	Name            string         `gorm:"not null;" json:"name"`
+	Email           string         `gorm:"uniqueIndex" json:"email"`
+	Password        string         `gorm:"not null" json:"-"`
+	Role            auth.Role      `gorm:"size:16;not null;default:member" json:"role"`
+	EmailVerifiedAt *time.Time     `json:"email_verified_at"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt `gorm:"index"`
+}
+
+// PasswordHistory es una contraseña anterior del usuario. Se guardan las
+// password.MaxHistory últimas para no permitir repetirlas.
+type PasswordHistory struct {
+	ID        string `gorm:"primaryKey;size:32"`
+	UserID    string `gorm:"size:32;not null;index"`
+	Password  string `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+func (PasswordHistory) TableName() string {
+	return "password_history"
+}
+
+// EventData es el contenido de los eventos user.signed_up,
+// user.password_changed y user.deleted.
+type EventData struct {
+	UserID string `json:"user_id"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+}
+
+func NewEventData(u *User) EventData {
+	return EventData{UserID: u.ID, Name: u.Name, Email: u.Email}
 }
 
 type UpdateUserRequest struct {
@@ -21,7 +51,11 @@ type UpdateUserRequest struct {
 	Email *string `json:"email" validate:"omitempty,email"`
 }
 
+type UpdateRoleRequest struct {
+	Role auth.Role `json:"role" validate:"required,oneof=admin member"`
+}
+
 type UpdatePasswordUserRequest struct {
-	CurrentPassword string `json:"current_password" validate:"required,min=6,max=32"`
-	NewPassword     string `json:"new_password" validate:"required,min=6,max=32"`
+	CurrentPassword string `json:"current_password" validate:"required,min=6,max=128"`
+	NewPassword     string `json:"new_password" validate:"required,min=6,max=128"`
 }
@@ -0,0 +1,68 @@
+package user
+
+import (
+	"image-processing-service/internal/shared/password"
+	"image-processing-service/internal/shared/utils"
+)
+
+// PasswordPolicy comprueba las contraseñas nuevas al registrarse, al
+// cambiarlas y al restablecerlas: las reglas de password.Policy más que no
+// repitan ninguna de las últimas del usuario.
+type PasswordPolicy struct {
+	repo  Repository
+	rules password.Policy
+}
+
+// NewPasswordPolicy limita rules.History a password.MaxHistory, las
+// contraseñas anteriores que guarda el repositorio.
+func NewPasswordPolicy(r Repository, rules password.Policy) *PasswordPolicy {
+	rules.History = min(rules.History, password.MaxHistory)
+	return &PasswordPolicy{repo: r, rules: rules}
+}
+
+// Check valida plain como nueva contraseña de u. Si incumple alguna regla
+// devuelve un error de validación con una entrada por regla en el campo
+// field de la petición. Un u sin ID es una cuenta por crear y no tiene
+// historial.
+func (p *PasswordPolicy) Check(u *User, field, plain string) error {
+	violations := p.rules.Check(plain, u.Email, u.Name)
+
+	reused, err := p.reused(u, plain)
+	if err != nil {
+		return err
+	}
+	if reused {
+		violations = append(violations, password.Violation{
+			Rule:    password.RuleReused,
+			Message: "Ya la has usado hace poco, elige otra",
+		})
+	}
+
+	if len(violations) > 0 {
+		return utils.ValidationError(map[string][]password.Violation{field: violations})
+	}
+	return nil
+}
+
+// reused compara plain con la contraseña actual y las anteriores que
+// completan las rules.History últimas.
+func (p *PasswordPolicy) reused(u *User, plain string) (bool, error) {
+	if p.rules.History <= 0 || u.ID == "" {
+		return false, nil
+	}
+
+	hashes, err := p.repo.ListPasswordHistory(u.ID, p.rules.History-1)
+	if err != nil {
+		return false, err
+	}
+	if u.Password != "" {
+		hashes = append(hashes, u.Password)
+	}
+
+	for _, hash := range hashes {
+		if utils.CheckPasswordHash(plain, hash) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -2,18 +2,30 @@ package user
 
 import (
 	"errors"
+	"time"
+
+	"image-processing-service/internal/shared/auth"
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/outbox"
+	"image-processing-service/internal/shared/password"
+	"image-processing-service/internal/shared/utils"
 
 	"gorm.io/gorm"
 )
 
 type Repository interface {
-	Create(user *User) error
+	Create(user *User, evts ...events.Event) error
 	GetByEmail(email string) (*User, error)
 	GetByID(id string) (*User, error)
 	GetAll(page, limit int) ([]*User, int64, error)
 	Update(user *User) error
-	UpdatePassword(user *User) error
-	Delete(id string) error
+	UpdatePassword(user *User, evts ...events.Event) error
+	RehashPassword(id, oldHash, newHash string) error
+	ListPasswordHistory(id string, limit int) ([]string, error)
+	UpdateRole(id string, role auth.Role) error
+	SetRoleByEmails(emails []string, role auth.Role) (int64, error)
+	MarkEmailVerified(id string, at time.Time) error
+	Delete(id string, evts ...events.Event) error
 }
 
 type repository struct {
@@ -24,8 +36,14 @@ func NewRepository(db *gorm.DB) Repository {
 	return &repository{db: db}
 }
 
-func (r *repository) Create(user *User) error {
-	return r.db.Create(user).Error
+// Create guarda el usuario y evts en la misma transacción.
+func (r *repository) Create(user *User, evts ...events.Event) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return outbox.Write(tx, evts...)
+	})
 }
 
 func (r *repository) GetByEmail(email string) (*User, error) {
@@ -76,15 +94,90 @@ func (r *repository) Update(user *User) error {
 		Save(user).Error
 }
 
-func (r *repository) UpdatePassword(user *User) error {
-	return r.db.Model(user).
-		Select("password").
-		Save(user).Error
+// UpdatePassword guarda la nueva contraseña y evts en la misma transacción.
+// La anterior pasa al historial, que se recorta a las password.MaxHistory
+// últimas.
+func (r *repository) UpdatePassword(user *User, evts ...events.Event) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var previous string
+		if err := tx.Model(&User{}).
+			Where("id = ?", user.ID).
+			Select("password").
+			Scan(&previous).Error; err != nil {
+			return err
+		}
+
+		// Las cuentas creadas con un proveedor OIDC no tienen contraseña.
+		if previous != "" {
+			if err := tx.Create(&PasswordHistory{
+				ID:        utils.GenerateID(),
+				UserID:    user.ID,
+				Password:  previous,
+				CreatedAt: time.Now().UTC(),
+			}).Error; err != nil {
+				return err
+			}
+
+			kept := tx.Model(&PasswordHistory{}).
+				Select("id").
+				Where("user_id = ?", user.ID).
+				Order("created_at DESC, id DESC").
+				Limit(password.MaxHistory)
+			if err := tx.Where("user_id = ? AND id NOT IN (?)", user.ID, kept).
+				Delete(&PasswordHistory{}).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(user).
+			Select("password").
+			Save(user).Error; err != nil {
+			return err
+		}
+		return outbox.Write(tx, evts...)
+	})
 }
 
-func (r *repository) Delete(id string) error {
-	result := r.db.Delete(&User{}, "id = ?", id)
+// RehashPassword cambia el hash de la contraseña por otro de la misma
+// contraseña, solo si sigue siendo oldHash: si entretanto se cambió la
+// contraseña no la pisa y devuelve gorm.ErrRecordNotFound.
+func (r *repository) RehashPassword(id, oldHash, newHash string) error {
+	result := r.db.Model(&User{}).
+		Where("id = ? AND password = ?", id, oldHash).
+		Update("password", newHash)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
 
+// ListPasswordHistory devuelve los hashes de las limit contraseñas
+// anteriores más recientes del usuario.
+func (r *repository) ListPasswordHistory(id string, limit int) ([]string, error) {
+	hashes := []string{}
+	if limit <= 0 {
+		return hashes, nil
+	}
+
+	err := r.db.Model(&PasswordHistory{}).
+		Where("user_id = ?", id).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Pluck("password", &hashes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+func (r *repository) UpdateRole(id string, role auth.Role) error {
+	result := r.db.Model(&User{}).Where("id = ?", id).Update("role", role)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -95,3 +188,40 @@ func (r *repository) Delete(id string) error {
 
 	return nil
 }
+
+// SetRoleByEmails asigna role a los usuarios con esos emails que aún no lo
+// tienen y devuelve cuántos cambiaron.
+func (r *repository) SetRoleByEmails(emails []string, role auth.Role) (int64, error) {
+	if len(emails) == 0 {
+		return 0, nil
+	}
+
+	result := r.db.Model(&User{}).
+		Where("email IN ? AND role <> ?", emails, role).
+		Update("role", role)
+	return result.RowsAffected, result.Error
+}
+
+// MarkEmailVerified marca el email del usuario como verificado en at si aún
+// no lo estaba.
+func (r *repository) MarkEmailVerified(id string, at time.Time) error {
+	return r.db.Model(&User{}).
+		Where("id = ? AND email_verified_at IS NULL", id).
+		Update("email_verified_at", at).Error
+}
+
+// Delete borra (soft delete) el usuario y guarda evts en la misma
+// transacción.
+func (r *repository) Delete(id string, evts ...events.Event) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&User{}, "id = ?", id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return outbox.Write(tx, evts...)
+	})
+}
@@ -20,7 +20,10 @@ import (
 	"errors"
 	"fmt"
 	"image-processing-service/internal/modules/user"
+	"image-processing-service/internal/shared/auth"
+	"image-processing-service/internal/shared/password"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -36,8 +39,9 @@ func newMemoryDB(t *testing.T) *gorm.DB {
 	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	require.NoError(t, err, "no se pudo abrir la base de datos en memoria")
 
-	// AutoMigrate crea la tabla users con todas sus columnas y restricciones
-	err = db.AutoMigrate(&user.User{})
+	// AutoMigrate crea las tablas users y password_history con todas sus
+	// columnas y restricciones
+	err = db.AutoMigrate(&user.User{}, &user.PasswordHistory{})
 	require.NoError(t, err, "no se pudo migrar el esquema")
 
 	return db
@@ -396,6 +400,131 @@ func TestRepository_UpdatePassword(t *testing.T) {
 		assert.Equal(t, "Ana", guardado.Name)           // nombre sin cambios
 		assert.Equal(t, "ana@test.com", guardado.Email) // email sin cambios
 	})
+
+	// ----------------------------------------------------------------
+	// Caso 2: la contraseña anterior pasa al historial
+	// ----------------------------------------------------------------
+	t.Run("Debe guardar en el historial las contraseñas anteriores más recientes", func(t *testing.T) {
+		// GIVEN: un usuario que cambia la contraseña más veces de las que se guardan
+		db := newMemoryDB(t)
+		repo := user.NewRepository(db)
+		u := &user.User{ID: "id-1", Name: "Ana", Email: "ana@test.com", Password: "hash-0"}
+		require.NoError(t, db.Create(u).Error)
+
+		// WHEN
+		for i := 1; i <= password.MaxHistory+2; i++ {
+			u.Password = fmt.Sprintf("hash-%d", i)
+			require.NoError(t, repo.UpdatePassword(u))
+		}
+
+		// THEN: quedan las MaxHistory anteriores a la actual, de la más reciente a la más antigua
+		hashes, err := repo.ListPasswordHistory("id-1", password.MaxHistory+10)
+		require.NoError(t, err)
+		require.Len(t, hashes, password.MaxHistory)
+		assert.Equal(t, fmt.Sprintf("hash-%d", password.MaxHistory+1), hashes[0])
+		assert.Equal(t, "hash-2", hashes[len(hashes)-1])
+	})
+
+	// ----------------------------------------------------------------
+	// Caso 3: cuentas sin contraseña
+	// ----------------------------------------------------------------
+	t.Run("Debe no guardar en el historial una contraseña vacía", func(t *testing.T) {
+		// GIVEN: una cuenta creada con un proveedor OIDC
+		db := newMemoryDB(t)
+		repo := user.NewRepository(db)
+		u := &user.User{ID: "id-1", Name: "Ana", Email: "ana@test.com"}
+		require.NoError(t, db.Create(u).Error)
+
+		// WHEN
+		u.Password = "hash-nuevo"
+		require.NoError(t, repo.UpdatePassword(u))
+
+		// THEN
+		hashes, err := repo.ListPasswordHistory("id-1", 5)
+		require.NoError(t, err)
+		assert.Empty(t, hashes)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// ListPasswordHistory
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_ListPasswordHistory(t *testing.T) {
+	t.Run("Debe devolver solo las limit más recientes del usuario", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := user.NewRepository(db)
+		base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		for i, entry := range []user.PasswordHistory{
+			{ID: "h-1", UserID: "id-1", Password: "hash-1"},
+			{ID: "h-2", UserID: "id-1", Password: "hash-2"},
+			{ID: "h-3", UserID: "id-1", Password: "hash-3"},
+			{ID: "h-4", UserID: "id-2", Password: "hash-otro"},
+		} {
+			entry.CreatedAt = base.Add(time.Duration(i) * time.Hour)
+			require.NoError(t, db.Create(&entry).Error)
+		}
+
+		// WHEN
+		hashes, err := repo.ListPasswordHistory("id-1", 2)
+
+		// THEN
+		require.NoError(t, err)
+		assert.Equal(t, []string{"hash-3", "hash-2"}, hashes)
+	})
+
+	t.Run("Debe devolver una lista vacía con limit cero", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := user.NewRepository(db)
+		require.NoError(t, db.Create(&user.PasswordHistory{ID: "h-1", UserID: "id-1", Password: "hash-1"}).Error)
+
+		// WHEN
+		hashes, err := repo.ListPasswordHistory("id-1", 0)
+
+		// THEN
+		require.NoError(t, err)
+		assert.Empty(t, hashes)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// RehashPassword
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_RehashPassword(t *testing.T) {
+	t.Run("Debe cambiar el hash si sigue siendo el mismo", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := user.NewRepository(db)
+		require.NoError(t, db.Create(&user.User{ID: "id-1", Name: "Ana", Email: "ana@test.com", Password: "hash-viejo"}).Error)
+
+		// WHEN
+		err := repo.RehashPassword("id-1", "hash-viejo", "hash-nuevo")
+
+		// THEN
+		require.NoError(t, err)
+		var guardado user.User
+		require.NoError(t, db.First(&guardado, "id = ?", "id-1").Error)
+		assert.Equal(t, "hash-nuevo", guardado.Password)
+	})
+
+	t.Run("Debe no pisar una contraseña cambiada mientras tanto", func(t *testing.T) {
+		// GIVEN: la contraseña ya no tiene el hash que se leyó al iniciar sesión
+		db := newMemoryDB(t)
+		repo := user.NewRepository(db)
+		require.NoError(t, db.Create(&user.User{ID: "id-1", Name: "Ana", Email: "ana@test.com", Password: "hash-cambiado"}).Error)
+
+		// WHEN
+		err := repo.RehashPassword("id-1", "hash-viejo", "hash-nuevo")
+
+		// THEN
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		var guardado user.User
+		require.NoError(t, db.First(&guardado, "id = ?", "id-1").Error)
+		assert.Equal(t, "hash-cambiado", guardado.Password)
+	})
 }
 
 // ─────────────────────────────────────────────────────────────────────────────
@@ -462,3 +591,61 @@ func TestRepository_Delete(t *testing.T) {
 
 // Aseguramos que errors se importa
 var _ = errors.New
+
+// ─────────────────────────────────────────────────────────────────────────────
+// UpdateRole / SetRoleByEmails
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_UpdateRole(t *testing.T) {
+	t.Run("Debe retornar ErrRecordNotFound cuando el ID no existe", func(t *testing.T) {
+		// GIVEN
+		repo := user.NewRepository(newMemoryDB(t))
+
+		// WHEN
+		err := repo.UpdateRole("id-inexistente", auth.RoleAdmin)
+
+		// THEN
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("Debe crear los usuarios como miembros y cambiarles el rol", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := user.NewRepository(db)
+		require.NoError(t, db.Create(&user.User{ID: "id-1", Name: "Ana", Email: "ana@test.com", Password: "hash"}).Error)
+
+		var antes user.User
+		require.NoError(t, db.First(&antes, "id = ?", "id-1").Error)
+
+		// WHEN
+		err := repo.UpdateRole("id-1", auth.RoleAdmin)
+
+		// THEN
+		require.NoError(t, err)
+		var guardado user.User
+		require.NoError(t, db.First(&guardado, "id = ?", "id-1").Error)
+		assert.Equal(t, auth.RoleMember, antes.Role)
+		assert.Equal(t, auth.RoleAdmin, guardado.Role)
+	})
+}
+
+func TestRepository_SetRoleByEmails(t *testing.T) {
+	t.Run("Debe cambiar solo los usuarios de la lista que no tenían el rol", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := user.NewRepository(db)
+		require.NoError(t, db.Create(&user.User{ID: "id-1", Name: "Ana", Email: "ana@test.com", Password: "hash"}).Error)
+		require.NoError(t, db.Create(&user.User{ID: "id-2", Name: "Luis", Email: "luis@test.com", Password: "hash", Role: auth.RoleAdmin}).Error)
+		require.NoError(t, db.Create(&user.User{ID: "id-3", Name: "Eva", Email: "eva@test.com", Password: "hash"}).Error)
+
+		// WHEN
+		changed, err := repo.SetRoleByEmails([]string{"ana@test.com", "luis@test.com", "nadie@test.com"}, auth.RoleAdmin)
+
+		// THEN
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), changed)
+		var eva user.User
+		require.NoError(t, db.First(&eva, "id = ?", "id-3").Error)
+		assert.Equal(t, auth.RoleMember, eva.Role)
+	})
+}
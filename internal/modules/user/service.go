@@ -2,6 +2,8 @@ package user
 
 import (
 	"errors"
+	"image-processing-service/internal/shared/auth"
+	"image-processing-service/internal/shared/events"
 	"image-processing-service/internal/shared/utils"
 
 	"gorm.io/gorm"
@@ -10,22 +12,43 @@ import (
 var (
 	ErrNotFound        = utils.NewError(404, "USER_NOT_FOUND", "Usuario no encontrado", nil)
 	ErrInvalidPassword = utils.NewError(403, "INVALID_PASSWORD", "La contraseña actual no concide", nil)
+	ErrOwnRole         = utils.NewError(409, "CANNOT_CHANGE_OWN_ROLE", "No puedes cambiar tu propio rol", nil)
 )
 
 type Service interface {
 	GetByID(id string) (*User, error)
 	GetAll(page, limit int) ([]*User, int64, error)
 	Update(id string, req UpdateUserRequest) (*User, error)
-	UpdatePassword(id string, req UpdatePasswordUserRequest) (*User, error)
+	UpdatePassword(id string, req UpdatePasswordUserRequest, currentJTI string) (*User, error)
+	UpdateRole(id string, req UpdateRoleRequest) (*User, error)
+	PromoteAdmins(emails []string) (int64, error)
+	IsEmailVerified(id string) (bool, error)
 	Delete(id string) error
 }
 
+// SessionRevoker cierra las sesiones de un usuario. Lo implementa
+// session.Service; se declara aquí para no importar el módulo de sesiones.
+type SessionRevoker interface {
+	DeleteByUserID(userID string) error
+	DeleteByUserIDExcept(userID, jti string) error
+}
+
+// AccountDataDeleter borra lo que otro módulo guarda de un usuario y no debe
+// sobrevivir a su cuenta. Lo implementan apikey.Service, webhook.Service y
+// auth.Service.
+type AccountDataDeleter interface {
+	DeleteByUserID(userID string) error
+}
+
 type service struct {
-	repo Repository
+	repo        Repository
+	sessions    SessionRevoker
+	accountData []AccountDataDeleter
+	passwords   *PasswordPolicy
 }
 
-func NewService(r Repository) Service {
-	return &service{repo: r}
+func NewService(r Repository, sessions SessionRevoker, passwords *PasswordPolicy, accountData ...AccountDataDeleter) Service {
+	return &service{repo: r, sessions: sessions, accountData: accountData, passwords: passwords}
 }
 
 func (s *service) GetByID(id string) (*User, error) {
@@ -64,7 +87,9 @@ func (s *service) Update(id string, req UpdateUserRequest) (*User, error) {
 			return nil, utils.ErrAlreadyExists
 		}
 
+		// El nuevo email no está verificado.
 		user.Email = *req.Email
+		user.EmailVerifiedAt = nil
 	}
 
 	if req.Name != nil {
@@ -78,7 +103,10 @@ func (s *service) Update(id string, req UpdateUserRequest) (*User, error) {
 	return user, nil
 }
 
-func (s *service) UpdatePassword(id string, req UpdatePasswordUserRequest) (*User, error) {
+// UpdatePassword cambia la contraseña, si cumple la política, y cierra el
+// resto de sesiones del usuario: solo se mantiene la del access token
+// currentJTI.
+func (s *service) UpdatePassword(id string, req UpdatePasswordUserRequest, currentJTI string) (*User, error) {
 	user, err := s.repo.GetByID(id)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -91,25 +119,103 @@ func (s *service) UpdatePassword(id string, req UpdatePasswordUserRequest) (*Use
 		return nil, ErrInvalidPassword
 	}
 
+	if err := s.passwords.Check(user, "new_password", req.NewPassword); err != nil {
+		return nil, err
+	}
+
 	hashedPassword, err := utils.HashPassword(req.NewPassword)
 	if err != nil {
 		return nil, err
 	}
 	user.Password = hashedPassword
 
-	if err := s.repo.UpdatePassword(user); err != nil {
+	changed := events.New(events.PasswordChanged, user.ID, NewEventData(user))
+	if err := s.repo.UpdatePassword(user, changed); err != nil {
+		return nil, err
+	}
+
+	if err := s.sessions.DeleteByUserIDExcept(id, currentJTI); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// UpdateRole cambia el rol del usuario y cierra sus sesiones: el rol viaja
+// en el access token, así que sin esto seguiría valiendo el anterior hasta
+// que el token caducara.
+func (s *service) UpdateRole(id string, req UpdateRoleRequest) (*User, error) {
+	user, err := s.repo.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if user.Role == req.Role {
+		return user, nil
+	}
+
+	if err := s.repo.UpdateRole(id, req.Role); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	user.Role = req.Role
+
+	if err := s.sessions.DeleteByUserID(id); err != nil {
 		return nil, err
 	}
 
 	return user, nil
 }
 
+// IsEmailVerified indica si el usuario verificó su email.
+func (s *service) IsEmailVerified(id string) (bool, error) {
+	user, err := s.GetByID(id)
+	if err != nil {
+		return false, err
+	}
+	return user.EmailVerifiedAt != nil, nil
+}
+
+// PromoteAdmins convierte en administradores a los usuarios con esos emails.
+// Se usa al arrancar para crear los primeros administradores.
+func (s *service) PromoteAdmins(emails []string) (int64, error) {
+	return s.repo.SetRoleByEmails(emails, auth.RoleAdmin)
+}
+
+// Delete borra la cuenta, cierra al momento todas sus sesiones y borra sus
+// API keys, webhooks, segundo factor e identidades OIDC. Los archivos se
+// borran después: user.deleted sale por el outbox y el módulo de archivos
+// programa su purga, cuyos file.deleted ya no llegan a ningún webhook.
 func (s *service) Delete(id string) error {
-	if err := s.repo.Delete(id); err != nil {
+	user, err := s.repo.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	deleted := events.New(events.UserDeleted, user.ID, NewEventData(user))
+	if err := s.repo.Delete(id, deleted); err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return ErrNotFound
 		}
 		return err
 	}
+
+	if err := s.sessions.DeleteByUserID(id); err != nil {
+		return err
+	}
+
+	for _, d := range s.accountData {
+		if err := d.DeleteByUserID(id); err != nil {
+			return err
+		}
+	}
 	return nil
 }
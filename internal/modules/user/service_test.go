@@ -12,10 +12,15 @@ package user_test
 import (
 	"errors"
 	"image-processing-service/internal/modules/user"
+	"image-processing-service/internal/shared/auth"
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/password"
 	"image-processing-service/internal/shared/utils"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
 )
 
@@ -24,24 +29,76 @@ import (
 // ─────────────────────────────────────────────────────────────────────────────
 
 type mockRepo struct {
-	CreateFn         func(u *user.User) error
-	GetByEmailFn     func(email string) (*user.User, error)
-	GetByIDFn        func(id string) (*user.User, error)
-	GetAllFn         func(page, limit int) ([]*user.User, int64, error)
-	UpdateFn         func(u *user.User) error
-	UpdatePasswordFn func(u *user.User) error
-	DeleteFn         func(id string) error
+	CreateFn              func(u *user.User, evts ...events.Event) error
+	GetByEmailFn          func(email string) (*user.User, error)
+	GetByIDFn             func(id string) (*user.User, error)
+	GetAllFn              func(page, limit int) ([]*user.User, int64, error)
+	UpdateFn              func(u *user.User) error
+	UpdatePasswordFn      func(u *user.User, evts ...events.Event) error
+	RehashPasswordFn      func(id, oldHash, newHash string) error
+	ListPasswordHistoryFn func(id string, limit int) ([]string, error)
+	UpdateRoleFn          func(id string, role auth.Role) error
+	SetRoleByEmailsFn     func(emails []string, role auth.Role) (int64, error)
+	MarkEmailVerifiedFn   func(id string, at time.Time) error
+	DeleteFn              func(id string, evts ...events.Event) error
 }
 
-func (m *mockRepo) Create(u *user.User) error                   { return m.CreateFn(u) }
+func (m *mockRepo) Create(u *user.User, evts ...events.Event) error {
+	return m.CreateFn(u, evts...)
+}
 func (m *mockRepo) GetByEmail(email string) (*user.User, error) { return m.GetByEmailFn(email) }
 func (m *mockRepo) GetByID(id string) (*user.User, error)       { return m.GetByIDFn(id) }
 func (m *mockRepo) GetAll(page, limit int) ([]*user.User, int64, error) {
 	return m.GetAllFn(page, limit)
 }
-func (m *mockRepo) Update(u *user.User) error                   { return m.UpdateFn(u) }
-func (m *mockRepo) UpdatePassword(u *user.User) error           { return m.UpdatePasswordFn(u) }
-func (m *mockRepo) Delete(id string) error                      { return m.DeleteFn(id) }
+func (m *mockRepo) Update(u *user.User) error { return m.UpdateFn(u) }
+func (m *mockRepo) UpdatePassword(u *user.User, evts ...events.Event) error {
+	return m.UpdatePasswordFn(u, evts...)
+}
+func (m *mockRepo) RehashPassword(id, oldHash, newHash string) error {
+	return m.RehashPasswordFn(id, oldHash, newHash)
+}
+func (m *mockRepo) ListPasswordHistory(id string, limit int) ([]string, error) {
+	return m.ListPasswordHistoryFn(id, limit)
+}
+func (m *mockRepo) UpdateRole(id string, role auth.Role) error { return m.UpdateRoleFn(id, role) }
+func (m *mockRepo) SetRoleByEmails(emails []string, role auth.Role) (int64, error) {
+	return m.SetRoleByEmailsFn(emails, role)
+}
+func (m *mockRepo) MarkEmailVerified(id string, at time.Time) error {
+	return m.MarkEmailVerifiedFn(id, at)
+}
+func (m *mockRepo) Delete(id string, evts ...events.Event) error {
+	return m.DeleteFn(id, evts...)
+}
+
+// mockSessions implementa user.SessionRevoker y recuerda a quién se le
+// cerraron las sesiones.
+type mockSessions struct {
+	revoked []string
+	kept    []string
+}
+
+func (m *mockSessions) DeleteByUserID(userID string) error {
+	m.revoked = append(m.revoked, userID)
+	return nil
+}
+
+func (m *mockSessions) DeleteByUserIDExcept(userID, jti string) error {
+	m.revoked = append(m.revoked, userID)
+	m.kept = append(m.kept, jti)
+	return nil
+}
+
+type mockAccountData struct {
+	revoked []string
+	err     error
+}
+
+func (m *mockAccountData) DeleteByUserID(userID string) error {
+	m.revoked = append(m.revoked, userID)
+	return m.err
+}
 
 // ─────────────────────────────────────────────────────────────────────────────
 // GetByID
@@ -49,7 +106,7 @@ func (m *mockRepo) Delete(id string) error                      { return m.Delet
 
 func TestService_GetByID(t *testing.T) {
 	repo := &mockRepo{}
-	service := user.NewService(repo)
+	service := user.NewService(repo, &mockSessions{}, user.NewPasswordPolicy(repo, password.Policy{}), &mockAccountData{})
 	userId := "ej55egzg4zdrs2zs6e6cxxzk"
 
 	// ----------------------------------------------------------------
@@ -113,7 +170,7 @@ func TestService_GetByID(t *testing.T) {
 
 func TestService_GetAll(t *testing.T) {
 	repo := &mockRepo{}
-	service := user.NewService(repo)
+	service := user.NewService(repo, &mockSessions{}, user.NewPasswordPolicy(repo, password.Policy{}), &mockAccountData{})
 
 	// ----------------------------------------------------------------
 	// Caso 1: error del repositorio → se propaga
@@ -201,7 +258,7 @@ func TestService_GetAll(t *testing.T) {
 
 func TestService_Update(t *testing.T) {
 	repo := &mockRepo{}
-	service := user.NewService(repo)
+	service := user.NewService(repo, &mockSessions{}, user.NewPasswordPolicy(repo, password.Policy{}), &mockAccountData{})
 	userId := "ej55egzg4zdrs2zs6e6cxxzk"
 
 	// ----------------------------------------------------------------
@@ -408,8 +465,13 @@ func TestService_Update(t *testing.T) {
 
 func TestService_UpdatePassword(t *testing.T) {
 	repo := &mockRepo{}
-	service := user.NewService(repo)
+	sessions := &mockSessions{}
+	service := user.NewService(repo, sessions, user.NewPasswordPolicy(repo, password.Policy{MinLength: 8, History: 3}), &mockAccountData{})
 	userId := "ej55egzg4zdrs2zs6e6cxxzk"
+	currentJTI := "jti-actual"
+	repo.ListPasswordHistoryFn = func(id string, limit int) ([]string, error) {
+		return nil, nil
+	}
 
 	// ----------------------------------------------------------------
 	// Caso 1: usuario no existe → ErrNotFound
@@ -425,7 +487,7 @@ func TestService_UpdatePassword(t *testing.T) {
 			CurrentPassword: "pass123",
 			NewPassword:     "nueva456",
 		}
-		res, err := service.UpdatePassword(userId, req)
+		res, err := service.UpdatePassword(userId, req, currentJTI)
 
 		// THEN
 		assert.ErrorIs(t, err, user.ErrNotFound)
@@ -446,7 +508,7 @@ func TestService_UpdatePassword(t *testing.T) {
 			CurrentPassword: "pass123",
 			NewPassword:     "nueva456",
 		}
-		res, err := service.UpdatePassword(userId, req)
+		res, err := service.UpdatePassword(userId, req, currentJTI)
 
 		// THEN
 		assert.EqualError(t, err, "db timeout")
@@ -468,7 +530,7 @@ func TestService_UpdatePassword(t *testing.T) {
 			CurrentPassword: "incorrecta999",
 			NewPassword:     "nueva456",
 		}
-		res, err := service.UpdatePassword(userId, req)
+		res, err := service.UpdatePassword(userId, req, currentJTI)
 
 		// THEN
 		assert.ErrorIs(t, err, user.ErrInvalidPassword)
@@ -476,7 +538,72 @@ func TestService_UpdatePassword(t *testing.T) {
 	})
 
 	// ----------------------------------------------------------------
-	// Caso 4: repo.UpdatePassword falla → error propagado
+	// Caso 4: la nueva contraseña no cumple la política → 422 por regla
+	// ----------------------------------------------------------------
+	t.Run("Debe retornar un error de validación cuando la nueva contraseña no cumple la política", func(t *testing.T) {
+		// GIVEN: la nueva contraseña es corta y es una de las anteriores
+		hashedPass, _ := utils.HashPassword("correcta123")
+		previous, _ := utils.HashPassword("corta")
+		repo.GetByIDFn = func(id string) (*user.User, error) {
+			return &user.User{ID: userId, Password: hashedPass}, nil
+		}
+		var limit int
+		repo.ListPasswordHistoryFn = func(id string, l int) ([]string, error) {
+			limit = l
+			return []string{previous}, nil
+		}
+		t.Cleanup(func() {
+			repo.ListPasswordHistoryFn = func(id string, limit int) ([]string, error) { return nil, nil }
+		})
+
+		// WHEN
+		req := user.UpdatePasswordUserRequest{
+			CurrentPassword: "correcta123",
+			NewPassword:     "corta",
+		}
+		res, err := service.UpdatePassword(userId, req, currentJTI)
+
+		// THEN: la actual cuenta como una de las 3, así que se piden 2 anteriores
+		var appErr *utils.AppError
+		if assert.ErrorAs(t, err, &appErr) {
+			assert.Equal(t, "VALIDATION_FAILED", appErr.Code)
+			assert.Equal(t, map[string][]password.Violation{"new_password": {
+				{Rule: password.RuleMinLength, Message: "Debe tener al menos 8 caracteres"},
+				{Rule: password.RuleReused, Message: "Ya la has usado hace poco, elige otra"},
+			}}, appErr.Details)
+		}
+		assert.Equal(t, 2, limit)
+		assert.Nil(t, res)
+	})
+
+	// ----------------------------------------------------------------
+	// Caso 5: la nueva contraseña es la actual → reused
+	// ----------------------------------------------------------------
+	t.Run("Debe rechazar la contraseña actual como nueva", func(t *testing.T) {
+		// GIVEN
+		hashedPass, _ := utils.HashPassword("correcta123")
+		repo.GetByIDFn = func(id string) (*user.User, error) {
+			return &user.User{ID: userId, Password: hashedPass}, nil
+		}
+
+		// WHEN
+		req := user.UpdatePasswordUserRequest{
+			CurrentPassword: "correcta123",
+			NewPassword:     "correcta123",
+		}
+		_, err := service.UpdatePassword(userId, req, currentJTI)
+
+		// THEN
+		var appErr *utils.AppError
+		if assert.ErrorAs(t, err, &appErr) {
+			assert.Equal(t, map[string][]password.Violation{"new_password": {
+				{Rule: password.RuleReused, Message: "Ya la has usado hace poco, elige otra"},
+			}}, appErr.Details)
+		}
+	})
+
+	// ----------------------------------------------------------------
+	// Caso 6: repo.UpdatePassword falla → error propagado
 	// ----------------------------------------------------------------
 	t.Run("Debe propagar el error cuando UpdatePassword del repositorio falla", func(t *testing.T) {
 		// GIVEN
@@ -484,7 +611,7 @@ func TestService_UpdatePassword(t *testing.T) {
 		repo.GetByIDFn = func(id string) (*user.User, error) {
 			return &user.User{ID: userId, Password: hashedPass}, nil
 		}
-		repo.UpdatePasswordFn = func(u *user.User) error {
+		repo.UpdatePasswordFn = func(u *user.User, evts ...events.Event) error {
 			return errors.New("db error")
 		}
 
@@ -493,7 +620,7 @@ func TestService_UpdatePassword(t *testing.T) {
 			CurrentPassword: "correcta123",
 			NewPassword:     "nueva456",
 		}
-		res, err := service.UpdatePassword(userId, req)
+		res, err := service.UpdatePassword(userId, req, currentJTI)
 
 		// THEN
 		assert.EqualError(t, err, "db error")
@@ -501,7 +628,7 @@ func TestService_UpdatePassword(t *testing.T) {
 	})
 
 	// ----------------------------------------------------------------
-	// Caso 5: flujo exitoso → contraseña actualizada y hasheada
+	// Caso 7: flujo exitoso → contraseña actualizada y hasheada
 	// ----------------------------------------------------------------
 	t.Run("Debe actualizar la contraseña correctamente cuando todo es válido", func(t *testing.T) {
 		// GIVEN: usuario con contraseña hasheada conocida
@@ -509,7 +636,9 @@ func TestService_UpdatePassword(t *testing.T) {
 		repo.GetByIDFn = func(id string) (*user.User, error) {
 			return &user.User{ID: userId, Password: hashedPass}, nil
 		}
-		repo.UpdatePasswordFn = func(u *user.User) error {
+		var recorded []events.Event
+		repo.UpdatePasswordFn = func(u *user.User, evts ...events.Event) error {
+			recorded = evts
 			return nil
 		}
 
@@ -518,13 +647,23 @@ func TestService_UpdatePassword(t *testing.T) {
 			CurrentPassword: "correcta123",
 			NewPassword:     "nueva456",
 		}
-		res, err := service.UpdatePassword(userId, req)
+		res, err := service.UpdatePassword(userId, req, currentJTI)
 
 		// THEN: la contraseña almacenada ya no es la original y verifica la nueva
 		assert.NoError(t, err)
 		assert.NotNil(t, res)
 		assert.NotEqual(t, hashedPass, res.Password)
 		assert.True(t, utils.CheckPasswordHash("nueva456", res.Password))
+
+		// THEN: el cambio se registra en el outbox junto con la contraseña
+		if assert.Len(t, recorded, 1) {
+			assert.Equal(t, events.PasswordChanged, recorded[0].Type)
+			assert.Equal(t, userId, recorded[0].UserID)
+		}
+
+		// THEN: se cierran las demás sesiones y se conserva la actual
+		assert.Equal(t, []string{userId}, sessions.revoked)
+		assert.Equal(t, []string{currentJTI}, sessions.kept)
 	})
 }
 
@@ -533,18 +672,20 @@ func TestService_UpdatePassword(t *testing.T) {
 // ─────────────────────────────────────────────────────────────────────────────
 
 func TestService_Delete(t *testing.T) {
-	repo := &mockRepo{}
-	service := user.NewService(repo)
 	userId := "ej55egzg4zdrs2zs6e6cxxzk"
+	existing := &user.User{ID: userId, Name: "Ana", Email: "ana@test.com"}
 
 	// ----------------------------------------------------------------
 	// Caso 1: usuario no existe → ErrNotFound
 	// ----------------------------------------------------------------
 	t.Run("Debe retornar ErrNotFound cuando el usuario no existe", func(t *testing.T) {
 		// GIVEN
-		repo.DeleteFn = func(id string) error {
-			return gorm.ErrRecordNotFound
+		repo := &mockRepo{
+			GetByIDFn: func(id string) (*user.User, error) {
+				return nil, gorm.ErrRecordNotFound
+			},
 		}
+		service := user.NewService(repo, &mockSessions{}, user.NewPasswordPolicy(repo, password.Policy{}), &mockAccountData{})
 
 		// WHEN
 		err := service.Delete(userId)
@@ -558,30 +699,139 @@ func TestService_Delete(t *testing.T) {
 	// ----------------------------------------------------------------
 	t.Run("Debe propagar el error genérico cuando el repositorio falla", func(t *testing.T) {
 		// GIVEN
-		repo.DeleteFn = func(id string) error {
-			return errors.New("constraint violation")
+		sessions := &mockSessions{}
+		repo := &mockRepo{
+			GetByIDFn: func(id string) (*user.User, error) { return existing, nil },
+			DeleteFn: func(id string, evts ...events.Event) error {
+				return errors.New("constraint violation")
+			},
 		}
+		service := user.NewService(repo, sessions, user.NewPasswordPolicy(repo, password.Policy{}), &mockAccountData{})
 
 		// WHEN
 		err := service.Delete(userId)
 
 		// THEN
 		assert.EqualError(t, err, "constraint violation")
+		assert.Empty(t, sessions.revoked)
 	})
 
 	// ----------------------------------------------------------------
 	// Caso 3: eliminación exitosa
 	// ----------------------------------------------------------------
-	t.Run("Debe eliminar el usuario sin error cuando existe", func(t *testing.T) {
+	t.Run("Debe eliminar el usuario con su evento y borrar sus sesiones y datos de cuenta", func(t *testing.T) {
 		// GIVEN
-		repo.DeleteFn = func(id string) error {
-			return nil
+		var published []events.Event
+		sessions := &mockSessions{}
+		apiKeys := &mockAccountData{}
+		webhooks := &mockAccountData{}
+		repo := &mockRepo{
+			GetByIDFn: func(id string) (*user.User, error) { return existing, nil },
+			DeleteFn: func(id string, evts ...events.Event) error {
+				published = evts
+				return nil
+			},
+		}
+		service := user.NewService(repo, sessions, user.NewPasswordPolicy(repo, password.Policy{}), apiKeys, webhooks)
+
+		// WHEN
+		err := service.Delete(userId)
+
+		// THEN
+		require.NoError(t, err)
+		require.Len(t, published, 1)
+		assert.Equal(t, events.UserDeleted, published[0].Type)
+		assert.Equal(t, userId, published[0].UserID)
+		assert.Equal(t, []string{userId}, sessions.revoked)
+		assert.Equal(t, []string{userId}, apiKeys.revoked)
+		assert.Equal(t, []string{userId}, webhooks.revoked)
+	})
+
+	// ----------------------------------------------------------------
+	// Caso 4: un módulo no puede borrar sus datos → se propaga
+	// ----------------------------------------------------------------
+	t.Run("Debe propagar el error al borrar los datos de la cuenta", func(t *testing.T) {
+		// GIVEN
+		dbErr := errors.New("db error")
+		failing := &mockAccountData{err: dbErr}
+		next := &mockAccountData{}
+		repo := &mockRepo{
+			GetByIDFn: func(id string) (*user.User, error) { return existing, nil },
+			DeleteFn:  func(id string, evts ...events.Event) error { return nil },
 		}
+		service := user.NewService(repo, &mockSessions{}, user.NewPasswordPolicy(repo, password.Policy{}), failing, next)
 
 		// WHEN
 		err := service.Delete(userId)
 
+		// THEN
+		assert.ErrorIs(t, err, dbErr)
+		assert.Empty(t, next.revoked)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// UpdateRole
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestService_UpdateRole(t *testing.T) {
+	userId := "ej55egzg4zdrs2zs6e6cxxzk"
+
+	t.Run("Debe retornar ErrNotFound cuando el usuario no existe", func(t *testing.T) {
+		// GIVEN
+		repo := &mockRepo{}
+		service := user.NewService(repo, &mockSessions{}, user.NewPasswordPolicy(repo, password.Policy{}), &mockAccountData{})
+		repo.GetByIDFn = func(id string) (*user.User, error) {
+			return nil, gorm.ErrRecordNotFound
+		}
+
+		// WHEN
+		res, err := service.UpdateRole(userId, user.UpdateRoleRequest{Role: auth.RoleAdmin})
+
+		// THEN
+		assert.ErrorIs(t, err, user.ErrNotFound)
+		assert.Nil(t, res)
+	})
+
+	t.Run("Debe cambiar el rol y cerrar las sesiones del usuario", func(t *testing.T) {
+		// GIVEN
+		repo := &mockRepo{}
+		sessions := &mockSessions{}
+		service := user.NewService(repo, sessions, user.NewPasswordPolicy(repo, password.Policy{}), &mockAccountData{})
+		repo.GetByIDFn = func(id string) (*user.User, error) {
+			return &user.User{ID: id, Role: auth.RoleMember}, nil
+		}
+		var savedRole auth.Role
+		repo.UpdateRoleFn = func(id string, role auth.Role) error {
+			savedRole = role
+			return nil
+		}
+
+		// WHEN
+		res, err := service.UpdateRole(userId, user.UpdateRoleRequest{Role: auth.RoleAdmin})
+
+		// THEN: el access token lleva el rol, así que las sesiones se cierran
+		assert.NoError(t, err)
+		assert.Equal(t, auth.RoleAdmin, res.Role)
+		assert.Equal(t, auth.RoleAdmin, savedRole)
+		assert.Equal(t, []string{userId}, sessions.revoked)
+	})
+
+	t.Run("No debe cerrar las sesiones cuando el rol no cambia", func(t *testing.T) {
+		// GIVEN
+		repo := &mockRepo{}
+		sessions := &mockSessions{}
+		service := user.NewService(repo, sessions, user.NewPasswordPolicy(repo, password.Policy{}), &mockAccountData{})
+		repo.GetByIDFn = func(id string) (*user.User, error) {
+			return &user.User{ID: id, Role: auth.RoleAdmin}, nil
+		}
+
+		// WHEN
+		res, err := service.UpdateRole(userId, user.UpdateRoleRequest{Role: auth.RoleAdmin})
+
 		// THEN
 		assert.NoError(t, err)
+		assert.Equal(t, auth.RoleAdmin, res.Role)
+		assert.Empty(t, sessions.revoked)
 	})
 }
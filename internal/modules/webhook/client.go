@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// errBlockedAddress lo devuelve la entrega a un endpoint que resuelve a una
+// dirección interna. Cualquier usuario puede registrar URLs, y el worker las
+// llama desde dentro de la red: sin este filtro servirían para explorar
+// hosts y puertos internos a través de response_status.
+var errBlockedAddress = errors.New("la URL del webhook apunta a una dirección interna")
+
+// sharedAddressSpace es 100.64.0.0/10, el rango del NAT de los operadores
+// (RFC 6598), que net/netip no considera privado.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// NewClient devuelve el cliente con el que se entregan los webhooks por
+// defecto. Comprueba cada conexión después de resolver el DNS, así que un
+// nombre que apunta a una IP interna también se rechaza, y no sigue
+// redirecciones: una respuesta 3xx cuenta como fallo.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: rejectInternal,
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// Sin proxy: la conexión tiene que ir al endpoint para poder
+			// comprobar su dirección.
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// rejectInternal es el Control del dialer: recibe la IP ya resuelta.
+func rejectInternal(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errBlockedAddress, address)
+	}
+	if !isPublic(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", errBlockedAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	return addr.IsGlobalUnicast() &&
+		!addr.IsPrivate() &&
+		!addr.IsLoopback() &&
+		!addr.IsLinkLocalUnicast() &&
+		!sharedAddressSpace.Contains(addr)
+}
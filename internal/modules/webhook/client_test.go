@@ -0,0 +1,54 @@
+package webhook_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"image-processing-service/internal/modules/webhook"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClient(t *testing.T) {
+	t.Run("Debe rechazar las direcciones internas antes de conectar", func(t *testing.T) {
+		// GIVEN
+		client := webhook.NewClient(time.Second)
+
+		for _, url := range []string{
+			"http://127.0.0.1/",
+			"http://localhost/",
+			"http://10.0.0.1/",
+			"http://172.16.5.4/",
+			"http://192.168.1.1/",
+			"http://169.254.169.254/latest/meta-data/",
+			"http://100.64.0.1/",
+			"http://0.0.0.0/",
+			"http://[::1]/",
+			"http://[fe80::1]/",
+			"http://[fd00::1]/",
+			"http://[::ffff:127.0.0.1]/",
+		} {
+			// WHEN
+			_, err := client.Get(url)
+
+			// THEN
+			require.Error(t, err, url)
+			assert.Contains(t, err.Error(), "dirección interna", url)
+		}
+	})
+
+	t.Run("No debe seguir las redirecciones", func(t *testing.T) {
+		// GIVEN
+		client := webhook.NewClient(time.Second)
+		req, err := http.NewRequest(http.MethodPost, "https://example.com/redirigido", nil)
+		require.NoError(t, err)
+
+		// WHEN
+		err = client.CheckRedirect(req, []*http.Request{req})
+
+		// THEN
+		assert.ErrorIs(t, err, http.ErrUseLastResponse)
+	})
+}
@@ -0,0 +1,137 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"image-processing-service/internal/modules/job"
+
+	"gorm.io/gorm"
+)
+
+// Cabeceras de cada entrega. SignatureHeader tiene la forma
+// "t=<unix>,v1=<hex>", donde v1 es el HMAC-SHA256 con el secreto del
+// endpoint de "<unix>.<cuerpo>". Incluir la fecha permite al receptor
+// rechazar entregas antiguas reenviadas por un tercero.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// maxResponseBody es lo que se guarda de la respuesta del receptor.
+const maxResponseBody = 2048
+
+type deliverPayload struct {
+	DeliveryID string `json:"delivery_id"`
+}
+
+// Sign calcula el valor de SignatureHeader para body.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return fmt.Sprintf("t=%s,v1=%s", t, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Deliver es el handler del trabajo JobDeliver. Cualquier respuesta que no
+// sea 2xx cuenta como fallo y se reintenta.
+func (s *service) Deliver(ctx context.Context, j *job.Job, progress job.ProgressFunc) (*job.Result, error) {
+	var p deliverPayload
+	if err := json.Unmarshal([]byte(j.Payload), &p); err != nil {
+		return nil, job.Permanent(err)
+	}
+
+	delivery, err := s.repo.FindDeliveryByID(p.DeliveryID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, job.Permanent(err)
+		}
+		return nil, err
+	}
+
+	endpoint, err := s.repo.FindEndpointByID(delivery.EndpointID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// El usuario borró el webhook: no hay a quién entregar.
+			delivery.Status = DeliveryFailed
+			delivery.LastError = "el webhook fue eliminado"
+			s.saveDelivery(delivery)
+			return nil, job.Permanent(errors.New(delivery.LastError))
+		}
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+
+	status, body, err := s.post(ctx, endpoint, delivery, now)
+	delivery.ResponseStatus = status
+	delivery.ResponseBody = body
+
+	if err != nil {
+		delivery.Status = DeliveryFailed
+		delivery.LastError = err.Error()
+		s.saveDelivery(delivery)
+		return nil, err
+	}
+
+	delivery.Status = DeliverySucceeded
+	delivery.LastError = ""
+	delivery.DeliveredAt = &now
+	s.saveDelivery(delivery)
+
+	return &job.Result{}, nil
+}
+
+func (s *service) post(ctx context.Context, endpoint *Endpoint, delivery *Delivery, now time.Time) (int, string, error) {
+	body := []byte(delivery.Payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", job.Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "image-processing-service-webhooks/1")
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, Sign(endpoint.Secret, now, body))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		if errors.Is(err, errBlockedAddress) {
+			return 0, "", job.Permanent(err)
+		}
+		return 0, "", err
+	}
+	defer res.Body.Close()
+
+	raw, _ := io.ReadAll(io.LimitReader(res.Body, maxResponseBody))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, string(raw), fmt.Errorf("el webhook respondió %d", res.StatusCode)
+	}
+
+	return res.StatusCode, string(raw), nil
+}
+
+func (s *service) saveDelivery(delivery *Delivery) {
+	if err := s.repo.UpdateDelivery(delivery); err != nil {
+		log.Printf("No se pudo guardar la entrega %s: %v", delivery.ID, err)
+	}
+}
@@ -0,0 +1,44 @@
+package webhook_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"image-processing-service/internal/modules/webhook"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Sign
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestSign(t *testing.T) {
+	t.Run("Debe firmar la fecha y el cuerpo con HMAC-SHA256", func(t *testing.T) {
+		// GIVEN
+		body := []byte(`{"type":"file.uploaded"}`)
+		at := time.Unix(1760860800, 0)
+		mac := hmac.New(sha256.New, []byte("whsec_test"))
+		mac.Write([]byte("1760860800." + string(body)))
+
+		// WHEN
+		signature := webhook.Sign("whsec_test", at, body)
+
+		// THEN
+		assert.Equal(t, "t=1760860800,v1="+hex.EncodeToString(mac.Sum(nil)), signature)
+	})
+
+	t.Run("Debe cambiar la firma si cambia el secreto, la fecha o el cuerpo", func(t *testing.T) {
+		// GIVEN
+		body := []byte(`{}`)
+		at := time.Unix(1760860800, 0)
+		base := webhook.Sign("secreto", at, body)
+
+		// WHEN / THEN
+		assert.NotEqual(t, base, webhook.Sign("otro", at, body))
+		assert.NotEqual(t, base, webhook.Sign("secreto", at.Add(time.Second), body))
+		assert.NotEqual(t, base, webhook.Sign("secreto", at, []byte(`{ }`)))
+	})
+}
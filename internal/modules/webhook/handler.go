@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"image-processing-service/internal/shared/auth"
+	"image-processing-service/internal/shared/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type Handler interface {
+	Create(w http.ResponseWriter, r *http.Request)
+	List(w http.ResponseWriter, r *http.Request)
+	Delete(w http.ResponseWriter, r *http.Request)
+	ListDeliveries(w http.ResponseWriter, r *http.Request)
+	Redeliver(w http.ResponseWriter, r *http.Request)
+}
+
+type handler struct {
+	service Service
+}
+
+func NewHandler(s Service) Handler {
+	return &handler{service: s}
+}
+
+func (h *handler) Create(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	var req CreateEndpointRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.HandleError(w, utils.ErrInvalidJSON)
+		return
+	}
+
+	if errs := utils.Validate(req); errs != nil {
+		utils.HandleError(w, utils.ValidationError(errs))
+		return
+	}
+
+	endpoint, err := h.service.Create(authUser.UserID, req)
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusCreated, endpoint)
+}
+
+func (h *handler) List(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	endpoints, err := h.service.List(authUser.UserID)
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, endpoints)
+}
+
+func (h *handler) Delete(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	id := chi.URLParam(r, "id")
+	if !utils.IsValidID(id) {
+		utils.HandleError(w, utils.ErrInvalidIDFormat)
+		return
+	}
+
+	if err := h.service.Delete(id, authUser.UserID); err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, map[string]string{"message": "Webhook eliminado correctamente"})
+}
+
+func (h *handler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	id := chi.URLParam(r, "id")
+	if !utils.IsValidID(id) {
+		utils.HandleError(w, utils.ErrInvalidIDFormat)
+		return
+	}
+
+	deliveries, err := h.service.ListDeliveries(id, authUser.UserID)
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusOK, deliveries)
+}
+
+func (h *handler) Redeliver(w http.ResponseWriter, r *http.Request) {
+	authUser, _ := auth.GetAuthUser(r.Context())
+
+	id := chi.URLParam(r, "id")
+	deliveryID := chi.URLParam(r, "deliveryId")
+	if !utils.IsValidID(id) || !utils.IsValidID(deliveryID) {
+		utils.HandleError(w, utils.ErrInvalidIDFormat)
+		return
+	}
+
+	delivery, err := h.service.Redeliver(id, deliveryID, authUser.UserID)
+	if err != nil {
+		utils.HandleError(w, err)
+		return
+	}
+
+	utils.Success(w, http.StatusAccepted, delivery)
+}
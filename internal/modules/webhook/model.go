@@ -0,0 +1,104 @@
+package webhook
+
+import (
+	"strings"
+	"time"
+)
+
+// Endpoint es una URL del usuario que recibe los eventos a los que está
+// suscrita. Secret firma cada entrega; solo se muestra al crearlo.
+type Endpoint struct {
+	ID        string    `gorm:"primaryKey;size=24" json:"id"`
+	UserID    string    `gorm:"not null;index" json:"user_id"`
+	URL       string    `gorm:"not null" json:"url"`
+	Secret    string    `gorm:"not null" json:"-"`
+	Events    string    `gorm:"not null" json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (Endpoint) TableName() string { return "webhook_endpoints" }
+
+// subscribed indica si el endpoint recibe eventos de ese tipo. Events se
+// guarda como lista separada por comas.
+func (e *Endpoint) subscribed(eventType string) bool {
+	for _, t := range e.eventList() {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Endpoint) eventList() []string {
+	if e.Events == "" {
+		return []string{}
+	}
+	return strings.Split(e.Events, ",")
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// Delivery es el registro de la entrega de un evento a un endpoint. Payload
+// es el cuerpo exacto que se envía, para poder reenviarlo idéntico.
+type Delivery struct {
+	ID             string         `gorm:"primaryKey;size=24" json:"id"`
+	EndpointID     string         `gorm:"not null;index;index:idx_webhook_deliveries_endpoint_event,priority:1" json:"endpoint_id"`
+	EventID        string         `gorm:"not null;index:idx_webhook_deliveries_endpoint_event,priority:2" json:"event_id"`
+	EventType      string         `gorm:"not null" json:"event_type"`
+	Payload        string         `gorm:"type:text;not null" json:"payload"`
+	Status         DeliveryStatus `gorm:"not null" json:"status"`
+	Attempts       int            `gorm:"not null;default:0" json:"attempts"`
+	ResponseStatus int            `json:"response_status"`
+	ResponseBody   string         `gorm:"type:text" json:"response_body"`
+	LastError      string         `json:"last_error"`
+	JobID          string         `json:"job_id"`
+	RedeliveryOf   string         `json:"redelivery_of"`
+	CreatedAt      time.Time      `json:"created_at"`
+	LastAttemptAt  *time.Time     `json:"last_attempt_at"`
+	DeliveredAt    *time.Time     `json:"delivered_at"`
+}
+
+func (Delivery) TableName() string { return "webhook_deliveries" }
+
+type CreateEndpointRequest struct {
+	URL    string   `json:"url" validate:"required,http_url,max=2048"`
+	Events []string `json:"events" validate:"required,min=1,dive,oneof=user.signed_up user.password_changed session.refresh_token_reused file.uploaded file.deleted job.completed job.failed"`
+}
+
+type EndpointResponse struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret solo se devuelve al crear el endpoint.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type DeliveryResponse struct {
+	ID             string         `json:"id"`
+	EventID        string         `json:"event_id"`
+	EventType      string         `json:"event_type"`
+	Status         DeliveryStatus `json:"status"`
+	Attempts       int            `json:"attempts"`
+	ResponseStatus int            `json:"response_status,omitempty"`
+	Error          *string        `json:"error"`
+	RedeliveryOf   string         `json:"redelivery_of,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	LastAttemptAt  *time.Time     `json:"last_attempt_at"`
+	DeliveredAt    *time.Time     `json:"delivered_at"`
+}
+
+// payload es el cuerpo JSON que recibe el endpoint.
+type payload struct {
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
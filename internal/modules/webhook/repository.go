@@ -0,0 +1,153 @@
+package webhook
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	CreateEndpoint(endpoint *Endpoint) error
+	FindEndpointsByUserID(userID string) ([]Endpoint, error)
+	FindEndpointByIDAndUserID(id string, userID string) (*Endpoint, error)
+	FindEndpointByID(id string) (*Endpoint, error)
+	DeleteEndpoint(id string, userID string) (bool, error)
+	DeleteEndpointsByUserID(userID string) error
+	CreateDelivery(delivery *Delivery) error
+	FindDeliveryByID(id string) (*Delivery, error)
+	FindDeliveryByIDAndEndpointID(id string, endpointID string) (*Delivery, error)
+	FindOriginalDelivery(endpointID string, eventID string) (*Delivery, error)
+	FindDeliveriesByEndpointID(endpointID string, limit int) ([]Delivery, error)
+	SetDeliveryJob(id string, jobID string) error
+	UpdateDelivery(delivery *Delivery) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) CreateEndpoint(endpoint *Endpoint) error {
+	return r.db.Create(endpoint).Error
+}
+
+func (r *repository) FindEndpointsByUserID(userID string) ([]Endpoint, error) {
+	var endpoints []Endpoint
+
+	if err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&endpoints).Error; err != nil {
+		return nil, err
+	}
+
+	return endpoints, nil
+}
+
+func (r *repository) FindEndpointByIDAndUserID(id string, userID string) (*Endpoint, error) {
+	var endpoint Endpoint
+
+	if err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&endpoint).Error; err != nil {
+		return nil, err
+	}
+
+	return &endpoint, nil
+}
+
+func (r *repository) FindEndpointByID(id string) (*Endpoint, error) {
+	var endpoint Endpoint
+
+	if err := r.db.Where("id = ?", id).First(&endpoint).Error; err != nil {
+		return nil, err
+	}
+
+	return &endpoint, nil
+}
+
+// DeleteEndpoint borra el endpoint y devuelve false si no existía. Las
+// entregas se conservan como historial.
+func (r *repository) DeleteEndpoint(id string, userID string) (bool, error) {
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&Endpoint{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// DeleteEndpointsByUserID borra los webhooks del usuario y sus entregas.
+// Las entregas que aún estén en cola fallan al no encontrarse.
+func (r *repository) DeleteEndpointsByUserID(userID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		endpoints := tx.Model(&Endpoint{}).Select("id").Where("user_id = ?", userID)
+		if err := tx.Where("endpoint_id IN (?)", endpoints).Delete(&Delivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&Endpoint{}).Error
+	})
+}
+
+func (r *repository) CreateDelivery(delivery *Delivery) error {
+	return r.db.Create(delivery).Error
+}
+
+func (r *repository) FindDeliveryByID(id string) (*Delivery, error) {
+	var delivery Delivery
+
+	if err := r.db.Where("id = ?", id).First(&delivery).Error; err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+func (r *repository) FindDeliveryByIDAndEndpointID(id string, endpointID string) (*Delivery, error) {
+	var delivery Delivery
+
+	if err := r.db.Where("id = ? AND endpoint_id = ?", id, endpointID).First(&delivery).Error; err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// FindDeliveriesByEndpointID devuelve las entregas más recientes primero.
+// FindOriginalDelivery devuelve la primera entrega del evento al endpoint,
+// sin contar los reenvíos, o nil si no hay ninguna.
+func (r *repository) FindOriginalDelivery(endpointID string, eventID string) (*Delivery, error) {
+	var delivery Delivery
+
+	err := r.db.Where("endpoint_id = ? AND event_id = ? AND redelivery_of = ''", endpointID, eventID).First(&delivery).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+func (r *repository) FindDeliveriesByEndpointID(endpointID string, limit int) ([]Delivery, error) {
+	var deliveries []Delivery
+
+	err := r.db.Where("endpoint_id = ?", endpointID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (r *repository) SetDeliveryJob(id string, jobID string) error {
+	return r.db.Model(&Delivery{}).Where("id = ?", id).Update("job_id", jobID).Error
+}
+
+func (r *repository) UpdateDelivery(delivery *Delivery) error {
+	return r.db.Model(delivery).Select(
+		"status", "attempts", "response_status", "response_body", "last_error", "last_attempt_at", "delivered_at",
+	).Updates(delivery).Error
+}
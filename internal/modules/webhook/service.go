@@ -0,0 +1,310 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"image-processing-service/internal/modules/job"
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/utils"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotFound         = utils.NewError(404, "WEBHOOK_NOT_FOUND", "Webhook no encontrado", nil)
+	ErrDeliveryNotFound = utils.NewError(404, "WEBHOOK_DELIVERY_NOT_FOUND", "Entrega de webhook no encontrada", nil)
+)
+
+// JobDeliver entrega un evento a un endpoint. Los reintentos con backoff y la
+// cola de muertos los gestiona el pool de trabajos; debe registrarse con
+// RegisterInternal para que las entregas no generen a su vez eventos.
+const JobDeliver = "webhook.deliver"
+
+const deliveriesPageSize = 50
+
+type Service interface {
+	events.Publisher
+	Create(userID string, req CreateEndpointRequest) (*EndpointResponse, error)
+	List(userID string) ([]EndpointResponse, error)
+	Delete(id string, userID string) error
+	// DeleteByUserID borra todos los webhooks del usuario, al borrar su
+	// cuenta, para que no reciban los eventos de la purga.
+	DeleteByUserID(userID string) error
+	ListDeliveries(endpointID string, userID string) ([]DeliveryResponse, error)
+	Redeliver(endpointID string, deliveryID string, userID string) (*DeliveryResponse, error)
+	Deliver(ctx context.Context, j *job.Job, progress job.ProgressFunc) (*job.Result, error)
+}
+
+// JobEnqueuer encola trabajos asíncronos.
+type JobEnqueuer interface {
+	Enqueue(jobType string, userID string, payload interface{}) (*job.Job, error)
+}
+
+// ServiceConfig agrupa las opciones de entrega.
+type ServiceConfig struct {
+	// Client es el cliente HTTP con el que se entregan los eventos. Por
+	// defecto NewClient, con un timeout de 10 segundos, que rechaza las
+	// direcciones internas.
+	Client *http.Client
+}
+
+type service struct {
+	repo   Repository
+	jobs   JobEnqueuer
+	client *http.Client
+}
+
+func NewService(r Repository, j JobEnqueuer, cfg ServiceConfig) Service {
+	client := cfg.Client
+	if client == nil {
+		client = NewClient(10 * time.Second)
+	}
+	return &service{repo: r, jobs: j, client: client}
+}
+
+func (s *service) Create(userID string, req CreateEndpointRequest) (*EndpointResponse, error) {
+	secret, err := generateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := &Endpoint{
+		ID:     utils.GenerateID(),
+		UserID: userID,
+		URL:    req.URL,
+		Secret: secret,
+		Events: strings.Join(uniqueEvents(req.Events), ","),
+	}
+
+	if err := s.repo.CreateEndpoint(endpoint); err != nil {
+		return nil, err
+	}
+
+	res := mapEndpointResponse(endpoint)
+	res.Secret = secret
+	return &res, nil
+}
+
+func (s *service) List(userID string) ([]EndpointResponse, error) {
+	endpoints, err := s.repo.FindEndpointsByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]EndpointResponse, 0, len(endpoints))
+	for i := range endpoints {
+		res = append(res, mapEndpointResponse(&endpoints[i]))
+	}
+	return res, nil
+}
+
+func (s *service) Delete(id string, userID string) error {
+	deleted, err := s.repo.DeleteEndpoint(id, userID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *service) DeleteByUserID(userID string) error {
+	return s.repo.DeleteEndpointsByUserID(userID)
+}
+
+func (s *service) ListDeliveries(endpointID string, userID string) ([]DeliveryResponse, error) {
+	if _, err := s.findEndpoint(endpointID, userID); err != nil {
+		return nil, err
+	}
+
+	deliveries, err := s.repo.FindDeliveriesByEndpointID(endpointID, deliveriesPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]DeliveryResponse, 0, len(deliveries))
+	for i := range deliveries {
+		res = append(res, mapDeliveryResponse(&deliveries[i]))
+	}
+	return res, nil
+}
+
+// Redeliver vuelve a enviar el mismo cuerpo de una entrega anterior como
+// una entrega nueva, con su propio registro y sus propios reintentos.
+func (s *service) Redeliver(endpointID string, deliveryID string, userID string) (*DeliveryResponse, error) {
+	endpoint, err := s.findEndpoint(endpointID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	original, err := s.repo.FindDeliveryByIDAndEndpointID(deliveryID, endpoint.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDeliveryNotFound
+		}
+		return nil, err
+	}
+
+	delivery, err := s.enqueue(endpoint, original.EventID, original.EventType, original.Payload, original.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := mapDeliveryResponse(delivery)
+	return &res, nil
+}
+
+// Publish crea una entrega por cada endpoint del usuario suscrito al evento.
+// Si el relay repite el evento no se duplican las entregas: solo se encola
+// el trabajo de las que se quedaron sin él.
+func (s *service) Publish(event events.Event) error {
+	endpoints, err := s.repo.FindEndpointsByUserID(event.UserID)
+	if err != nil {
+		return err
+	}
+
+	var (
+		body []byte
+		errs []error
+	)
+	for i := range endpoints {
+		endpoint := &endpoints[i]
+		if !endpoint.subscribed(event.Type) {
+			continue
+		}
+
+		existing, err := s.repo.FindOriginalDelivery(endpoint.ID, event.ID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if existing != nil {
+			if existing.JobID == "" {
+				errs = append(errs, s.enqueueJob(endpoint, existing))
+			}
+			continue
+		}
+
+		if body == nil {
+			body, err = json.Marshal(payload{
+				ID:         event.ID,
+				Type:       event.Type,
+				OccurredAt: event.OccurredAt,
+				Data:       event.Data,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, err := s.enqueue(endpoint, event.ID, event.Type, string(body), ""); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *service) enqueue(endpoint *Endpoint, eventID string, eventType string, body string, redeliveryOf string) (*Delivery, error) {
+	delivery := &Delivery{
+		ID:           utils.GenerateID(),
+		EndpointID:   endpoint.ID,
+		EventID:      eventID,
+		EventType:    eventType,
+		Payload:      body,
+		Status:       DeliveryPending,
+		RedeliveryOf: redeliveryOf,
+	}
+
+	if err := s.repo.CreateDelivery(delivery); err != nil {
+		return nil, err
+	}
+
+	if err := s.enqueueJob(endpoint, delivery); err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+func (s *service) enqueueJob(endpoint *Endpoint, delivery *Delivery) error {
+	deliveryJob, err := s.jobs.Enqueue(JobDeliver, endpoint.UserID, deliverPayload{DeliveryID: delivery.ID})
+	if err != nil {
+		return err
+	}
+
+	delivery.JobID = deliveryJob.ID
+	if err := s.repo.SetDeliveryJob(delivery.ID, deliveryJob.ID); err != nil {
+		log.Printf("No se pudo enlazar la entrega %s con su trabajo: %v", delivery.ID, err)
+	}
+
+	return nil
+}
+
+func (s *service) findEndpoint(id string, userID string) (*Endpoint, error) {
+	endpoint, err := s.repo.FindEndpointByIDAndUserID(id, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return endpoint, nil
+}
+
+func generateSecret() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(raw), nil
+}
+
+func uniqueEvents(types []string) []string {
+	seen := make(map[string]bool, len(types))
+	unique := make([]string, 0, len(types))
+	for _, t := range types {
+		if !seen[t] {
+			seen[t] = true
+			unique = append(unique, t)
+		}
+	}
+	return unique
+}
+
+func mapEndpointResponse(endpoint *Endpoint) EndpointResponse {
+	return EndpointResponse{
+		ID:        endpoint.ID,
+		URL:       endpoint.URL,
+		Events:    endpoint.eventList(),
+		CreatedAt: endpoint.CreatedAt,
+	}
+}
+
+func mapDeliveryResponse(delivery *Delivery) DeliveryResponse {
+	res := DeliveryResponse{
+		ID:             delivery.ID,
+		EventID:        delivery.EventID,
+		EventType:      delivery.EventType,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		ResponseStatus: delivery.ResponseStatus,
+		RedeliveryOf:   delivery.RedeliveryOf,
+		CreatedAt:      delivery.CreatedAt,
+		LastAttemptAt:  delivery.LastAttemptAt,
+		DeliveredAt:    delivery.DeliveredAt,
+	}
+	if delivery.LastError != "" {
+		res.Error = utils.Pointer(delivery.LastError)
+	}
+	return res
+}
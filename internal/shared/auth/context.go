@@ -2,16 +2,79 @@ package auth
 
 import "context"
 
+// Role es el rol de un usuario. Los tokens emitidos antes de que existieran
+// los roles no lo llevan y se tratan como RoleMember.
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+)
+
+// Scope es un permiso que se concede a una API key. Las sesiones
+// interactivas no tienen scopes: pueden hacer todo lo que permite su rol.
+type Scope string
+
+const (
+	ScopeFilesRead   Scope = "files:read"
+	ScopeFilesWrite  Scope = "files:write"
+	ScopeFilesDelete Scope = "files:delete"
+)
+
+// AuthenticatedUser es quien hace la petición. Si se autenticó con una API
+// key, APIKeyID la identifica y Scopes son sus permisos; si no, JTI es el
+// del access token.
 type AuthenticatedUser struct {
-	UserID string
-	JTI    string
+	UserID   string
+	JTI      string
+	Role     Role
+	APIKeyID string
+	Scopes   []Scope
+}
+
+// IsAPIKey indica si la petición se autenticó con una API key.
+func (u AuthenticatedUser) IsAPIKey() bool {
+	return u.APIKeyID != ""
+}
+
+// HasScope indica si el usuario puede hacer lo que cubre scope. Una sesión
+// interactiva puede siempre.
+func (u AuthenticatedUser) HasScope(scope Scope) bool {
+	if !u.IsAPIKey() {
+		return true
+	}
+	for _, s := range u.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole indica si el usuario tiene alguno de los roles.
+func (u AuthenticatedUser) HasRole(roles ...Role) bool {
+	for _, role := range roles {
+		if u.Role == role {
+			return true
+		}
+	}
+	return false
 }
 
 type contextKey string
 
-const AuthKey contextKey = "auth_info"
+const (
+	AuthKey     contextKey = "auth_info"
+	ClientIPKey contextKey = "client_ip"
+)
 
 func GetAuthUser(ctx context.Context) (AuthenticatedUser, bool) {
 	user, ok := ctx.Value(AuthKey).(AuthenticatedUser)
 	return user, ok
 }
+
+// GetClientIP devuelve la IP del cliente que resolvió el middleware RealIP.
+func GetClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(ClientIPKey).(string)
+	return ip
+}
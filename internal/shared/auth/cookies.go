@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"image-processing-service/internal/shared/utils"
+)
+
+// ErrInvalidCSRFToken lo recibe una petición autenticada con cookies que no
+// repite el token CSRF.
+var ErrInvalidCSRFToken = utils.NewError(403, "INVALID_CSRF_TOKEN", "Falta el token CSRF o no coincide con el de la sesión", nil)
+
+// Modo cookie: los navegadores que no quieren guardar los tokens en
+// localStorage piden con SessionModeHeader que los tokens vayan en cookies
+// HttpOnly. Como el navegador envía las cookies solo, las peticiones que
+// modifican algo deben repetir en CSRFHeader el valor de la cookie
+// CSRFCookie, que una web de otro origen no puede leer (double-submit).
+const (
+	SessionModeHeader = "X-Session-Mode"
+	SessionModeCookie = "cookie"
+
+	AccessCookie  = "access_token"
+	RefreshCookie = "refresh_token"
+	CSRFCookie    = "csrf_token"
+	CSRFHeader    = "X-CSRF-Token"
+
+	// refreshCookiePath limita la cookie del refresh token a las rutas de
+	// autenticación: el resto de la API no la necesita.
+	refreshCookiePath = "/api/v1/auth"
+	accessCookiePath  = "/api"
+)
+
+// CookieConfig es cómo se emiten las cookies del modo cookie.
+type CookieConfig struct {
+	// Secure solo debe desactivarse en desarrollo, sin HTTPS.
+	Secure   bool
+	SameSite http.SameSite
+	// Domain permite compartir las cookies con subdominios. Vacío, solo las
+	// recibe el host de la API.
+	Domain string
+	// Duración de cada cookie: la de su token.
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// ParseSameSite convierte el valor de COOKIE_SAME_SITE.
+func ParseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("valor de SameSite desconocido: %q", value)
+	}
+}
+
+// WantsCookies indica si la petición pide el modo cookie.
+func WantsCookies(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get(SessionModeHeader), SessionModeCookie)
+}
+
+// SetSessionCookies guarda los tokens en cookies HttpOnly y emite un token
+// CSRF nuevo, que devuelve para que el cliente no tenga que leer la cookie.
+func (c CookieConfig) SetSessionCookies(w http.ResponseWriter, accessToken, refreshToken string) (string, error) {
+	csrf, err := newCSRFToken()
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, c.cookie(AccessCookie, accessToken, accessCookiePath, c.AccessTTL, true))
+	http.SetCookie(w, c.cookie(RefreshCookie, refreshToken, refreshCookiePath, c.RefreshTTL, true))
+	// El JavaScript del frontend lee esta para copiarla en CSRFHeader.
+	http.SetCookie(w, c.cookie(CSRFCookie, csrf, "/", c.RefreshTTL, false))
+
+	return csrf, nil
+}
+
+// ClearSessionCookies borra las cookies del modo cookie, al cerrar sesión.
+func (c CookieConfig) ClearSessionCookies(w http.ResponseWriter) {
+	http.SetCookie(w, c.cookie(AccessCookie, "", accessCookiePath, -1, true))
+	http.SetCookie(w, c.cookie(RefreshCookie, "", refreshCookiePath, -1, true))
+	http.SetCookie(w, c.cookie(CSRFCookie, "", "/", -1, false))
+}
+
+func (c CookieConfig) cookie(name, value, path string, ttl time.Duration, httpOnly bool) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   c.Domain,
+		HttpOnly: httpOnly,
+		Secure:   c.Secure,
+		SameSite: c.SameSite,
+	}
+	if ttl < 0 {
+		cookie.MaxAge = -1
+	} else {
+		cookie.MaxAge = int(ttl.Seconds())
+	}
+	return cookie
+}
+
+// ValidCSRF comprueba el double-submit de una petición autenticada con
+// cookies. Los métodos seguros no lo necesitan: no deben modificar nada.
+func ValidCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	cookie, err := r.Cookie(CSRFCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	header := r.Header.Get(CSRFHeader)
+
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) == 1
+}
+
+func newCSRFToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"errors"
 	"image-processing-service/internal/shared/utils"
 	"time"
 
@@ -13,20 +14,24 @@ var (
 
 type AppClaims struct {
 	UserID string `json:"user_id"`
+	Role   Role   `json:"role"`
 	jwt.RegisteredClaims
 }
 
+// TokenManager firma los access tokens con la clave activa del Keyring y
+// los valida con la clave de su kid, de modo que otros servicios pueden
+// comprobarlos con el JWKS público.
 type TokenManager struct {
-	secretKey []byte
-	issuer    string
-	expiry    time.Duration
+	keys   *Keyring
+	issuer string
+	expiry time.Duration
 }
 
-func NewTokenManager(secret string, expiry time.Duration) *TokenManager {
+func NewTokenManager(keys *Keyring, expiry time.Duration) *TokenManager {
 	return &TokenManager{
-		secretKey: []byte(secret),
-		expiry:    expiry,
-		issuer:    "image-processing-service",
+		keys:   keys,
+		expiry: expiry,
+		issuer: "image-processing-service",
 	}
 }
 
@@ -36,12 +41,13 @@ type TokenResponse struct {
 	JTI          string
 }
 
-func (m *TokenManager) GeneratePair(UserID string) (*TokenResponse, error) {
+func (m *TokenManager) GeneratePair(UserID string, role Role) (*TokenResponse, error) {
 	jti := utils.GenerateID()
 	refreshToken := utils.GenerateID()
 
 	claims := AppClaims{
 		UserID: UserID,
+		Role:   role,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ID:        jti,
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(m.expiry)),
@@ -50,8 +56,14 @@ func (m *TokenManager) GeneratePair(UserID string) (*TokenResponse, error) {
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	accessToken, err := token.SignedString(m.secretKey)
+	key := m.keys.signingKey()
+	if key == nil {
+		return nil, errors.New("no hay ninguna clave de firma activa")
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.algorithm), claims)
+	token.Header["kid"] = key.id
+	accessToken, err := token.SignedString(key.signer)
 	if err != nil {
 		return nil, err
 	}
@@ -63,10 +75,24 @@ func (m *TokenManager) GeneratePair(UserID string) (*TokenResponse, error) {
 	}, nil
 }
 
+// Validate comprueba la firma con la clave del kid, que el algoritmo sea el
+// de esa clave y el emisor.
 func (m *TokenManager) Validate(tokenStr string) (*AppClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenStr, &AppClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return m.secretKey, nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		key := m.keys.verificationKey(kid)
+		if key == nil {
+			return nil, ErrInvalidToken
+		}
+		if token.Method.Alg() != key.algorithm {
+			return nil, ErrInvalidToken
+		}
+		return key.signer.Public(), nil
+	},
+		jwt.WithValidMethods([]string{AlgorithmEdDSA, AlgorithmRS256}),
+		jwt.WithIssuer(m.issuer),
+		jwt.WithExpirationRequired(),
+	)
 	if err != nil || !token.Valid {
 		return nil, ErrInvalidToken
 	}
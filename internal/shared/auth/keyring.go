@@ -0,0 +1,383 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"image-processing-service/internal/shared/utils"
+
+	"gorm.io/gorm"
+)
+
+// Algoritmos de firma admitidos. EdDSA (Ed25519) es el de por defecto: las
+// claves se generan al instante y las firmas son cortas.
+const (
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmRS256 = "RS256"
+)
+
+const (
+	DefaultKeyRotationInterval = 30 * 24 * time.Hour
+	DefaultKeyGracePeriod      = 24 * time.Hour
+
+	// JWKSMaxAge es lo que otros servicios pueden cachear el JWKS. La
+	// siguiente clave se publica al menos este tiempo antes de firmar con
+	// ella, para que nadie reciba un token con un kid que no tiene.
+	JWKSMaxAge = 5 * time.Minute
+
+	rsaKeyBits = 2048
+	// reloadCooldown limita las recargas por un kid desconocido, para que
+	// tokens con kids inventados no lleguen a la base de datos en cada
+	// petición.
+	reloadCooldown = 30 * time.Second
+)
+
+// SigningKey es una clave de firma de los access tokens. Su ID es el kid de
+// la cabecera del JWT. Una clave sin ActivatedAt está pendiente: se publica
+// en el JWKS pero aún no firma. La clave activa es la activada más reciente
+// sin RetiredAt; al rotar, la anterior deja de firmar pero sigue validando
+// hasta ExpiresAt, para que los tokens ya emitidos no caduquen antes de
+// tiempo.
+type SigningKey struct {
+	ID          string    `gorm:"primaryKey;size:24"`
+	Algorithm   string    `gorm:"size:16;not null"`
+	PrivateKey  string    `gorm:"type:text;not null"`
+	CreatedAt   time.Time `gorm:"not null"`
+	ActivatedAt *time.Time
+	RetiredAt   *time.Time
+	ExpiresAt   *time.Time `gorm:"index"`
+}
+
+func (SigningKey) TableName() string {
+	return "signing_keys"
+}
+
+// KeyringConfig configura la rotación. Algorithm es el de las claves
+// nuevas; cambiarlo provoca una rotación. GracePeriod debe ser al menos la
+// duración de los access tokens. Los valores a cero toman los de por
+// defecto.
+type KeyringConfig struct {
+	Algorithm        string
+	RotationInterval time.Duration
+	GracePeriod      time.Duration
+}
+
+// JWK es la parte pública de una clave en formato JSON Web Key (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JWKS es el documento que publica /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+type loadedKey struct {
+	id          string
+	algorithm   string
+	signer      crypto.Signer
+	createdAt   time.Time
+	activatedAt *time.Time
+	expiresAt   *time.Time
+}
+
+// Keyring guarda en memoria las claves vigentes de la base de datos. Varias
+// instancias comparten las claves: una clave creada por otra instancia se
+// carga en la siguiente rotación o al validar un token con su kid.
+type Keyring struct {
+	store  KeyStore
+	config KeyringConfig
+
+	mu     sync.RWMutex
+	active *loadedKey
+	// pending es la siguiente clave: ya publicada, firmará al rotar.
+	pending *loadedKey
+	keys    map[string]*loadedKey
+	// lastMiss es la última recarga provocada por un kid desconocido.
+	lastMiss time.Time
+}
+
+// NewKeyring carga las claves y crea la primera si no hay ninguna.
+func NewKeyring(store KeyStore, cfg KeyringConfig) (*Keyring, error) {
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = AlgorithmEdDSA
+	}
+	if cfg.Algorithm != AlgorithmEdDSA && cfg.Algorithm != AlgorithmRS256 {
+		return nil, fmt.Errorf("algoritmo de firma no soportado: %s", cfg.Algorithm)
+	}
+	if cfg.RotationInterval <= 0 {
+		cfg.RotationInterval = DefaultKeyRotationInterval
+	}
+	if cfg.GracePeriod <= 0 {
+		cfg.GracePeriod = DefaultKeyGracePeriod
+	}
+
+	k := &Keyring{store: store, config: cfg}
+	if err := k.Rotate(time.Now()); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Rotate activa la clave pendiente si la activa es más antigua que
+// RotationInterval o usa otro algoritmo, publica una nueva pendiente y borra
+// las que ya caducaron. La pendiente solo se activa tras llevar JWKSMaxAge
+// publicada. Si otra instancia rota a la vez, se queda con la clave de esa
+// instancia.
+func (k *Keyring) Rotate(now time.Time) error {
+	if err := k.Reload(now); err != nil {
+		return err
+	}
+
+	k.mu.RLock()
+	active, pending := k.active, k.pending
+	k.mu.RUnlock()
+
+	// Una pendiente de otro algoritmo no llegará a firmar: se sustituye.
+	if pending != nil && pending.algorithm != k.config.Algorithm {
+		if err := k.store.DeletePendingKey(pending.id); err != nil {
+			return err
+		}
+		pending = nil
+	}
+
+	if active == nil || active.algorithm != k.config.Algorithm || now.Sub(*active.activatedAt) >= k.config.RotationInterval {
+		var err error
+		switch {
+		case pending != nil && (active == nil || now.Sub(pending.createdAt) >= JWKSMaxAge):
+			var activeID string
+			if active != nil {
+				activeID = active.id
+			}
+			err = k.store.PromoteKey(pending.id, activeID, now, now.Add(k.config.GracePeriod))
+		case active == nil:
+			// Sin ninguna clave no hay JWKS que nadie pueda tener cacheado:
+			// la primera firma desde el principio.
+			err = k.createKey(now, true)
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if err := k.Reload(now); err != nil {
+			return err
+		}
+		k.mu.RLock()
+		pending = k.pending
+		k.mu.RUnlock()
+	}
+
+	if pending == nil {
+		if err := k.createKey(now, false); err != nil {
+			return err
+		}
+	}
+
+	if _, err := k.store.DeleteExpiredKeys(now); err != nil {
+		return err
+	}
+
+	return k.Reload(now)
+}
+
+// createKey guarda una clave nueva del algoritmo configurado, activa o
+// pendiente.
+func (k *Keyring) createKey(now time.Time, active bool) error {
+	key, err := generateKey(k.config.Algorithm, now)
+	if err != nil {
+		return err
+	}
+	if active {
+		key.ActivatedAt = &now
+	}
+	return k.store.CreateKey(key)
+}
+
+// Reload vuelve a leer las claves vigentes.
+func (k *Keyring) Reload(now time.Time) error {
+	stored, err := k.store.ListKeys(now)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*loadedKey, len(stored))
+	var active, pending *loadedKey
+	for _, s := range stored {
+		key, err := parseKey(s)
+		if err != nil {
+			return fmt.Errorf("clave de firma %s: %w", s.ID, err)
+		}
+		keys[key.id] = key
+		switch {
+		case s.RetiredAt != nil:
+		case s.ActivatedAt == nil:
+			// La pendiente publicada hace más tiempo es la siguiente.
+			if pending == nil || key.createdAt.Before(pending.createdAt) {
+				pending = key
+			}
+		case active == nil || key.activatedAt.After(*active.activatedAt):
+			active = key
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = keys
+	k.active = active
+	k.pending = pending
+	return nil
+}
+
+// JWKS devuelve la parte pública de las claves vigentes, de la más reciente
+// a la más antigua.
+func (k *Keyring) JWKS() JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := make([]*loadedKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].createdAt.After(keys[j].createdAt) })
+
+	jwks := JWKS{Keys: make([]JWK, 0, len(keys))}
+	for _, key := range keys {
+		jwks.Keys = append(jwks.Keys, key.jwk())
+	}
+	return jwks
+}
+
+// signingKey devuelve la clave con la que se firma.
+func (k *Keyring) signingKey() *loadedKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// verificationKey devuelve la clave del kid si sigue vigente. Si no la
+// conoce, recarga por si la creó otra instancia.
+func (k *Keyring) verificationKey(kid string) *loadedKey {
+	now := time.Now()
+
+	k.mu.Lock()
+	key, ok := k.keys[kid]
+	reload := !ok && now.Sub(k.lastMiss) >= reloadCooldown
+	if reload {
+		k.lastMiss = now
+	}
+	k.mu.Unlock()
+
+	if reload {
+		if err := k.Reload(now); err != nil {
+			return nil
+		}
+		k.mu.RLock()
+		key, ok = k.keys[kid]
+		k.mu.RUnlock()
+	}
+
+	if !ok || (key.expiresAt != nil && !now.Before(*key.expiresAt)) {
+		return nil
+	}
+	return key
+}
+
+func (key *loadedKey) jwk() JWK {
+	jwk := JWK{KeyID: key.id, Algorithm: key.algorithm, Use: "sig"}
+
+	switch public := key.signer.Public().(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	}
+
+	return jwk
+}
+
+// generateKey crea una clave del algoritmo y la serializa en PKCS #8.
+func generateKey(algorithm string, now time.Time) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+
+	switch algorithm {
+	case AlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		ID:         utils.GenerateID(),
+		Algorithm:  algorithm,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		CreatedAt:  now,
+	}, nil
+}
+
+func parseKey(s SigningKey) (*loadedKey, error) {
+	block, _ := pem.Decode([]byte(s.PrivateKey))
+	if block == nil {
+		return nil, errors.New("PEM no válido")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var signer crypto.Signer
+	switch private := parsed.(type) {
+	case ed25519.PrivateKey:
+		if s.Algorithm != AlgorithmEdDSA {
+			return nil, fmt.Errorf("una clave Ed25519 no sirve para %s", s.Algorithm)
+		}
+		signer = private
+	case *rsa.PrivateKey:
+		if s.Algorithm != AlgorithmRS256 {
+			return nil, fmt.Errorf("una clave RSA no sirve para %s", s.Algorithm)
+		}
+		signer = private
+	default:
+		return nil, fmt.Errorf("tipo de clave no soportado: %T", parsed)
+	}
+
+	return &loadedKey{
+		id:          s.ID,
+		algorithm:   s.Algorithm,
+		signer:      signer,
+		createdAt:   s.CreatedAt,
+		activatedAt: s.ActivatedAt,
+		expiresAt:   s.ExpiresAt,
+	}, nil
+}
@@ -0,0 +1,309 @@
+package auth_test
+
+// Los tests usan SQLite en memoria como almacén de claves, compartido entre
+// varios Keyring para simular varias instancias.
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"image-processing-service/internal/shared/auth"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newKeyStore(t *testing.T) auth.KeyStore {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err, "no se pudo abrir la base de datos en memoria")
+	require.NoError(t, db.AutoMigrate(&auth.SigningKey{}), "no se pudo migrar el esquema")
+
+	return auth.NewKeyStore(db)
+}
+
+func newKeyring(t *testing.T, store auth.KeyStore, cfg auth.KeyringConfig) *auth.Keyring {
+	t.Helper()
+
+	keyring, err := auth.NewKeyring(store, cfg)
+	require.NoError(t, err)
+	return keyring
+}
+
+func accessToken(t *testing.T, m *auth.TokenManager) string {
+	t.Helper()
+
+	pair, err := m.GeneratePair("user-1", auth.RoleMember)
+	require.NoError(t, err)
+	return pair.AccessToken
+}
+
+func kid(t *testing.T, token string) string {
+	t.Helper()
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &auth.AppClaims{})
+	require.NoError(t, err)
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+// storedKey devuelve la clave privada guardada con el kid, para firmar
+// tokens manipulados con una firma válida.
+func storedKey(t *testing.T, store auth.KeyStore, kid string) interface{} {
+	t.Helper()
+
+	keys, err := store.ListKeys(time.Now())
+	require.NoError(t, err)
+	for _, key := range keys {
+		if key.ID != kid {
+			continue
+		}
+		block, _ := pem.Decode([]byte(key.PrivateKey))
+		require.NotNil(t, block)
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		require.NoError(t, err)
+		return private
+	}
+	t.Fatalf("no existe la clave %s", kid)
+	return nil
+}
+
+// publishedKey devuelve la clave del JWKS con el kid.
+func publishedKey(t *testing.T, jwks auth.JWKS, kid string) auth.JWK {
+	t.Helper()
+
+	for _, key := range jwks.Keys {
+		if key.KeyID == kid {
+			return key
+		}
+	}
+	t.Fatalf("el JWKS no publica la clave %s", kid)
+	return auth.JWK{}
+}
+
+func validClaims(issuer string) auth.AppClaims {
+	return auth.AppClaims{
+		UserID: "user-1",
+		Role:   auth.RoleMember,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti-1",
+			Issuer:    issuer,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+}
+
+func TestTokenManager_Validate(t *testing.T) {
+	t.Run("Debe validar un token firmado con EdDSA e indicar el kid", func(t *testing.T) {
+		// GIVEN
+		keyring := newKeyring(t, newKeyStore(t), auth.KeyringConfig{})
+		m := auth.NewTokenManager(keyring, time.Hour)
+		token := accessToken(t, m)
+
+		// WHEN
+		claims, err := m.Validate(token)
+
+		// THEN
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", claims.UserID)
+		key := publishedKey(t, keyring.JWKS(), kid(t, token))
+		assert.Equal(t, "OKP", key.KeyType)
+		assert.Equal(t, "Ed25519", key.Curve)
+		assert.Equal(t, auth.AlgorithmEdDSA, key.Algorithm)
+	})
+
+	t.Run("Debe validar un token firmado con RS256", func(t *testing.T) {
+		// GIVEN
+		keyring := newKeyring(t, newKeyStore(t), auth.KeyringConfig{Algorithm: auth.AlgorithmRS256})
+		m := auth.NewTokenManager(keyring, time.Hour)
+
+		token := accessToken(t, m)
+
+		// WHEN
+		_, err := m.Validate(token)
+
+		// THEN
+		require.NoError(t, err)
+		key := publishedKey(t, keyring.JWKS(), kid(t, token))
+		assert.Equal(t, "RSA", key.KeyType)
+		assert.Equal(t, "AQAB", key.E)
+		assert.NotEmpty(t, key.N)
+	})
+
+	t.Run("Debe rechazar un token de otro emisor", func(t *testing.T) {
+		// GIVEN
+		store := newKeyStore(t)
+		keyring := newKeyring(t, store, auth.KeyringConfig{})
+		m := auth.NewTokenManager(keyring, time.Hour)
+		active := kid(t, accessToken(t, m))
+		forged := jwt.NewWithClaims(jwt.SigningMethodEdDSA, validClaims("otro-servicio"))
+		forged.Header["kid"] = active
+		token, err := forged.SignedString(storedKey(t, store, active))
+		require.NoError(t, err)
+
+		// WHEN
+		_, err = m.Validate(token)
+
+		// THEN
+		assert.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+
+	t.Run("Debe rechazar un token HS256 firmado con la clave pública", func(t *testing.T) {
+		// GIVEN
+		keyring := newKeyring(t, newKeyStore(t), auth.KeyringConfig{})
+		m := auth.NewTokenManager(keyring, time.Hour)
+		jwk := keyring.JWKS().Keys[0]
+		forged := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims("image-processing-service"))
+		forged.Header["kid"] = jwk.KeyID
+		token, err := forged.SignedString([]byte(jwk.X))
+		require.NoError(t, err)
+
+		// WHEN
+		_, err = m.Validate(token)
+
+		// THEN
+		assert.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+
+	t.Run("Debe rechazar un token sin kid", func(t *testing.T) {
+		// GIVEN
+		store := newKeyStore(t)
+		keyring := newKeyring(t, store, auth.KeyringConfig{})
+		m := auth.NewTokenManager(keyring, time.Hour)
+		active := kid(t, accessToken(t, m))
+		forged := jwt.NewWithClaims(jwt.SigningMethodEdDSA, validClaims("image-processing-service"))
+		token, err := forged.SignedString(storedKey(t, store, active))
+		require.NoError(t, err)
+
+		// WHEN
+		_, err = m.Validate(token)
+
+		// THEN
+		assert.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+}
+
+func TestKeyring_Rotate(t *testing.T) {
+	t.Run("Debe seguir validando con la clave anterior durante el periodo de gracia", func(t *testing.T) {
+		// GIVEN
+		cfg := auth.KeyringConfig{RotationInterval: 24 * time.Hour, GracePeriod: time.Hour}
+		keyring := newKeyring(t, newKeyStore(t), cfg)
+		m := auth.NewTokenManager(keyring, time.Hour)
+		old := accessToken(t, m)
+
+		// WHEN
+		require.NoError(t, keyring.Rotate(time.Now().Add(cfg.RotationInterval)))
+
+		// THEN
+		fresh := accessToken(t, m)
+		assert.NotEqual(t, kid(t, old), kid(t, fresh))
+		_, err := m.Validate(old)
+		assert.NoError(t, err)
+		_, err = m.Validate(fresh)
+		assert.NoError(t, err)
+		jwks := keyring.JWKS()
+		assert.Len(t, jwks.Keys, 3, "la anterior, la activa y la siguiente")
+		publishedKey(t, jwks, kid(t, old))
+	})
+
+	t.Run("Debe publicar la siguiente clave antes de firmar con ella", func(t *testing.T) {
+		// GIVEN
+		cfg := auth.KeyringConfig{RotationInterval: 24 * time.Hour}
+		keyring := newKeyring(t, newKeyStore(t), cfg)
+		m := auth.NewTokenManager(keyring, time.Hour)
+		before := keyring.JWKS()
+		require.Len(t, before.Keys, 2, "la activa y la siguiente")
+
+		// WHEN
+		require.NoError(t, keyring.Rotate(time.Now().Add(cfg.RotationInterval)))
+
+		// THEN
+		publishedKey(t, before, kid(t, accessToken(t, m)))
+	})
+
+	t.Run("Debe borrar la clave anterior al acabar el periodo de gracia", func(t *testing.T) {
+		// GIVEN
+		cfg := auth.KeyringConfig{RotationInterval: 24 * time.Hour, GracePeriod: time.Hour}
+		keyring := newKeyring(t, newKeyStore(t), cfg)
+		m := auth.NewTokenManager(keyring, time.Hour)
+		old := accessToken(t, m)
+		rotatedAt := time.Now().Add(cfg.RotationInterval)
+		require.NoError(t, keyring.Rotate(rotatedAt))
+
+		// WHEN
+		require.NoError(t, keyring.Rotate(rotatedAt.Add(cfg.GracePeriod+time.Minute)))
+
+		// THEN
+		_, err := m.Validate(old)
+		assert.ErrorIs(t, err, auth.ErrInvalidToken)
+		assert.Len(t, keyring.JWKS().Keys, 2, "la activa y la siguiente")
+	})
+
+	t.Run("Debe no rotar antes de tiempo", func(t *testing.T) {
+		// GIVEN
+		keyring := newKeyring(t, newKeyStore(t), auth.KeyringConfig{RotationInterval: 24 * time.Hour})
+		m := auth.NewTokenManager(keyring, time.Hour)
+		before := kid(t, accessToken(t, m))
+
+		// WHEN
+		require.NoError(t, keyring.Rotate(time.Now().Add(time.Hour)))
+
+		// THEN
+		assert.Len(t, keyring.JWKS().Keys, 2)
+		assert.Equal(t, before, kid(t, accessToken(t, m)))
+	})
+
+	t.Run("Debe rotar al cambiar de algoritmo", func(t *testing.T) {
+		// GIVEN
+		store := newKeyStore(t)
+		newKeyring(t, store, auth.KeyringConfig{Algorithm: auth.AlgorithmEdDSA})
+
+		keyring := newKeyring(t, store, auth.KeyringConfig{Algorithm: auth.AlgorithmRS256})
+		m := auth.NewTokenManager(keyring, time.Hour)
+		// La clave RS256 acaba de publicarse: todavía firma la EdDSA.
+		require.Equal(t, auth.AlgorithmEdDSA, publishedKey(t, keyring.JWKS(), kid(t, accessToken(t, m))).Algorithm)
+
+		// WHEN
+		require.NoError(t, keyring.Rotate(time.Now().Add(auth.JWKSMaxAge)))
+
+		// THEN
+		assert.Equal(t, auth.AlgorithmRS256, publishedKey(t, keyring.JWKS(), kid(t, accessToken(t, m))).Algorithm)
+		algorithms := []string{}
+		for _, key := range keyring.JWKS().Keys {
+			algorithms = append(algorithms, key.Algorithm)
+		}
+		assert.ElementsMatch(t, []string{auth.AlgorithmEdDSA, auth.AlgorithmRS256, auth.AlgorithmRS256}, algorithms)
+	})
+
+	t.Run("Debe validar los tokens de una clave creada por otra instancia", func(t *testing.T) {
+		// GIVEN
+		store := newKeyStore(t)
+		cfg := auth.KeyringConfig{RotationInterval: 24 * time.Hour}
+		a := newKeyring(t, store, cfg)
+		b := newKeyring(t, store, cfg)
+		require.NoError(t, a.Rotate(time.Now().Add(cfg.RotationInterval)))
+
+		// WHEN
+		_, err := auth.NewTokenManager(b, time.Hour).Validate(accessToken(t, auth.NewTokenManager(a, time.Hour)))
+
+		// THEN
+		assert.NoError(t, err)
+	})
+}
+
+func TestNewKeyring(t *testing.T) {
+	t.Run("Debe rechazar un algoritmo no soportado", func(t *testing.T) {
+		// WHEN
+		_, err := auth.NewKeyring(newKeyStore(t), auth.KeyringConfig{Algorithm: "HS256"})
+
+		// THEN
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// KeyStore guarda las claves de firma que comparten todas las instancias.
+type KeyStore interface {
+	ListKeys(now time.Time) ([]SigningKey, error)
+	CreateKey(key *SigningKey) error
+	PromoteKey(pendingID, activeID string, activatedAt, expiresAt time.Time) error
+	DeletePendingKey(id string) error
+	DeleteExpiredKeys(now time.Time) (int64, error)
+}
+
+type keyStore struct {
+	db *gorm.DB
+}
+
+func NewKeyStore(db *gorm.DB) KeyStore {
+	return &keyStore{db: db}
+}
+
+// ListKeys devuelve las claves que no han caducado.
+func (s *keyStore) ListKeys(now time.Time) ([]SigningKey, error) {
+	var keys []SigningKey
+
+	err := s.db.Where("expires_at IS NULL OR expires_at > ?", now).
+		Order("created_at").
+		Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// CreateKey guarda una clave nueva. Sin ActivatedAt queda pendiente: se
+// publica en el JWKS pero todavía no firma.
+func (s *keyStore) CreateKey(key *SigningKey) error {
+	return s.db.Create(key).Error
+}
+
+// PromoteKey retira la clave activeID y activa la pendiente pendingID. Si
+// otra instancia ya rotó, devuelve gorm.ErrRecordNotFound sin cambiar nada.
+// Con activeID vacío solo activa la pendiente.
+func (s *keyStore) PromoteKey(pendingID, activeID string, activatedAt, expiresAt time.Time) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if activeID != "" {
+			result := tx.Model(&SigningKey{}).
+				Where("id = ? AND retired_at IS NULL", activeID).
+				Updates(map[string]interface{}{"retired_at": activatedAt, "expires_at": expiresAt})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+		}
+
+		result := tx.Model(&SigningKey{}).
+			Where("id = ? AND activated_at IS NULL AND retired_at IS NULL", pendingID).
+			Update("activated_at", activatedAt)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// DeletePendingKey borra una clave pendiente. Nunca ha firmado, así que no
+// hay tokens que dependan de ella.
+func (s *keyStore) DeletePendingKey(id string) error {
+	return s.db.Where("id = ? AND activated_at IS NULL", id).Delete(&SigningKey{}).Error
+}
+
+func (s *keyStore) DeleteExpiredKeys(now time.Time) (int64, error) {
+	result := s.db.Where("expires_at <= ?", now).Delete(&SigningKey{})
+	return result.RowsAffected, result.Error
+}
@@ -3,6 +3,11 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"image-processing-service/internal/shared/ratelimit"
 
 	"github.com/joho/godotenv"
 )
@@ -10,7 +15,6 @@ import (
 type Config struct {
 	DatabaseURL       string
 	Port              string
-	SecretKey         string
 	EnableAutoMigrate bool
 	S3Bucket          string
 	S3Region          string
@@ -18,6 +22,121 @@ type Config struct {
 	S3AccessKey       string
 	S3SecretKey       string
 	S3ForcePath       bool
+	// Cache-Control de las imágenes servidas. Los originales son inmutables
+	// (su clave incluye el ID del archivo); las derivadas pueden regenerarse.
+	CacheControlOriginals   string
+	CacheControlDerivatives string
+	// Comparte los objetos deduplicados entre usuarios distintos.
+	DedupAcrossUsers bool
+	// Cuota de almacenamiento por usuario (originales más derivadas). Cero
+	// significa sin límite.
+	QuotaMaxBytes int64
+	QuotaMaxFiles int64
+	// Cola de trabajos asíncronos: workers del pool, intentos antes de la
+	// cola de muertos e intervalo de sondeo cuando no hay trabajo.
+	JobWorkers      int
+	JobMaxAttempts  int
+	JobPollInterval time.Duration
+	// Tiempo que se conservan los eventos del stream SSE. Un cliente
+	// desconectado más tiempo no puede reanudar sin perder eventos.
+	EventLogRetention time.Duration
+	// Cada cuánto consulta el relay los eventos pendientes del outbox.
+	OutboxPollInterval time.Duration
+	// Rate limiting: presupuesto de cada grupo de rutas con el formato
+	// <peticiones>/<ventana>, por ejemplo 10/1m. "0" lo desactiva.
+	RateLimitSignIn   ratelimit.Limit
+	RateLimitUploads  ratelimit.Limit
+	RateLimitDelivery ratelimit.Limit
+	// IPs o redes CIDR de los proxies cuyo X-Forwarded-For se acepta.
+	TrustedProxies []string
+	// Si está definida, el estado del rate limiting se guarda en Redis y se
+	// comparte entre instancias; si no, cada instancia lo guarda en memoria.
+	// También reparte entre instancias las revocaciones de sesiones.
+	RedisURL string
+	// Caché de sesiones válidas: cuántas se guardan y cuánto se reutilizan.
+	// Sin Redis, el TTL es lo que tarda una revocación en llegar a las demás
+	// instancias.
+	SessionCacheSize int
+	SessionCacheTTL  time.Duration
+	// Caché de API keys válidas, igual que la de sesiones. Sin Redis, el TTL
+	// es lo que tarda un borrado en llegar a las demás instancias.
+	APIKeyCacheSize int
+	APIKeyCacheTTL  time.Duration
+	// Sesiones activas que puede tener cada usuario. Al iniciar sesión por
+	// encima del límite se cierran las más antiguas. "0" lo desactiva.
+	SessionMaxPerUser int
+	// Duración de los access tokens y de los refresh tokens. Un refresh token
+	// sin usar caduca, y con él la sesión; al usarlo se cambia por otro.
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// Cookies del modo cookie de los navegadores: Secure (solo se desactiva
+	// en desarrollo sin HTTPS), SameSite ("strict", "lax" o "none") y un
+	// dominio opcional para compartirlas con subdominios.
+	CookieSecure   bool
+	CookieSameSite string
+	CookieDomain   string
+	// Firma de los access tokens: algoritmo de las claves ("EdDSA" o
+	// "RS256"), cada cuánto se rota la clave y cuánto sigue validando la
+	// anterior.
+	JWTAlgorithm           string
+	JWTKeyRotationInterval time.Duration
+	JWTKeyGracePeriod      time.Duration
+	// Bloqueo de cuentas: fallos seguidos antes de bloquear la cuenta y
+	// duración del bloqueo.
+	LoginMaxFailures     int
+	LoginLockoutDuration time.Duration
+	// Tiempo que se conservan los intentos de inicio de sesión auditados.
+	LoginAttemptRetention time.Duration
+	// Emails de las cuentas que se convierten en administradoras al
+	// arrancar. Sirve para crear el primer administrador.
+	AdminEmails []string
+	// URL del frontend a la que apuntan los enlaces de los correos.
+	AppBaseURL string
+	// Cómo se envían los correos: "smtp", "file" (ficheros .eml en MailDir)
+	// o "log" (por defecto).
+	MailDriver string
+	MailFrom   string
+	MailDir    string
+	SMTPHost   string
+	SMTPPort   int
+	SMTPUser   string
+	SMTPPass   string
+	// Validez de los enlaces de verificación de email y de restablecimiento
+	// de contraseña.
+	VerifyEmailTTL   time.Duration
+	ResetPasswordTTL time.Duration
+	// Rechaza las subidas de usuarios con el email sin verificar.
+	RequireVerifiedEmail bool
+	// Nombre con el que aparece la cuenta en las apps de autenticación.
+	TOTPIssuer string
+	// Parámetros de argon2id para los hashes de contraseñas: memoria en
+	// KiB, iteraciones e hilos. Al cambiarlos, cada hash se recalcula en el
+	// siguiente inicio de sesión del usuario.
+	PasswordArgon2Memory      uint32
+	PasswordArgon2Iterations  uint32
+	PasswordArgon2Parallelism uint8
+	// Política de las contraseñas nuevas: longitud mínima, tipos de
+	// carácter obligatorios (lowercase, uppercase, digit, symbol), cuántas
+	// de las últimas no se pueden repetir y un archivo opcional con más
+	// contraseñas filtradas, una por línea, además de las incluidas.
+	PasswordMinLength       int
+	PasswordRequiredClasses []string
+	PasswordHistory         int
+	PasswordBreachedList    string
+	// Proveedores OpenID Connect con los que se puede iniciar sesión,
+	// nombrados en OIDC_PROVIDERS. El frontend recibe la respuesta del
+	// proveedor en APP_BASE_URL/oidc/<nombre>/callback.
+	OIDCProviders []OIDCProvider
+}
+
+// OIDCProvider es un proveedor de OIDC_PROVIDERS, configurado con las
+// variables OIDC_<NOMBRE>_ISSUER, _CLIENT_ID, _CLIENT_SECRET y _SCOPES.
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
 }
 
 func NewEnv() *Config {
@@ -36,15 +155,9 @@ func NewEnv() *Config {
 		port = "3000"
 	}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET no configurado")
-	}
-
 	return &Config{
 		DatabaseURL:       dbUrl,
 		Port:              port,
-		SecretKey:         jwtSecret,
 		EnableAutoMigrate: os.Getenv("ENABLE_GORM_AUTOMIGRATE") == "true",
 		S3Bucket:          os.Getenv("STORAGE_BUCKET_NAME"),
 		S3Region:          os.Getenv("STORAGE_REGION"),
@@ -52,5 +165,147 @@ func NewEnv() *Config {
 		S3AccessKey:       os.Getenv("STORAGE_ACCESS_KEY_ID"),
 		S3SecretKey:       os.Getenv("STORAGE_SECRET_ACCESS_KEY"),
 		S3ForcePath:       os.Getenv("STORAGE_FORCE_PATH_STYLE") == "true",
+
+		CacheControlOriginals:   getEnvOrDefault("CACHE_CONTROL_ORIGINALS", "private, max-age=31536000, immutable"),
+		CacheControlDerivatives: getEnvOrDefault("CACHE_CONTROL_DERIVATIVES", "private, max-age=86400"),
+		DedupAcrossUsers:        os.Getenv("DEDUP_ACROSS_USERS") == "true",
+
+		QuotaMaxBytes: getEnvInt64("QUOTA_MAX_BYTES", 0),
+		QuotaMaxFiles: getEnvInt64("QUOTA_MAX_FILES", 0),
+
+		JobWorkers:      int(getEnvInt64("JOB_WORKERS", 4)),
+		JobMaxAttempts:  int(getEnvInt64("JOB_MAX_ATTEMPTS", 5)),
+		JobPollInterval: getEnvDuration("JOB_POLL_INTERVAL", time.Second),
+
+		EventLogRetention:  getEnvDuration("EVENT_LOG_RETENTION", 7*24*time.Hour),
+		OutboxPollInterval: getEnvDuration("OUTBOX_POLL_INTERVAL", 500*time.Millisecond),
+
+		RateLimitSignIn:   getEnvLimit("RATE_LIMIT_SIGNIN", "10/1m"),
+		RateLimitUploads:  getEnvLimit("RATE_LIMIT_UPLOADS", "60/1m"),
+		RateLimitDelivery: getEnvLimit("RATE_LIMIT_DELIVERY", "600/1m"),
+		TrustedProxies:    getEnvList("TRUSTED_PROXIES"),
+		RedisURL:          os.Getenv("REDIS_URL"),
+
+		SessionCacheSize:  int(getEnvInt64("SESSION_CACHE_SIZE", 10000)),
+		SessionCacheTTL:   getEnvDuration("SESSION_CACHE_TTL", 10*time.Second),
+		SessionMaxPerUser: int(getEnvInt64("SESSION_MAX_PER_USER", 10)),
+
+		APIKeyCacheSize: int(getEnvInt64("API_KEY_CACHE_SIZE", 10000)),
+		APIKeyCacheTTL:  getEnvDuration("API_KEY_CACHE_TTL", 10*time.Second),
+
+		AccessTokenTTL:  getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 7*24*time.Hour),
+
+		CookieSecure:   os.Getenv("COOKIE_SECURE") != "false",
+		CookieSameSite: getEnvOrDefault("COOKIE_SAME_SITE", "lax"),
+		CookieDomain:   os.Getenv("COOKIE_DOMAIN"),
+
+		JWTAlgorithm:           getEnvOrDefault("JWT_ALGORITHM", "EdDSA"),
+		JWTKeyRotationInterval: getEnvDuration("JWT_KEY_ROTATION_INTERVAL", 30*24*time.Hour),
+		JWTKeyGracePeriod:      getEnvDuration("JWT_KEY_GRACE_PERIOD", 24*time.Hour),
+
+		LoginMaxFailures:      int(getEnvInt64("LOGIN_MAX_FAILURES", 10)),
+		LoginLockoutDuration:  getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		LoginAttemptRetention: getEnvDuration("LOGIN_ATTEMPT_RETENTION", 90*24*time.Hour),
+
+		AdminEmails: getEnvList("ADMIN_EMAILS"),
+
+		AppBaseURL: getEnvOrDefault("APP_BASE_URL", "http://localhost:"+port),
+		MailDriver: getEnvOrDefault("MAIL_DRIVER", "log"),
+		MailFrom:   getEnvOrDefault("MAIL_FROM", "no-reply@localhost"),
+		MailDir:    getEnvOrDefault("MAIL_DIR", "tmp/mail"),
+		SMTPHost:   os.Getenv("SMTP_HOST"),
+		SMTPPort:   int(getEnvInt64("SMTP_PORT", 587)),
+		SMTPUser:   os.Getenv("SMTP_USERNAME"),
+		SMTPPass:   os.Getenv("SMTP_PASSWORD"),
+
+		VerifyEmailTTL:       getEnvDuration("VERIFY_EMAIL_TTL", 48*time.Hour),
+		ResetPasswordTTL:     getEnvDuration("RESET_PASSWORD_TTL", time.Hour),
+		RequireVerifiedEmail: os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
+
+		TOTPIssuer: getEnvOrDefault("TOTP_ISSUER", "Image Processing Service"),
+
+		PasswordArgon2Memory:      uint32(getEnvInt64("PASSWORD_ARGON2_MEMORY", 19*1024)),
+		PasswordArgon2Iterations:  uint32(getEnvInt64("PASSWORD_ARGON2_ITERATIONS", 2)),
+		PasswordArgon2Parallelism: uint8(getEnvInt64("PASSWORD_ARGON2_PARALLELISM", 1)),
+
+		PasswordMinLength:       int(getEnvInt64("PASSWORD_MIN_LENGTH", 8)),
+		PasswordRequiredClasses: getEnvList("PASSWORD_REQUIRED_CLASSES"),
+		PasswordHistory:         int(getEnvInt64("PASSWORD_HISTORY", 5)),
+		PasswordBreachedList:    os.Getenv("PASSWORD_BREACHED_LIST"),
+
+		OIDCProviders: getOIDCProviders(),
+	}
+}
+
+func getEnvOrDefault(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt64(key string, fallback int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Fatalf("%s debe ser un número entero: %v", key, err)
+	}
+	return parsed
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s debe ser una duración válida (por ejemplo 500ms o 2s): %v", key, err)
+	}
+	return parsed
+}
+
+func getEnvLimit(key string, fallback string) ratelimit.Limit {
+	limit, err := ratelimit.ParseLimit(getEnvOrDefault(key, fallback))
+	if err != nil {
+		log.Fatalf("%s debe tener el formato <peticiones>/<ventana> (por ejemplo 10/1m): %v", key, err)
+	}
+	return limit
+}
+
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getOIDCProviders lee la configuración de cada proveedor de
+// OIDC_PROVIDERS. El emisor y el client id son obligatorios.
+func getOIDCProviders() []OIDCProvider {
+	var providers []OIDCProvider
+	for _, name := range getEnvList("OIDC_PROVIDERS") {
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		provider := OIDCProvider{
+			Name:         strings.ToLower(name),
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       getEnvList(prefix + "SCOPES"),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			log.Fatalf("El proveedor OIDC %s necesita %sISSUER y %sCLIENT_ID", name, prefix, prefix)
+		}
+		providers = append(providers, provider)
 	}
+	return providers
 }
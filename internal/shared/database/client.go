@@ -1,9 +1,17 @@
 package database
 
 import (
+	"image-processing-service/internal/modules/activity"
+	"image-processing-service/internal/modules/apikey"
+	"image-processing-service/internal/modules/auth"
 	"image-processing-service/internal/modules/file"
+	"image-processing-service/internal/modules/job"
+	"image-processing-service/internal/modules/quota"
 	"image-processing-service/internal/modules/session"
 	"image-processing-service/internal/modules/user"
+	"image-processing-service/internal/modules/webhook"
+	sharedAuth "image-processing-service/internal/shared/auth"
+	"image-processing-service/internal/shared/outbox"
 	"log"
 
 	"gorm.io/driver/postgres"
@@ -20,10 +28,16 @@ func NewConection(url string, enableAutoMigrate bool) *gorm.DB {
 	}
 
 	if enableAutoMigrate {
-		db.AutoMigrate(user.User{}, session.Session{}, file.File{})
+		AutoMigrate(db)
 		log.Println("GORM AutoMigrate habilitado")
 	}
 
 	log.Println("Conexión a Postgres exitosa")
 	return db
 }
+
+// AutoMigrate migra el esquema de todos los modelos de la aplicación. Se usa
+// con AutoMigrate habilitado y para levantar bases de datos de prueba.
+func AutoMigrate(db *gorm.DB) error {
+	return db.AutoMigrate(&user.User{}, &user.PasswordHistory{}, &session.Session{}, &session.RotatedToken{}, &file.File{}, &file.Blob{}, &quota.Usage{}, &job.Job{}, &webhook.Endpoint{}, &webhook.Delivery{}, &activity.Event{}, &activity.Cursor{}, &outbox.Message{}, &auth.LoginAttempt{}, &auth.Throttle{}, &apikey.APIKey{}, &auth.EmailToken{}, &auth.TwoFactor{}, &auth.RecoveryCode{}, &auth.LoginChallenge{}, &auth.OIDCLogin{}, &auth.ExternalIdentity{}, &sharedAuth.SigningKey{})
+}
@@ -0,0 +1,42 @@
+package events
+
+import (
+	"errors"
+	"sync"
+)
+
+// Handler procesa un evento recibido por el bus.
+type Handler func(event Event) error
+
+// Bus reparte los eventos entre los componentes del proceso que se suscriben
+// a ellos. Es un Publisher más del relay: si algún handler falla, Publish
+// devuelve el error y el evento se reintenta para todos.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers []Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registra un handler que recibe todos los eventos.
+func (b *Bus) Subscribe(handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *Bus) Publish(event Event) error {
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, handler := range handlers {
+		if err := handler(event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,55 @@
+// Package events define los eventos de dominio que los módulos publican y la
+// interfaz con la que se publican, para que quien los produce no dependa de
+// quien los consume (webhooks, logs, etc.).
+package events
+
+import (
+	"time"
+
+	"image-processing-service/internal/shared/utils"
+)
+
+const (
+	UserSignedUp       = "user.signed_up"
+	PasswordChanged    = "user.password_changed"
+	UserDeleted        = "user.deleted"
+	RefreshTokenReused = "session.refresh_token_reused"
+	FileUploaded       = "file.uploaded"
+	FileDeleted        = "file.deleted"
+	JobCompleted       = "job.completed"
+	JobFailed          = "job.failed"
+)
+
+// Types son todos los tipos de evento que se pueden suscribir.
+var Types = []string{UserSignedUp, PasswordChanged, UserDeleted, RefreshTokenReused, FileUploaded, FileDeleted, JobCompleted, JobFailed}
+
+// Event es algo que le ocurrió a un recurso de UserID. Data se serializa a
+// JSON tal cual; los eventos leídos del outbox la traen como
+// json.RawMessage.
+type Event struct {
+	ID         string
+	Type       string
+	UserID     string
+	OccurredAt time.Time
+	Data       interface{}
+}
+
+// New crea un evento con ID y fecha.
+func New(eventType string, userID string, data interface{}) Event {
+	return Event{
+		ID:         utils.GenerateID(),
+		Type:       eventType,
+		UserID:     userID,
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	}
+}
+
+// Publisher entrega eventos a sus consumidores. Los productores no lo usan
+// directamente: escriben los eventos en el outbox, en la misma transacción
+// que el cambio, y el relay los entrega después. Si Publish devuelve error,
+// el relay vuelve a entregar el evento más tarde, así que cada consumidor
+// debe tolerar recibir el mismo evento (mismo ID) más de una vez.
+type Publisher interface {
+	Publish(event Event) error
+}
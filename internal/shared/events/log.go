@@ -0,0 +1,13 @@
+package events
+
+import "log"
+
+// Log es un Publisher que escribe cada evento en el log de la aplicación.
+var Log Publisher = logPublisher{}
+
+type logPublisher struct{}
+
+func (logPublisher) Publish(event Event) error {
+	log.Printf("Evento %s (%s) del usuario %s", event.Type, event.ID, event.UserID)
+	return nil
+}
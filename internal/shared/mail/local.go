@@ -0,0 +1,89 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFileMailer crea un Mailer que guarda cada correo como un fichero .eml
+// en dir, para abrirlo con un cliente de correo en desarrollo.
+func NewFileMailer(dir, from string) Mailer {
+	return &fileMailer{dir: dir, from: from}
+}
+
+func (m *fileMailer) Send(msg Message) error {
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("%s-%s.eml", now.Format("20060102T150405.000000000"), sanitize(msg.To))
+	return os.WriteFile(filepath.Join(m.dir, name), format(m.from, msg, now), 0644)
+}
+
+// sanitize deja solo caracteres seguros para un nombre de fichero.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		case r == '@':
+			return '_'
+		}
+		return -1
+	}, s)
+}
+
+type logMailer struct{}
+
+// NewLogMailer crea un Mailer que escribe los correos en el log. Es el que
+// se usa si no hay nada configurado.
+func NewLogMailer() Mailer {
+	return logMailer{}
+}
+
+func (logMailer) Send(msg Message) error {
+	log.Printf("Correo para %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// MemoryMailer guarda los correos enviados en memoria. Pensado para pruebas.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Sent devuelve los correos enviados a to, del más antiguo al más reciente.
+func (m *MemoryMailer) Sent(to string) []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sent []Message
+	for _, msg := range m.messages {
+		if msg.To == to {
+			sent = append(sent, msg)
+		}
+	}
+	return sent
+}
@@ -0,0 +1,54 @@
+package mail_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"image-processing-service/internal/shared/mail"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileMailer_Send(t *testing.T) {
+	t.Run("Debe guardar el correo como fichero .eml", func(t *testing.T) {
+		// GIVEN
+		dir := t.TempDir()
+		mailer := mail.NewFileMailer(dir, "no-reply@test.com")
+
+		// WHEN
+		err := mailer.Send(mail.Message{To: "ana@test.com", Subject: "Verifica tu email", Body: "Hola Ana"})
+
+		// THEN
+		require.NoError(t, err)
+		files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		assert.True(t, strings.HasSuffix(files[0], "ana_test.com.eml"))
+		raw, err := os.ReadFile(files[0])
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), "From: no-reply@test.com\r\n")
+		assert.Contains(t, string(raw), "To: ana@test.com\r\n")
+		assert.Contains(t, string(raw), "\r\n\r\nHola Ana")
+	})
+
+	t.Run("Debe codificar los asuntos con acentos", func(t *testing.T) {
+		// GIVEN
+		dir := t.TempDir()
+		mailer := mail.NewFileMailer(dir, "no-reply@test.com")
+
+		// WHEN
+		err := mailer.Send(mail.Message{To: "ana@test.com", Subject: "Restablece tu contraseña", Body: "Hola"})
+
+		// THEN
+		require.NoError(t, err)
+		files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+		require.Len(t, files, 1)
+		raw, err := os.ReadFile(files[0])
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), "Subject: =?utf-8?q?")
+		assert.NotContains(t, string(raw), "Subject: Restablece tu contraseña")
+	})
+}
@@ -0,0 +1,40 @@
+// Package mail envía los correos de la aplicación: verificación de email y
+// recuperación de contraseña. Mailer tiene una implementación SMTP para
+// producción y otras que escriben los correos en disco, en el log o en
+// memoria para desarrollo y pruebas.
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"time"
+)
+
+// Message es un correo de texto plano.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer envía correos.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// format serializa el mensaje en formato RFC 5322, con el asunto codificado
+// para admitir acentos.
+func format(from string, msg Message, date time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(msg.Body)
+	return buf.Bytes()
+}
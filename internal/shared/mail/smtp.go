@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTPConfig es el servidor por el que se envían los correos. Sin Username
+// no se autentica.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+type smtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer crea un Mailer que envía por SMTP. smtp.SendMail usa
+// STARTTLS si el servidor lo ofrece.
+func NewSMTPMailer(cfg SMTPConfig) Mailer {
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	return &smtpMailer{
+		addr: net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		auth: auth,
+		from: cfg.From,
+	}
+}
+
+func (m *smtpMailer) Send(msg Message) error {
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, format(m.from, msg, time.Now()))
+}
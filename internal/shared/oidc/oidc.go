@@ -0,0 +1,333 @@
+// Package oidc inicia sesión con proveedores OpenID Connect mediante el
+// flujo authorization code con PKCE (RFC 7636): descubre los endpoints del
+// proveedor, canjea el código y verifica el ID token con las claves
+// públicas de su JWKS.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrUnavailable indica que el proveedor no respondió como se esperaba
+	// al descubrimiento o al canje del código.
+	ErrUnavailable = errors.New("oidc: proveedor no disponible")
+	// ErrInvalidGrant indica que el proveedor rechazó el código.
+	ErrInvalidGrant = errors.New("oidc: código rechazado por el proveedor")
+	// ErrInvalidIDToken indica que el ID token no supera la verificación.
+	ErrInvalidIDToken = errors.New("oidc: ID token no válido")
+)
+
+// supportedAlgorithms son los algoritmos de firma asimétricos admitidos en
+// los ID tokens. Nunca se aceptan HMAC ni "none".
+var supportedAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+const (
+	maxResponseSize = 1 << 20
+	// clockSkew es el margen con el que se comprueban exp e iat.
+	clockSkew = time.Minute
+)
+
+// Config identifica al cliente ante el proveedor. RedirectURL es la página
+// del frontend a la que vuelve el usuario y debe estar registrada en el
+// proveedor. Sin Scopes se piden openid, email y profile.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Identity es el usuario autenticado según el ID token.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgorithms     []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// Provider es un proveedor OpenID Connect. Los endpoints se descubren en el
+// primer uso y se guardan; si el descubrimiento falla se reintenta en la
+// siguiente petición.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu   sync.Mutex
+	meta *metadata
+	keys *keySet
+}
+
+func NewProvider(cfg Config, client *http.Client) *Provider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{config: cfg, client: client}
+}
+
+// AuthCodeURL devuelve la URL del proveedor a la que se envía al usuario.
+// codeChallenge es el S256 del verificador PKCE.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientID)
+	params.Set("redirect_uri", p.config.RedirectURL)
+	params.Set("scope", strings.Join(p.config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return meta.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Authenticate canjea el código por los tokens y devuelve la identidad del
+// ID token, que debe llevar nonce.
+func (p *Provider) Authenticate(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, err := p.exchange(ctx, meta, code, codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.verify(ctx, meta, rawIDToken, nonce)
+}
+
+// NewPKCE genera un verificador PKCE y su challenge S256.
+func NewPKCE() (verifier, challenge string, err error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	verifier = base64.RawURLEncoding.EncodeToString(raw)
+	return verifier, S256(verifier), nil
+}
+
+// S256 es el challenge PKCE del verificador.
+func S256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	var meta metadata
+	endpoint := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(ctx, p.client, endpoint, &meta); err != nil {
+		return nil, err
+	}
+
+	// El emisor anunciado tiene que ser exactamente el configurado (OpenID
+	// Connect Discovery, sección 4.3).
+	if meta.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("%w: el emisor anunciado es %q", ErrUnavailable, meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: faltan endpoints en el descubrimiento", ErrUnavailable)
+	}
+
+	p.meta = &meta
+	p.keys = newKeySet(meta.JWKSURI, p.client)
+	return p.meta, nil
+}
+
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+	Error   string `json:"error"`
+}
+
+// exchange canjea el código en el token endpoint autenticando al cliente
+// con client_secret_basic y devuelve el ID token.
+func (p *Provider) exchange(ctx context.Context, meta *metadata, code, codeVerifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer res.Body.Close()
+
+	var body tokenResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(&body); err != nil {
+		return "", fmt.Errorf("%w: respuesta del token endpoint no válida", ErrUnavailable)
+	}
+
+	switch {
+	case res.StatusCode == http.StatusBadRequest || res.StatusCode == http.StatusUnauthorized:
+		return "", fmt.Errorf("%w: %s", ErrInvalidGrant, body.Error)
+	case res.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("%w: el token endpoint respondió %d", ErrUnavailable, res.StatusCode)
+	case body.IDToken == "":
+		return "", fmt.Errorf("%w: la respuesta no trae id_token", ErrInvalidIDToken)
+	}
+
+	return body.IDToken, nil
+}
+
+type idTokenClaims struct {
+	Nonce           string       `json:"nonce"`
+	Email           string       `json:"email"`
+	EmailVerified   flexibleBool `json:"email_verified"`
+	Name            string       `json:"name"`
+	AuthorizedParty string       `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// flexibleBool acepta email_verified como booleano o como cadena: algunos
+// proveedores lo envían como "true".
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case bool:
+		*b = flexibleBool(v)
+	case string:
+		*b = flexibleBool(v == "true")
+	}
+	return nil
+}
+
+// verify comprueba la firma con la clave del kid, el emisor, la audiencia,
+// las fechas y el nonce (OpenID Connect Core, sección 3.1.3.7).
+func (p *Provider) verify(ctx context.Context, meta *metadata, raw, nonce string) (*Identity, error) {
+	var claims idTokenClaims
+
+	_, err := jwt.ParseWithClaims(raw, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.keys.key(ctx, kid)
+	},
+		jwt.WithValidMethods(allowedAlgorithms(meta.SigningAlgorithms)),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID {
+		return nil, fmt.Errorf("%w: azp no es el cliente", ErrInvalidIDToken)
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: el nonce no coincide", ErrInvalidIDToken)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: falta sub", ErrInvalidIDToken)
+	}
+
+	return &Identity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+	}, nil
+}
+
+// allowedAlgorithms limita los algoritmos admitidos a los que anuncia el
+// proveedor. Si no anuncia ninguno, OpenID Connect exige RS256.
+func allowedAlgorithms(announced []string) []string {
+	if len(announced) == 0 {
+		return []string{"RS256"}
+	}
+
+	allowed := []string{}
+	for _, alg := range announced {
+		for _, supported := range supportedAlgorithms {
+			if alg == supported {
+				allowed = append(allowed, alg)
+			}
+		}
+	}
+	return allowed
+}
+
+func getJSON(ctx context.Context, client *http.Client, endpoint string, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s respondió %d", ErrUnavailable, endpoint, res.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(dst); err != nil {
+		return fmt.Errorf("%w: respuesta de %s no válida", ErrUnavailable, endpoint)
+	}
+	return nil
+}
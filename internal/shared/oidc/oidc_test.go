@@ -0,0 +1,188 @@
+package oidc_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"image-processing-service/internal/shared/oidc"
+	"image-processing-service/internal/shared/oidc/oidctest"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURL = "http://localhost:5173/oidc/mock/callback"
+
+var ana = oidctest.User{Subject: "sub-ana", Email: "ana@test.com", EmailVerified: true, Name: "Ana García"}
+
+// login recorre el flujo hasta tener el code y devuelve lo que el cliente
+// necesita para canjearlo.
+func login(t *testing.T, mock *oidctest.Provider, provider *oidc.Provider) (code, verifier, nonce string) {
+	t.Helper()
+
+	verifier, challenge, err := oidc.NewPKCE()
+	require.NoError(t, err)
+	nonce = "nonce-1"
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state-1", nonce, challenge)
+	require.NoError(t, err)
+	code, state := mock.Authorize(t, authURL, ana)
+	require.Equal(t, "state-1", state)
+
+	return code, verifier, nonce
+}
+
+func TestProvider_AuthCodeURL(t *testing.T) {
+	t.Run("Debe construir la URL de autorización con PKCE a partir del descubrimiento", func(t *testing.T) {
+		// GIVEN
+		mock := oidctest.New(t)
+		provider := oidc.NewProvider(mock.Config(redirectURL), nil)
+
+		// WHEN
+		authURL, err := provider.AuthCodeURL(context.Background(), "state-1", "nonce-1", oidc.S256("verifier"))
+
+		// THEN
+		require.NoError(t, err)
+		parsed, err := url.Parse(authURL)
+		require.NoError(t, err)
+		assert.Equal(t, mock.URL+"/authorize", parsed.Scheme+"://"+parsed.Host+parsed.Path)
+		q := parsed.Query()
+		assert.Equal(t, "code", q.Get("response_type"))
+		assert.Equal(t, oidctest.ClientID, q.Get("client_id"))
+		assert.Equal(t, redirectURL, q.Get("redirect_uri"))
+		assert.Equal(t, "openid email profile", q.Get("scope"))
+		assert.Equal(t, "S256", q.Get("code_challenge_method"))
+		assert.Equal(t, oidc.S256("verifier"), q.Get("code_challenge"))
+	})
+
+	t.Run("Debe fallar si el proveedor no responde", func(t *testing.T) {
+		// GIVEN
+		mock := oidctest.New(t)
+		provider := oidc.NewProvider(mock.Config(redirectURL), nil)
+		mock.Close()
+
+		// WHEN
+		_, err := provider.AuthCodeURL(context.Background(), "state-1", "nonce-1", "challenge")
+
+		// THEN
+		assert.ErrorIs(t, err, oidc.ErrUnavailable)
+	})
+
+	t.Run("Debe fallar si el emisor anunciado no es el configurado", func(t *testing.T) {
+		// GIVEN
+		mock := oidctest.New(t)
+		cfg := mock.Config(redirectURL)
+		cfg.Issuer += "/"
+		provider := oidc.NewProvider(cfg, nil)
+
+		// WHEN
+		_, err := provider.AuthCodeURL(context.Background(), "state-1", "nonce-1", "challenge")
+
+		// THEN
+		assert.ErrorIs(t, err, oidc.ErrUnavailable)
+	})
+}
+
+func TestProvider_Authenticate(t *testing.T) {
+	t.Run("Debe devolver la identidad del ID token", func(t *testing.T) {
+		// GIVEN
+		mock := oidctest.New(t)
+		provider := oidc.NewProvider(mock.Config(redirectURL), nil)
+		code, verifier, nonce := login(t, mock, provider)
+
+		// WHEN
+		identity, err := provider.Authenticate(context.Background(), code, verifier, nonce)
+
+		// THEN
+		require.NoError(t, err)
+		assert.Equal(t, "sub-ana", identity.Subject)
+		assert.Equal(t, "ana@test.com", identity.Email)
+		assert.True(t, identity.EmailVerified)
+		assert.Equal(t, "Ana García", identity.Name)
+	})
+
+	t.Run("Debe rechazar un verificador PKCE que no corresponde", func(t *testing.T) {
+		// GIVEN
+		mock := oidctest.New(t)
+		provider := oidc.NewProvider(mock.Config(redirectURL), nil)
+		code, _, nonce := login(t, mock, provider)
+
+		// WHEN
+		_, err := provider.Authenticate(context.Background(), code, "otro-verificador", nonce)
+
+		// THEN
+		assert.ErrorIs(t, err, oidc.ErrInvalidGrant)
+	})
+
+	t.Run("Debe rechazar un código ya canjeado", func(t *testing.T) {
+		// GIVEN
+		mock := oidctest.New(t)
+		provider := oidc.NewProvider(mock.Config(redirectURL), nil)
+		code, verifier, nonce := login(t, mock, provider)
+		_, err := provider.Authenticate(context.Background(), code, verifier, nonce)
+		require.NoError(t, err)
+
+		// WHEN
+		_, err = provider.Authenticate(context.Background(), code, verifier, nonce)
+
+		// THEN
+		assert.ErrorIs(t, err, oidc.ErrInvalidGrant)
+	})
+
+	t.Run("Debe rechazar un nonce distinto", func(t *testing.T) {
+		// GIVEN
+		mock := oidctest.New(t)
+		provider := oidc.NewProvider(mock.Config(redirectURL), nil)
+		code, verifier, _ := login(t, mock, provider)
+
+		// WHEN
+		_, err := provider.Authenticate(context.Background(), code, verifier, "otro-nonce")
+
+		// THEN
+		assert.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+	})
+
+	tampered := map[string]func(jwt.MapClaims){
+		"para otro cliente": func(c jwt.MapClaims) { c["aud"] = "otro-cliente" },
+		"de otro emisor":    func(c jwt.MapClaims) { c["iss"] = "https://evil.test" },
+		"caducado":          func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"sin sub":           func(c jwt.MapClaims) { delete(c, "sub") },
+		"con varias audiencias y azp ajeno": func(c jwt.MapClaims) {
+			c["aud"] = []string{oidctest.ClientID, "otro-cliente"}
+			c["azp"] = "otro-cliente"
+		},
+	}
+	for name, tamper := range tampered {
+		t.Run("Debe rechazar un ID token "+name, func(t *testing.T) {
+			// GIVEN
+			mock := oidctest.New(t)
+			mock.Tamper = tamper
+			provider := oidc.NewProvider(mock.Config(redirectURL), nil)
+			code, verifier, nonce := login(t, mock, provider)
+
+			// WHEN
+			_, err := provider.Authenticate(context.Background(), code, verifier, nonce)
+
+			// THEN
+			assert.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+		})
+	}
+
+	t.Run("Debe aceptar email_verified como cadena", func(t *testing.T) {
+		// GIVEN
+		mock := oidctest.New(t)
+		mock.Tamper = func(c jwt.MapClaims) { c["email_verified"] = "true" }
+		provider := oidc.NewProvider(mock.Config(redirectURL), nil)
+		code, verifier, nonce := login(t, mock, provider)
+
+		// WHEN
+		identity, err := provider.Authenticate(context.Background(), code, verifier, nonce)
+
+		// THEN
+		require.NoError(t, err)
+		assert.True(t, identity.EmailVerified)
+	})
+}
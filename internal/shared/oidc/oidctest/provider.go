@@ -0,0 +1,221 @@
+// Package oidctest levanta un proveedor OpenID Connect en proceso para las
+// pruebas: descubrimiento, token endpoint con PKCE y JWKS. El paso en el
+// que el usuario inicia sesión en el proveedor se simula con Authorize.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"image-processing-service/internal/shared/oidc"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	ClientID     = "apitest-client"
+	ClientSecret = "apitest-client-secret"
+)
+
+// User es quien inicia sesión en el proveedor.
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type grant struct {
+	user        User
+	redirectURI string
+	challenge   string
+	nonce       string
+}
+
+// Provider es el proveedor de prueba. Tamper, si no es nil, modifica los
+// claims de cada ID token antes de firmarlo, para probar tokens no válidos.
+type Provider struct {
+	*httptest.Server
+	Tamper func(claims jwt.MapClaims)
+
+	key *rsa.PrivateKey
+	kid string
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+// New arranca el proveedor. Se detiene al terminar el test.
+func New(t testing.TB) *Provider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("no se pudo generar la clave del proveedor: %v", err)
+	}
+
+	p := &Provider{key: key, kid: "mock-key-1", grants: map[string]grant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("POST /token", p.token)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+// Config devuelve la configuración de un cliente registrado en el proveedor.
+func (p *Provider) Config(redirectURL string) oidc.Config {
+	return oidc.Config{
+		Issuer:       p.URL,
+		ClientID:     ClientID,
+		ClientSecret: ClientSecret,
+		RedirectURL:  redirectURL,
+	}
+}
+
+// Authorize simula que user inicia sesión en el proveedor con la URL de
+// autorización authURL. Devuelve el code y el state con los que el
+// proveedor redirigiría al frontend.
+func (p *Provider) Authorize(t testing.TB, authURL string, user User) (code, state string) {
+	t.Helper()
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("URL de autorización no válida: %v", err)
+	}
+	q := parsed.Query()
+
+	switch {
+	case parsed.Scheme+"://"+parsed.Host != p.URL || parsed.Path != "/authorize":
+		t.Fatalf("la URL de autorización no apunta al proveedor: %s", authURL)
+	case q.Get("response_type") != "code":
+		t.Fatalf("response_type no es code: %q", q.Get("response_type"))
+	case q.Get("client_id") != ClientID:
+		t.Fatalf("client_id desconocido: %q", q.Get("client_id"))
+	case q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "":
+		t.Fatalf("falta el challenge PKCE S256")
+	case q.Get("state") == "" || q.Get("nonce") == "":
+		t.Fatalf("faltan state o nonce")
+	}
+
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	code = hex.EncodeToString(raw)
+
+	p.mu.Lock()
+	p.grants[code] = grant{
+		user:        user,
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+	}
+	p.mu.Unlock()
+
+	return code, q.Get("state")
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// token canjea el código una sola vez comprobando el cliente, el
+// redirect_uri y el verificador PKCE.
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	}
+	if !ok || id != ClientID || secret != ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	g, found := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+
+	if !found || g.redirectURI != r.PostForm.Get("redirect_uri") || oidc.S256(r.PostForm.Get("code_verifier")) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            p.URL,
+		"sub":            g.user.Subject,
+		"aud":            ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.user.Email,
+		"email_verified": g.user.EmailVerified,
+		"name":           g.user.Name,
+	}
+	if p.Tamper != nil {
+		p.Tamper(claims)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.kid
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": p.kid,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
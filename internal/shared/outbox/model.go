@@ -0,0 +1,28 @@
+// Package outbox guarda los eventos de dominio en la misma transacción que el
+// cambio que los produce y los entrega después a los consumidores. Así un
+// evento nunca se pierde si el proceso cae justo después de confirmar el
+// cambio, ni se publica uno de un cambio que se deshizo.
+package outbox
+
+import "time"
+
+// Message es un evento pendiente o ya entregado. ID da el orden de entrega.
+type Message struct {
+	ID            int64      `gorm:"primaryKey;autoIncrement"`
+	EventID       string     `gorm:"size:24;not null;uniqueIndex"`
+	Type          string     `gorm:"not null"`
+	UserID        string     `gorm:"not null"`
+	Data          string     `gorm:"type:text;not null"`
+	OccurredAt    time.Time  `gorm:"not null"`
+	Attempts      int        `gorm:"not null;default:0"`
+	LastError     string     `gorm:"type:text"`
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_outbox_messages_pending,priority:2"`
+	LockedBy      string     `gorm:"size:64"`
+	LockedUntil   *time.Time ``
+	PublishedAt   *time.Time `gorm:"index:idx_outbox_messages_pending,priority:1"`
+	CreatedAt     time.Time  ``
+}
+
+func (Message) TableName() string {
+	return "outbox_messages"
+}
@@ -0,0 +1,164 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/utils"
+)
+
+// RelayConfig agrupa las opciones del relay. Los valores a cero toman un
+// valor por defecto razonable.
+type RelayConfig struct {
+	PollInterval time.Duration
+	// BatchSize es el máximo de mensajes que se reservan de una vez.
+	BatchSize int
+	// BaseBackoff es la espera tras el primer fallo; se duplica en cada
+	// intento hasta MaxBackoff. Los mensajes no se descartan nunca.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// LeaseTimeout es cuánto dura la reserva de un lote. Si el relay cae,
+	// otra instancia lo entrega cuando vence.
+	LeaseTimeout time.Duration
+}
+
+// Relay lee los mensajes pendientes del outbox y los entrega, en orden, a
+// cada publisher. Un mensaje se marca como entregado solo cuando todos lo
+// aceptan; si alguno falla se reintenta para todos, así que la entrega es al
+// menos una vez.
+type Relay struct {
+	repo       Repository
+	publishers []events.Publisher
+	config     RelayConfig
+	name       string
+	wg         sync.WaitGroup
+}
+
+func NewRelay(r Repository, cfg RelayConfig, publishers ...events.Publisher) *Relay {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.BaseBackoff <= 0 {
+		cfg.BaseBackoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 5 * time.Minute
+	}
+	if cfg.LeaseTimeout <= 0 {
+		cfg.LeaseTimeout = time.Minute
+	}
+
+	hostname, _ := os.Hostname()
+
+	return &Relay{
+		repo:       r,
+		publishers: publishers,
+		config:     cfg,
+		name:       fmt.Sprintf("%s-%s", hostname, utils.GenerateID()),
+	}
+}
+
+// Start arranca el relay. Se detiene al cancelar ctx, después de terminar el
+// lote en curso.
+func (r *Relay) Start(ctx context.Context) {
+	r.wg.Add(1)
+	go r.run(ctx)
+}
+
+// Wait bloquea hasta que el relay se haya detenido.
+func (r *Relay) Wait() {
+	r.wg.Wait()
+}
+
+func (r *Relay) run(ctx context.Context) {
+	defer r.wg.Done()
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		claimed, err := r.relayBatch()
+		if err != nil {
+			log.Printf("Error leyendo el outbox: %v", err)
+		}
+
+		// Un lote lleno indica que quedan más mensajes: se sigue sin esperar.
+		if claimed == r.config.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.config.PollInterval):
+		}
+	}
+}
+
+func (r *Relay) relayBatch() (int, error) {
+	now := time.Now().UTC()
+	messages, err := r.repo.Claim(r.name, r.config.BatchSize, now, now.Add(r.config.LeaseTimeout))
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range messages {
+		r.deliver(&messages[i])
+	}
+
+	return len(messages), nil
+}
+
+func (r *Relay) deliver(message *Message) {
+	event := events.Event{
+		ID:         message.EventID,
+		Type:       message.Type,
+		UserID:     message.UserID,
+		OccurredAt: message.OccurredAt,
+		Data:       json.RawMessage(message.Data),
+	}
+
+	var errs []error
+	for _, publisher := range r.publishers {
+		if err := publisher.Publish(event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	now := time.Now().UTC()
+	if err := errors.Join(errs...); err != nil {
+		log.Printf("No se pudo entregar el evento %s (%s), intento %d: %v", event.ID, event.Type, message.Attempts+1, err)
+		if err := r.repo.Retry(message.ID, err.Error(), now.Add(r.backoff(message.Attempts+1))); err != nil {
+			log.Printf("No se pudo reprogramar el evento %s: %v", event.ID, err)
+		}
+		return
+	}
+
+	if err := r.repo.MarkPublished(message.ID, now); err != nil {
+		log.Printf("No se pudo marcar como entregado el evento %s: %v", event.ID, err)
+	}
+}
+
+// backoff devuelve la espera antes del siguiente intento: BaseBackoff tras el
+// primero, el doble tras el segundo, y así hasta MaxBackoff.
+func (r *Relay) backoff(attempts int) time.Duration {
+	delay := r.config.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= r.config.MaxBackoff {
+			return r.config.MaxBackoff
+		}
+	}
+	return delay
+}
@@ -0,0 +1,119 @@
+package outbox_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/outbox"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// recorder es un Publisher que guarda los eventos recibidos y falla las
+// primeras failures veces.
+type recorder struct {
+	mu       sync.Mutex
+	failures int
+	received []events.Event
+}
+
+func (r *recorder) Publish(event events.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("consumidor caído")
+	}
+	r.received = append(r.received, event)
+	return nil
+}
+
+func (r *recorder) published() []events.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]events.Event(nil), r.received...)
+}
+
+// startRelay arranca un relay con tiempos cortos y lo detiene al terminar.
+func startRelay(t *testing.T, db *gorm.DB, publishers ...events.Publisher) {
+	t.Helper()
+
+	relay := outbox.NewRelay(outbox.NewRepository(db), outbox.RelayConfig{
+		PollInterval: 5 * time.Millisecond,
+		BaseBackoff:  5 * time.Millisecond,
+		MaxBackoff:   20 * time.Millisecond,
+	}, publishers...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	relay.Start(ctx)
+	t.Cleanup(func() {
+		cancel()
+		relay.Wait()
+	})
+}
+
+// waitPublished espera a que no queden mensajes por entregar.
+func waitPublished(t *testing.T, db *gorm.DB) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		var pending int64
+		require.NoError(t, db.Model(&outbox.Message{}).Where("published_at IS NULL").Count(&pending).Error)
+		return pending == 0
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestRelay(t *testing.T) {
+	t.Run("Debe entregar los eventos en orden a todos los publishers", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		uploaded := events.New(events.FileUploaded, "user-1", map[string]string{"file_id": "f1"})
+		deleted := events.New(events.FileDeleted, "user-1", map[string]string{"file_id": "f1"})
+		write(t, db, uploaded, deleted)
+		first, second := &recorder{}, &recorder{}
+
+		// WHEN
+		startRelay(t, db, first, second)
+		waitPublished(t, db)
+
+		// THEN
+		for _, rec := range []*recorder{first, second} {
+			received := rec.published()
+			require.Len(t, received, 2)
+			assert.Equal(t, uploaded.ID, received[0].ID)
+			assert.Equal(t, events.FileUploaded, received[0].Type)
+			assert.Equal(t, "user-1", received[0].UserID)
+			assert.JSONEq(t, `{"file_id":"f1"}`, string(received[0].Data.(json.RawMessage)))
+			assert.Equal(t, deleted.ID, received[1].ID)
+		}
+	})
+
+	t.Run("Debe reintentar el evento para todos cuando un publisher falla", func(t *testing.T) {
+		// GIVEN: el segundo publisher falla las dos primeras veces
+		db := newMemoryDB(t)
+		event := events.New(events.FileUploaded, "user-1", nil)
+		write(t, db, event)
+		healthy, flaky := &recorder{}, &recorder{failures: 2}
+
+		// WHEN
+		startRelay(t, db, healthy, flaky)
+		waitPublished(t, db)
+
+		// THEN: al menos una vez para cada uno; el sano lo recibe repetido
+		assert.Len(t, healthy.published(), 3)
+		require.Len(t, flaky.published(), 1)
+		assert.Equal(t, event.ID, flaky.published()[0].ID)
+
+		var message outbox.Message
+		require.NoError(t, db.First(&message).Error)
+		assert.Equal(t, 2, message.Attempts)
+		assert.NotNil(t, message.PublishedAt)
+	})
+}
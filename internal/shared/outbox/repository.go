@@ -0,0 +1,124 @@
+package outbox
+
+import (
+	"encoding/json"
+	"time"
+
+	"image-processing-service/internal/shared/events"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Write guarda los eventos en el outbox usando tx, que debe ser la
+// transacción del cambio que los produce.
+func Write(tx *gorm.DB, evts ...events.Event) error {
+	if len(evts) == 0 {
+		return nil
+	}
+
+	messages := make([]Message, 0, len(evts))
+	for _, event := range evts {
+		data, err := json.Marshal(event.Data)
+		if err != nil {
+			return err
+		}
+
+		messages = append(messages, Message{
+			EventID:       event.ID,
+			Type:          event.Type,
+			UserID:        event.UserID,
+			Data:          string(data),
+			OccurredAt:    event.OccurredAt,
+			NextAttemptAt: event.OccurredAt,
+		})
+	}
+
+	return tx.Create(&messages).Error
+}
+
+type Repository interface {
+	Claim(relayID string, limit int, now time.Time, leaseUntil time.Time) ([]Message, error)
+	MarkPublished(id int64, now time.Time) error
+	Retry(id int64, lastError string, nextAttemptAt time.Time) error
+	DeletePublishedBefore(before time.Time) (int64, error)
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+// ready son los mensajes sin entregar cuyo siguiente intento ya toca y que no
+// tiene reservados otra instancia.
+const ready = "published_at IS NULL AND next_attempt_at <= ? AND (locked_until IS NULL OR locked_until < ?)"
+
+// Claim reserva para relayID hasta limit mensajes listos, en orden, hasta
+// leaseUntil. Si el relay cae con mensajes reservados, otra instancia los
+// recoge cuando vence la reserva.
+func (r *repository) Claim(relayID string, limit int, now time.Time, leaseUntil time.Time) ([]Message, error) {
+	var claimed []Message
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var ids []int64
+		if err := tx.Model(&Message{}).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where(ready, now, now).
+			Order("id").
+			Limit(limit).
+			Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		// La condición se repite por si otra instancia reservó alguno entre
+		// la lectura y la actualización.
+		if err := tx.Model(&Message{}).
+			Where("id IN ?", ids).
+			Where(ready, now, now).
+			Updates(map[string]interface{}{
+				"locked_by":    relayID,
+				"locked_until": leaseUntil,
+			}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("id IN ? AND locked_by = ? AND published_at IS NULL", ids, relayID).
+			Order("id").
+			Find(&claimed).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return claimed, nil
+}
+
+func (r *repository) MarkPublished(id int64, now time.Time) error {
+	return r.db.Model(&Message{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"published_at": now,
+		"locked_by":    "",
+		"locked_until": nil,
+		"last_error":   "",
+	}).Error
+}
+
+func (r *repository) Retry(id int64, lastError string, nextAttemptAt time.Time) error {
+	return r.db.Model(&Message{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"attempts":        gorm.Expr("attempts + 1"),
+		"last_error":      lastError,
+		"next_attempt_at": nextAttemptAt,
+		"locked_by":       "",
+		"locked_until":    nil,
+	}).Error
+}
+
+func (r *repository) DeletePublishedBefore(before time.Time) (int64, error) {
+	result := r.db.Where("published_at IS NOT NULL AND published_at < ?", before).Delete(&Message{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,162 @@
+package outbox_test
+
+import (
+	"image-processing-service/internal/shared/events"
+	"image-processing-service/internal/shared/outbox"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// newMemoryDB abre SQLite en memoria con una sola conexión: cada conexión
+// nueva vería una base de datos distinta, y el relay usa su propia goroutine.
+func newMemoryDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err, "no se pudo abrir la base de datos en memoria")
+
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+
+	err = db.AutoMigrate(&outbox.Message{})
+	require.NoError(t, err, "no se pudo migrar el esquema")
+
+	return db
+}
+
+func write(t *testing.T, db *gorm.DB, evts ...events.Event) {
+	t.Helper()
+
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		return outbox.Write(tx, evts...)
+	}))
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Write
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestWrite(t *testing.T) {
+	t.Run("No debe guardar nada si la transacción se deshace", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+
+		// WHEN
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := outbox.Write(tx, events.New(events.FileUploaded, "user-1", nil)); err != nil {
+				return err
+			}
+			return gorm.ErrInvalidData
+		})
+
+		// THEN
+		require.ErrorIs(t, err, gorm.ErrInvalidData)
+		var count int64
+		require.NoError(t, db.Model(&outbox.Message{}).Count(&count).Error)
+		assert.Zero(t, count)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Claim
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_Claim(t *testing.T) {
+	t.Run("Debe reservar los mensajes pendientes en orden", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := outbox.NewRepository(db)
+		first := events.New(events.FileUploaded, "user-1", map[string]string{"file_id": "f1"})
+		second := events.New(events.FileDeleted, "user-1", map[string]string{"file_id": "f1"})
+		write(t, db, first, second)
+		now := time.Now().UTC().Add(time.Second)
+
+		// WHEN
+		claimed, err := repo.Claim("relay-1", 10, now, now.Add(time.Minute))
+
+		// THEN
+		require.NoError(t, err)
+		require.Len(t, claimed, 2)
+		assert.Equal(t, first.ID, claimed[0].EventID)
+		assert.Equal(t, second.ID, claimed[1].EventID)
+		assert.JSONEq(t, `{"file_id":"f1"}`, claimed[0].Data)
+	})
+
+	t.Run("No debe reservar mensajes reservados por otro relay hasta que venza la reserva", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := outbox.NewRepository(db)
+		write(t, db, events.New(events.FileUploaded, "user-1", nil))
+		now := time.Now().UTC().Add(time.Second)
+		_, err := repo.Claim("relay-1", 10, now, now.Add(time.Minute))
+		require.NoError(t, err)
+
+		// WHEN
+		during, err := repo.Claim("relay-2", 10, now, now.Add(time.Minute))
+		require.NoError(t, err)
+		after, err := repo.Claim("relay-2", 10, now.Add(2*time.Minute), now.Add(3*time.Minute))
+		require.NoError(t, err)
+
+		// THEN
+		assert.Empty(t, during)
+		assert.Len(t, after, 1)
+	})
+
+	t.Run("No debe reservar mensajes entregados ni reprogramados a futuro", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := outbox.NewRepository(db)
+		write(t, db, events.New(events.FileUploaded, "user-1", nil), events.New(events.FileDeleted, "user-1", nil))
+		now := time.Now().UTC().Add(time.Second)
+		claimed, err := repo.Claim("relay-1", 10, now, now.Add(time.Minute))
+		require.NoError(t, err)
+		require.Len(t, claimed, 2)
+		require.NoError(t, repo.MarkPublished(claimed[0].ID, now))
+		require.NoError(t, repo.Retry(claimed[1].ID, "sin conexión", now.Add(time.Hour)))
+
+		// WHEN
+		again, err := repo.Claim("relay-1", 10, now, now.Add(time.Minute))
+
+		// THEN
+		require.NoError(t, err)
+		assert.Empty(t, again)
+
+		var retried outbox.Message
+		require.NoError(t, db.First(&retried, claimed[1].ID).Error)
+		assert.Equal(t, 1, retried.Attempts)
+		assert.Equal(t, "sin conexión", retried.LastError)
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// DeletePublishedBefore
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRepository_DeletePublishedBefore(t *testing.T) {
+	t.Run("Debe borrar solo los mensajes entregados antes de la fecha", func(t *testing.T) {
+		// GIVEN
+		db := newMemoryDB(t)
+		repo := outbox.NewRepository(db)
+		write(t, db, events.New(events.FileUploaded, "user-1", nil), events.New(events.FileDeleted, "user-1", nil))
+		now := time.Now().UTC().Add(time.Second)
+		claimed, err := repo.Claim("relay-1", 10, now, now.Add(time.Minute))
+		require.NoError(t, err)
+		require.NoError(t, repo.MarkPublished(claimed[0].ID, now.Add(-48*time.Hour)))
+
+		// WHEN
+		deleted, err := repo.DeletePublishedBefore(now.Add(-24 * time.Hour))
+
+		// THEN
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+		var remaining int64
+		require.NoError(t, db.Model(&outbox.Message{}).Count(&remaining).Error)
+		assert.Equal(t, int64(1), remaining)
+	})
+}
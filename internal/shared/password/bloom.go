@@ -0,0 +1,66 @@
+package password
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+)
+
+// BloomFilter es un conjunto aproximado: Contains nunca falla con lo que se
+// ha añadido y se equivoca con lo demás con la probabilidad con la que se
+// dimensionó. Con un 0,1 % ocupa unos 1,8 bytes por elemento, así que cabe
+// en memoria una lista de millones de contraseñas.
+//
+// Las posiciones no dependen del proceso: una contraseña que da falso
+// positivo lo da siempre y en todas las réplicas, en lugar de rechazarse o
+// no según la semilla con la que arrancó cada una.
+type BloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+// NewBloomFilter dimensiona el filtro para n elementos con una probabilidad
+// de falso positivo falsePositiveRate.
+func NewBloomFilter(n int, falsePositiveRate float64) *BloomFilter {
+	if n < 1 {
+		n = 1
+	}
+
+	m := math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/float64(n)*math.Ln2))
+
+	words := (uint64(m) + 63) / 64
+	return &BloomFilter{
+		bits: make([]uint64, words),
+		m:    words * 64,
+		k:    uint64(k),
+	}
+}
+
+func (f *BloomFilter) Add(s string) {
+	h1, h2 := f.hashes(s)
+	for i := range f.k {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (f *BloomFilter) Contains(s string) bool {
+	h1, h2 := f.hashes(s)
+	for i := range f.k {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hashes calcula las dos funciones de las que salen las k posiciones
+// (Kirsch y Mitzenmacher). h2 nunca es cero: si no, las k posiciones
+// serían la misma.
+func (f *BloomFilter) hashes(s string) (uint64, uint64) {
+	sum := sha256.Sum256([]byte(s))
+	return binary.LittleEndian.Uint64(sum[0:8]), binary.LittleEndian.Uint64(sum[8:16]) | 1
+}
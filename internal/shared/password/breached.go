@@ -0,0 +1,74 @@
+package password
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// breachedFalsePositiveRate es la probabilidad de rechazar por error una
+// contraseña que no está en la lista.
+const breachedFalsePositiveRate = 0.001
+
+// bundled son las contraseñas más repetidas en filtraciones públicas, una
+// por línea. Se usan siempre; PASSWORD_BREACHED_LIST añade otras.
+//
+//go:embed breached.txt
+var bundled string
+
+// LoadBreached construye el filtro con la lista incluida y, si path no está
+// vacío, con el archivo de path: una contraseña por línea. El archivo se
+// lee dos veces, una para contar las líneas y dimensionar el filtro y otra
+// para añadirlas, así que no hace falta tenerlo entero en memoria.
+func LoadBreached(path string) (*BloomFilter, error) {
+	n := countLines(strings.NewReader(bundled))
+
+	var file *os.File
+	if path != "" {
+		var err error
+		file, err = os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("no se pudo abrir la lista de contraseñas filtradas: %w", err)
+		}
+		defer file.Close()
+
+		n += countLines(file)
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	filter := NewBloomFilter(n, breachedFalsePositiveRate)
+	if err := addLines(filter, strings.NewReader(bundled)); err != nil {
+		return nil, err
+	}
+	if file != nil {
+		if err := addLines(filter, file); err != nil {
+			return nil, fmt.Errorf("no se pudo leer la lista de contraseñas filtradas: %w", err)
+		}
+	}
+
+	return filter, nil
+}
+
+func countLines(r io.Reader) int {
+	n := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		n++
+	}
+	return n
+}
+
+func addLines(filter *BloomFilter, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := normalize(scanner.Text()); line != "" {
+			filter.Add(line)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,162 @@
+// Package password implementa la política de contraseñas: longitud mínima,
+// tipos de caracteres obligatorios, que no contengan datos personales y que
+// no aparezcan en listas de contraseñas filtradas.
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultMinLength es la longitud mínima por defecto, en caracteres.
+	DefaultMinLength = 8
+	// DefaultHistory es cuántas contraseñas, contando la actual, no se
+	// pueden repetir por defecto.
+	DefaultHistory = 5
+	// MaxHistory es cuántas contraseñas anteriores se guardan por usuario.
+	MaxHistory = 24
+
+	// minPersonalLength es la longitud mínima de un dato personal para
+	// comprobar si la contraseña lo contiene: más cortos darían falsos
+	// positivos.
+	minPersonalLength = 3
+)
+
+// Class es un tipo de carácter que se puede exigir.
+type Class string
+
+const (
+	Lowercase Class = "lowercase"
+	Uppercase Class = "uppercase"
+	Digit     Class = "digit"
+	Symbol    Class = "symbol"
+)
+
+// Reglas que no son un tipo de carácter. Junto con las Class, son los
+// valores de Violation.Rule.
+const (
+	RuleMinLength    = "min_length"
+	RulePersonalInfo = "personal_info"
+	RuleBreached     = "breached"
+	RuleReused       = "reused"
+)
+
+var classMessages = map[Class]string{
+	Lowercase: "Debe incluir al menos una minúscula",
+	Uppercase: "Debe incluir al menos una mayúscula",
+	Digit:     "Debe incluir al menos un número",
+	Symbol:    "Debe incluir al menos un símbolo",
+}
+
+// ParseClasses convierte los nombres de PASSWORD_REQUIRED_CLASSES.
+func ParseClasses(names []string) ([]Class, error) {
+	classes := make([]Class, 0, len(names))
+	for _, name := range names {
+		class := Class(strings.ToLower(name))
+		if _, ok := classMessages[class]; !ok {
+			return nil, fmt.Errorf("tipo de carácter desconocido: %q", name)
+		}
+		classes = append(classes, class)
+	}
+	return classes, nil
+}
+
+// Policy son las reglas de las contraseñas nuevas. Los valores a cero
+// desactivan cada regla.
+type Policy struct {
+	MinLength       int
+	RequiredClasses []Class
+	// History es cuántas contraseñas, contando la actual, no se pueden
+	// repetir. Lo comprueba quien tiene el historial del usuario.
+	History int
+	// Breached son las contraseñas filtradas que no se aceptan.
+	Breached *BloomFilter
+}
+
+// Violation es una regla que la contraseña no cumple.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Check devuelve las reglas que incumple password, o nada si las cumple
+// todas. personal son datos del usuario, como el email o el nombre, que la
+// contraseña no puede contener.
+func (p Policy) Check(password string, personal ...string) []Violation {
+	var violations []Violation
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, Violation{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("Debe tener al menos %d caracteres", p.MinLength),
+		})
+	}
+
+	for _, class := range p.RequiredClasses {
+		if !strings.ContainsFunc(password, matcher(class)) {
+			violations = append(violations, Violation{Rule: string(class), Message: classMessages[class]})
+		}
+	}
+
+	if containsPersonal(password, personal) {
+		violations = append(violations, Violation{
+			Rule:    RulePersonalInfo,
+			Message: "No puede contener tu email ni tu nombre",
+		})
+	}
+
+	if p.Breached != nil && p.Breached.Contains(normalize(password)) {
+		violations = append(violations, Violation{
+			Rule:    RuleBreached,
+			Message: "Aparece en filtraciones de contraseñas conocidas, elige otra",
+		})
+	}
+
+	return violations
+}
+
+func matcher(class Class) func(rune) bool {
+	switch class {
+	case Lowercase:
+		return unicode.IsLower
+	case Uppercase:
+		return unicode.IsUpper
+	case Digit:
+		return unicode.IsDigit
+	default:
+		return func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }
+	}
+}
+
+// containsPersonal comprueba el email entero, la parte antes de la arroba
+// y el nombre completo y palabra a palabra, sin distinguir mayúsculas.
+func containsPersonal(password string, personal []string) bool {
+	lower := strings.ToLower(password)
+
+	var parts []string
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		parts = append(parts, value)
+		if local, _, ok := strings.Cut(value, "@"); ok {
+			parts = append(parts, local)
+		}
+		parts = append(parts, strings.Fields(value)...)
+	}
+
+	for _, part := range parts {
+		if utf8.RuneCountInString(part) >= minPersonalLength && strings.Contains(lower, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize es la forma en que se guardan y se buscan las contraseñas
+// filtradas: sin distinguir mayúsculas, para que "Password1" cuente como
+// "password1".
+func normalize(password string) string {
+	return strings.ToLower(strings.TrimSpace(password))
+}
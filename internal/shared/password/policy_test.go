@@ -0,0 +1,175 @@
+package password_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"image-processing-service/internal/shared/password"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rules devuelve las reglas que incumple la contraseña.
+func rules(violations []password.Violation) []string {
+	names := make([]string, 0, len(violations))
+	for _, v := range violations {
+		names = append(names, v.Rule)
+	}
+	return names
+}
+
+func TestPolicy_Check(t *testing.T) {
+	t.Run("Debe aceptar una contraseña que cumple todas las reglas", func(t *testing.T) {
+		// GIVEN
+		policy := password.Policy{
+			MinLength:       10,
+			RequiredClasses: []password.Class{password.Lowercase, password.Uppercase, password.Digit, password.Symbol},
+		}
+
+		// WHEN
+		violations := policy.Check("Caballo-Grapa-42", "ana@test.com", "Ana García")
+
+		// THEN
+		assert.Empty(t, violations)
+	})
+
+	t.Run("Debe devolver una violación por cada regla incumplida", func(t *testing.T) {
+		// GIVEN
+		policy := password.Policy{
+			MinLength:       10,
+			RequiredClasses: []password.Class{password.Uppercase, password.Digit, password.Symbol},
+		}
+
+		// WHEN
+		violations := policy.Check("corta")
+
+		// THEN
+		assert.Equal(t, []string{password.RuleMinLength, "uppercase", "digit", "symbol"}, rules(violations))
+		assert.Equal(t, "Debe tener al menos 10 caracteres", violations[0].Message)
+	})
+
+	t.Run("Debe contar la longitud en caracteres y no en bytes", func(t *testing.T) {
+		// GIVEN
+		policy := password.Policy{MinLength: 8}
+
+		// THEN: 7 caracteres, 9 bytes
+		assert.Equal(t, []string{password.RuleMinLength}, rules(policy.Check("ñandúes")))
+	})
+
+	t.Run("Debe rechazar contraseñas que contienen el email o el nombre", func(t *testing.T) {
+		// GIVEN
+		policy := password.Policy{}
+
+		for _, plain := range []string{"ANA.GARCIA2024", "garcía-segura", "x-ana.garcia@test.com"} {
+			// WHEN
+			violations := policy.Check(plain, "ana.garcia@test.com", "Ana García")
+
+			// THEN
+			assert.Equal(t, []string{password.RulePersonalInfo}, rules(violations), plain)
+		}
+	})
+
+	t.Run("Debe ignorar datos personales demasiado cortos", func(t *testing.T) {
+		// GIVEN: "Lu" daría falsos positivos con cualquier contraseña que lo contenga
+		policy := password.Policy{}
+
+		// THEN
+		assert.Empty(t, policy.Check("luna-de-plata", "lu@test.com", "Lu"))
+	})
+
+	t.Run("Debe rechazar las contraseñas filtradas sin distinguir mayúsculas", func(t *testing.T) {
+		// GIVEN
+		breached, err := password.LoadBreached("")
+		require.NoError(t, err)
+		policy := password.Policy{Breached: breached}
+
+		// THEN
+		assert.Equal(t, []string{password.RuleBreached}, rules(policy.Check("Password123")))
+		assert.Equal(t, []string{password.RuleBreached}, rules(policy.Check("QWERTY123")))
+		assert.Empty(t, policy.Check("caballo-grapa-bateria"))
+	})
+}
+
+func TestParseClasses(t *testing.T) {
+	t.Run("Debe aceptar los tipos de carácter sin distinguir mayúsculas", func(t *testing.T) {
+		// WHEN
+		classes, err := password.ParseClasses([]string{"Lowercase", "DIGIT"})
+
+		// THEN
+		require.NoError(t, err)
+		assert.Equal(t, []password.Class{password.Lowercase, password.Digit}, classes)
+	})
+
+	t.Run("Debe rechazar un tipo de carácter desconocido", func(t *testing.T) {
+		// WHEN
+		_, err := password.ParseClasses([]string{"digit", "emoji"})
+
+		// THEN
+		assert.Error(t, err)
+	})
+}
+
+func TestLoadBreached(t *testing.T) {
+	t.Run("Debe añadir las contraseñas del archivo a las incluidas", func(t *testing.T) {
+		// GIVEN
+		path := filepath.Join(t.TempDir(), "breached.txt")
+		require.NoError(t, os.WriteFile(path, []byte("Caballo-Grapa-42\n\nbateria-correcta\n"), 0o600))
+
+		// WHEN
+		filter, err := password.LoadBreached(path)
+
+		// THEN
+		require.NoError(t, err)
+		assert.True(t, filter.Contains("caballo-grapa-42"))
+		assert.True(t, filter.Contains("bateria-correcta"))
+		assert.True(t, filter.Contains("123456"))
+	})
+
+	t.Run("Debe fallar si el archivo no existe", func(t *testing.T) {
+		// WHEN
+		_, err := password.LoadBreached(filepath.Join(t.TempDir(), "no-existe.txt"))
+
+		// THEN
+		assert.Error(t, err)
+	})
+}
+
+func TestBloomFilter(t *testing.T) {
+	t.Run("Debe encontrar todo lo añadido y pocos falsos positivos", func(t *testing.T) {
+		// GIVEN
+		const n = 10000
+		filter := password.NewBloomFilter(n, 0.001)
+		for i := range n {
+			filter.Add(fmt.Sprintf("añadida-%d", i))
+		}
+
+		// WHEN
+		falsePositives := 0
+		for i := range n {
+			require.True(t, filter.Contains(fmt.Sprintf("añadida-%d", i)))
+			if filter.Contains(fmt.Sprintf("otra-%d", i)) {
+				falsePositives++
+			}
+		}
+
+		// THEN: se esperan unos 10; el margen evita que el test sea inestable
+		assert.Less(t, falsePositives, 50)
+	})
+
+	t.Run("Debe dar los mismos falsos positivos en filtros construidos por separado", func(t *testing.T) {
+		// GIVEN: dos réplicas cargan la misma lista
+		first, err := password.LoadBreached("")
+		require.NoError(t, err)
+		second, err := password.LoadBreached("")
+		require.NoError(t, err)
+
+		// WHEN / THEN
+		for i := range 20000 {
+			candidate := fmt.Sprintf("candidata-%d", i)
+			require.Equal(t, first.Contains(candidate), second.Contains(candidate), candidate)
+		}
+	})
+}
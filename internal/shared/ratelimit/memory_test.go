@@ -0,0 +1,127 @@
+package ratelimit_test
+
+import (
+	"context"
+	"image-processing-service/internal/shared/ratelimit"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var base = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// take consume un token del bucket key y falla el test si el store devuelve
+// un error.
+func take(t *testing.T, store ratelimit.Store, key string, limit ratelimit.Limit, now time.Time) ratelimit.Decision {
+	t.Helper()
+
+	decision, err := store.Take(context.Background(), key, limit, now)
+	require.NoError(t, err)
+	return decision
+}
+
+func TestMemoryStore_Take(t *testing.T) {
+	limit := ratelimit.Limit{Requests: 3, Window: time.Minute}
+
+	t.Run("Debe permitir una ráfaga de hasta Requests peticiones", func(t *testing.T) {
+		// GIVEN
+		store := ratelimit.NewMemoryStore()
+
+		// WHEN
+		var decisions []ratelimit.Decision
+		for range 4 {
+			decisions = append(decisions, take(t, store, "k", limit, base))
+		}
+
+		// THEN
+		for i, remaining := range []int{2, 1, 0} {
+			assert.True(t, decisions[i].Allowed)
+			assert.Equal(t, remaining, decisions[i].Remaining)
+			assert.Equal(t, 3, decisions[i].Limit)
+		}
+		assert.False(t, decisions[3].Allowed)
+		assert.Equal(t, 0, decisions[3].Remaining)
+		assert.Equal(t, 20*time.Second, decisions[3].RetryAfter)
+		assert.Equal(t, time.Minute, decisions[3].Reset)
+	})
+
+	t.Run("Debe rellenar el bucket con el paso del tiempo", func(t *testing.T) {
+		// GIVEN
+		store := ratelimit.NewMemoryStore()
+		for range 3 {
+			take(t, store, "k", limit, base)
+		}
+
+		// WHEN
+		early := take(t, store, "k", limit, base.Add(10*time.Second))
+		refilled := take(t, store, "k", limit, base.Add(30*time.Second))
+
+		// THEN
+		assert.False(t, early.Allowed)
+		assert.Equal(t, 10*time.Second, early.RetryAfter)
+		assert.True(t, refilled.Allowed)
+	})
+
+	t.Run("Debe llevar un bucket independiente por clave", func(t *testing.T) {
+		// GIVEN
+		store := ratelimit.NewMemoryStore()
+		for range 3 {
+			take(t, store, "a", limit, base)
+		}
+
+		// WHEN
+		other := take(t, store, "b", limit, base)
+
+		// THEN
+		assert.True(t, other.Allowed)
+		assert.Equal(t, 2, other.Remaining)
+	})
+
+	t.Run("Debe empezar con el bucket lleno tras descartar los buckets ya rellenos", func(t *testing.T) {
+		// GIVEN
+		store := ratelimit.NewMemoryStore()
+		take(t, store, "k", limit, base)
+
+		// WHEN
+		decision := take(t, store, "k", limit, base.Add(time.Hour))
+
+		// THEN
+		assert.True(t, decision.Allowed)
+		assert.Equal(t, 2, decision.Remaining)
+	})
+}
+
+func TestParseLimit(t *testing.T) {
+	t.Run("Debe leer peticiones y ventana", func(t *testing.T) {
+		// WHEN
+		limit, err := ratelimit.ParseLimit("10/1m")
+
+		// THEN
+		require.NoError(t, err)
+		assert.Equal(t, ratelimit.Limit{Requests: 10, Window: time.Minute}, limit)
+		assert.True(t, limit.Enabled())
+	})
+
+	t.Run("Debe desactivar el límite con la cadena vacía o 0", func(t *testing.T) {
+		for _, value := range []string{"", "0", "0/1m"} {
+			// WHEN
+			limit, err := ratelimit.ParseLimit(value)
+
+			// THEN
+			require.NoError(t, err)
+			assert.False(t, limit.Enabled(), value)
+		}
+	})
+
+	t.Run("Debe retornar error cuando el formato es inválido", func(t *testing.T) {
+		for _, value := range []string{"10", "diez/1m", "10/minuto", "10/0s", "-1/1m"} {
+			// WHEN
+			_, err := ratelimit.ParseLimit(value)
+
+			// THEN
+			assert.Error(t, err, value)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+// Package ratelimit implementa un limitador de peticiones por token bucket.
+// El estado de los buckets vive detrás de Store para poder compartirlo entre
+// instancias (Redis) o tenerlo solo en memoria.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit permite ráfagas de hasta Requests peticiones y rellena el bucket a
+// razón de Requests por Window. Un Limit con Requests a cero no limita.
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Enabled indica si el límite está activo.
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Window > 0
+}
+
+// perSecond es la velocidad de relleno del bucket.
+func (l Limit) perSecond() float64 {
+	return float64(l.Requests) / l.Window.Seconds()
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Window)
+}
+
+// ParseLimit lee un límite con el formato "<peticiones>/<ventana>", por
+// ejemplo "10/1m". La cadena vacía o "0" devuelven un límite desactivado.
+func ParseLimit(value string) (Limit, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "0" {
+		return Limit{}, nil
+	}
+
+	requests, window, ok := strings.Cut(value, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("formato inválido %q, se esperaba <peticiones>/<ventana>", value)
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 0 {
+		return Limit{}, fmt.Errorf("número de peticiones inválido en %q", value)
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("ventana inválida en %q", value)
+	}
+
+	return Limit{Requests: n, Window: d}, nil
+}
+
+// Decision es el resultado de consumir un token.
+type Decision struct {
+	Allowed bool
+	// Limit es la capacidad del bucket.
+	Limit int
+	// Remaining son los tokens que quedan tras esta petición.
+	Remaining int
+	// Reset es lo que tarda el bucket en volver a estar lleno.
+	Reset time.Duration
+	// RetryAfter es lo que hay que esperar hasta el siguiente token. Solo
+	// tiene sentido si la petición se rechazó.
+	RetryAfter time.Duration
+}
+
+// decide construye la decisión a partir de los tokens que quedan en el
+// bucket después de intentar consumir uno.
+func decide(limit Limit, allowed bool, tokens float64) Decision {
+	rate := limit.perSecond()
+
+	decision := Decision{
+		Allowed:   allowed,
+		Limit:     limit.Requests,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(limit.Requests) - tokens) / rate),
+	}
+	if !allowed {
+		decision.RetryAfter = seconds((1 - tokens) / rate)
+	}
+	return decision
+}
+
+func seconds(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
+
+// Store guarda los buckets. Take consume un token del bucket de key, si lo
+// hay, y devuelve la decisión.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Decision, error)
+}
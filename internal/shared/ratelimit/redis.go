@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeScript consume un token de forma atómica. El bucket se guarda como un
+// hash {tokens, ts} que caduca cuando se habría rellenado del todo. Los
+// tokens se devuelven como texto porque Redis trunca a entero los números
+// que devuelve un script.
+var takeScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+  tokens = capacity
+  ts = now
+end
+
+if now > ts then
+  tokens = math.min(capacity, tokens + (now - ts) * rate)
+  ts = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(ts))
+redis.call("PEXPIRE", KEYS[1], math.ceil((capacity - tokens) / rate) + 1000)
+
+return {allowed, tostring(tokens)}
+`)
+
+// RedisStore guarda los buckets en Redis (o un servidor compatible), así que
+// todas las instancias comparten los límites.
+type RedisStore struct {
+	client redis.Scripter
+	prefix string
+}
+
+// NewRedisStore crea el store. Las claves se guardan con el prefijo
+// "ratelimit:".
+func NewRedisStore(client redis.Scripter) *RedisStore {
+	return &RedisStore{client: client, prefix: "ratelimit:"}
+}
+
+func (s *RedisStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Decision, error) {
+	// Tiempos en milisegundos: la velocidad es de tokens por milisegundo.
+	rate := limit.perSecond() / 1000
+
+	result, err := takeScript.Run(ctx, s.client, []string{s.prefix + key},
+		limit.Requests,
+		strconv.FormatFloat(rate, 'f', -1, 64),
+		now.UnixMilli(),
+	).Slice()
+	if err != nil {
+		return Decision{}, err
+	}
+	if len(result) != 2 {
+		return Decision{}, fmt.Errorf("respuesta inesperada del script de rate limit: %v", result)
+	}
+
+	allowed, _ := result[0].(int64)
+	raw, _ := result[1].(string)
+	tokens, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return Decision{}, fmt.Errorf("tokens inválidos en la respuesta de rate limit: %q", raw)
+	}
+
+	return decide(limit, allowed == 1, tokens), nil
+}
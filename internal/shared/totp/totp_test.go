@@ -0,0 +1,153 @@
+package totp_test
+
+import (
+	"bytes"
+	"image/png"
+	"net/url"
+	"testing"
+	"time"
+
+	"image-processing-service/internal/shared/totp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret es "12345678901234567890" en base32, la clave SHA-1 de los
+// vectores de prueba de la RFC 6238.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	t.Run("Debe coincidir con los vectores de la RFC 6238", func(t *testing.T) {
+		// GIVEN: los vectores son de 8 dígitos; los de 6 son sus últimas cifras
+		vectors := map[int64]string{
+			59:          "287082",
+			1111111109:  "081804",
+			1111111111:  "050471",
+			1234567890:  "005924",
+			2000000000:  "279037",
+			20000000000: "353130",
+		}
+
+		for unix, want := range vectors {
+			// WHEN
+			code, err := totp.Code(rfcSecret, time.Unix(unix, 0))
+
+			// THEN
+			require.NoError(t, err)
+			assert.Equal(t, want, code, "t=%d", unix)
+		}
+	})
+
+	t.Run("Debe rechazar un secreto que no es base32", func(t *testing.T) {
+		// WHEN
+		_, err := totp.Code("no-es-base32!", time.Now())
+
+		// THEN
+		assert.Error(t, err)
+	})
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	t.Run("Debe aceptar el código del paso actual y de los contiguos", func(t *testing.T) {
+		for _, offset := range []time.Duration{-totp.Period, 0, totp.Period} {
+			// GIVEN
+			code, err := totp.Code(rfcSecret, now.Add(offset))
+			require.NoError(t, err)
+
+			// WHEN
+			step, ok := totp.Validate(rfcSecret, code, now, 0)
+
+			// THEN
+			assert.True(t, ok)
+			assert.Equal(t, totp.Step(now.Add(offset)), step)
+		}
+	})
+
+	t.Run("Debe rechazar un código fuera del margen", func(t *testing.T) {
+		// GIVEN
+		code, err := totp.Code(rfcSecret, now.Add(-2*totp.Period))
+		require.NoError(t, err)
+
+		// WHEN
+		_, ok := totp.Validate(rfcSecret, code, now, 0)
+
+		// THEN
+		assert.False(t, ok)
+	})
+
+	t.Run("Debe rechazar un código de un paso ya usado", func(t *testing.T) {
+		// GIVEN
+		code, err := totp.Code(rfcSecret, now)
+		require.NoError(t, err)
+
+		// WHEN
+		_, ok := totp.Validate(rfcSecret, code, now, totp.Step(now))
+
+		// THEN
+		assert.False(t, ok)
+	})
+
+	t.Run("Debe rechazar códigos mal formados", func(t *testing.T) {
+		for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+			_, ok := totp.Validate(rfcSecret, code, now, 0)
+			assert.False(t, ok, code)
+		}
+	})
+}
+
+func TestGenerateSecret(t *testing.T) {
+	t.Run("Debe generar secretos distintos y utilizables", func(t *testing.T) {
+		// WHEN
+		a, err := totp.GenerateSecret()
+		require.NoError(t, err)
+		b, err := totp.GenerateSecret()
+		require.NoError(t, err)
+
+		// THEN
+		assert.Len(t, a, 32)
+		assert.NotEqual(t, a, b)
+		_, err = totp.Code(a, time.Now())
+		assert.NoError(t, err)
+	})
+}
+
+func TestURI(t *testing.T) {
+	t.Run("Debe construir una URI otpauth con emisor y cuenta", func(t *testing.T) {
+		// WHEN
+		uri := totp.URI("Image Service", "ana@test.com", rfcSecret)
+
+		// THEN
+		parsed, err := url.Parse(uri)
+		require.NoError(t, err)
+		assert.Equal(t, "otpauth", parsed.Scheme)
+		assert.Equal(t, "totp", parsed.Host)
+		assert.Equal(t, "/Image Service:ana@test.com", parsed.Path)
+		assert.Equal(t, rfcSecret, parsed.Query().Get("secret"))
+		assert.Equal(t, "Image Service", parsed.Query().Get("issuer"))
+		assert.Equal(t, "6", parsed.Query().Get("digits"))
+		assert.Equal(t, "30", parsed.Query().Get("period"))
+	})
+}
+
+func TestQRCode(t *testing.T) {
+	t.Run("Debe generar un PNG cuadrado con margen blanco", func(t *testing.T) {
+		// WHEN
+		raw, err := totp.QRCode(totp.URI("Image Service", "ana@test.com", rfcSecret), 4)
+
+		// THEN
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(raw))
+		require.NoError(t, err)
+		bounds := img.Bounds()
+		assert.Equal(t, bounds.Dx(), bounds.Dy())
+		assert.Zero(t, bounds.Dx()%4)
+		r, g, b, _ := img.At(0, 0).RGBA()
+		assert.Equal(t, []uint32{0xffff, 0xffff, 0xffff}, []uint32{r, g, b})
+		// El primer módulo del patrón de posición es negro.
+		r, _, _, _ = img.At(4*4, 4*4).RGBA()
+		assert.Zero(t, r)
+	})
+}
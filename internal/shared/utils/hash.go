@@ -13,3 +13,10 @@ func GenerateSHA256(data string) string {
 func CompareSHA256(plainData, hashedData string) bool {
 	return GenerateSHA256(plainData) == hashedData
 }
+
+// GenerateSHA256FromBytes calcula el SHA-256 en hexadecimal de un contenido
+// binario sin convertirlo antes a string.
+func GenerateSHA256FromBytes(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
@@ -1,13 +1,136 @@
 package utils
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Las contraseñas se guardan con argon2id en formato PHC:
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<sal>$<hash>
+//
+// El hash lleva el algoritmo, la versión y los parámetros con los que se
+// calculó, así que se puede verificar aunque los parámetros actuales sean
+// otros. Los hashes bcrypt de antes se siguen aceptando; PasswordNeedsRehash
+// indica cuándo conviene recalcular uno al iniciar sesión.
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// PasswordParams son los parámetros de argon2id: memoria en KiB,
+// iteraciones e hilos.
+type PasswordParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// DefaultPasswordParams es la configuración mínima recomendada por OWASP
+// para argon2id: 19 MiB, 2 iteraciones y 1 hilo.
+var DefaultPasswordParams = PasswordParams{Memory: 19 * 1024, Iterations: 2, Parallelism: 1}
+
+var passwordParams atomic.Pointer[PasswordParams]
+
+func init() {
+	params := DefaultPasswordParams
+	passwordParams.Store(&params)
+}
+
+// SetPasswordParams cambia los parámetros con los que HashPassword calcula
+// los hashes nuevos. Se llama al arrancar.
+func SetPasswordParams(params PasswordParams) error {
+	if params.Iterations < 1 || params.Parallelism < 1 {
+		return errors.New("argon2id necesita al menos una iteración y un hilo")
+	}
+	if params.Memory < 8*uint32(params.Parallelism) {
+		return fmt.Errorf("argon2id necesita al menos %d KiB de memoria con %d hilos", 8*uint32(params.Parallelism), params.Parallelism)
+	}
+
+	passwordParams.Store(&params)
+	return nil
+}
 
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
+	params := *passwordParams.Load()
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
 }
 
+// CheckPasswordHash acepta hashes argon2id y bcrypt. Un hash vacío o que no
+// se entiende nunca coincide.
 func CheckPasswordHash(password, hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := decodeArgon2Hash(hash)
+		if err != nil {
+			return false
+		}
+		candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(candidate, key) == 1
+	}
+
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+// PasswordNeedsRehash indica si el hash no es argon2id con los parámetros
+// actuales: los bcrypt antiguos y los argon2id calculados con otros.
+func PasswordNeedsRehash(hash string) bool {
+	params, _, key, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+	return params != *passwordParams.Load() || len(key) != argon2KeyLength
+}
+
+func decodeArgon2Hash(hash string) (PasswordParams, []byte, []byte, error) {
+	var params PasswordParams
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", sal, hash
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errors.New("no es un hash argon2id")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("versión de argon2 no soportada: %s", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("parámetros de argon2id inválidos: %w", err)
+	}
+	if params.Iterations < 1 || params.Parallelism < 1 {
+		return params, nil, nil, errors.New("parámetros de argon2id inválidos")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("sal inválida: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errors.New("hash inválido")
+	}
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,123 @@
+package utils_test
+
+import (
+	"strings"
+	"testing"
+
+	"image-processing-service/internal/shared/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// setParams cambia los parámetros de argon2id durante el test.
+func setParams(t *testing.T, params utils.PasswordParams) {
+	t.Helper()
+
+	require.NoError(t, utils.SetPasswordParams(params))
+	t.Cleanup(func() { require.NoError(t, utils.SetPasswordParams(utils.DefaultPasswordParams)) })
+}
+
+func TestHashPassword(t *testing.T) {
+	t.Run("Debe generar un hash argon2id que verifica la contraseña", func(t *testing.T) {
+		// GIVEN: una contraseña más larga que los 72 bytes que admite bcrypt
+		password := strings.Repeat("contraseña-larga-", 6)
+
+		// WHEN
+		hash, err := utils.HashPassword(password)
+
+		// THEN
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$"))
+		assert.True(t, utils.CheckPasswordHash(password, hash))
+		assert.False(t, utils.CheckPasswordHash(password+"x", hash))
+		assert.False(t, utils.CheckPasswordHash(password[:72], hash))
+	})
+
+	t.Run("Debe usar una sal distinta en cada hash", func(t *testing.T) {
+		// WHEN
+		a, err := utils.HashPassword("secreto123")
+		require.NoError(t, err)
+		b, err := utils.HashPassword("secreto123")
+		require.NoError(t, err)
+
+		// THEN
+		assert.NotEqual(t, a, b)
+	})
+}
+
+func TestCheckPasswordHash(t *testing.T) {
+	t.Run("Debe aceptar los hashes bcrypt anteriores", func(t *testing.T) {
+		// GIVEN
+		legacy, err := bcrypt.GenerateFromPassword([]byte("secreto123"), bcrypt.MinCost)
+		require.NoError(t, err)
+
+		// THEN
+		assert.True(t, utils.CheckPasswordHash("secreto123", string(legacy)))
+		assert.False(t, utils.CheckPasswordHash("otro", string(legacy)))
+	})
+
+	t.Run("Debe verificar un hash calculado con otros parámetros", func(t *testing.T) {
+		// GIVEN
+		setParams(t, utils.PasswordParams{Memory: 8 * 1024, Iterations: 1, Parallelism: 1})
+		hash, err := utils.HashPassword("secreto123")
+		require.NoError(t, err)
+
+		// WHEN
+		require.NoError(t, utils.SetPasswordParams(utils.DefaultPasswordParams))
+
+		// THEN
+		assert.True(t, utils.CheckPasswordHash("secreto123", hash))
+	})
+
+	t.Run("Debe rechazar los hashes vacíos o mal formados", func(t *testing.T) {
+		for _, hash := range []string{
+			"",
+			"$argon2id$v=19$m=19456,t=2,p=1$sal",
+			"$argon2id$v=18$m=19456,t=2,p=1$c2FsdHNhbHRzYWx0c2FsdA$aGFzaA",
+			"$argon2id$v=19$m=19456,t=0,p=1$c2FsdHNhbHRzYWx0c2FsdA$aGFzaA",
+			"$argon2id$v=19$m=19456,t=2,p=1$c2FsdHNhbHRzYWx0c2FsdA$",
+		} {
+			assert.False(t, utils.CheckPasswordHash("secreto123", hash), hash)
+		}
+	})
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	t.Run("Debe pedir recalcular los hashes bcrypt", func(t *testing.T) {
+		// GIVEN
+		legacy, err := bcrypt.GenerateFromPassword([]byte("secreto123"), bcrypt.MinCost)
+		require.NoError(t, err)
+
+		// THEN
+		assert.True(t, utils.PasswordNeedsRehash(string(legacy)))
+	})
+
+	t.Run("Debe pedir recalcular solo los hashes con otros parámetros", func(t *testing.T) {
+		// GIVEN
+		current, err := utils.HashPassword("secreto123")
+		require.NoError(t, err)
+
+		// WHEN
+		setParams(t, utils.PasswordParams{Memory: 32 * 1024, Iterations: 3, Parallelism: 2})
+
+		// THEN
+		assert.True(t, utils.PasswordNeedsRehash(current))
+		upgraded, err := utils.HashPassword("secreto123")
+		require.NoError(t, err)
+		assert.False(t, utils.PasswordNeedsRehash(upgraded))
+	})
+}
+
+func TestSetPasswordParams(t *testing.T) {
+	t.Run("Debe rechazar parámetros que argon2id no admite", func(t *testing.T) {
+		for _, params := range []utils.PasswordParams{
+			{Memory: 19 * 1024, Iterations: 0, Parallelism: 1},
+			{Memory: 19 * 1024, Iterations: 2, Parallelism: 0},
+			{Memory: 8, Iterations: 2, Parallelism: 2},
+		} {
+			assert.Error(t, utils.SetPasswordParams(params), "%+v", params)
+		}
+	})
+}
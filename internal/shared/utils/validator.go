@@ -48,6 +48,10 @@ func msgForTag(fe validator.FieldError) string {
 		return fmt.Sprintf("Debe tener al menos %s caracteres", fe.Param())
 	case "max":
 		return fmt.Sprintf("No puede tener más de %s caracteres", fe.Param())
+	case "http_url":
+		return "Debe ser una URL http o https válida"
+	case "oneof":
+		return fmt.Sprintf("Debe ser uno de: %s", strings.ReplaceAll(fe.Param(), " ", ", "))
 	}
 	return "Valor inválido"
 }
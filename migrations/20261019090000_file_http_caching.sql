@@ -0,0 +1,2 @@
+-- Modify "files" table
+ALTER TABLE "files" ADD COLUMN "content_hash" text NULL, ADD COLUMN "thumbnail_size" bigint NULL, ADD COLUMN "thumbnail_hash" text NULL;
@@ -0,0 +1,19 @@
+-- Modify "files" table
+ALTER TABLE "files" ADD COLUMN "blob_id" text NULL;
+-- Create index "idx_files_blob_id" to table: "files"
+CREATE INDEX "idx_files_blob_id" ON "files" ("blob_id");
+-- Create "blobs" table
+CREATE TABLE "blobs" (
+  "id" text NOT NULL,
+  "scope" text NOT NULL,
+  "content_hash" text NOT NULL,
+  "object_key" text NOT NULL,
+  "thumbnail_object_key" text NOT NULL,
+  "thumbnail_size" bigint NOT NULL,
+  "thumbnail_hash" text NOT NULL,
+  "ref_count" bigint NOT NULL DEFAULT 0,
+  "created_at" timestamptz NULL,
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_blobs_scope_content_hash" to table: "blobs"
+CREATE UNIQUE INDEX "idx_blobs_scope_content_hash" ON "blobs" ("scope", "content_hash");
@@ -0,0 +1,4 @@
+-- Modify "files" table
+ALTER TABLE "files" ADD COLUMN "a_hash" bigint NULL, ADD COLUMN "d_hash" bigint NULL, ADD COLUMN "p_hash" bigint NULL;
+-- Modify "blobs" table
+ALTER TABLE "blobs" ADD COLUMN "a_hash" bigint NULL, ADD COLUMN "d_hash" bigint NULL, ADD COLUMN "p_hash" bigint NULL;
@@ -0,0 +1,16 @@
+-- Create "usages" table
+CREATE TABLE "usages" (
+  "user_id" text NOT NULL,
+  "bytes" bigint NOT NULL DEFAULT 0,
+  "files" bigint NOT NULL DEFAULT 0,
+  "max_bytes" bigint NULL,
+  "max_files" bigint NULL,
+  "updated_at" timestamptz NULL,
+  PRIMARY KEY ("user_id")
+);
+-- Backfill usage from existing files
+INSERT INTO "usages" ("user_id", "bytes", "files", "updated_at")
+SELECT "user_id", COALESCE(SUM("file_size" + COALESCE("thumbnail_size", 0)), 0), COUNT(*), now()
+FROM "files"
+WHERE "user_id" IS NOT NULL
+GROUP BY "user_id";
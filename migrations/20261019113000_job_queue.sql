@@ -0,0 +1,24 @@
+-- Create "jobs" table
+CREATE TABLE "jobs" (
+  "id" text NOT NULL,
+  "type" text NOT NULL,
+  "user_id" text NULL,
+  "payload" text NOT NULL,
+  "status" text NOT NULL,
+  "progress" bigint NOT NULL DEFAULT 0,
+  "attempts" bigint NOT NULL DEFAULT 0,
+  "max_attempts" bigint NOT NULL,
+  "last_error" text NULL,
+  "result" text NULL,
+  "run_at" timestamptz NOT NULL,
+  "locked_by" text NULL,
+  "locked_at" timestamptz NULL,
+  "finished_at" timestamptz NULL,
+  "created_at" timestamptz NULL,
+  "updated_at" timestamptz NULL,
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_jobs_status_run_at" to table: "jobs"
+CREATE INDEX "idx_jobs_status_run_at" ON "jobs" ("status", "run_at");
+-- Create index "idx_jobs_user_id" to table: "jobs"
+CREATE INDEX "idx_jobs_user_id" ON "jobs" ("user_id");
@@ -0,0 +1,34 @@
+-- Create "webhook_endpoints" table
+CREATE TABLE "webhook_endpoints" (
+  "id" text NOT NULL,
+  "user_id" text NOT NULL,
+  "url" text NOT NULL,
+  "secret" text NOT NULL,
+  "events" text NOT NULL,
+  "created_at" timestamptz NULL,
+  "updated_at" timestamptz NULL,
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_webhook_endpoints_user_id" to table: "webhook_endpoints"
+CREATE INDEX "idx_webhook_endpoints_user_id" ON "webhook_endpoints" ("user_id");
+-- Create "webhook_deliveries" table
+CREATE TABLE "webhook_deliveries" (
+  "id" text NOT NULL,
+  "endpoint_id" text NOT NULL,
+  "event_id" text NOT NULL,
+  "event_type" text NOT NULL,
+  "payload" text NOT NULL,
+  "status" text NOT NULL,
+  "attempts" bigint NOT NULL DEFAULT 0,
+  "response_status" bigint NULL,
+  "response_body" text NULL,
+  "last_error" text NULL,
+  "job_id" text NULL,
+  "redelivery_of" text NULL,
+  "created_at" timestamptz NULL,
+  "last_attempt_at" timestamptz NULL,
+  "delivered_at" timestamptz NULL,
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_webhook_deliveries_endpoint_id" to table: "webhook_deliveries"
+CREATE INDEX "idx_webhook_deliveries_endpoint_id" ON "webhook_deliveries" ("endpoint_id");
@@ -0,0 +1,14 @@
+-- Create "activity_events" table
+CREATE TABLE "activity_events" (
+  "seq" bigserial NOT NULL,
+  "event_id" text NOT NULL,
+  "user_id" text NOT NULL,
+  "type" text NOT NULL,
+  "data" text NOT NULL,
+  "occurred_at" timestamptz NOT NULL,
+  PRIMARY KEY ("seq")
+);
+-- Create index "idx_activity_events_occurred_at" to table: "activity_events"
+CREATE INDEX "idx_activity_events_occurred_at" ON "activity_events" ("occurred_at");
+-- Create index "idx_activity_events_user_seq" to table: "activity_events"
+CREATE INDEX "idx_activity_events_user_seq" ON "activity_events" ("user_id", "seq");
@@ -0,0 +1,27 @@
+-- Create "outbox_messages" table
+CREATE TABLE "outbox_messages" (
+  "id" bigserial NOT NULL,
+  "event_id" character varying(24) NOT NULL,
+  "type" text NOT NULL,
+  "user_id" text NOT NULL,
+  "data" text NOT NULL,
+  "occurred_at" timestamptz NOT NULL,
+  "attempts" bigint NOT NULL DEFAULT 0,
+  "last_error" text NULL,
+  "next_attempt_at" timestamptz NOT NULL,
+  "locked_by" character varying(64) NULL,
+  "locked_until" timestamptz NULL,
+  "published_at" timestamptz NULL,
+  "created_at" timestamptz NULL,
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_outbox_messages_event_id" to table: "outbox_messages"
+CREATE UNIQUE INDEX "idx_outbox_messages_event_id" ON "outbox_messages" ("event_id");
+-- Create index "idx_outbox_messages_pending" to table: "outbox_messages"
+CREATE INDEX "idx_outbox_messages_pending" ON "outbox_messages" ("published_at", "next_attempt_at");
+-- Modify "activity_events" table
+ALTER TABLE "activity_events" ALTER COLUMN "event_id" TYPE character varying(24);
+-- Create index "idx_activity_events_event_id" to table: "activity_events"
+CREATE UNIQUE INDEX "idx_activity_events_event_id" ON "activity_events" ("event_id");
+-- Create index "idx_webhook_deliveries_endpoint_event" to table: "webhook_deliveries"
+CREATE INDEX "idx_webhook_deliveries_endpoint_event" ON "webhook_deliveries" ("endpoint_id", "event_id");
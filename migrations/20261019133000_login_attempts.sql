@@ -0,0 +1,24 @@
+-- Create "login_attempts" table
+CREATE TABLE "login_attempts" (
+  "id" character varying(24) NOT NULL,
+  "user_id" character varying(24) NULL,
+  "email" text NOT NULL,
+  "ip" character varying(45) NOT NULL,
+  "result" character varying(32) NOT NULL,
+  "created_at" timestamptz NOT NULL,
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_login_attempts_created_at" to table: "login_attempts"
+CREATE INDEX "idx_login_attempts_created_at" ON "login_attempts" ("created_at");
+-- Create index "idx_login_attempts_email" to table: "login_attempts"
+CREATE INDEX "idx_login_attempts_email" ON "login_attempts" ("email");
+-- Create index "idx_login_attempts_user_created" to table: "login_attempts"
+CREATE INDEX "idx_login_attempts_user_created" ON "login_attempts" ("user_id", "created_at");
+-- Create "login_throttles" table
+CREATE TABLE "login_throttles" (
+  "key" character varying(320) NOT NULL,
+  "failures" bigint NOT NULL DEFAULT 0,
+  "last_failure_at" timestamptz NOT NULL,
+  "locked_until" timestamptz NULL,
+  PRIMARY KEY ("key")
+);
@@ -0,0 +1,2 @@
+-- Modify "users" table
+ALTER TABLE "users" ADD COLUMN "role" character varying(16) NOT NULL DEFAULT 'member';
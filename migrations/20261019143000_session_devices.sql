@@ -0,0 +1,4 @@
+-- Modify "sessions" table
+ALTER TABLE "sessions" ADD COLUMN "user_agent" text NULL, ADD COLUMN "ip" character varying(45) NULL, ADD COLUMN "device_label" character varying(100) NULL, ADD COLUMN "last_used_at" timestamptz NULL;
+-- Create index "idx_sessions_user_id" to table: "sessions"
+CREATE INDEX "idx_sessions_user_id" ON "sessions" ("user_id");
@@ -0,0 +1,12 @@
+-- Create "rotated_refresh_tokens" table
+CREATE TABLE "rotated_refresh_tokens" (
+  "token_hash" character varying(64) NOT NULL,
+  "session_id" character varying(24) NOT NULL,
+  "rotated_at" timestamptz NOT NULL,
+  "expires_at" timestamptz NOT NULL,
+  PRIMARY KEY ("token_hash")
+);
+-- Create index "idx_rotated_refresh_tokens_expires_at" to table: "rotated_refresh_tokens"
+CREATE INDEX "idx_rotated_refresh_tokens_expires_at" ON "rotated_refresh_tokens" ("expires_at");
+-- Create index "idx_rotated_refresh_tokens_session_id" to table: "rotated_refresh_tokens"
+CREATE INDEX "idx_rotated_refresh_tokens_session_id" ON "rotated_refresh_tokens" ("session_id");
@@ -0,0 +1,17 @@
+-- Create "api_keys" table
+CREATE TABLE "api_keys" (
+  "id" character varying(24) NOT NULL,
+  "user_id" character varying(24) NOT NULL,
+  "name" character varying(100) NOT NULL,
+  "prefix" character varying(16) NOT NULL,
+  "key_hash" character varying(64) NOT NULL,
+  "scopes" text NOT NULL,
+  "expires_at" timestamptz NULL,
+  "last_used_at" timestamptz NULL,
+  "created_at" timestamptz NULL,
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_api_keys_key_hash" to table: "api_keys"
+CREATE UNIQUE INDEX "idx_api_keys_key_hash" ON "api_keys" ("key_hash");
+-- Create index "idx_api_keys_user_id" to table: "api_keys"
+CREATE INDEX "idx_api_keys_user_id" ON "api_keys" ("user_id");
@@ -0,0 +1,17 @@
+-- Modify "users" table
+ALTER TABLE "users" ADD COLUMN "email_verified_at" timestamptz NULL;
+-- Create "email_tokens" table
+CREATE TABLE "email_tokens" (
+  "id" character varying(24) NOT NULL,
+  "user_id" character varying(24) NOT NULL,
+  "purpose" character varying(32) NOT NULL,
+  "token_hash" character varying(64) NOT NULL,
+  "expires_at" timestamptz NOT NULL,
+  "used_at" timestamptz NULL,
+  "created_at" timestamptz NULL,
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_email_tokens_token_hash" to table: "email_tokens"
+CREATE UNIQUE INDEX "idx_email_tokens_token_hash" ON "email_tokens" ("token_hash");
+-- Create index "idx_email_tokens_user_id" to table: "email_tokens"
+CREATE INDEX "idx_email_tokens_user_id" ON "email_tokens" ("user_id");
@@ -0,0 +1,39 @@
+-- Create "two_factors" table
+CREATE TABLE "two_factors" (
+  "user_id" character varying(24) NOT NULL,
+  "secret" character varying(64) NOT NULL,
+  "confirmed_at" timestamptz NULL,
+  "last_step" bigint NOT NULL DEFAULT 0,
+  "created_at" timestamptz NULL,
+  PRIMARY KEY ("user_id")
+);
+-- Create "recovery_codes" table
+CREATE TABLE "recovery_codes" (
+  "id" character varying(24) NOT NULL,
+  "user_id" character varying(24) NOT NULL,
+  "code_hash" character varying(64) NOT NULL,
+  "used_at" timestamptz NULL,
+  "created_at" timestamptz NULL,
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_recovery_codes_code_hash" to table: "recovery_codes"
+CREATE UNIQUE INDEX "idx_recovery_codes_code_hash" ON "recovery_codes" ("code_hash");
+-- Create index "idx_recovery_codes_user_id" to table: "recovery_codes"
+CREATE INDEX "idx_recovery_codes_user_id" ON "recovery_codes" ("user_id");
+-- Create "login_challenges" table
+CREATE TABLE "login_challenges" (
+  "id" character varying(24) NOT NULL,
+  "user_id" character varying(24) NOT NULL,
+  "token_hash" character varying(64) NOT NULL,
+  "device_label" character varying(100) NULL,
+  "attempts" bigint NOT NULL DEFAULT 0,
+  "expires_at" timestamptz NOT NULL,
+  "created_at" timestamptz NULL,
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_login_challenges_expires_at" to table: "login_challenges"
+CREATE INDEX "idx_login_challenges_expires_at" ON "login_challenges" ("expires_at");
+-- Create index "idx_login_challenges_token_hash" to table: "login_challenges"
+CREATE UNIQUE INDEX "idx_login_challenges_token_hash" ON "login_challenges" ("token_hash");
+-- Create index "idx_login_challenges_user_id" to table: "login_challenges"
+CREATE INDEX "idx_login_challenges_user_id" ON "login_challenges" ("user_id");
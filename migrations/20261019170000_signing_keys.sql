@@ -0,0 +1,12 @@
+-- Create "signing_keys" table
+CREATE TABLE "signing_keys" (
+  "id" character varying(24) NOT NULL,
+  "algorithm" character varying(16) NOT NULL,
+  "private_key" text NOT NULL,
+  "created_at" timestamptz NOT NULL,
+  "retired_at" timestamptz NULL,
+  "expires_at" timestamptz NULL,
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_signing_keys_expires_at" to table: "signing_keys"
+CREATE INDEX "idx_signing_keys_expires_at" ON "signing_keys" ("expires_at");
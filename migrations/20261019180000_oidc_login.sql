@@ -0,0 +1,26 @@
+-- Create "oidc_logins" table
+CREATE TABLE "oidc_logins" (
+  "state_hash" character varying(64) NOT NULL,
+  "provider" character varying(64) NOT NULL,
+  "nonce" character varying(64) NOT NULL,
+  "code_verifier" character varying(128) NOT NULL,
+  "expires_at" timestamptz NOT NULL,
+  "created_at" timestamptz NULL,
+  PRIMARY KEY ("state_hash")
+);
+-- Create index "idx_oidc_logins_expires_at" to table: "oidc_logins"
+CREATE INDEX "idx_oidc_logins_expires_at" ON "oidc_logins" ("expires_at");
+-- Create "external_identities" table
+CREATE TABLE "external_identities" (
+  "id" character varying(24) NOT NULL,
+  "user_id" character varying(24) NOT NULL,
+  "provider" character varying(64) NOT NULL,
+  "subject" character varying(255) NOT NULL,
+  "email" text NULL,
+  "created_at" timestamptz NULL,
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_external_identities_provider_subject" to table: "external_identities"
+CREATE UNIQUE INDEX "idx_external_identities_provider_subject" ON "external_identities" ("provider", "subject");
+-- Create index "idx_external_identities_user_id" to table: "external_identities"
+CREATE INDEX "idx_external_identities_user_id" ON "external_identities" ("user_id");
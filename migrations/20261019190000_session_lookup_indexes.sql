@@ -0,0 +1,4 @@
+-- Create index "idx_sessions_access_jti" to table: "sessions"
+CREATE UNIQUE INDEX "idx_sessions_access_jti" ON "sessions" ("access_jti");
+-- Create index "idx_sessions_token_hash" to table: "sessions"
+CREATE UNIQUE INDEX "idx_sessions_token_hash" ON "sessions" ("token_hash");
@@ -0,0 +1,2 @@
+-- Create index "idx_sessions_expires_at" to table: "sessions"
+CREATE INDEX "idx_sessions_expires_at" ON "sessions" ("expires_at");
@@ -0,0 +1,10 @@
+-- Create "password_history" table
+CREATE TABLE "password_history" (
+  "id" character varying(32) NOT NULL,
+  "user_id" character varying(32) NOT NULL,
+  "password" text NOT NULL,
+  "created_at" timestamptz NULL,
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_password_history_user_id" to table: "password_history"
+CREATE INDEX "idx_password_history_user_id" ON "password_history" ("user_id");
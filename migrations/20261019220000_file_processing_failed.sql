@@ -0,0 +1,8 @@
+-- Modify "files" table
+ALTER TABLE "files" ADD COLUMN "processing_failed" boolean NOT NULL DEFAULT false;
+-- Modify "blobs" table
+ALTER TABLE "blobs" ADD COLUMN "processing_failed" boolean NOT NULL DEFAULT false;
+-- Drop index "idx_blobs_scope_content_hash" from table: "blobs"
+DROP INDEX "idx_blobs_scope_content_hash";
+-- Create index "idx_blobs_scope_content_hash" to table: "blobs"
+CREATE UNIQUE INDEX "idx_blobs_scope_content_hash" ON "blobs" ("scope", "content_hash") WHERE (processing_failed = false);
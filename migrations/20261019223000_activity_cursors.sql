@@ -0,0 +1,15 @@
+-- Create "activity_cursors" table
+CREATE TABLE "activity_cursors" (
+  "user_id" text NOT NULL,
+  "last_seq" bigint NOT NULL DEFAULT 0,
+  PRIMARY KEY ("user_id")
+);
+-- Backfill cursors from existing events
+INSERT INTO "activity_cursors" ("user_id", "last_seq")
+SELECT "user_id", MAX("seq") FROM "activity_events" GROUP BY "user_id";
+-- Modify "activity_events" table
+ALTER TABLE "activity_events" DROP CONSTRAINT "activity_events_pkey", ALTER COLUMN "seq" DROP DEFAULT, ADD PRIMARY KEY ("user_id", "seq");
+-- Drop sequence "activity_events_seq_seq"
+DROP SEQUENCE "activity_events_seq_seq";
+-- Drop index "idx_activity_events_user_seq" from table: "activity_events"
+DROP INDEX "idx_activity_events_user_seq";
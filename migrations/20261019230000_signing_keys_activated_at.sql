@@ -0,0 +1,4 @@
+-- Modify "signing_keys" table
+ALTER TABLE "signing_keys" ADD COLUMN "activated_at" timestamptz NULL;
+-- Backfill "activated_at" for keys that already signed
+UPDATE "signing_keys" SET "activated_at" = "created_at";